@@ -0,0 +1,145 @@
+package dbmigrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Default alerting API endpoints
+const (
+	PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	OpsgenieAlertsURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+// Alerter opens and resolves incidents for failing runs. Incidents are
+// identified by a dedup key so repeated failures update a single incident.
+type Alerter interface {
+	// Trigger opens (or updates) the incident for dedupKey
+	Trigger(dedupKey, summary string, report *Report) error
+	// Resolve closes the incident for dedupKey, if one is open
+	Resolve(dedupKey string) error
+}
+
+// alertClient uses the default transport, which sends requests through the
+// proxy of HTTPS_PROXY unless NO_PROXY excludes the API host
+var alertClient = &http.Client{Timeout: 30 * time.Second}
+
+// PagerDutyAlerter sends events to the PagerDuty Events API v2
+type PagerDutyAlerter struct {
+	RoutingKey string // integration key of the PagerDuty service
+	URL        string // defaults to PagerDutyEventsURL
+}
+
+// Trigger implements Alerter
+func (a *PagerDutyAlerter) Trigger(dedupKey, summary string, report *Report) error {
+	return a.send(map[string]interface{}{
+		"routing_key":  a.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    dedupKey,
+		"payload": map[string]interface{}{
+			"summary":        summary,
+			"source":         "dbmigrate",
+			"severity":       "error",
+			"custom_details": report,
+		},
+	})
+}
+
+// Resolve implements Alerter
+func (a *PagerDutyAlerter) Resolve(dedupKey string) error {
+	return a.send(map[string]interface{}{
+		"routing_key":  a.RoutingKey,
+		"event_action": "resolve",
+		"dedup_key":    dedupKey,
+	})
+}
+
+func (a *PagerDutyAlerter) send(event map[string]interface{}) error {
+	endpoint := a.URL
+	if endpoint == "" {
+		endpoint = PagerDutyEventsURL
+	}
+	return postAlert(endpoint, nil, event)
+}
+
+// OpsgenieAlerter creates and closes alerts through the Opsgenie Alert API
+type OpsgenieAlerter struct {
+	APIKey string // API key of an Opsgenie API integration
+	URL    string // defaults to OpsgenieAlertsURL
+}
+
+// Trigger implements Alerter
+func (a *OpsgenieAlerter) Trigger(dedupKey, summary string, report *Report) error {
+	details := map[string]string{
+		"run_id":   report.RunID,
+		"status":   report.Status,
+		"database": report.Database,
+		"error":    report.Error,
+		"duration": report.Duration,
+	}
+	// Opsgenie limits the message to 130 characters
+	message := summary
+	if len(message) > 130 {
+		message = message[:127] + "..."
+	}
+	return postAlert(a.endpoint(), a.headers(), map[string]interface{}{
+		"message":     message,
+		"alias":       dedupKey,
+		"description": report.Error,
+		"source":      "dbmigrate",
+		"priority":    "P2",
+		"details":     details,
+	})
+}
+
+// Resolve implements Alerter
+func (a *OpsgenieAlerter) Resolve(dedupKey string) error {
+	endpoint := a.endpoint() + "/" + url.PathEscape(dedupKey) + "/close?identifierType=alias"
+	return postAlert(endpoint, a.headers(), map[string]interface{}{
+		"source": "dbmigrate",
+		"note":   "Migration succeeded",
+	})
+}
+
+func (a *OpsgenieAlerter) endpoint() string {
+	if a.URL != "" {
+		return a.URL
+	}
+	return OpsgenieAlertsURL
+}
+
+func (a *OpsgenieAlerter) headers() map[string]string {
+	return map[string]string{"Authorization": "GenieKey " + a.APIKey}
+}
+
+// postAlert posts a JSON body and treats any non-2xx response as an error
+func postAlert(endpoint string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending alert: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("alert rejected by %s: %s: %s", endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
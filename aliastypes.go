@@ -0,0 +1,41 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// aliasTypeFrom selects the alias type (CREATE TYPE ... FROM) of column c of an
+// INFORMATION_SCHEMA.COLUMNS row as ut; CLR types such as hierarchyid are not aliases
+const aliasTypeFrom = `FROM sys.columns sc JOIN sys.types ut ON ut.user_type_id = sc.user_type_id
+			WHERE sc.object_id = OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME))
+			AND sc.name = c.COLUMN_NAME AND ut.is_user_defined = 1 AND ut.is_assembly_type = 0`
+
+// DataTypeExpr is the SQL Server expression for the data type of column c of an
+// INFORMATION_SCHEMA.COLUMNS row, with alias types resolved to their base type
+const DataTypeExpr = "COALESCE((SELECT TYPE_NAME(ut.system_type_id) " + aliasTypeFrom + "), c.DATA_TYPE)"
+
+// aliasTypeExpr is the SQL Server expression for the schema-qualified alias type
+// of column c of an INFORMATION_SCHEMA.COLUMNS row, or NULL
+const aliasTypeExpr = "(SELECT SCHEMA_NAME(ut.schema_id) + '.' + ut.name " + aliasTypeFrom + ")"
+
+// formatBaseType formats a base type with the length, precision or scale of its
+// definition, e.g. varchar(20), as read from INFORMATION_SCHEMA.COLUMNS
+func formatBaseType(dataType string, length, precision, scale, datetimePrecision sql.NullInt64) string {
+	switch dataType {
+	case "char", "varchar", "nchar", "nvarchar", "binary", "varbinary":
+		if length.Int64 == -1 {
+			return dataType + "(max)"
+		}
+		if length.Valid {
+			return fmt.Sprintf("%s(%d)", dataType, length.Int64)
+		}
+	case "decimal", "numeric":
+		return fmt.Sprintf("%s(%d,%d)", dataType, precision.Int64, scale.Int64)
+	case "datetime2", "datetimeoffset", "time":
+		if datetimePrecision.Valid {
+			return fmt.Sprintf("%s(%d)", dataType, datetimePrecision.Int64)
+		}
+	}
+	return dataType
+}
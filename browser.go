@@ -0,0 +1,116 @@
+package dbmigrate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SQL Server Browser (SSRP) message types
+const (
+	browserRequestInstance = 0x04 // CLNT_UCAST_INST, followed by the instance name
+	browserResponse        = 0x05 // SVR_RESP, followed by the data size and the data
+)
+
+// browserTimeout is how long to wait for SQL Server Browser to answer
+const browserTimeout = 5 * time.Second
+
+// lookupInstancePort finds the TCP port of a named instance; tests replace it
+var lookupInstancePort = LookupSqlServerInstance
+
+// LookupSqlServerInstance asks the SQL Server Browser service on UDP port 1434
+// of host for the TCP port of a named instance
+func LookupSqlServerInstance(host, instance string) (string, error) {
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(strings.Trim(host, "[]"), "1434"))
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %v", host, err)
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return "", fmt.Errorf("error opening a UDP socket: %v", err)
+	}
+	defer conn.Close()
+	return queryBrowser(conn, addr, instance, browserTimeout)
+}
+
+// queryBrowser sends a request for instance to the SQL Server Browser at addr
+// over conn and returns the TCP port from its response. SQL Server Browser does
+// not answer requests for instances it does not know.
+func queryBrowser(conn net.PacketConn, addr net.Addr, instance string, timeout time.Duration) (string, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", fmt.Errorf("error setting a deadline: %v", err)
+	}
+	request := append([]byte{browserRequestInstance}, instance...)
+	if _, err := conn.WriteTo(append(request, 0), addr); err != nil {
+		return "", fmt.Errorf("error sending a request to SQL Server Browser on %s: %v", addr, err)
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no response from SQL Server Browser on %s within %s (is the SQL Server Browser service running, UDP port 1434 open and %s the name of an instance?): %v", addr, timeout, instance, err)
+		}
+		if from != nil && from.String() != addr.String() {
+			continue // not from the server we asked
+		}
+		instances, err := parseBrowserResponse(buf[:n])
+		if err != nil {
+			return "", fmt.Errorf("error reading the response of SQL Server Browser on %s: %v", addr, err)
+		}
+		names := make([]string, 0, len(instances))
+		for name, info := range instances {
+			if !strings.EqualFold(name, instance) {
+				names = append(names, name)
+				continue
+			}
+			if info["tcp"] == "" {
+				return "", fmt.Errorf("instance %s does not accept TCP connections (enable TCP/IP in SQL Server Configuration Manager)", name)
+			}
+			return info["tcp"], nil
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("SQL Server Browser on %s does not list instance %s (instances: %s)", addr, instance, strings.Join(names, ", "))
+	}
+}
+
+// parseBrowserResponse parses an SVR_RESP message: 0x05, the size of the data
+// (2 bytes, little endian) and the data, which describes each instance as
+// key;value; pairs (ServerName, InstanceName, tcp, ...) ending in an extra ";".
+// It returns the pairs of each instance by instance name.
+func parseBrowserResponse(msg []byte) (map[string]map[string]string, error) {
+	if len(msg) < 3 || msg[0] != browserResponse {
+		return nil, fmt.Errorf("not a SQL Server Browser response")
+	}
+	size := int(binary.LittleEndian.Uint16(msg[1:3]))
+	if size > len(msg)-3 {
+		return nil, fmt.Errorf("response has %d bytes of data, expected %d", len(msg)-3, size)
+	}
+	instances := make(map[string]map[string]string)
+	info := make(map[string]string)
+	tokens := strings.Split(string(msg[3:3+size]), ";")
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "" {
+			// The end of an instance
+			if len(info) > 0 {
+				if info["InstanceName"] == "" {
+					return nil, fmt.Errorf("instance without InstanceName in response")
+				}
+				instances[info["InstanceName"]] = info
+				info = make(map[string]string)
+			}
+			continue
+		}
+		if i+1 == len(tokens) {
+			return nil, fmt.Errorf("%s has no value in response", tokens[i])
+		}
+		info[tokens[i]] = tokens[i+1]
+		i++
+	}
+	if len(info) > 0 {
+		return nil, fmt.Errorf("response ends in the middle of instance %s", info["InstanceName"])
+	}
+	return instances, nil
+}
@@ -0,0 +1,123 @@
+package dbmigrate
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// browserMessage builds an SVR_RESP message with data
+func browserMessage(data string) []byte {
+	msg := []byte{browserResponse, 0, 0}
+	binary.LittleEndian.PutUint16(msg[1:], uint16(len(data)))
+	return append(msg, data...)
+}
+
+// fakePacketConn is a net.PacketConn that records what is written to it and
+// answers reads with its responses, then times out
+type fakePacketConn struct {
+	written   [][]byte
+	to        []net.Addr
+	responses [][]byte
+	from      net.Addr
+	deadline  time.Time
+}
+
+func (c *fakePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if len(c.responses) == 0 {
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+	n := copy(p, c.responses[0])
+	c.responses = c.responses[1:]
+	return n, c.from, nil
+}
+
+func (c *fakePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.written = append(c.written, append([]byte(nil), p...))
+	c.to = append(c.to, addr)
+	return len(p), nil
+}
+
+func (c *fakePacketConn) Close() error                       { return nil }
+func (c *fakePacketConn) LocalAddr() net.Addr                { return &net.UDPAddr{} }
+func (c *fakePacketConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *fakePacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fakePacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+const twoInstances = "ServerName;DBHOST;InstanceName;SQLEXPRESS;IsClustered;No;Version;15.0.2000.5;tcp;49872;np;\\\\DBHOST\\pipe\\MSSQL$SQLEXPRESS\\sql\\query;;" +
+	"ServerName;DBHOST;InstanceName;REPORTS;IsClustered;No;Version;15.0.2000.5;np;\\\\DBHOST\\pipe\\MSSQL$REPORTS\\sql\\query;;"
+
+func TestParseBrowserResponse(t *testing.T) {
+	instances, err := parseBrowserResponse(browserMessage(twoInstances))
+	if err != nil {
+		t.Fatalf("parseBrowserResponse() failed: %v", err)
+	}
+	if len(instances) != 2 || instances["SQLEXPRESS"]["tcp"] != "49872" || instances["REPORTS"]["tcp"] != "" {
+		t.Errorf("parseBrowserResponse() = %v", instances)
+	}
+	want := map[string]string{"ServerName": "DBHOST", "InstanceName": "SQLEXPRESS", "IsClustered": "No", "Version": "15.0.2000.5", "tcp": "49872", "np": `\\DBHOST\pipe\MSSQL$SQLEXPRESS\sql\query`}
+	if !reflect.DeepEqual(instances["SQLEXPRESS"], want) {
+		t.Errorf("SQLEXPRESS = %v, want %v", instances["SQLEXPRESS"], want)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		msg     []byte
+		wantErr string
+	}{
+		{"empty", nil, "not a SQL Server Browser response"},
+		{"wrong type", append([]byte{0x03}, browserMessage(twoInstances)[1:]...), "not a SQL Server Browser response"},
+		{"truncated", browserMessage(twoInstances)[:40], "bytes of data, expected"},
+		{"missing value", browserMessage("ServerName;DBHOST;InstanceName"), "InstanceName has no value"},
+		{"missing end", browserMessage("ServerName;DBHOST;InstanceName;SQLEXPRESS;tcp;1433"), "ends in the middle of instance SQLEXPRESS"},
+		{"no instance name", browserMessage("ServerName;DBHOST;tcp;1433;;"), "without InstanceName"},
+	} {
+		if _, err := parseBrowserResponse(tt.msg); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: parseBrowserResponse() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestQueryBrowser(t *testing.T) {
+	server := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 1434}
+	other := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 1434}
+
+	conn := &fakePacketConn{responses: [][]byte{browserMessage(twoInstances)}, from: server}
+	port, err := queryBrowser(conn, server, "sqlexpress", time.Second)
+	if err != nil {
+		t.Fatalf("queryBrowser() failed: %v", err)
+	}
+	if port != "49872" {
+		t.Errorf("queryBrowser() = %s, want 49872", port)
+	}
+	if want := append([]byte{browserRequestInstance}, "sqlexpress\x00"...); len(conn.written) != 1 || !reflect.DeepEqual(conn.written[0], want) || conn.to[0] != server {
+		t.Errorf("sent %q to %v, want %q to %v", conn.written, conn.to, want, server)
+	}
+	if conn.deadline.IsZero() {
+		t.Error("no deadline set")
+	}
+
+	for _, tt := range []struct {
+		name      string
+		instance  string
+		responses [][]byte
+		from      net.Addr
+		wantErr   string
+	}{
+		{"no TCP", "REPORTS", [][]byte{browserMessage(twoInstances)}, server, "does not accept TCP connections"},
+		{"not listed", "SALES", [][]byte{browserMessage(twoInstances)}, server, "does not list instance SALES (instances: REPORTS, SQLEXPRESS)"},
+		{"no answer", "SALES", nil, server, "no response from SQL Server Browser on 10.0.0.5:1434"},
+		{"answer from another host", "SQLEXPRESS", [][]byte{browserMessage(twoInstances)}, other, "no response"},
+		{"garbage", "SQLEXPRESS", [][]byte{[]byte("HTTP/1.1 400")}, server, "not a SQL Server Browser response"},
+	} {
+		conn := &fakePacketConn{responses: tt.responses, from: tt.from}
+		_, err := queryBrowser(conn, server, tt.instance, time.Second)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: queryBrowser() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Citus distributes tables across the worker nodes of a cluster by the hash of
+// a distribution column, or copies small reference tables to every node. With
+// SchemaOptions.Citus, the tables configured with distribution_column or
+// reference_table are distributed right after they are created, while they are
+// still empty; other tables stay local tables on the coordinator.
+
+// citusStatements returns the statements distributing the tables (source
+// names) configured for Citus: reference tables first, then distributed
+// tables, and those colocated with another table last, so the table they are
+// colocated with is distributed first. pkMap holds the primary key columns of
+// each table, which must include the distribution column.
+func citusStatements(tables []string, pkMap map[string][]string, opts SchemaOptions) ([]string, error) {
+	var reference, distributed, colocated []string
+	for _, table := range tables {
+		settings := opts.Config.TableSettings(table)
+		if !settings.ReferenceTable && settings.DistributionColumn == "" {
+			continue
+		}
+		target := QuoteLiteral(quotedTarget(table, opts))
+
+		var call string
+		switch {
+		case settings.ReferenceTable:
+			call = fmt.Sprintf("create_reference_table(%s)", target)
+		default:
+			column := settings.DistributionColumn
+			pks := pkMap[table]
+			if len(pks) > 0 && !slices.ContainsFunc(pks, func(pk string) bool { return strings.EqualFold(pk, column) }) {
+				return nil, fmt.Errorf("distribution column %s of %s is not part of its primary key (%s), which Citus requires",
+					column, table, strings.Join(pks, ", "))
+			}
+			// Citus takes the column name as stored, not as an identifier
+			for _, pk := range pks {
+				if strings.EqualFold(pk, column) {
+					column = pk
+				}
+			}
+			if !opts.PreserveCase {
+				column = strings.ToLower(column)
+			}
+			call = fmt.Sprintf("create_distributed_table(%s, %s", target, QuoteLiteral(column))
+			if settings.ColocateWith != "" {
+				call += fmt.Sprintf(", colocate_with => %s", QuoteLiteral(quotedTarget(settings.ColocateWith, opts)))
+			}
+			call += ")"
+		}
+
+		// Tables distributed by an earlier run are left as they are
+		statement := "SELECT " + call
+		if opts.IfNotExists {
+			statement += fmt.Sprintf(" WHERE NOT EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = %s::regclass)", target)
+		}
+		switch {
+		case settings.ReferenceTable:
+			reference = append(reference, statement)
+		case settings.ColocateWith != "":
+			colocated = append(colocated, statement)
+		default:
+			distributed = append(distributed, statement)
+		}
+	}
+	return slices.Concat(reference, distributed, colocated), nil
+}
+
+// quotedTarget returns the quoted target name of a source table (schema.table)
+func quotedTarget(table string, opts SchemaOptions) string {
+	parts := strings.SplitN(table, ".", 2)
+	schema, name := opts.Mapper.Map(parts[0], parts[1])
+	return QuoteQualified(schema, name, opts.PreserveCase)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// failureCountKey is the state store entry counting consecutive failed runs
+const failureCountKey = "run:consecutive_failures"
+
+// alertOnFailures tracks consecutive failed runs in the state store and opens an
+// incident once threshold is reached. A successful run resets the count and
+// resolves the incident. Without a state store every failure counts as the first.
+func alertOnFailures(alerters []dbmigrate.Alerter, stateStore dbmigrate.StateStore, report *dbmigrate.Report, threshold int) {
+	failures := 0
+	if stateStore != nil {
+		checkpoints, err := stateStore.Load()
+		if err != nil {
+			log.Printf("Warning: Could not read failure count: %v", err)
+		} else if cp, ok := checkpoints[failureCountKey]; ok {
+			failures, _ = strconv.Atoi(cp.Value)
+		}
+	}
+
+	dedupKey := "dbmigrate"
+	if report.Database != "" {
+		dedupKey += "-" + report.Database
+	}
+
+	if report.Status == dbmigrate.StatusSucceeded {
+		if failures == 0 {
+			return
+		}
+		saveFailureCount(stateStore, report.RunID, 0)
+		if failures >= threshold {
+			for _, alerter := range alerters {
+				if err := alerter.Resolve(dedupKey); err != nil {
+					log.Printf("Warning: Could not resolve alert: %v", err)
+				}
+			}
+			fmt.Println("Resolved failure alert")
+		}
+		return
+	}
+
+	failures++
+	saveFailureCount(stateStore, report.RunID, failures)
+	if failures < threshold {
+		fmt.Printf("Run %s (%d consecutive failures, alerting after %d)\n", report.Status, failures, threshold)
+		return
+	}
+
+	summary := fmt.Sprintf("dbmigrate run %s %d times in a row", report.Status, failures)
+	if report.Database != "" {
+		summary += " for " + report.Database
+	}
+	if report.Error != "" {
+		summary += ": " + report.Error
+	}
+	for _, alerter := range alerters {
+		if err := alerter.Trigger(dedupKey, summary, report); err != nil {
+			log.Printf("Warning: Could not send alert: %v", err)
+		}
+	}
+	fmt.Printf("Sent failure alert after %d consecutive failures\n", failures)
+}
+
+// saveFailureCount records the consecutive failure count if a state store is configured
+func saveFailureCount(stateStore dbmigrate.StateStore, runID string, failures int) {
+	if stateStore == nil {
+		return
+	}
+	if err := stateStore.Save(dbmigrate.Checkpoint{Table: failureCountKey, Value: strconv.Itoa(failures), RunID: runID}); err != nil {
+		log.Printf("Warning: Could not save failure count: %v", err)
+	}
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// indexNamePattern finds the name of the index a CREATE INDEX statement creates
+var indexNamePattern = regexp.MustCompile(`INDEX (?:CONCURRENTLY )?(?:IF NOT EXISTS )?(\S+) ON `)
+
+// Formats of the archive subcommand
+const (
+	archiveFormatDirectory = "directory"
+	archiveFormatSQL       = "sql"
+	archiveFormatCSV       = "csv"
+	archiveFormatJSONL     = "jsonl"
+	archiveFormatSnowflake = dbmigrate.WarehouseSnowflake
+	archiveFormatRedshift  = dbmigrate.WarehouseRedshift
+)
+
+// archiveOptions holds the flags of the archive subcommand
+type archiveOptions struct {
+	out, format, sqlStatements                                 string
+	gzip, csvHeader                                            bool
+	csvDelimiter, csvNull                                      string
+	warehouseStage, warehouseIAMRole                           string
+	sourceDsn, endpointProfile, schemas, tables, excludeTables string
+	indexes, config, schemaMap, typeMap                        string
+	preserveCase, strictTypes, coerceUnknownTypes              bool
+	datetimeType, sourceTimezone, hierarchyid, invalidText     string
+	set                                                        map[string]bool // flags set explicitly
+}
+
+// archiveFlags defines the flags of the archive subcommand
+func archiveFlags() (*flag.FlagSet, *archiveOptions) {
+	o := &archiveOptions{}
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	fs.StringVar(&o.out, "out", "", "Directory to write the archive or SQL files to (must not hold an archive yet)")
+	fs.StringVar(&o.format, "format", archiveFormatDirectory, "Output format: directory (pg_dump directory format, for pg_restore), sql (SQL files per table, for psql), csv (a CSV file per table), jsonl (a JSON Lines file per table), or snowflake or redshift (a CSV file per table with the warehouse DDL and load script; only CSV files are written, not Parquet)")
+	fs.StringVar(&o.sqlStatements, "sql-statements", "copy", "With -format sql, how rows are written: copy (COPY ... FROM stdin, fastest) or insert (multi-row INSERT statements)")
+	fs.BoolVar(&o.gzip, "gzip", false, "With -format sql, csv or jsonl, gzip-compress the files of table data")
+	fs.StringVar(&o.csvDelimiter, "csv-delimiter", ",", "With -format csv, the field delimiter: a single character, or tab")
+	fs.BoolVar(&o.csvHeader, "csv-header", true, "With -format csv, write the column names as the first row")
+	fs.StringVar(&o.csvNull, "csv-null", "", "With -format csv, the text of NULL values (default: empty; empty strings are then quoted)")
+	fs.StringVar(&o.warehouseStage, "warehouse-stage", "", "With -format snowflake, the stage the files are uploaded to (default: @~/dbmigrate); with -format redshift, the S3 prefix they are uploaded to (s3://bucket/path, required)")
+	fs.StringVar(&o.warehouseIAMRole, "warehouse-iam-role", "", "With -format redshift, the ARN of the IAM role COPY reads the S3 prefix with (default: the default role of the cluster)")
+	fs.StringVar(&o.sourceDsn, "source-dsn", "", "SQL Server connection string (default: SOURCE_DB_DSN environment variable)")
+	fs.StringVar(&o.endpointProfile, "endpoint-profile", dbmigrate.EndpointAuto, "Connection parameter preset for the SQL Server host: auto (detect from the host name), none, aws-rds, azure-sql or gcp-cloudsql")
+	fs.StringVar(&o.schemas, "schemas", "dbo", "Comma-separated list of schemas to archive")
+	fs.StringVar(&o.tables, "tables", "", "Comma-separated list of tables to archive, with '*' wildcards or re: regular expressions (default: all tables of -schemas)")
+	fs.StringVar(&o.excludeTables, "exclude-tables", "", "Comma-separated list of tables to leave out, with '*' wildcards or re: regular expressions")
+	fs.StringVar(&o.indexes, "indexes", dbmigrate.IndexesAfterData, "Whether to archive the secondary indexes and unique constraints: none, or schema and after-data (both restored after the data)")
+	fs.StringVar(&o.config, "config", "", "Path to a YAML configuration file")
+	fs.StringVar(&o.schemaMap, "schema-map", "", "Comma-separated list of source=target schema mappings (e.g., dbo=public)")
+	fs.StringVar(&o.typeMap, "type-map", "", "Comma-separated list of source=target type mappings overriding the built-in ones")
+	fs.BoolVar(&o.preserveCase, "preserve-case", false, "Preserve case sensitivity of identifiers using double quotes")
+	fs.BoolVar(&o.strictTypes, "strict-types", false, "Fail on source types without a mapping (the default unless -coerce-unknown-to-text)")
+	fs.BoolVar(&o.coerceUnknownTypes, "coerce-unknown-to-text", false, "Create columns of source types without a mapping as TEXT instead of failing")
+	fs.StringVar(&o.datetimeType, "datetime-type", "timestamptz", "Target type of datetime and datetime2 columns: timestamptz or timestamp (without time zone)")
+	fs.StringVar(&o.sourceTimezone, "source-timezone", "UTC", "Time zone of the source datetime and datetime2 values (with -datetime-type timestamptz)")
+	fs.StringVar(&o.hierarchyid, "hierarchyid", "text", "Target type of hierarchyid columns: text or ltree")
+	fs.StringVar(&o.invalidText, "invalid-text", "fail", "How to handle text values with NUL bytes or invalid UTF-8: fail, strip or replace")
+	return fs, o
+}
+
+// runArchive implements the archive subcommand, which writes the schema and
+// data of the source tables, converted as the data migration converts them, to
+// a pg_dump directory-format archive for pg_restore or to SQL files for psql
+// instead of a target database
+func runArchive(args []string) {
+	fs, o := archiveFlags()
+	fs.Parse(args)
+	o.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { o.set[f.Name] = true })
+	o.run()
+}
+
+// runFileOutput implements -output sql, csv and jsonl of the data migration,
+// which write files like archive -format sql, csv or jsonl, with the flags set
+// on the command line
+func runFileOutput(format, outDir string) {
+	fs, o := archiveFlags()
+	o.set = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		switch {
+		case f.Name == "output" || f.Name == "out-dir":
+		case fs.Lookup(f.Name) == nil:
+			log.Fatalf("-%s is not supported with -output %s", f.Name, format)
+		default:
+			fs.Set(f.Name, f.Value.String())
+			o.set[f.Name] = true
+		}
+	})
+	fs.Set("format", format)
+	fs.Set("out", outDir)
+	o.run()
+}
+
+// run archives the selected tables in the chosen format
+func (o *archiveOptions) run() {
+	if o.out == "" {
+		log.Fatal("-out is required")
+	}
+	switch o.format {
+	case archiveFormatDirectory, archiveFormatSQL, archiveFormatCSV, archiveFormatJSONL:
+	case archiveFormatSnowflake:
+		if o.warehouseStage == "" {
+			o.warehouseStage = "@~/dbmigrate"
+		}
+	case archiveFormatRedshift:
+		if !strings.HasPrefix(o.warehouseStage, "s3://") {
+			log.Fatal("-format redshift requires -warehouse-stage with the S3 prefix the files are uploaded to (s3://bucket/path)")
+		}
+	default:
+		log.Fatalf("Invalid -format value: %s (expected %s, %s, %s, %s, %s or %s)", o.format, archiveFormatDirectory, archiveFormatSQL, archiveFormatCSV, archiveFormatJSONL, archiveFormatSnowflake, archiveFormatRedshift)
+	}
+	if o.sqlStatements != "copy" && o.sqlStatements != "insert" {
+		log.Fatalf("Invalid -sql-statements value: %s (expected copy or insert)", o.sqlStatements)
+	}
+	if o.strictTypes && o.coerceUnknownTypes {
+		log.Fatalf("-strict-types cannot be combined with -coerce-unknown-to-text")
+	}
+	csvOptions, err := parseCSVOptions(o.csvDelimiter, o.csvHeader, o.csvNull)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	sourceDsn := o.sourceDsn
+	if sourceDsn == "" {
+		sourceDsn = os.Getenv("SOURCE_DB_DSN")
+	}
+	if sourceDsn == "" {
+		log.Fatal("No source database connection. Set SOURCE_DB_DSN environment variable or use -source-dsn flag.")
+	}
+
+	var cfg *dbmigrate.Config
+	if o.config != "" {
+		var err error
+		if cfg, err = dbmigrate.LoadConfig(o.config); err != nil {
+			log.Fatalf("Error loading config file: %v", err)
+		}
+		// Values from the config file only apply when the flag was not set explicitly
+		if !o.set["schemas"] && len(cfg.Schemas) > 0 {
+			o.schemas = strings.Join(cfg.Schemas, ",")
+		}
+		if !o.set["tables"] && len(cfg.IncludeTables) > 0 {
+			o.tables = strings.Join(cfg.IncludeTables, ",")
+		}
+		if !o.set["exclude-tables"] && len(cfg.ExcludeTables) > 0 {
+			o.excludeTables = strings.Join(cfg.ExcludeTables, ",")
+		}
+	}
+	mapper, err := dbmigrate.NewNameMapper(cfg, o.schemaMap)
+	if err != nil {
+		log.Fatalf("Error parsing name mappings: %v", err)
+	}
+	typeMapper, err := dbmigrate.NewTypeMapper(cfg, o.typeMap)
+	if err != nil {
+		log.Fatalf("Error parsing type mappings: %v", err)
+	}
+	indexes, err := dbmigrate.ParseIndexes(o.indexes)
+	if err != nil {
+		log.Fatalf("Error parsing -indexes: %v", err)
+	}
+	datetimeType, err := dbmigrate.ParseDatetimeType(o.datetimeType)
+	if err != nil {
+		log.Fatalf("Error parsing -datetime-type: %v", err)
+	}
+	hierarchyid, err := dbmigrate.ParseHierarchyid(o.hierarchyid)
+	if err != nil {
+		log.Fatalf("Error parsing -hierarchyid: %v", err)
+	}
+	sourceTimezone, err := time.LoadLocation(o.sourceTimezone)
+	if err != nil {
+		log.Fatalf("Error parsing -source-timezone: %v", err)
+	}
+	invalidText, err := parseInvalidText(o.invalidText)
+	if err != nil {
+		log.Fatalf("Error parsing -invalid-text: %v", err)
+	}
+
+	sourceDsn, err = dbmigrate.PrepareSqlServerDsn(sourceDsn, o.endpointProfile)
+	if err != nil {
+		log.Fatalf("Error in source connection string: %v", err)
+	}
+	sourceDsn = dbmigrate.TagSqlServerDsn(sourceDsn, "dbmigrate-archive")
+	sourceDb, err := dbmigrate.OpenReadOnlySqlServer(sourceDsn)
+	if err != nil {
+		log.Fatalf("Error connecting to source database: %v", err)
+	}
+	defer sourceDb.Close()
+	if err := sourceDb.Ping(); err != nil {
+		log.Fatalf("Error connecting to source database: %v", err)
+	}
+	fmt.Println("✅ Connected to SQL Server source database (read-only)")
+
+	tables, err := spoolTables(sourceDb, o.schemas, o.tables)
+	if err != nil {
+		log.Fatalf("Error getting tables: %v", err)
+	}
+	if o.excludeTables != "" {
+		excluded, unmatched, err := dbmigrate.ResolveTableNames(strings.Split(o.excludeTables, ","), tables)
+		if err != nil {
+			log.Fatalf("Error in -exclude-tables: %v", err)
+		}
+		for _, name := range unmatched {
+			log.Printf("Warning: -exclude-tables entry %s matched no table in schemas %s", name, o.schemas)
+		}
+		tables = withoutTables(tables, excluded)
+	}
+	var schemas []string
+	for _, table := range tables {
+		if schema := strings.SplitN(table, ".", 2)[0]; !slices.Contains(schemas, schema) {
+			schemas = append(schemas, schema)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	m := &migrator{
+		ctx:                ctx,
+		source:             dbmigrate.SQLServer,
+		sourceDb:           sourceDb,
+		schemas:            schemas,
+		tables:             tables,
+		mapper:             mapper,
+		typeMapper:         typeMapper,
+		config:             cfg,
+		indexes:            indexes,
+		invalidText:        invalidText,
+		datetimeType:       datetimeType,
+		hierarchyid:        hierarchyid,
+		sourceTimezone:     sourceTimezone,
+		preserveCase:       o.preserveCase,
+		coerceUnknownTypes: o.coerceUnknownTypes,
+		computedColumns:    dbmigrate.ComputedMaterialize,
+		columnSets:         dbmigrate.ColumnSetsSkip,
+		rowversion:         dbmigrate.RowversionExclude,
+		xmlType:            dbmigrate.XmlTypeXML,
+	}
+	write := m.writeArchive
+	switch o.format {
+	case archiveFormatSQL:
+		write = func(dir string) error { return m.writeSQLFiles(dir, o.sqlStatements == "insert", o.gzip) }
+	case archiveFormatCSV:
+		write = func(dir string) error { return m.writeCSVFiles(dir, csvOptions, o.gzip) }
+	case archiveFormatJSONL:
+		write = func(dir string) error { return m.writeJSONLFiles(dir, o.gzip) }
+	case archiveFormatSnowflake, archiveFormatRedshift:
+		write = func(dir string) error {
+			return m.writeWarehouseFiles(dir, o.format, csvOptions, o.gzip, o.warehouseStage, o.warehouseIAMRole)
+		}
+	}
+	if err := write(o.out); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+// writeArchive writes the selected tables to a directory archive: the
+// extensions, schemas and tables in the pre-data section, the rows of each
+// table in the data section and its secondary indexes in the post-data section
+func (m *migrator) writeArchive(dir string) error {
+	start := time.Now()
+	var database, version string
+	if err := m.sourceDb.QueryRowContext(m.ctx, "SELECT DB_NAME(), CAST(SERVERPROPERTY('ProductVersion') AS nvarchar(128))").Scan(&database, &version); err != nil {
+		return fmt.Errorf("error reading the source database name: %v", err)
+	}
+	archive, err := dbmigrate.NewDumpArchive(dir, database, "SQL Server "+version)
+	if err != nil {
+		return err
+	}
+
+	// The statements of each table follow its CREATE TABLE statement
+	options := m.schemaOptions()
+	options.IfNotExists = false
+	statements, err := dbmigrate.GenerateSchema(m.sourceDb, options)
+	if err != nil {
+		return fmt.Errorf("error generating schema: %v", withTypeMappingHint(err))
+	}
+	type archivedTable struct {
+		schema, name, target string
+		statements           []string
+	}
+	byCreate := make(map[string]*archivedTable)
+	var archived []*archivedTable
+	for _, table := range m.tables {
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		t := &archivedTable{schema: targetSchema, name: targetTable, target: dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)}
+		byCreate["CREATE TABLE "+t.target] = t
+		archived = append(archived, t)
+	}
+	var preamble []int // extensions and schemas, which every table depends on
+	var current *archivedTable
+	for _, statement := range statements {
+		head, _, _ := strings.Cut(statement, " (\n")
+		switch {
+		case strings.HasPrefix(statement, "CREATE EXTENSION "):
+			name := statement[strings.LastIndex(statement, " ")+1:]
+			preamble = append(preamble, archive.AddEntry("EXTENSION", name, "", statement+";\n", "", dbmigrate.DumpSectionPreData))
+		case strings.HasPrefix(statement, "CREATE SCHEMA "):
+			name := statement[strings.LastIndex(statement, " ")+1:]
+			preamble = append(preamble, archive.AddEntry("SCHEMA", strings.Trim(name, `"`), "", statement+";\n", "", dbmigrate.DumpSectionPreData))
+		case byCreate[head] != nil:
+			current = byCreate[head]
+			current.statements = append(current.statements, statement)
+		case current != nil:
+			current.statements = append(current.statements, statement)
+		default:
+			return fmt.Errorf("unexpected schema statement %q", firstLine(statement))
+		}
+	}
+	tableIDs := make([]int, len(archived))
+	for i, t := range archived {
+		defn := strings.Join(t.statements, ";\n\n") + ";\n"
+		tableIDs[i] = archive.AddTable(t.schema, t.name, defn, "DROP TABLE "+t.target+";\n", preamble...)
+	}
+	fmt.Printf("Archived the schema of %d tables\n", len(archived))
+
+	var totalRows int64
+	for i, table := range m.tables {
+		t := archived[i]
+		rows, err := m.archiveTableData(table, func(columns []string) (rowWriter, error) {
+			return archive.AddTableData(tableIDs[i], t.schema, t.name, t.target, m.quoteColumns(columns))
+		})
+		if err != nil {
+			return fmt.Errorf("error archiving the data of %s: %v", table, err)
+		}
+		totalRows += rows
+	}
+
+	if m.indexes != dbmigrate.IndexesNone {
+		count := 0
+		for i, table := range m.tables {
+			statements, err := m.indexStatements(table)
+			if err != nil {
+				return err
+			}
+			for _, statement := range statements {
+				name := ""
+				if match := indexNamePattern.FindStringSubmatch(statement); match != nil {
+					name = strings.Trim(match[1], `"`)
+				}
+				archive.AddEntry("INDEX", name, archived[i].schema, statement+";\n", "", dbmigrate.DumpSectionPostData, tableIDs[i])
+				count++
+			}
+		}
+		fmt.Printf("Archived %d indexes\n", count)
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Archived %d rows from %d tables to %s in %s\n", totalRows, len(m.tables), dir, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("Restore it with: pg_restore -d <database> -j 4 %s\n", dir)
+	return nil
+}
+
+// writeSQLFiles writes the selected tables to numbered SQL files that psql
+// applies in order: the schema to 000_schema.sql, the rows of each table to a
+// file of its own and the secondary indexes to 999_indexes.sql
+func (m *migrator) writeSQLFiles(dir string, insert, compress bool) error {
+	start := time.Now()
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating output directory: %v", err)
+	}
+	writeScript := func(name string, statements []string) error {
+		var b strings.Builder
+		b.WriteString("SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n\n")
+		for _, statement := range statements {
+			b.WriteString(statement + ";\n\n")
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(b.String()), 0o644); err != nil {
+			return fmt.Errorf("error writing %s: %v", name, err)
+		}
+		return nil
+	}
+
+	options := m.schemaOptions()
+	options.IfNotExists = true
+	statements, err := dbmigrate.GenerateSchema(m.sourceDb, options)
+	if err != nil {
+		return fmt.Errorf("error generating schema: %v", withTypeMappingHint(err))
+	}
+	if err := writeScript("000_schema.sql", statements); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the schema of %d tables to 000_schema.sql\n", len(m.tables))
+
+	extension := compressedExtension(".sql", compress)
+	var totalRows int64
+	for i, table := range m.tables {
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+		name := fmt.Sprintf("%03d_%s.%s%s", i+1, targetSchema, targetTable, extension)
+		rows, err := m.archiveTableData(table, func(columns []string) (rowWriter, error) {
+			return dbmigrate.CreateSQLTableFile(filepath.Join(dir, name), target, m.quoteColumns(columns), insert, compress)
+		})
+		if err != nil {
+			return fmt.Errorf("error writing the data of %s: %v", table, err)
+		}
+		totalRows += rows
+	}
+
+	if m.indexes != dbmigrate.IndexesNone {
+		var indexes []string
+		for _, table := range m.tables {
+			statements, err := m.indexStatements(table)
+			if err != nil {
+				return err
+			}
+			indexes = append(indexes, statements...)
+		}
+		if err := writeScript("999_indexes.sql", indexes); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d indexes to 999_indexes.sql\n", len(indexes))
+	}
+
+	fmt.Printf("✅ Wrote %d rows from %d tables to %s in %s\n", totalRows, len(m.tables), dir, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("Apply them with: for f in %s/*; do zcat -f \"$f\" | psql -v ON_ERROR_STOP=1 -d <database> || break; done\n", dir)
+	return nil
+}
+
+// writeTableFiles writes the rows of each selected table to a file of its own
+// in dir, named after the target table with extension, to the writer create
+// returns for its path and the target names of its source columns
+func (m *migrator) writeTableFiles(dir, extension string, create func(table, path string, columns []string) (rowWriter, error)) error {
+	start := time.Now()
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating output directory: %v", err)
+	}
+	var totalRows int64
+	for _, table := range m.tables {
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		path := filepath.Join(dir, targetSchema+"."+targetTable+extension)
+		rows, err := m.archiveTableData(table, func(columns []string) (rowWriter, error) {
+			names := make([]string, len(columns))
+			for i, column := range columns {
+				names[i] = column
+				if !m.preserveCase {
+					names[i] = strings.ToLower(column)
+				}
+			}
+			return create(table, path, names)
+		})
+		if err != nil {
+			return fmt.Errorf("error writing the data of %s: %v", table, err)
+		}
+		totalRows += rows
+	}
+	fmt.Printf("✅ Wrote %d rows from %d tables to %s in %s\n", totalRows, len(m.tables), dir, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// writeCSVFiles writes the rows of each selected table to a CSV file
+func (m *migrator) writeCSVFiles(dir string, opts dbmigrate.CSVOptions, compress bool) error {
+	return m.writeTableFiles(dir, compressedExtension(".csv", compress), func(table, path string, columns []string) (rowWriter, error) {
+		return dbmigrate.CreateCSVTableFile(path, columns, opts, compress)
+	})
+}
+
+// writeWarehouseFiles writes the selected tables for loading into a Snowflake
+// or Redshift warehouse: the rows of each table to a CSV file, the DDL of the
+// tables to 000_schema.sql and the statements staging and loading the files to
+// 001_load.sql
+func (m *migrator) writeWarehouseFiles(dir, warehouse string, opts dbmigrate.CSVOptions, compress bool, stage, iamRole string) error {
+	extension := compressedExtension(".csv", compress)
+	columns := make(map[string][]string) // target names of the columns of each file
+	err := m.writeTableFiles(dir, extension, func(table, path string, names []string) (rowWriter, error) {
+		columns[table] = names
+		return dbmigrate.CreateCSVTableFile(path, names, opts, compress)
+	})
+	if err != nil {
+		return err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %v", dir, err)
+	}
+
+	var schema, load []string
+	schemas := make(map[string]bool)
+	for _, table := range m.tables {
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		tableColumns, err := m.source.Columns(m.sourceDb, table, m.schemaOptions())
+		if err != nil {
+			return fmt.Errorf("error getting columns for table %s: %v", table, err)
+		}
+		statements := dbmigrate.WarehouseCreateTable(warehouse, targetSchema, targetTable, tableColumns, m.preserveCase)
+		if schemas[strings.ToLower(targetSchema)] {
+			statements = statements[1:]
+		}
+		schemas[strings.ToLower(targetSchema)] = true
+		schema = append(schema, statements...)
+
+		file := targetSchema + "." + targetTable + extension
+		load = append(load, dbmigrate.WarehouseLoadStatements(warehouse, dbmigrate.WarehouseLoad{
+			Table:      dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase),
+			Columns:    m.quoteColumns(columns[table]),
+			Path:       filepath.Join(absDir, file),
+			File:       file,
+			Stage:      stage,
+			IAMRole:    iamRole,
+			CSV:        opts,
+			Compressed: compress,
+		})...)
+	}
+	for name, statements := range map[string][]string{"000_schema.sql": schema, "001_load.sql": load} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(statements, ";\n\n")+";\n"), 0o644); err != nil {
+			return fmt.Errorf("error writing %s: %v", name, err)
+		}
+	}
+	fmt.Printf("Wrote the %s DDL of %d tables to 000_schema.sql and their load statements to 001_load.sql\n", warehouse, len(m.tables))
+	if warehouse == dbmigrate.WarehouseRedshift {
+		fmt.Printf("Upload the files with: aws s3 cp --recursive --exclude '*.sql' %s %s\n", dir, stage)
+		fmt.Printf("Then load them with: psql -v ON_ERROR_STOP=1 -d <cluster> -f %s/000_schema.sql -f %s/001_load.sql\n", dir, dir)
+	} else {
+		fmt.Printf("Load them with: snowsql -o exit_on_error=true -f %s/000_schema.sql && snowsql -o exit_on_error=true -f %s/001_load.sql\n", dir, dir)
+	}
+	return nil
+}
+
+// writeJSONLFiles writes the rows of each selected table to a JSON Lines file,
+// with the values of decimal and money columns as numbers
+func (m *migrator) writeJSONLFiles(dir string, compress bool) error {
+	return m.writeTableFiles(dir, compressedExtension(".jsonl", compress), func(table, path string, columns []string) (rowWriter, error) {
+		columnTypes, err := m.getColumnTypes(table)
+		if err != nil {
+			return nil, err
+		}
+		numeric := make([]bool, len(columns))
+		for i, column := range columns {
+			for _, columnType := range columnTypes {
+				if strings.EqualFold(columnType[0], column) && numericTypes[strings.ToLower(columnType[1])] {
+					numeric[i] = true
+				}
+			}
+		}
+		return dbmigrate.CreateJSONLTableFile(path, columns, numeric, compress)
+	})
+}
+
+// compressedExtension returns the file name extension of gzip-compressed files
+// if compress is set
+func compressedExtension(extension string, compress bool) string {
+	if compress {
+		return extension + ".gz"
+	}
+	return extension
+}
+
+// quoteColumns returns the quoted target names of source columns
+func (m *migrator) quoteColumns(columns []string) []string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = dbmigrate.QuoteIdent(column, m.preserveCase)
+	}
+	return quoted
+}
+
+// rowWriter writes the rows of a table to a file of an archive
+type rowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// archiveTableData writes the rows of a table, converted like the data
+// migration converts them, to the writer open returns for its source columns
+func (m *migrator) archiveTableData(table string, open func(columns []string) (rowWriter, error)) (int64, error) {
+	columns, err := m.sourceColumns(table)
+	if err != nil {
+		return 0, err
+	}
+	transcode, err := getTranscodeColumns(m.sourceDb, table)
+	if err != nil {
+		return 0, err
+	}
+	exprs, err := m.sourceExprs(table, transcode)
+	if err != nil {
+		return 0, err
+	}
+	transformRow, err := m.rowTransform(table, columns, make(map[string]int64), make(map[string]int64), make(map[string]string))
+	if err != nil {
+		return 0, err
+	}
+	selectList := make([]string, len(columns))
+	for i, column := range columns {
+		selectList[i] = sourceColumnExpr(m.source, column, exprs)
+	}
+
+	data, err := open(columns)
+	if err != nil {
+		return 0, err
+	}
+	parts := strings.SplitN(table, ".", 2)
+	rows, err := m.sourceDb.QueryContext(m.ctx, fmt.Sprintf("SELECT %s FROM [%s].[%s]", strings.Join(selectList, ", "), parts[0], parts[1]))
+	if err != nil {
+		data.Close()
+		return 0, fmt.Errorf("error querying source table: %v", err)
+	}
+	defer rows.Close()
+	var count int64
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			data.Close()
+			return 0, fmt.Errorf("error scanning row: %v", err)
+		}
+		if transformRow != nil {
+			if err := transformRow(values); err != nil {
+				data.Close()
+				return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", count+1), row: formatSampleRow(columns, values), err: err}
+			}
+		}
+		if err := data.WriteRow(values); err != nil {
+			data.Close()
+			return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", count+1), row: formatSampleRow(columns, values), err: err}
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		data.Close()
+		if m.ctx.Err() != nil {
+			return 0, m.ctx.Err()
+		}
+		return 0, fmt.Errorf("error reading source rows: %v", err)
+	}
+	if err := data.Close(); err != nil {
+		return 0, err
+	}
+	fmt.Printf("✅ Archived %d rows of %s\n", count, table)
+	return count, nil
+}
+
+// parseCSVOptions validates the -csv-delimiter, -csv-header and -csv-null
+// flags of the CSV files written by archive and read by load
+func parseCSVOptions(delimiter string, header bool, null string) (dbmigrate.CSVOptions, error) {
+	if delimiter == "tab" {
+		delimiter = "\t"
+	}
+	if len(delimiter) != 1 || strings.ContainsAny(delimiter, "\"\r\n") {
+		return dbmigrate.CSVOptions{}, fmt.Errorf("invalid -csv-delimiter value: %q (expected a single character other than a quote or line break)", delimiter)
+	}
+	if strings.Contains(null, delimiter) {
+		return dbmigrate.CSVOptions{}, fmt.Errorf("-csv-null must not contain the delimiter")
+	}
+	return dbmigrate.CSVOptions{Delimiter: delimiter[0], Header: header, Null: null}, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// parseAuditBackfill validates an -audit-backfill value: now (the start of the
+// run) or a timestamp, read in the source time zone unless it has an offset.
+// It returns nil for an empty value.
+func parseAuditBackfill(value string, zone *time.Location, start time.Time) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(value, "now") {
+		return &start, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return &t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, zone); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid timestamp: %s (expected now, 2006-01-02, 2006-01-02 15:04:05 or RFC 3339)", value)
+}
+
+// auditBackfillTransform returns a row transform setting the NULL values of the
+// columns of a table whose current date and time default is translated (audit
+// columns such as CreatedAt) to -audit-backfill, counting them by column in
+// counts, or nil if disabled or the table has no such column
+func (m *migrator) auditBackfillTransform(table string, columns []string, counts map[string]int64) (func(values []interface{}) error, error) {
+	if m.auditBackfill == nil {
+		return nil, nil
+	}
+	sourceColumns, err := m.source.Columns(m.sourceDb, table, m.schemaOptions())
+	if err != nil {
+		return nil, err
+	}
+	audit := make(map[string]bool)
+	for _, column := range sourceColumns {
+		if _, ok := dbmigrate.TranslateDateDefault(column.Default, column.TargetType); ok {
+			audit[strings.ToLower(column.Name)] = true
+		}
+	}
+	var indexes []int
+	for i, column := range columns {
+		if audit[strings.ToLower(column)] {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	for _, i := range indexes {
+		fmt.Printf("Backfilling NULL values of audit column %s with %s\n", columns[i], m.auditBackfill.Format(time.RFC3339))
+	}
+	backfill := *m.auditBackfill
+	return func(values []interface{}) error {
+		for _, i := range indexes {
+			if values[i] == nil {
+				values[i] = backfill
+				counts[columns[i]]++
+			}
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// blobStreamer copies large binary values (varbinary(max) and image) in chunks,
+// so a row's blob is never held in memory in full. Values up to chunkSize bytes
+// are read with the row; larger values are inserted as NULL and then appended to
+// the target row chunk by chunk, read from the source by primary key.
+type blobStreamer struct {
+	columns      []string // large binary columns
+	chunkSize    int64
+	key          *primaryKey
+	source       string // source table as [schema].[table]
+	target       string // quoted target table
+	preserveCase bool
+	onChunk      func(bytes int64) // called after each chunk written, may be nil
+}
+
+// newBlobStreamer returns a streamer for the large binary columns of a source
+// table, or nil if there are none. Tables without a primary key cannot look up
+// chunks, so their blobs are read in full.
+func newBlobStreamer(db *sql.DB, table string, columns []string, chunkSize int64, target string, preserveCase bool) (*blobStreamer, error) {
+	if chunkSize <= 0 {
+		return nil, nil
+	}
+	parts := strings.SplitN(table, ".", 2)
+	query := `
+		SELECT c.COLUMN_NAME
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		WHERE c.TABLE_SCHEMA = @p1 AND c.TABLE_NAME = @p2
+		AND ` + dbmigrate.DataTypeExpr + ` IN ('image', 'varbinary')
+		AND c.CHARACTER_MAXIMUM_LENGTH IN (-1, 2147483647)
+		ORDER BY c.ORDINAL_POSITION`
+	rows, err := db.Query(query, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting binary columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	migrated := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		migrated[column] = true
+	}
+	streamer := &blobStreamer{chunkSize: chunkSize, source: fmt.Sprintf("[%s].[%s]", parts[0], parts[1]), target: target, preserveCase: preserveCase}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		if migrated[column] {
+			streamer.columns = append(streamer.columns, column)
+		}
+	}
+	if err := rows.Err(); err != nil || len(streamer.columns) == 0 {
+		return nil, err
+	}
+
+	pkColumns, err := dbmigrate.SQLServer.PrimaryKey(db, table)
+	if err != nil {
+		return nil, err
+	}
+	if streamer.key = newPrimaryKey(pkColumns, columns); streamer.key == nil {
+		fmt.Printf("Table %s has no primary key, reading binary columns %s in full\n", table, strings.Join(streamer.columns, ", "))
+		return nil, nil
+	}
+	fmt.Printf("Streaming binary columns %s in chunks of %d bytes\n", strings.Join(streamer.columns, ", "), chunkSize)
+	return streamer, nil
+}
+
+// has tells whether column is streamed
+func (b *blobStreamer) has(column string) bool {
+	for _, c := range b.columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceExpr reads a streamed column with the row only if it fits in one chunk
+func (b *blobStreamer) sourceExpr(column string) string {
+	return fmt.Sprintf("CASE WHEN DATALENGTH([%s]) > %d THEN NULL ELSE [%s] END AS [%s]", column, b.chunkSize, column, column)
+}
+
+// lengthExprs returns the expressions selecting the length of each streamed
+// column, read after the migrated columns
+func (b *blobStreamer) lengthExprs() []string {
+	exprs := make([]string, len(b.columns))
+	for i, column := range b.columns {
+		exprs[i] = fmt.Sprintf("DATALENGTH([%s])", column)
+	}
+	return exprs
+}
+
+// stream appends the values larger than one chunk to the inserted target row,
+// given the row's values and the lengths read with lengthExprs. Returns the
+// number of bytes copied.
+func (b *blobStreamer) stream(ctx context.Context, sourceDb *sql.DB, tx *sql.Tx, values []interface{}, lengths []interface{}) (int64, error) {
+	var sourceConditions []string
+	for i, column := range b.key.columns {
+		sourceConditions = append(sourceConditions, fmt.Sprintf("[%s] = @p%d", column, i+3))
+	}
+	targetCondition := b.key.targetCondition(2, b.preserveCase)
+	key := b.key.values(values)
+
+	var copied int64
+	for i, column := range b.columns {
+		length, _ := lengths[i].(int64)
+		if length <= b.chunkSize {
+			continue
+		}
+		sourceQuery := fmt.Sprintf("SELECT SUBSTRING([%s], @p1, @p2) FROM %s WHERE %s", column, b.source, strings.Join(sourceConditions, " AND "))
+		quoted := dbmigrate.QuoteIdent(column, b.preserveCase)
+		targetQuery := fmt.Sprintf("UPDATE %s SET %s = COALESCE(%s, ''::bytea) || $1 WHERE %s", b.target, quoted, quoted, targetCondition)
+
+		for offset := int64(0); offset < length; offset += b.chunkSize {
+			var chunk []byte
+			args := append([]interface{}{offset + 1, b.chunkSize}, key...)
+			if err := sourceDb.QueryRowContext(ctx, sourceQuery, args...).Scan(&chunk); err != nil {
+				return copied, fmt.Errorf("error reading %s at offset %d: %v", column, offset, err)
+			}
+			if _, err := tx.ExecContext(ctx, targetQuery, append([]interface{}{chunk}, key...)...); err != nil {
+				return copied, fmt.Errorf("error writing %s at offset %d: %v", column, offset, err)
+			}
+			copied += int64(len(chunk))
+			if b.onChunk != nil {
+				b.onChunk(int64(len(chunk)))
+			}
+		}
+	}
+	return copied, nil
+}
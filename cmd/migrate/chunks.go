@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// chunkKeyPrefix prefixes the state store keys of the chunk checksums of a
+// table; chunkGenerationPrefix those recording which load of a table the
+// chunks belong to, as restarting a table starts a new generation
+const (
+	chunkKeyPrefix        = "chunk:"
+	chunkGenerationPrefix = "chunks:"
+)
+
+// maxChunkLookupKeys limits how many keys are looked up in one target query
+const maxChunkLookupKeys = 1000
+
+// chunkRecord is the checksum of one committed batch of a table, stored as JSON
+// in the checkpoint value
+type chunkRecord struct {
+	Generation string `json:"generation"`
+	// Where is the slice condition the batch was read with, if any
+	Where string `json:"where,omitempty"`
+	// After is the encoded key the batch starts after, empty for the first
+	// batch; Last is the encoded key of its last row
+	After string `json:"after,omitempty"`
+	Last  string `json:"last,omitempty"`
+	// Read counts the source rows of the batch, Rows the hashed rows
+	Read     int    `json:"read"`
+	Rows     int    `json:"rows"`
+	Rejected int    `json:"rejected,omitempty"`
+	Checksum string `json:"checksum"`
+	// Skipped are the columns left out of the checksum
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// chunkChecksums computes a checksum of the rows of each batch of a table as
+// they are written, checks it against the rows read back from the target once
+// the batch commits and records it in the state store. The records are saved
+// together by flush when the table is done, rather than one state store write
+// per batch; those of a run killed mid-table are lost, which only leaves
+// fewer chunks for verification to choose from.
+type chunkChecksums struct {
+	m             *migrator
+	table, target string
+	generation    string
+	columns       []string
+	types         []string // lowercase source types by column
+	skip          []bool   // columns left out of the checksum
+	key           *primaryKey
+	targetColumns []string
+
+	where    string
+	after    string // encoded key the current batch starts after
+	sum      uint64
+	read     int
+	rows     int
+	rejected int
+	keys     [][]interface{}
+
+	pending        []dbmigrate.Checkpoint // records of the batches not saved yet
+	chunks, failed int
+}
+
+// newChunkChecksums returns the chunk checksums of a table loaded into the
+// target table as part of generation, or nil if the table has no primary key to
+// read batches back by
+func (m *migrator) newChunkChecksums(table, target, generation string, columns []string, blobs *blobStreamer) (*chunkChecksums, error) {
+	key, err := m.primaryKey(table, columns)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		fmt.Printf("Table %s has no migrated primary key, chunk checksums are skipped\n", table)
+		return nil, nil
+	}
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(columnTypes))
+	for _, column := range columnTypes {
+		types[column[0]] = strings.ToLower(column[1])
+	}
+
+	c := &chunkChecksums{m: m, table: table, target: target, generation: generation, columns: columns, key: key}
+	for _, column := range columns {
+		c.types = append(c.types, types[column])
+		// Streamed blobs are not part of the rows as read, and PostGIS
+		// formats geometries differently from SQL Server
+		skip := (blobs != nil && blobs.has(column)) || (m.postgis && dbmigrate.IsSpatial(types[column]))
+		c.skip = append(c.skip, skip)
+		c.targetColumns = append(c.targetColumns, dbmigrate.QuoteIdent(column, m.preserveCase))
+	}
+	return c, nil
+}
+
+// chunkGeneration returns the generation of the chunks of a table being loaded:
+// a resumed load continues the previous one, a restarted load replaces its chunks
+func (m *migrator) chunkGeneration(table string, resumed bool) string {
+	if cp, ok := m.checkpoint(chunkGenerationPrefix + table); ok && resumed {
+		return cp.Value
+	}
+	m.saveCheckpoint(dbmigrate.Checkpoint{Table: chunkGenerationPrefix + table, Value: m.runID})
+	return m.runID
+}
+
+// start begins a slice of the table read with where after key
+func (c *chunkChecksums) start(where string, after []interface{}) {
+	c.where, c.after = where, ""
+	if after != nil {
+		c.after, _ = encodeKey(after)
+	}
+}
+
+// wrap returns a row transform hashing each row transform accepts
+func (c *chunkChecksums) wrap(transform func(values []interface{}) error) func(values []interface{}) error {
+	return func(values []interface{}) error {
+		c.read++
+		if transform != nil {
+			if err := transform(values); err != nil {
+				return err
+			}
+		}
+		c.sum += rowChecksum(values, c.types, c.skip)
+		c.rows++
+		c.keys = append(c.keys, c.key.values(values))
+		return nil
+	}
+}
+
+// reject takes a row that failed to insert out of the current batch. A row
+// the transform failed was not hashed; one that failed to insert, which may be
+// found after other rows were hashed, is looked up by its key.
+func (c *chunkChecksums) reject(values []interface{}) {
+	c.rejected++
+	rejected, err := encodeKey(c.key.values(values))
+	if err != nil {
+		return
+	}
+	for i := len(c.keys) - 1; i >= 0; i-- {
+		if key, err := encodeKey(c.keys[i]); err == nil && key == rejected {
+			c.sum -= rowChecksum(values, c.types, c.skip)
+			c.rows--
+			c.keys = append(c.keys[:i], c.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+// commit checks the batch that was just committed, whose last row has lastKey,
+// against the target and records its checksum
+func (c *chunkChecksums) commit(lastKey []interface{}) {
+	if c.read == 0 {
+		return
+	}
+	record := chunkRecord{
+		Generation: c.generation,
+		Where:      c.where,
+		After:      c.after,
+		Read:       c.read,
+		Rows:       c.rows,
+		Rejected:   c.rejected,
+		Checksum:   fmt.Sprintf("%016x", c.sum),
+	}
+	for i, column := range c.columns {
+		if c.skip[i] {
+			record.Skipped = append(record.Skipped, column)
+		}
+	}
+	if lastKey != nil {
+		record.Last, _ = encodeKey(lastKey)
+	}
+	keys := c.keys
+	c.after, c.sum, c.read, c.rows, c.rejected, c.keys = record.Last, 0, 0, 0, 0, nil
+	c.chunks++
+
+	rows, sum, err := c.m.targetChecksum(c.target, c.targetColumns, c.types, c.skip, c.key, keys)
+	if err != nil {
+		log.Printf("Warning: Could not read back a batch of %s to check its checksum: %v", c.table, err)
+	} else if rows != record.Rows || fmt.Sprintf("%016x", sum) != record.Checksum {
+		c.failed++
+		fmt.Printf("❌ %s: batch after key %q differs in the target (%d of %d rows, checksum %016x instead of %s)\n",
+			c.table, record.After, rows, record.Rows, sum, record.Checksum)
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Warning: Could not record the checksum of a batch of %s: %v", c.table, err)
+		return
+	}
+	c.pending = append(c.pending, dbmigrate.Checkpoint{Table: chunkID(c.table, record), RowsMigrated: int64(record.Rows), Completed: true, Value: string(value), RunID: c.m.runID})
+}
+
+// flush saves the records of the batches committed since the last flush
+func (c *chunkChecksums) flush() {
+	if len(c.pending) == 0 || c.m.stateStore == nil {
+		return
+	}
+	if err := c.m.stateStore.SaveAll(c.pending); err != nil {
+		log.Printf("Warning: Could not record the checksums of %d batches of %s: %v", len(c.pending), c.table, err)
+	}
+	c.pending = nil
+}
+
+// chunkID returns the state store key of a chunk, which is the same for the
+// batch starting at the same key when a table is reloaded
+func chunkID(table string, record chunkRecord) string {
+	h := fnv.New64a()
+	h.Write([]byte(record.Where + "\x00" + record.After))
+	return fmt.Sprintf("%s%s:%016x", chunkKeyPrefix, table, h.Sum64())
+}
+
+// rowChecksum hashes the normalized values of a row, leaving out skipped columns
+func rowChecksum(values []interface{}, types []string, skip []bool) uint64 {
+	h := fnv.New64a()
+	for i, value := range values[:len(types)] {
+		if skip[i] {
+			continue
+		}
+		h.Write([]byte(normalizeSampleValue(value, types[i])))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// targetChecksum reads the rows with the given keys from the target table and
+// returns how many were found and the sum of their row checksums, which does not
+// depend on the order of the rows
+func (m *migrator) targetChecksum(target string, targetColumns, types []string, skip []bool, key *primaryKey, keys [][]interface{}) (int, uint64, error) {
+	keyColumns := make([]string, len(key.indexes))
+	for i, index := range key.indexes {
+		keyColumns[i] = targetColumns[index]
+	}
+
+	found := 0
+	var sum uint64
+	for len(keys) > 0 {
+		group := keys[:min(len(keys), maxChunkLookupKeys)]
+		keys = keys[len(group):]
+
+		var tuples []string
+		var args []interface{}
+		for _, key := range group {
+			var placeholders []string
+			for _, value := range key {
+				args = append(args, value)
+				placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+			}
+			tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE (%s) IN (%s)",
+			strings.Join(targetColumns, ", "), target, strings.Join(keyColumns, ", "), strings.Join(tuples, ", "))
+
+		rows, err := m.targetDb.QueryContext(m.ctx, query, args...)
+		if err != nil {
+			return found, sum, err
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(targetColumns))
+			valuePtrs := make([]interface{}, len(targetColumns))
+			for i := range values {
+				valuePtrs[i] = &values[i]
+			}
+			if err := rows.Scan(valuePtrs...); err != nil {
+				rows.Close()
+				return found, sum, err
+			}
+			sum += rowChecksum(values, types, skip)
+			found++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return found, sum, err
+		}
+	}
+	return found, sum, nil
+}
+
+// verifyChunks re-reads up to m.verifyChunks recorded chunks of a table from
+// both databases and compares them with their checksums, which finds rows
+// changed in either database since they were copied without a full table scan.
+// Returns the number of mismatched chunks.
+func (m *migrator) verifyChunks(table string, checkpoints map[string]dbmigrate.Checkpoint) (int, error) {
+	generation, ok := checkpoints[chunkGenerationPrefix+table]
+	if !ok {
+		fmt.Printf("  %s: no chunk checksums recorded\n", table)
+		return 0, nil
+	}
+	var chunks []chunkRecord
+	for key, cp := range checkpoints {
+		if !strings.HasPrefix(key, chunkKeyPrefix+table+":") {
+			continue
+		}
+		var record chunkRecord
+		if err := json.Unmarshal([]byte(cp.Value), &record); err != nil {
+			log.Printf("Warning: Could not read chunk checksum %s: %v", key, err)
+			continue
+		}
+		// Chunks with rows that failed to insert cannot match the source
+		if record.Generation == generation.Value && record.Rejected == 0 {
+			chunks = append(chunks, record)
+		}
+	}
+	rand.Shuffle(len(chunks), func(i, j int) { chunks[i], chunks[j] = chunks[j], chunks[i] })
+	chunks = chunks[:min(len(chunks), m.verifyChunkCount)]
+	if len(chunks) == 0 {
+		fmt.Printf("  %s: no chunk checksums recorded\n", table)
+		return 0, nil
+	}
+
+	parts := strings.SplitN(table, ".", 2)
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	columns, err := m.sourceColumns(table)
+	if err != nil {
+		return 0, err
+	}
+	transcode, err := getTranscodeColumns(m.sourceDb, table)
+	if err != nil {
+		return 0, err
+	}
+	exprs, err := m.sourceExprs(table, transcode)
+	if err != nil {
+		return 0, err
+	}
+	keyset, err := m.newKeysetScan(table, columns, exprs)
+	if err != nil {
+		return 0, err
+	}
+	c, err := m.newChunkChecksums(table, dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase), generation.Value, columns, nil)
+	if err != nil || c == nil || keyset == nil {
+		return 0, err
+	}
+	transform, err := m.rowTransform(table, columns, make(map[string]int64), make(map[string]int64), make(map[string]string))
+	if err != nil {
+		return 0, err
+	}
+	hash := c.wrap(transform)
+	sourceColumns := make([]string, len(columns))
+	for i, column := range columns {
+		sourceColumns[i] = sourceColumnExpr(m.source, column, exprs)
+	}
+	selectList := strings.Join(sourceColumns, ", ")
+
+	mismatches := 0
+	for _, record := range chunks {
+		skipped := make(map[string]bool, len(record.Skipped))
+		for _, column := range record.Skipped {
+			skipped[column] = true
+		}
+		for i, column := range columns {
+			c.skip[i] = skipped[column]
+		}
+
+		// Read the same rows from the source as the batch did
+		var after []interface{}
+		if record.After != "" {
+			if after, err = decodeKey(record.After); err != nil {
+				return mismatches, fmt.Errorf("error reading chunk key of %s: %v", table, err)
+			}
+		}
+		c.start(record.Where, after)
+		rows, err := m.sourceDb.QueryContext(m.ctx, keyset.query(selectList, table, record.Where, record.Read, after), after...)
+		if err != nil {
+			return mismatches, fmt.Errorf("error reading chunk of %s: %v", table, err)
+		}
+		var lastKey []interface{}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			valuePtrs := make([]interface{}, len(columns))
+			for i := range values {
+				valuePtrs[i] = &values[i]
+			}
+			if err := rows.Scan(valuePtrs...); err != nil {
+				rows.Close()
+				return mismatches, fmt.Errorf("error scanning chunk row of %s: %v", table, err)
+			}
+			lastKey = keyset.values(values)
+			hash(values)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return mismatches, fmt.Errorf("error reading chunk of %s: %v", table, err)
+		}
+		last := ""
+		if lastKey != nil {
+			last, _ = encodeKey(lastKey)
+		}
+
+		sourceChecksum := fmt.Sprintf("%016x", c.sum)
+		targetRows, targetSum, err := m.targetChecksum(c.target, c.targetColumns, c.types, c.skip, c.key, c.keys)
+		if err != nil {
+			return mismatches, fmt.Errorf("error reading chunk of %s from the target: %v", table, err)
+		}
+		c.sum, c.read, c.rows, c.keys = 0, 0, 0, nil
+
+		var problems []string
+		// Batches of tables copied in one transaction have no last key
+		if sourceChecksum != record.Checksum || (record.Last != "" && last != record.Last) {
+			problems = append(problems, "the source changed")
+		}
+		if targetRows != record.Rows || fmt.Sprintf("%016x", targetSum) != record.Checksum {
+			problems = append(problems, "the target changed")
+		}
+		if len(problems) > 0 {
+			mismatches++
+			fmt.Printf("❌ %s: chunk after key %q: %s since it was copied\n", table, record.After, strings.Join(problems, " and "))
+		}
+	}
+
+	if mismatches > 0 {
+		fmt.Printf("❌ %s: %d of %d checked chunks differ\n", table, mismatches, len(chunks))
+	} else {
+		fmt.Printf("✅ %s: %d checked chunks match their checksums\n", table, len(chunks))
+	}
+	return mismatches, nil
+}
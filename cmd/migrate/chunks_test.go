@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tendant/dbmigrate"
+)
+
+func TestChunkChecksumsWithRejectedRows(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		bad          []int64 // rows failing to insert
+		invalid      int64   // row failing the transform, or 0
+		altered      map[int64]string
+		wantRows     int
+		wantRejected int
+		wantFailed   int
+	}{
+		{"no rejected rows", nil, 0, nil, 8, 0, 0},
+		{"row failing to insert in the middle", []int64{5}, 0, nil, 7, 1, 0},
+		{"rows failing to insert and the transform", []int64{1, 6}, 3, nil, 5, 3, 0},
+		{"target differs", []int64{5}, 0, map[int64]string{2: "changed"}, 7, 1, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newCopyTarget(tt.bad...)
+			for id, name := range tt.altered {
+				target.altered[id] = name
+			}
+			columns := []string{"id", "name"}
+			m := &migrator{
+				ctx:         context.Background(),
+				source:      dbmigrate.SQLServer,
+				sourceDb:    openFakeDB(t, sqlServerStats(nil)),
+				targetDb:    openFakeDB(t, target.fakeDB),
+				columnTypes: map[string][][2]string{"dbo.Lookup": {{"id", "bigint"}, {"name", "nvarchar"}}},
+			}
+			checksums, err := m.newChunkChecksums("dbo.Lookup", "dbo.lookup", "run-1", columns, nil)
+			if err != nil || checksums == nil {
+				t.Fatalf("newChunkChecksums() = %v, %v", checksums, err)
+			}
+
+			// Wired as migrateTable does
+			checksums.start("", nil)
+			opts := copyOptions{
+				source:        dbmigrate.SQLServer,
+				fullTableName: "dbo.Lookup",
+				targetSchema:  "dbo",
+				targetTable:   "lookup",
+				columns:       columns,
+				transformRow: checksums.wrap(func(values []interface{}) error {
+					if values[0].(int64) == tt.invalid {
+						return errors.New("invalid value")
+					}
+					return nil
+				}),
+				onReject: func(values []interface{}, err error) error {
+					checksums.reject(values)
+					return nil
+				},
+				onCommit: func(rows int, bytes int64, lastKey []interface{}) { checksums.commit(lastKey) },
+			}
+			count, err := copyTableData(context.Background(), openFakeDB(t, sourceRows(8)), openFakeDB(t, target.fakeDB), opts)
+			if err != nil || count != tt.wantRows {
+				t.Fatalf("copyTableData() = %d, %v, want %d rows", count, err, tt.wantRows)
+			}
+
+			if len(checksums.pending) != 1 {
+				t.Fatalf("%d chunks recorded, want 1", len(checksums.pending))
+			}
+			var record chunkRecord
+			if err := json.Unmarshal([]byte(checksums.pending[0].Value), &record); err != nil {
+				t.Fatal(err)
+			}
+			if record.Read != 8 || record.Rows != tt.wantRows || record.Rejected != tt.wantRejected {
+				t.Errorf("chunk read %d, rows %d, rejected %d, want 8, %d, %d", record.Read, record.Rows, record.Rejected, tt.wantRows, tt.wantRejected)
+			}
+
+			// The checksum is that of the committed rows
+			var sum uint64
+			for _, row := range target.committed {
+				sum += rowChecksum([]interface{}{row[0], row[1]}, checksums.types, checksums.skip)
+			}
+			if want := fmt.Sprintf("%016x", sum); record.Checksum != want || int64(len(target.committed)) != checksums.pending[0].RowsMigrated {
+				t.Errorf("chunk checksum %s of %d rows, committed rows have %s of %d", record.Checksum, checksums.pending[0].RowsMigrated, want, len(target.committed))
+			}
+			if checksums.failed != tt.wantFailed {
+				t.Errorf("%d chunks differ in the target, want %d", checksums.failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestChunkChecksumsReadBack(t *testing.T) {
+	// Rows are read back in groups of maxChunkLookupKeys keys
+	target := newCopyTarget()
+	for id := int64(1); id <= maxChunkLookupKeys+1; id++ {
+		target.committed = append(target.committed, []driver.Value{id, fmt.Sprintf("row %d", id)})
+	}
+	m := &migrator{ctx: context.Background(), targetDb: openFakeDB(t, target.fakeDB)}
+	key := newPrimaryKey([]string{"id"}, []string{"id", "name"})
+	var keys [][]interface{}
+	var want uint64
+	types, skip := []string{"bigint", "nvarchar"}, []bool{false, false}
+	for _, row := range target.committed {
+		keys = append(keys, []interface{}{row[0]})
+		want += rowChecksum([]interface{}{row[0], row[1]}, types, skip)
+	}
+	rows, sum, err := m.targetChecksum("dbo.lookup", []string{"id", "name"}, types, skip, key, keys)
+	if err != nil || rows != len(keys) || sum != want {
+		t.Errorf("targetChecksum() = %d, %016x, %v, want %d, %016x", rows, sum, err, len(keys), want)
+	}
+	if queries := len(target.executed()); queries != 2 {
+		t.Errorf("%d queries, want 2", queries)
+	}
+}
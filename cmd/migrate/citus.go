@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// checkCitusCoordinator checks that the target is the coordinator of a Citus
+// cluster, which routes the COPY of distributed tables to the shards on the
+// workers. Rows copied into a worker directly would land in a local table there.
+func checkCitusCoordinator(ctx context.Context, db *sql.DB) error {
+	var version sql.NullString
+	if err := db.QueryRowContext(ctx, "SELECT extversion FROM pg_extension WHERE extname = 'citus'").Scan(&version); err == sql.ErrNoRows {
+		return fmt.Errorf("the citus extension is not installed in the target database")
+	} else if err != nil {
+		return fmt.Errorf("error checking the citus extension: %v", err)
+	}
+	var group, workers int
+	err := db.QueryRowContext(ctx, `
+		SELECT (SELECT groupid FROM pg_dist_local_group),
+			(SELECT COUNT(*) FROM pg_dist_node WHERE noderole = 'primary' AND groupid <> 0)`).Scan(&group, &workers)
+	if err != nil {
+		return fmt.Errorf("error checking the Citus cluster: %v", err)
+	}
+	if group != 0 {
+		return fmt.Errorf("the target is a Citus worker node (group %d); connect to the coordinator", group)
+	}
+	fmt.Printf("✅ Target is the coordinator of a Citus %s cluster with %d workers\n", version.String, workers)
+	return nil
+}
+
+// citusDistribution describes how a table is distributed with -citus, or
+// returns "" for a table that stays local on the coordinator
+func citusDistribution(config *dbmigrate.Config, table string) string {
+	settings := config.TableSettings(table)
+	switch {
+	case settings.ReferenceTable:
+		return "reference table"
+	case settings.DistributionColumn != "" && settings.ColocateWith != "":
+		return fmt.Sprintf("distribute by %s, colocated with %s", settings.DistributionColumn, settings.ColocateWith)
+	case settings.DistributionColumn != "":
+		return "distribute by " + settings.DistributionColumn
+	}
+	return ""
+}
+
+// warnLocalTables warns if no selected table is configured for distribution
+func warnLocalTables(config *dbmigrate.Config, tables []string) {
+	distributed := 0
+	for _, table := range tables {
+		if citusDistribution(config, table) != "" {
+			distributed++
+		}
+	}
+	if distributed == 0 {
+		log.Printf("Warning: -citus is set but no selected table has distribution_column or reference_table in the config; all tables stay local on the coordinator")
+		return
+	}
+	fmt.Printf("Citus: %d of %d tables are distributed or reference tables, the others stay local on the coordinator\n", distributed, len(tables))
+}
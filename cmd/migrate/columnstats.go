@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// columnStats collects the ColumnStats of the columns of a table from the rows
+// passing through its row transform. Streamed blob columns, which are written
+// as NULL and filled in afterwards, are left out.
+type columnStats struct {
+	columns []string
+	stats   []*dbmigrate.ColumnStats // by column position, nil for columns left out
+	before  []interface{}            // values of the current row before the transform
+}
+
+// newColumnStats returns the statistics of the columns of a table, which may
+// have blobs streamed (blobs may be nil)
+func newColumnStats(columns []string, blobs *blobStreamer) *columnStats {
+	s := &columnStats{columns: columns, stats: make([]*dbmigrate.ColumnStats, len(columns)), before: make([]interface{}, len(columns))}
+	for i, column := range columns {
+		if blobs == nil || !blobs.has(column) {
+			s.stats[i] = &dbmigrate.ColumnStats{}
+		}
+	}
+	return s
+}
+
+// wrap returns a row transform applying transform (which may be nil) and
+// recording the values it returns. Rows the transform fails are not recorded.
+func (s *columnStats) wrap(transform func(values []interface{}) error) func(values []interface{}) error {
+	return func(values []interface{}) error {
+		if transform != nil {
+			copy(s.before, values)
+			if err := transform(values); err != nil {
+				return err
+			}
+		}
+		for i, stats := range s.stats {
+			if stats == nil {
+				continue
+			}
+			value := values[i]
+			if value == nil {
+				stats.Nulls++
+			} else if length, ok := valueLength(value); ok && length > stats.MaxLength {
+				stats.MaxLength = length
+			}
+			before := value
+			if transform != nil {
+				before = s.before[i]
+			}
+			if !sameValue(before, value) {
+				stats.Conversions++
+			}
+			if truncated(before, value) {
+				stats.Truncations++
+			}
+		}
+		return nil
+	}
+}
+
+// byColumn returns the statistics by column name for the report, logging the
+// columns with truncated values
+func (s *columnStats) byColumn(table string) map[string]*dbmigrate.ColumnStats {
+	byColumn := make(map[string]*dbmigrate.ColumnStats, len(s.columns))
+	var truncatedColumns []string
+	for i, stats := range s.stats {
+		if stats == nil {
+			continue
+		}
+		byColumn[s.columns[i]] = stats
+		if stats.Truncations > 0 {
+			truncatedColumns = append(truncatedColumns, s.columns[i])
+		}
+	}
+	sort.Strings(truncatedColumns)
+	for _, column := range truncatedColumns {
+		log.Printf("Warning: %d values of column %s of %s were truncated in conversion", byColumn[column].Truncations, column, table)
+	}
+	return byColumn
+}
+
+// valueLength returns the length of a text value in characters or of a binary
+// value in bytes
+func valueLength(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		return utf8.RuneCountInString(v), true
+	case []byte:
+		return len(v), true
+	}
+	return 0, false
+}
+
+// sameValue tells whether a value is unchanged by a conversion. Text read as
+// bytes is the same as a string of the same text.
+func sameValue(before, after interface{}) bool {
+	if before == nil || after == nil {
+		return before == nil && after == nil
+	}
+	switch b := before.(type) {
+	case []byte:
+		switch a := after.(type) {
+		case []byte:
+			return bytes.Equal(b, a)
+		case string:
+			return string(b) == a
+		}
+		return false
+	case string:
+		switch a := after.(type) {
+		case []byte:
+			return b == string(a)
+		case string:
+			return b == a
+		}
+		return false
+	case time.Time:
+		a, ok := after.(time.Time)
+		return ok && b.Equal(a)
+	}
+	t := reflect.TypeOf(before)
+	return t == reflect.TypeOf(after) && t.Comparable() && before == after
+}
+
+// truncated tells whether a conversion shortened a text or binary value, or
+// whether a timestamp has digits beyond the microseconds PostgreSQL keeps
+func truncated(before, after interface{}) bool {
+	switch a := after.(type) {
+	case string:
+		b, ok := before.(string)
+		return ok && utf8.RuneCountInString(a) < utf8.RuneCountInString(b)
+	case []byte:
+		b, ok := before.([]byte)
+		return ok && len(a) < len(b)
+	case time.Time:
+		return a.Nanosecond()%1000 != 0
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// batchSettings are applied to the transaction of every batch of a table
+type batchSettings struct {
+	// deferConstraints checks the deferrable constraints of the target when the
+	// batch commits instead of after every row, so rows of a batch may reference
+	// each other in any order
+	deferConstraints bool
+	// replicaRole sets session_replication_role to replica, which skips
+	// triggers and foreign key checks (-fast-load)
+	replicaRole bool
+	// citus propagates the settings to the transactions on the Citus workers,
+	// where the rows of distributed tables are written
+	citus bool
+}
+
+// beginBatch starts the transaction of a batch with the given settings
+func beginBatch(targetDb *sql.DB, settings batchSettings) (*sql.Tx, error) {
+	tx, err := targetDb.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if settings.deferConstraints {
+		if _, err := tx.Exec("SET CONSTRAINTS ALL DEFERRED"); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("error deferring constraints: %v", err)
+		}
+	}
+	if settings.citus && settings.replicaRole {
+		if _, err := tx.Exec("SET LOCAL citus.propagate_set_commands = 'local'"); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("error setting citus.propagate_set_commands: %v", err)
+		}
+	}
+	if settings.replicaRole {
+		if _, err := tx.Exec("SET LOCAL session_replication_role = replica"); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("error setting session_replication_role: %v", err)
+		}
+	}
+	return tx, nil
+}
+
+// checkReplicaRole tells whether the target user may set
+// session_replication_role, which takes a superuser or, since PostgreSQL 15, a
+// SET privilege granted on the parameter
+func checkReplicaRole(targetDb *sql.DB) error {
+	tx, err := beginBatch(targetDb, batchSettings{replicaRole: true})
+	if err != nil {
+		return err
+	}
+	return tx.Rollback()
+}
+
+// revalidateForeignKeys checks the rows of a target table against its foreign
+// keys after a load with -fast-load, which skipped the checks. PostgreSQL
+// cannot revalidate a valid constraint, so each foreign key is recreated as NOT
+// VALID and then validated; a foreign key with violating rows is left NOT VALID.
+// It returns the number of foreign keys checked and the errors of those that
+// failed.
+func revalidateForeignKeys(targetDb *sql.DB, schema, table string, preserveCase bool) (int, []error, error) {
+	qualified := dbmigrate.QuoteQualified(schema, table, preserveCase)
+	rows, err := targetDb.Query(`
+		SELECT conname, pg_get_constraintdef(oid) FROM pg_constraint
+		WHERE conrelid = $1::regclass AND contype = 'f' AND convalidated`, qualified)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error querying foreign keys of %s: %v", qualified, err)
+	}
+	var names, definitions []string
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("error scanning foreign key: %v", err)
+		}
+		names = append(names, name)
+		definitions = append(definitions, definition)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error querying foreign keys of %s: %v", qualified, err)
+	}
+
+	var failed []error
+	for i, name := range names {
+		quoted := dbmigrate.QuoteIdent(name, true)
+		if _, err := targetDb.Exec(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s, ADD CONSTRAINT %s %s NOT VALID",
+			qualified, quoted, quoted, definitions[i])); err != nil {
+			return i, failed, fmt.Errorf("error recreating foreign key %s: %v", name, err)
+		}
+		if _, err := targetDb.Exec(fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", qualified, quoted)); err != nil {
+			failed = append(failed, fmt.Errorf("foreign key %s of %s: %v", name, qualified, err))
+		}
+	}
+	return len(names), failed, nil
+}
+
+// makeForeignKeysDeferrable makes the foreign keys of a target table that are
+// not deferrable yet DEFERRABLE INITIALLY IMMEDIATE, so they are only checked
+// at commit in transactions that defer them. It returns the number of foreign
+// keys changed.
+func makeForeignKeysDeferrable(targetDb *sql.DB, schema, table string, preserveCase bool) (int, error) {
+	qualified := dbmigrate.QuoteQualified(schema, table, preserveCase)
+	rows, err := targetDb.Query(`
+		SELECT conname FROM pg_constraint
+		WHERE conrelid = $1::regclass AND contype = 'f' AND NOT condeferrable`, qualified)
+	if err != nil {
+		return 0, fmt.Errorf("error querying foreign keys of %s: %v", qualified, err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning foreign key: %v", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error querying foreign keys of %s: %v", qualified, err)
+	}
+
+	for _, name := range names {
+		if _, err := targetDb.Exec(fmt.Sprintf("ALTER TABLE %s ALTER CONSTRAINT %s DEFERRABLE INITIALLY IMMEDIATE",
+			qualified, dbmigrate.QuoteIdent(name, true))); err != nil {
+			return 0, fmt.Errorf("error making foreign key %s deferrable: %v", name, err)
+		}
+	}
+	return len(names), nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/tendant/dbmigrate"
+)
+
+// typeConversionTransform returns a row transform converting source values whose
+// driver representation PostgreSQL does not accept for the mapped column type:
+// bit to bool, uniqueidentifier bytes to canonical UUID strings, decimal and
+// money bytes to decimal strings, time values to time-of-day strings and xml
+// values to UTF-8 text without an encoding declaration. The
+// datetime values of the columns in zones, which the driver returns as UTC, are
+// interpreted in the given time zone. With ltree, hierarchyid paths are converted
+// to ltree paths. targets holds the user-defined target types of columns: bit
+// columns mapped to an integer type are converted to 0 and 1, and hierarchyid
+// columns mapped to LTREE are converted to ltree paths. Returns nil if no column
+// needs converting.
+func typeConversionTransform(columns []string, columnTypes [][2]string, targets map[string]string, zones map[string]*time.Location, ltree bool) func(values []interface{}) error {
+	types := make(map[string]string, len(columnTypes))
+	for _, column := range columnTypes {
+		types[column[0]] = strings.ToLower(column[1])
+	}
+	converters := make(map[int]func(value interface{}) (interface{}, error))
+	for i, column := range columns {
+		switch sourceType := types[column]; {
+		case sourceType == "bit" && dbmigrate.IsIntegerType(targets[column]):
+			converters[i] = convertBitToInt
+		case sourceType == "bit":
+			converters[i] = convertBit
+		case sourceType == "uniqueidentifier":
+			converters[i] = convertUniqueIdentifier
+		case numericTypes[sourceType]:
+			converters[i] = convertDecimal
+		case sourceType == "time":
+			converters[i] = convertTime
+		case sourceType == "xml":
+			converters[i] = convertXML
+		case sourceType == "hierarchyid" && (ltree || strings.EqualFold(targets[column], "ltree")):
+			converters[i] = convertHierarchyid
+		case zones[column] != nil:
+			converters[i] = convertZone(zones[column])
+		}
+	}
+	if len(converters) == 0 {
+		return nil
+	}
+
+	return func(values []interface{}) error {
+		for i, convert := range converters {
+			if values[i] == nil {
+				continue
+			}
+			value, err := convert(values[i])
+			if err != nil {
+				return fmt.Errorf("error converting column %s: %v", columns[i], err)
+			}
+			values[i] = value
+		}
+		return nil
+	}
+}
+
+// sourceConversionTransform returns a row transform converting the values of a
+// source other than SQL Server with its ConvertValue; the datetime values of the
+// columns in zones are then interpreted in the given time zone like for SQL
+// Server
+func sourceConversionTransform(source dbmigrate.Source, sourceColumns []dbmigrate.Column, columns []string, zones map[string]*time.Location) func(values []interface{}) error {
+	byName := make(map[string]dbmigrate.Column, len(sourceColumns))
+	for _, column := range sourceColumns {
+		byName[column.Name] = column
+	}
+	selected := make([]dbmigrate.Column, len(columns))
+	for i, column := range columns {
+		selected[i] = byName[column]
+	}
+
+	return func(values []interface{}) error {
+		for i, column := range selected {
+			if values[i] == nil {
+				continue
+			}
+			value, err := source.ConvertValue(column, values[i])
+			if err == nil && zones[column.Name] != nil {
+				value, err = convertZone(zones[column.Name])(value)
+			}
+			if err != nil {
+				return fmt.Errorf("error converting column %s: %v", columns[i], err)
+			}
+			values[i] = value
+		}
+		return nil
+	}
+}
+
+// convertBit converts a bit value to bool
+func convertBit(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case []byte:
+		return len(v) > 0 && v[0] != 0 && v[0] != '0', nil
+	}
+	return nil, fmt.Errorf("unexpected bit value of type %T", value)
+}
+
+// convertBitToInt converts a bit value to 0 or 1
+func convertBitToInt(value interface{}) (interface{}, error) {
+	b, err := convertBit(value)
+	if err != nil {
+		return nil, err
+	}
+	if b.(bool) {
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+// convertUniqueIdentifier converts a uniqueidentifier, which the driver returns
+// in SQL Server's mixed-endian byte order, to a canonical lowercase UUID string
+func convertUniqueIdentifier(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return strings.ToLower(v), nil
+	case []byte:
+		var id mssql.UniqueIdentifier
+		if err := id.Scan(v); err != nil {
+			return nil, err
+		}
+		return strings.ToLower(id.String()), nil
+	}
+	return nil, fmt.Errorf("unexpected uniqueidentifier value of type %T", value)
+}
+
+// convertTime converts a time value, which the driver returns as a time.Time on
+// 0001-01-01, to a time of day, truncated to the microseconds PostgreSQL keeps
+func convertTime(value interface{}) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return t.Truncate(time.Microsecond).Format("15:04:05.999999"), nil
+	}
+	return value, nil
+}
+
+// xmlEncodingPattern matches the encoding declaration of an XML document
+var xmlEncodingPattern = regexp.MustCompile(`^(\s*<\?xml\s[^?]*?)\s+encoding\s*=\s*("[^"]*"|'[^']*')`)
+
+// convertXML converts an xml value to a string. SQL Server stores xml as UTF-16,
+// which the driver decodes; values read as bytes are decoded here (UTF-16 if they
+// start with a byte order mark, UTF-8 otherwise). An encoding declaration no
+// longer matches the UTF-8 the target stores, so it is removed.
+func convertXML(value interface{}) (interface{}, error) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		switch {
+		case len(v) >= 2 && v[0] == 0xFF && v[1] == 0xFE:
+			s = decodeUTF16(v[2:], func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 })
+		case len(v) >= 2 && v[0] == 0xFE && v[1] == 0xFF:
+			s = decodeUTF16(v[2:], func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) })
+		default:
+			s = strings.TrimPrefix(string(v), "\uFEFF")
+		}
+	default:
+		return nil, fmt.Errorf("unexpected xml value of type %T", value)
+	}
+	return xmlEncodingPattern.ReplaceAllString(s, "$1"), nil
+}
+
+// decodeUTF16 decodes UTF-16 bytes using the given byte order
+func decodeUTF16(b []byte, unit func(b []byte) uint16) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = unit(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// convertHierarchyid converts a hierarchyid path, read with ToString(), to an ltree path
+func convertHierarchyid(value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return dbmigrate.HierarchyidToLtree(s), nil
+	}
+	return nil, fmt.Errorf("unexpected hierarchyid value of type %T", value)
+}
+
+// convertZone returns a converter reinterpreting the wall clock time of a datetime
+// value in zone
+func convertZone(zone *time.Location) func(value interface{}) (interface{}, error) {
+	return func(value interface{}) (interface{}, error) {
+		if t, ok := value.(time.Time); ok {
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone), nil
+		}
+		return value, nil
+	}
+}
+
+// convertDecimal converts a decimal or money value, which the driver returns as
+// text in a []byte, to a string so it is not sent as binary data
+func convertDecimal(value interface{}) (interface{}, error) {
+	if b, ok := value.([]byte); ok {
+		return string(b), nil
+	}
+	return value, nil
+}
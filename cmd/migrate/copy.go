@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// copyOptions are the inputs of the copy of a table by migrateTableData or
+// copyTableData
+type copyOptions struct {
+	source dbmigrate.Source
+	// fullTableName is the source table (schema.table), loaded into
+	// targetSchema.targetTable
+	fullTableName             string
+	targetSchema, targetTable string
+	columns                   []string
+	// exprs are the columns read through an expression (see sourceExprs)
+	exprs map[string]string
+	// where, if set, restricts the source rows read. Not used by copyTableData.
+	where string
+	// onConflict, if set, is appended to the inserts (see conflictClause). Not
+	// used by copyTableData.
+	onConflict string
+	// keyset reads the source in primary key order and blobs copies large binary
+	// columns in chunks after each row. Not used by copyTableData.
+	keyset    *keysetScan
+	blobs     *blobStreamer
+	batchSize int
+	// maxRows is the most rows copyTableData copies in one transaction, as the
+	// row count statistics it is chosen by may be stale
+	maxRows int
+	// transformRow, if set, may modify the values of each row before insert; a
+	// row it returns an error for is rejected or fails the batch like a failed
+	// insert
+	transformRow func(values []interface{}) error
+	preserveCase bool
+	// provenanceColumn, if set, is written runID in every row
+	provenanceColumn, runID string
+	// governor slows down the batches when the databases are under load
+	governor *loadGovernor
+	// settings are applied to the transaction of every batch
+	settings batchSettings
+	// onCommit (optional) is called with the total committed row count and
+	// the approximate number of bytes read for those rows after each batch,
+	// and with the key of the last row read with keyset
+	onCommit func(rows int, bytes int64, lastKey []interface{})
+	// onReject (optional) is passed the rows that fail to insert, which are
+	// skipped; the copy stops if it returns an error
+	onReject func(values []interface{}, err error) error
+}
+
+// errNotSmall is returned by copyTableData for a table with more than
+// opts.maxRows rows
+var errNotSmall = errors.New("table has more rows than its estimate")
+
+// copyTableData copies a whole table with a single source query and a single
+// COPY in one transaction. It is the fast path for small tables, which do not
+// need batching or checkpoints. The rows are read before any is transformed or
+// written, so a table with more than opts.maxRows rows returns errNotSmall
+// without side effects, to be copied in batches instead. With opts.onReject, a
+// failed COPY is bisected by copyRows to reject only the failing rows.
+// opts.onCommit is called once after the commit.
+func copyTableData(ctx context.Context, sourceDb *sql.DB, targetDb *sql.DB, opts copyOptions) (int, error) {
+	if len(strings.Split(opts.fullTableName, ".")) != 2 {
+		return 0, fmt.Errorf("invalid table name format: %s (expected schema.table)", opts.fullTableName)
+	}
+
+	columnList := make([]string, len(opts.columns))
+	sqlServerColumns := make([]string, len(opts.columns))
+	for i, col := range opts.columns {
+		columnList[i] = dbmigrate.QuoteIdent(col, opts.preserveCase)
+		sqlServerColumns[i] = sourceColumnExpr(opts.source, col, opts.exprs)
+	}
+	if opts.provenanceColumn != "" {
+		columnList = append(columnList, dbmigrate.QuoteIdent(opts.provenanceColumn, opts.preserveCase))
+	}
+
+	rows, err := sourceDb.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(sqlServerColumns, ", "), opts.source.QuoteTable(opts.fullTableName)))
+	if err != nil {
+		return 0, fmt.Errorf("error querying source table: %v", err)
+	}
+	defer rows.Close()
+
+	var read [][]interface{}
+	for rows.Next() {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if opts.maxRows > 0 && len(read) == opts.maxRows {
+			return 0, errNotSmall
+		}
+
+		values := make([]interface{}, len(opts.columns))
+		valuePtrs := make([]interface{}, len(opts.columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return 0, fmt.Errorf("error scanning row: %v", err)
+		}
+		read = append(read, values)
+	}
+	if err := rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("error reading source rows: %v", err)
+	}
+
+	tx, err := beginBatch(targetDb, opts.settings)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	copyQuery := fmt.Sprintf("COPY %s (%s) FROM STDIN",
+		dbmigrate.QuoteQualified(opts.targetSchema, opts.targetTable, opts.preserveCase), strings.Join(columnList, ", "))
+	var stmt *sql.Stmt
+	var buffered [][]interface{}
+	if opts.onReject == nil {
+		stmt, err = tx.Prepare(copyQuery)
+		if err != nil {
+			return 0, fmt.Errorf("error starting COPY: %v", err)
+		}
+		defer stmt.Close()
+	}
+
+	rowCount := 0
+	var bytes int64
+	for _, values := range read {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if opts.transformRow != nil {
+			if err := opts.transformRow(values); err != nil {
+				if opts.onReject == nil {
+					return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", rowCount+1), row: formatSampleRow(opts.columns, values), err: err}
+				}
+				if rejectErr := opts.onReject(values, err); rejectErr != nil {
+					return 0, rejectErr
+				}
+				continue
+			}
+		}
+		for _, value := range values {
+			bytes += valueSize(value)
+		}
+		if opts.provenanceColumn != "" {
+			values = append(values, opts.runID)
+		}
+		if stmt == nil {
+			buffered = append(buffered, values)
+			continue
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", rowCount+1), row: formatSampleRow(opts.columns, values), err: fmt.Errorf("error copying row: %v", err)}
+		}
+		rowCount++
+	}
+
+	// Flush the COPY; errors in the copied data are reported here
+	if stmt != nil {
+		if _, err := stmt.Exec(); err != nil {
+			return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", rowCount), column: offendingColumn(err, opts.columns, nil), err: fmt.Errorf("error copying rows: %v", err)}
+		}
+	} else {
+		rowCount, err = copyRows(ctx, tx, copyQuery, buffered, func(values []interface{}, err error) error {
+			return opts.onReject(values[:len(opts.columns)], err)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	if opts.onCommit != nil {
+		opts.onCommit(rowCount, bytes, nil)
+	}
+	return rowCount, nil
+}
+
+// copySavepoint is the savepoint a COPY of copyRows is rolled back to
+const copySavepoint = "dbmigrate_copy"
+
+// copyRows copies rows with a COPY in a transaction. If the COPY fails, it is
+// rolled back and the rows are split in halves that are copied again,
+// recursively, until the failing rows are isolated and passed to reject; all
+// other rows are copied. A few bad rows among n cost about 2 log2(n) COPYs
+// each. It returns the number of rows copied.
+func copyRows(ctx context.Context, tx *sql.Tx, copyQuery string, rows [][]interface{}, reject func(values []interface{}, err error) error) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+copySavepoint); err != nil {
+		return 0, fmt.Errorf("error creating savepoint: %v", err)
+	}
+	copied := len(rows)
+	if err := copyOnce(ctx, tx, copyQuery, rows); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+copySavepoint); rbErr != nil {
+			return 0, fmt.Errorf("error rolling back to savepoint: %v", rbErr)
+		}
+		if len(rows) == 1 {
+			copied = 0
+			if rejectErr := reject(rows[0], err); rejectErr != nil {
+				return 0, rejectErr
+			}
+		} else {
+			half := len(rows) / 2
+			first, err := copyRows(ctx, tx, copyQuery, rows[:half], reject)
+			if err != nil {
+				return 0, err
+			}
+			second, err := copyRows(ctx, tx, copyQuery, rows[half:], reject)
+			if err != nil {
+				return 0, err
+			}
+			copied = first + second
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+copySavepoint); err != nil {
+		return 0, fmt.Errorf("error releasing savepoint: %v", err)
+	}
+	return copied, nil
+}
+
+// copyOnce copies rows with a single COPY
+func copyOnce(ctx context.Context, tx *sql.Tx, copyQuery string, rows [][]interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, copyQuery)
+	if err != nil {
+		return fmt.Errorf("error starting COPY: %v", err)
+	}
+	defer stmt.Close()
+	for _, values := range rows {
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tendant/dbmigrate"
+)
+
+func TestSmallTable(t *testing.T) {
+	m := &migrator{
+		smallTableRows: 10000,
+		rowEstimates:   map[string]int64{"dbo.Small": 800, "dbo.Limit": 10000, "dbo.Large": 2000000, "dbo.Empty": 0},
+	}
+	tests := []struct {
+		table string
+		want  bool
+	}{
+		{"dbo.Small", true},
+		{"dbo.Limit", false},
+		{"dbo.Large", false},
+		{"dbo.Empty", false},   // statistics never gathered look the same
+		{"dbo.Unknown", false}, // no statistics
+	}
+	for _, tt := range tests {
+		if got := m.smallTable(tt.table); got != tt.want {
+			t.Errorf("smallTable(%s) = %v, want %v", tt.table, got, tt.want)
+		}
+	}
+
+	m.smallTableRows = 0
+	if m.smallTable("dbo.Small") {
+		t.Error("smallTable with -small-table-rows 0 = true, want false")
+	}
+}
+
+// sourceRows returns a fake source table of n rows (id, name)
+func sourceRows(n int) *fakeDB {
+	return &fakeDB{query: func(query string, args []driver.Value) (*fakeRows, error) {
+		rows := &fakeRows{columns: []string{"id", "name"}}
+		for i := 1; i <= n; i++ {
+			rows.rows = append(rows.rows, []driver.Value{int64(i), fmt.Sprintf("row %d", i)})
+		}
+		return rows, nil
+	}}
+}
+
+func TestCopyTableDataMaxRows(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		rows    int
+		wantErr error
+	}{
+		{"below the limit", 4, nil},
+		{"at the limit", 5, nil},
+		{"above the limit", 6, errNotSmall},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &fakeDB{}
+			transformed := 0
+			opts := copyOptions{
+				source:        dbmigrate.SQLServer,
+				fullTableName: "dbo.Lookup",
+				targetSchema:  "dbo",
+				targetTable:   "lookup",
+				columns:       []string{"id", "name"},
+				maxRows:       5,
+				transformRow:  func(values []interface{}) error { transformed++; return nil },
+			}
+			count, err := copyTableData(context.Background(), openFakeDB(t, sourceRows(tt.rows)), openFakeDB(t, target), opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("copyTableData() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				// Nothing may be transformed or written before falling back
+				if transformed != 0 || len(target.executed()) != 0 {
+					t.Errorf("transformed %d rows and executed %q before returning errNotSmall", transformed, target.executed())
+				}
+				return
+			}
+			if count != tt.rows || transformed != tt.rows {
+				t.Errorf("copied %d rows, transformed %d, want %d", count, transformed, tt.rows)
+			}
+			// One COPY call per row and one to flush it, in one transaction
+			want := "BEGIN\n" + strings.Repeat("COPY dbo.lookup (id, name) FROM STDIN\n", tt.rows+1) + "COMMIT"
+			if executed := strings.Join(target.executed(), "\n"); executed != want {
+				t.Errorf("target statements:\n%s\nwant:\n%s", executed, want)
+			}
+		})
+	}
+}
+
+// copyTarget is a fake PostgreSQL table (id, name) loaded with COPY in a
+// transaction. A COPY with a row whose id is in bad fails when it is flushed,
+// as PostgreSQL reports constraint violations, leaving the rows before it in
+// the table and the transaction aborted until it is rolled back to a
+// savepoint. Queries read the committed rows back by id, with the names in
+// altered instead of the committed ones.
+type copyTarget struct {
+	*fakeDB
+	bad     map[int64]bool
+	altered map[int64]string
+
+	committed, table, copying [][]driver.Value
+	savepoints                []int
+	aborted                   bool
+	copies                    int
+}
+
+func newCopyTarget(bad ...int64) *copyTarget {
+	c := &copyTarget{fakeDB: &fakeDB{}, bad: make(map[int64]bool), altered: make(map[int64]string)}
+	for _, id := range bad {
+		c.bad[id] = true
+	}
+	c.exec = func(query string, args []driver.Value) error {
+		if c.aborted && query != "ROLLBACK" && !strings.HasPrefix(query, "ROLLBACK TO SAVEPOINT ") {
+			return errors.New("pq: current transaction is aborted, commands ignored until end of transaction block")
+		}
+		switch {
+		case query == "BEGIN":
+			c.table, c.savepoints = append([][]driver.Value(nil), c.committed...), nil
+		case query == "COMMIT":
+			c.committed = c.table
+		case query == "ROLLBACK":
+		case strings.HasPrefix(query, "SAVEPOINT "):
+			c.savepoints = append(c.savepoints, len(c.table))
+		case strings.HasPrefix(query, "ROLLBACK TO SAVEPOINT "):
+			c.table, c.copying, c.aborted = c.table[:c.savepoints[len(c.savepoints)-1]], nil, false
+		case strings.HasPrefix(query, "RELEASE SAVEPOINT "):
+			c.savepoints = c.savepoints[:len(c.savepoints)-1]
+		case strings.HasPrefix(query, "COPY "):
+			if len(args) > 0 {
+				c.copying = append(c.copying, args)
+				return nil
+			}
+			rows := c.copying
+			c.copying = nil
+			c.copies++
+			for _, row := range rows {
+				if c.bad[row[0].(int64)] {
+					c.aborted = true
+					return fmt.Errorf(`pq: new row for relation "lookup" violates check constraint "lookup_name_check"`)
+				}
+				c.table = append(c.table, row)
+			}
+		default:
+			return fmt.Errorf("unexpected statement %s", query)
+		}
+		return nil
+	}
+	c.query = func(query string, args []driver.Value) (*fakeRows, error) {
+		wanted := make(map[int64]bool)
+		for _, arg := range args {
+			wanted[arg.(int64)] = true
+		}
+		rows := &fakeRows{columns: []string{"id", "name"}}
+		for _, row := range c.committed {
+			if !wanted[row[0].(int64)] {
+				continue
+			}
+			if name, ok := c.altered[row[0].(int64)]; ok {
+				row = []driver.Value{row[0], name}
+			}
+			rows.rows = append(rows.rows, row)
+		}
+		return rows, nil
+	}
+	return c
+}
+
+// ids returns the ids of the committed rows in order
+func (c *copyTarget) ids() []int64 {
+	var ids []int64
+	for _, row := range c.committed {
+		ids = append(ids, row[0].(int64))
+	}
+	return ids
+}
+
+func TestCopyRowsRejectsOnlyFailingRows(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		rows      int
+		bad       []int64
+		maxCopies int
+	}{
+		{"no bad rows", 8, nil, 1},
+		{"one row in the middle", 8, []int64{5}, 1 + 2*3},
+		{"first and last rows", 8, []int64{1, 8}, 1 + 2*2*3},
+		{"adjacent rows", 9, []int64{4, 5}, 1 + 2*2*4},
+		{"single bad row", 1, []int64{1}, 1},
+		{"all rows", 3, []int64{1, 2, 3}, 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newCopyTarget(tt.bad...)
+			var rejected []int64
+			opts := copyOptions{
+				source:        dbmigrate.SQLServer,
+				fullTableName: "dbo.Lookup",
+				targetSchema:  "dbo",
+				targetTable:   "lookup",
+				columns:       []string{"id", "name"},
+				onReject: func(values []interface{}, err error) error {
+					if !strings.Contains(err.Error(), "violates check constraint") {
+						t.Errorf("row %v rejected with %v", values, err)
+					}
+					rejected = append(rejected, values[0].(int64))
+					return nil
+				},
+			}
+			count, err := copyTableData(context.Background(), openFakeDB(t, sourceRows(tt.rows)), openFakeDB(t, target.fakeDB), opts)
+			if err != nil {
+				t.Fatalf("copyTableData() failed: %v", err)
+			}
+
+			var want []int64
+			for id := int64(1); id <= int64(tt.rows); id++ {
+				if !target.bad[id] {
+					want = append(want, id)
+				}
+			}
+			if !reflect.DeepEqual(rejected, tt.bad) {
+				t.Errorf("rejected rows %v, want %v", rejected, tt.bad)
+			}
+			if !reflect.DeepEqual(target.ids(), want) || count != len(want) {
+				t.Errorf("committed rows %v (count %d), want %v", target.ids(), count, want)
+			}
+			if len(target.savepoints) != 0 {
+				t.Errorf("%d savepoints not released", len(target.savepoints))
+			}
+			if target.copies > tt.maxCopies {
+				t.Errorf("%d COPYs, want at most %d", target.copies, tt.maxCopies)
+			}
+		})
+	}
+}
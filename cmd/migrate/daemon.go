@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// runDaemon runs the phases again every interval until interrupted, for
+// continuous incremental syncs, table refreshes or change tracking syncs
+// during a phased cutover. A failed cycle is reported on the health endpoints
+// and retried by the next cycle. It returns the error of the last cycle, or
+// the context error if interrupted during a cycle.
+func (m *migrator) runDaemon(phases []string, interval time.Duration, health *healthServer) error {
+	var lastErr error
+	for cycle := 1; ; cycle++ {
+		fmt.Printf("\n=== Cycle %d ===\n", cycle)
+		start := time.Now()
+		lastErr = m.runCycle(phases)
+		if errors.Is(lastErr, context.Canceled) {
+			return lastErr
+		}
+		health.recordCycle(lastErr)
+		if lastErr != nil {
+			fmt.Printf("❌ Cycle %d failed after %s: %v\n", cycle, time.Since(start).Round(time.Millisecond), lastErr)
+		} else {
+			fmt.Printf("✅ Cycle %d completed in %s\n", cycle, time.Since(start).Round(time.Millisecond))
+		}
+
+		// The interval runs from the end of a cycle, so cycles never overlap
+		fmt.Printf("Next cycle at %s\n", time.Now().Add(interval).Format(time.TimeOnly))
+		select {
+		case <-m.ctx.Done():
+			fmt.Println("Daemon stopped")
+			return lastErr
+		case <-time.After(interval):
+		}
+	}
+}
+
+// runCycle runs the phases once, starting from the checkpoints recorded by the
+// previous cycle
+func (m *migrator) runCycle(phases []string) error {
+	checkpoints, err := m.stateStore.Load()
+	if err != nil {
+		return fmt.Errorf("error loading checkpoints: %v", err)
+	}
+	m.checkpoints = checkpoints
+	m.failedTables, m.deferredTables, m.pausedTables = nil, nil, nil
+	m.report.Tables, m.report.TotalRows, m.report.TotalBytes = nil, 0, 0
+
+	for _, phase := range phases {
+		if err := m.runPhase(phase); err != nil {
+			return fmt.Errorf("phase %s failed: %w", phase, err)
+		}
+	}
+	if len(m.failedTables) > 0 {
+		m.printErrorReport()
+		return fmt.Errorf("%d of %d tables failed to migrate", len(m.failedTables), len(m.tables))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// sparseKeyDensity is the fraction of the key range below which an integer
+// primary key is reported as sparse
+const sparseKeyDensity = 0.01
+
+// maxSliceNullShare is the share of rows without a slice_column value above
+// which a table is copied in primary key order instead of time slices, since
+// those rows all fall in the last slice
+const maxSliceNullShare = 0.5
+
+// integerTypes are the source types whose key range can be measured
+var integerTypes = map[string]bool{"tinyint": true, "smallint": true, "mediumint": true, "int": true, "integer": true, "bigint": true}
+
+// checkKeyDistribution returns a warning when the primary key of a table is
+// unsuitable for splitting the table into key ranges: a missing key, or an
+// integer key whose values are spread thinly over a much larger range (e.g.,
+// after large deletes or with sparse IDs), so equal-width ranges would be
+// heavily unbalanced. Batches are read as keyset pages of batch-size rows,
+// which gaps in the key do not unbalance, so the warning is informational, for
+// tools splitting the table by key range. Tables without a row count estimate
+// or below -small-table-rows are not checked, sparing the MIN/MAX query.
+// Returns "" if there is nothing to report.
+func (m *migrator) checkKeyDistribution(table string, rowCount int64) (string, error) {
+	pkColumns, err := m.source.PrimaryKey(m.sourceDb, table)
+	if err != nil {
+		return "", err
+	}
+	if len(pkColumns) == 0 {
+		return "no primary key, rows cannot be split into key ranges", nil
+	}
+	if len(pkColumns) > 1 || rowCount == 0 || rowCount < m.smallTableRows {
+		return "", nil
+	}
+
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return "", err
+	}
+	for _, column := range columnTypes {
+		if column[0] != pkColumns[0] || !integerTypes[strings.ToLower(column[1])] {
+			continue
+		}
+
+		var minKey, maxKey int64
+		integer := "BIGINT"
+		if m.source == dbmigrate.MySQL {
+			integer = "SIGNED"
+		}
+		query := fmt.Sprintf("SELECT CAST(MIN(%[1]s) AS %[3]s), CAST(MAX(%[1]s) AS %[3]s) FROM %[2]s", m.source.QuoteIdent(column[0]), m.source.QuoteTable(table), integer)
+		if err := m.sourceDb.QueryRowContext(m.ctx, query).Scan(&minKey, &maxKey); err != nil {
+			return "", fmt.Errorf("error getting key range of %s: %v", table, err)
+		}
+		span := float64(maxKey) - float64(minKey) + 1
+		if density := float64(rowCount) / span; density < sparseKeyDensity {
+			return fmt.Sprintf("skewed key %s: ~%d rows spread over %d..%d (%.2f%% of the range used)",
+				column[0], rowCount, minKey, maxKey, density*100), nil
+		}
+	}
+	return "", nil
+}
+
+// sliceNullWarning returns a warning when more than maxSliceNullShare of the
+// rows of a table have no value in its slice column, or "" if the table can
+// be sliced
+func sliceNullWarning(column string, rows, nulls int64) string {
+	if rows == 0 || float64(nulls)/float64(rows) <= maxSliceNullShare {
+		return ""
+	}
+	return fmt.Sprintf("%d of %d rows (%.0f%%) have no %s", nulls, rows, float64(nulls)/float64(rows)*100, column)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// sqlServerStats returns a fake SQL Server source whose tables have the primary
+// key id and answer MIN/MAX queries with minMax
+func sqlServerStats(minMax []driver.Value) *fakeDB {
+	return &fakeDB{query: func(query string, args []driver.Value) (*fakeRows, error) {
+		switch {
+		case strings.Contains(query, "is_primary_key"):
+			return &fakeRows{rows: [][]driver.Value{{"id"}}}, nil
+		case strings.HasPrefix(query, "SELECT MIN("), strings.HasPrefix(query, "SELECT CAST(MIN("):
+			return &fakeRows{rows: [][]driver.Value{minMax}}, nil
+		}
+		return nil, nil
+	}}
+}
+
+func TestCheckKeyDistribution(t *testing.T) {
+	tests := []struct {
+		name      string
+		rows      int64
+		minMax    []driver.Value
+		want      string
+		wantQuery bool
+	}{
+		{"dense key", 900000, []driver.Value{int64(1), int64(1000000)}, "", true},
+		{"sparse key", 50000, []driver.Value{int64(1), int64(1000000000)}, "skewed key id: ~50000 rows spread over 1..1000000000 (0.01% of the range used)", true},
+		{"small table is not scanned", 5000, []driver.Value{int64(1), int64(1000000000)}, "", false},
+		{"no estimate is not scanned", 0, []driver.Value{int64(1), int64(1000000000)}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := sqlServerStats(tt.minMax)
+			m := &migrator{
+				ctx:            context.Background(),
+				source:         dbmigrate.SQLServer,
+				sourceDb:       openFakeDB(t, source),
+				smallTableRows: 10000,
+				columnTypes:    map[string][][2]string{"dbo.Events": {{"id", "bigint"}, {"name", "nvarchar"}}},
+			}
+			got, err := m.checkKeyDistribution("dbo.Events", tt.rows)
+			if err != nil {
+				t.Fatalf("checkKeyDistribution() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("checkKeyDistribution() = %q, want %q", got, tt.want)
+			}
+			scanned := strings.Contains(strings.Join(source.executed(), "\n"), "MIN(")
+			if scanned != tt.wantQuery {
+				t.Errorf("queried the key range: %v, want %v", scanned, tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestSliceNullWarning(t *testing.T) {
+	tests := []struct {
+		rows, nulls int64
+		want        string
+	}{
+		{0, 0, ""},
+		{1000, 0, ""},
+		{1000, 500, ""},
+		{1000, 501, "501 of 1000 rows (50%) have no CreatedAt"},
+		{1000, 1000, "1000 of 1000 rows (100%) have no CreatedAt"},
+	}
+	for _, tt := range tests {
+		if got := sliceNullWarning("CreatedAt", tt.rows, tt.nulls); got != tt.want {
+			t.Errorf("sliceNullWarning(%d, %d) = %q, want %q", tt.rows, tt.nulls, got, tt.want)
+		}
+	}
+}
+
+func TestPlanSlicesMostlyNull(t *testing.T) {
+	minValue := time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)
+	maxValue := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name        string
+		rows, nulls int64
+		wantSliced  bool
+	}{
+		{"few NULLs", 1000, 100, true},
+		{"mostly NULL", 1000, 900, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &migrator{
+				ctx:         context.Background(),
+				source:      dbmigrate.SQLServer,
+				sourceDb:    openFakeDB(t, sqlServerStats([]driver.Value{minValue, maxValue, tt.rows, tt.rows - tt.nulls})),
+				config:      &dbmigrate.Config{Tables: map[string]dbmigrate.TableConfig{"audit.Events": {SliceColumn: "CreatedAt"}}},
+				columnTypes: map[string][][2]string{"audit.Events": {{"id", "bigint"}, {"CreatedAt", "datetime2"}}},
+			}
+			slices, err := m.planSlices("audit.Events", &keysetScan{})
+			if err != nil {
+				t.Fatalf("planSlices() failed: %v", err)
+			}
+			if sliced := slices != nil; sliced != tt.wantSliced {
+				t.Errorf("planSlices() returned %d slices, want sliced %v", len(slices), tt.wantSliced)
+			}
+		})
+	}
+}
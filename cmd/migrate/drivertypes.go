@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Go types the SQL Server driver returns for source values
+var (
+	boolType   = reflect.TypeOf(false)
+	int64Type  = reflect.TypeOf(int64(0))
+	floatType  = reflect.TypeOf(float64(0))
+	stringType = reflect.TypeOf("")
+	bytesType  = reflect.TypeOf([]byte(nil))
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// driverTypes maps source types to the Go type the driver returns for them and
+// the conversions expect. Decimal and money values arrive as their decimal text
+// in bytes; hierarchyid, sql_variant and spatial columns are read as text.
+var driverTypes = map[string]reflect.Type{
+	"bit":              boolType,
+	"tinyint":          int64Type,
+	"smallint":         int64Type,
+	"int":              int64Type,
+	"bigint":           int64Type,
+	"real":             floatType,
+	"float":            floatType,
+	"decimal":          bytesType,
+	"numeric":          bytesType,
+	"money":            bytesType,
+	"smallmoney":       bytesType,
+	"char":             stringType,
+	"varchar":          stringType,
+	"text":             stringType,
+	"nchar":            stringType,
+	"nvarchar":         stringType,
+	"ntext":            stringType,
+	"sysname":          stringType,
+	"xml":              stringType,
+	"hierarchyid":      stringType,
+	"sql_variant":      stringType,
+	"geometry":         stringType,
+	"geography":        stringType,
+	"binary":           bytesType,
+	"varbinary":        bytesType,
+	"image":            bytesType,
+	"timestamp":        bytesType,
+	"rowversion":       bytesType,
+	"uniqueidentifier": bytesType,
+	"date":             timeType,
+	"time":             timeType,
+	"datetime":         timeType,
+	"datetime2":        timeType,
+	"smalldatetime":    timeType,
+	"datetimeoffset":   timeType,
+}
+
+// driverTypeTransform returns a row transform that checks the Go type of each
+// scanned value against driverTypes before any conversion. The first unexpected
+// type of a column is logged with the source type and recorded in unexpected by
+// column, as "<Go type> (expected <Go type>)". Returns nil if no column has a
+// known source type.
+func driverTypeTransform(table string, columns []string, columnTypes [][2]string, unexpected map[string]string) func(values []interface{}) error {
+	types := make(map[string]string, len(columnTypes))
+	for _, column := range columnTypes {
+		types[column[0]] = strings.ToLower(column[1])
+	}
+	expected := make(map[int]reflect.Type)
+	for i, column := range columns {
+		if goType, ok := driverTypes[types[column]]; ok {
+			expected[i] = goType
+		}
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+
+	return func(values []interface{}) error {
+		for i, goType := range expected {
+			if values[i] == nil {
+				continue
+			}
+			actual := reflect.TypeOf(values[i])
+			if actual == goType {
+				continue
+			}
+			column := columns[i]
+			if _, seen := unexpected[column]; !seen {
+				log.Printf("Warning: Column %s.%s (%s) returned Go type %s, expected %s; its values may be converted differently than intended",
+					table, column, types[column], actual, goType)
+				unexpected[column] = actual.String() + " (expected " + goType.String() + ")"
+			}
+		}
+		return nil
+	}
+}
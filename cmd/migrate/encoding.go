@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// utf8CodePage is the code page of the _UTF8 collations (SQL Server 2019+)
+const utf8CodePage = 65001
+
+// getTranscodeColumns returns the non-Unicode text columns (char, varchar, text)
+// of a source table whose collation uses a code page other than UTF-8, keyed by
+// column name with the code page as value. Values of these columns are converted
+// to UTF-8 by reading them as NVARCHAR, which SQL Server decodes using the
+// column's collation.
+func getTranscodeColumns(db *sql.DB, fullTableName string) (map[string]int, error) {
+	parts := strings.Split(fullTableName, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid table name format: %s (expected schema.table)", fullTableName)
+	}
+
+	query := `
+		SELECT c.COLUMN_NAME, CAST(COLLATIONPROPERTY(c.COLLATION_NAME, 'CodePage') AS INT)
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		WHERE c.TABLE_SCHEMA = @p1 AND c.TABLE_NAME = @p2
+		AND ` + dbmigrate.DataTypeExpr + ` IN ('char', 'varchar', 'text')
+		AND c.COLLATION_NAME IS NOT NULL`
+
+	rows, err := db.Query(query, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting column collations for table %s: %v", fullTableName, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var codePage sql.NullInt64
+		if err := rows.Scan(&name, &codePage); err != nil {
+			return nil, err
+		}
+		if codePage.Valid && codePage.Int64 != 0 && codePage.Int64 != utf8CodePage {
+			columns[name] = int(codePage.Int64)
+		}
+	}
+	return columns, rows.Err()
+}
+
+// sourceColumnExpr returns the SELECT expression for a source column, using its
+// expression in exprs (see sourceExprs) if it has one
+func sourceColumnExpr(source dbmigrate.Source, column string, exprs map[string]string) string {
+	if expr, ok := exprs[column]; ok {
+		return fmt.Sprintf("%s AS %s", expr, source.QuoteIdent(column))
+	}
+	return source.QuoteIdent(column)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tendant/dbmigrate"
+)
+
+// tableSize holds the size statistics of a source table
+type tableSize struct {
+	table   string
+	rows    int64
+	dataKB  int64 // heap or clustered index, including LOB and row-overflow pages
+	indexKB int64 // nonclustered indexes
+}
+
+// avgRowBytes returns the average width of a row on disk
+func (s tableSize) avgRowBytes() int64 {
+	if s.rows == 0 {
+		return 0
+	}
+	return s.dataKB * 1024 / s.rows
+}
+
+// runEstimate implements the "estimate" subcommand, which sizes the selected
+// tables from the partition statistics of the source and estimates how long
+// copying them takes at a given throughput, for planning maintenance windows
+func runEstimate(args []string) {
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	sourceDsnFlag := fs.String("source-dsn", "", "SQL Server connection string (default: SOURCE_DB_DSN environment variable)")
+	endpointProfileFlag := fs.String("endpoint-profile", dbmigrate.EndpointAuto, "Connection parameter preset for the SQL Server host: auto (detect from the host name), none, aws-rds, azure-sql or gcp-cloudsql")
+	schemasFlag := fs.String("schemas", "dbo", "Comma-separated list of schemas to estimate")
+	tablesFlag := fs.String("tables", "", "Comma-separated list of tables to estimate, with '*' wildcards or re: regular expressions (default: all tables of -schemas)")
+	excludeTablesFlag := fs.String("exclude-tables", "", "Comma-separated list of tables to leave out, with '*' wildcards or re: regular expressions")
+	throughputFlag := fs.Float64("throughput", 20, "Expected copy throughput in MB/s of table data")
+	rowsPerSecondFlag := fs.Int64("rows-per-second", 0, "Expected copy throughput in rows/s, for tables of narrow rows where the row rate limits first (0 = no limit)")
+	fs.Parse(args)
+
+	if *throughputFlag <= 0 {
+		log.Fatal("-throughput must be positive")
+	}
+	sourceDsn := *sourceDsnFlag
+	if sourceDsn == "" {
+		sourceDsn = os.Getenv("SOURCE_DB_DSN")
+	}
+	if sourceDsn == "" {
+		log.Fatal("No source database connection. Set SOURCE_DB_DSN environment variable or use -source-dsn flag.")
+	}
+	sourceDsn, err := dbmigrate.PrepareSqlServerDsn(sourceDsn, *endpointProfileFlag)
+	if err != nil {
+		log.Fatalf("Error in source connection string: %v", err)
+	}
+	sourceDb, err := dbmigrate.OpenReadOnlySqlServer(dbmigrate.TagSqlServerDsn(sourceDsn, "dbmigrate-estimate"))
+	if err != nil {
+		log.Fatalf("Error connecting to source database: %v", err)
+	}
+	defer sourceDb.Close()
+	if err := sourceDb.Ping(); err != nil {
+		log.Fatalf("Error connecting to source database: %v", err)
+	}
+
+	tables, err := spoolTables(sourceDb, *schemasFlag, *tablesFlag)
+	if err != nil {
+		log.Fatalf("Error getting tables: %v", err)
+	}
+	if *excludeTablesFlag != "" {
+		excluded, unmatched, err := dbmigrate.ResolveTableNames(strings.Split(*excludeTablesFlag, ","), tables)
+		if err != nil {
+			log.Fatalf("Error in -exclude-tables: %v", err)
+		}
+		for _, name := range unmatched {
+			log.Printf("Warning: -exclude-tables entry %s matched no table in schemas %s", name, *schemasFlag)
+		}
+		tables = withoutTables(tables, excluded)
+	}
+
+	sizes, err := getTableSizes(sourceDb)
+	if err != nil {
+		log.Fatalf("Error getting table statistics: %v", err)
+	}
+	selected := make([]tableSize, 0, len(tables))
+	for _, table := range tables {
+		size := sizes[table]
+		size.table = table
+		selected = append(selected, size)
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].dataKB > selected[j].dataKB })
+
+	estimate := func(size tableSize) time.Duration {
+		seconds := float64(size.dataKB) / 1024 / *throughputFlag
+		if *rowsPerSecondFlag > 0 {
+			seconds = max(seconds, float64(size.rows)/float64(*rowsPerSecondFlag))
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	fmt.Printf("%-40s  %14s  %10s  %10s  %9s  %10s\n", "TABLE", "ROWS", "DATA", "INDEXES", "AVG ROW", "EST. TIME")
+	var total tableSize
+	var duration time.Duration
+	for _, size := range selected {
+		tableDuration := estimate(size)
+		fmt.Printf("%-40s  %14d  %10s  %10s  %7d B  %10s\n", size.table, size.rows, formatSizeKB(size.dataKB),
+			formatSizeKB(size.indexKB), size.avgRowBytes(), tableDuration.Round(time.Second))
+		total.rows += size.rows
+		total.dataKB += size.dataKB
+		total.indexKB += size.indexKB
+		duration += tableDuration
+	}
+
+	fmt.Printf("\n%d tables, %d rows, average row %d B\n", len(selected), total.rows, total.avgRowBytes())
+	fmt.Printf("Estimated transfer size: %s of table data (%s with indexes)\n", formatSizeKB(total.dataKB), formatSizeKB(total.dataKB+total.indexKB))
+	throughput := fmt.Sprintf("%.1f MB/s", *throughputFlag)
+	if *rowsPerSecondFlag > 0 {
+		throughput += fmt.Sprintf(" and %d rows/s", *rowsPerSecondFlag)
+	}
+	fmt.Printf("Estimated copy duration at %s: %s\n", throughput, duration.Round(time.Second))
+	fmt.Println("Sizes come from the partition statistics; index builds, verification and network overhead are not included. Compare with the throughput of previous runs (migrate history).")
+}
+
+// getTableSizes returns the row counts and sizes of all user tables of the
+// source (schema.table) from the partition statistics, which are cheap to query
+func getTableSizes(db *sql.DB) (map[string]tableSize, error) {
+	rows, err := db.Query(`
+		SELECT s.name, t.name,
+			COALESCE(SUM(CASE WHEN p.index_id IN (0, 1) THEN p.row_count END), 0),
+			COALESCE(SUM(CASE WHEN p.index_id IN (0, 1) THEN p.used_page_count END), 0) * 8,
+			COALESCE(SUM(CASE WHEN p.index_id > 1 THEN p.used_page_count END), 0) * 8
+		FROM sys.tables t
+		JOIN sys.schemas s ON t.schema_id = s.schema_id
+		LEFT JOIN sys.dm_db_partition_stats p ON p.object_id = t.object_id
+		WHERE t.is_ms_shipped = 0
+		GROUP BY s.name, t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]tableSize)
+	for rows.Next() {
+		var schema, table string
+		var size tableSize
+		if err := rows.Scan(&schema, &table, &size.rows, &size.dataKB, &size.indexKB); err != nil {
+			return nil, err
+		}
+		sizes[schema+"."+table] = size
+	}
+	return sizes, rows.Err()
+}
+
+// sourceTableSizes returns the estimated sizes of the tables of the source by
+// schema.table: from the partition stats of SQL Server, the table statistics
+// of MySQL, which are approximate for InnoDB tables, or the planner
+// statistics and relation sizes of PostgreSQL, summed over the partitions of
+// partitioned tables. Tables whose row count is not known, such as PostgreSQL
+// tables never analyzed, have 0 rows.
+func sourceTableSizes(source dbmigrate.Source, db *sql.DB, schemas []string) (map[string]tableSize, error) {
+	var rows *sql.Rows
+	var err error
+	switch source {
+	case dbmigrate.SQLServer:
+		return getTableSizes(db)
+	case dbmigrate.Postgres:
+		rows, err = db.Query(`
+			SELECT n.nspname, c.relname,
+				(SELECT CASE WHEN bool_or(p.reltuples < 0) THEN 0 ELSE COALESCE(sum(p.reltuples), 0) END::bigint
+					FROM pg_partition_tree(c.oid) t JOIN pg_class p ON p.oid = t.relid WHERE t.isleaf),
+				(SELECT COALESCE(sum(pg_table_size(t.relid)), 0)::bigint / 1024 FROM pg_partition_tree(c.oid) t),
+				(SELECT COALESCE(sum(pg_indexes_size(t.relid)), 0)::bigint / 1024 FROM pg_partition_tree(c.oid) t)
+			FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND n.nspname = ANY($1)`, pq.Array(schemas))
+	default:
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(schemas)), ", ")
+		params := make([]interface{}, len(schemas))
+		for i, schema := range schemas {
+			params[i] = schema
+		}
+		rows, err = db.Query(`
+			SELECT TABLE_SCHEMA, TABLE_NAME, COALESCE(TABLE_ROWS, 0),
+				COALESCE(DATA_LENGTH, 0) DIV 1024, COALESCE(INDEX_LENGTH, 0) DIV 1024
+			FROM information_schema.TABLES
+			WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA IN (`+placeholders+`)`, params...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]tableSize)
+	for rows.Next() {
+		var schema, table string
+		var size tableSize
+		if err := rows.Scan(&schema, &table, &size.rows, &size.dataKB, &size.indexKB); err != nil {
+			return nil, err
+		}
+		sizes[schema+"."+table] = size
+	}
+	return sizes, rows.Err()
+}
+
+// withoutTables returns the tables not in excluded
+func withoutTables(tables, excluded []string) []string {
+	skip := make(map[string]bool, len(excluded))
+	for _, table := range excluded {
+		skip[table] = true
+	}
+	var kept []string
+	for _, table := range tables {
+		if !skip[table] {
+			kept = append(kept, table)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql connector for tests that passes every statement to
+// its handlers: exec for statements, including BEGIN, COMMIT and ROLLBACK, and
+// query for queries. A nil handler accepts statements and returns no rows.
+// Executed statements are recorded in order.
+type fakeDB struct {
+	exec  func(query string, args []driver.Value) error
+	query func(query string, args []driver.Value) (*fakeRows, error)
+
+	mu         sync.Mutex
+	statements []string
+}
+
+// openFakeDB returns a database backed by f, closed when the test ends
+func openFakeDB(t *testing.T, f *fakeDB) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// executed returns the statements executed so far
+func (f *fakeDB) executed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.statements...)
+}
+
+func (f *fakeDB) run(query string, args []driver.Value) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = append(f.statements, query)
+	if f.exec == nil {
+		return nil
+	}
+	return f.exec(query, args)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake databases are opened with openFakeDB")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if err := c.db.run("BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return fakeTx{c.db}, nil
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error   { return tx.db.run("COMMIT", nil) }
+func (tx fakeTx) Rollback() error { return tx.db.run("ROLLBACK", nil) }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.db.run(s.query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.statements = append(s.db.statements, s.query)
+	if s.db.query == nil {
+		return &fakeRows{}, nil
+	}
+	rows, err := s.db.query(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = &fakeRows{}
+	}
+	return rows, nil
+}
+
+// fakeRows are the result of a query of a fakeDB
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if r.columns == nil && len(r.rows) > 0 {
+		// Scanning checks the number of columns only
+		return make([]string, len(r.rows[0]))
+	}
+	return r.columns
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// columnFormat is how the values of a column are written in a loaded file:
+// its date layout, number separators or boolean tokens
+type columnFormat struct {
+	dateLayout  string
+	zone        *time.Location
+	decimal     string // "" unless the column has number separators
+	thousands   string
+	trueValues  []string
+	falseValues []string
+}
+
+// fileFormats are the formats of the columns of a loaded file, by position
+type fileFormats struct {
+	columns []string
+	formats []*columnFormat // nil for columns read as PostgreSQL writes them
+}
+
+// newFileFormats returns the formats configured for the columns (target
+// names, matched case-insensitively) of a file loaded into a table, or nil if
+// none is. Dates are read in the column's timezone, else in zone.
+func newFileFormats(settings dbmigrate.TableConfig, columns []string, zone *time.Location) (*fileFormats, error) {
+	f := &fileFormats{columns: columns, formats: make([]*columnFormat, len(columns))}
+	configured := false
+	for i, column := range columns {
+		for name, columnSettings := range settings.Columns {
+			if !strings.EqualFold(name, column) {
+				continue
+			}
+			format := &columnFormat{
+				dateLayout:  columnSettings.DateLayout,
+				zone:        zone,
+				thousands:   columnSettings.ThousandsSeparator,
+				trueValues:  columnSettings.TrueValues,
+				falseValues: columnSettings.FalseValues,
+			}
+			if columnSettings.DecimalSeparator != "" || columnSettings.ThousandsSeparator != "" {
+				format.decimal = columnSettings.DecimalSeparator
+				if format.decimal == "" {
+					format.decimal = "."
+				}
+			}
+			if format.dateLayout == "" && format.decimal == "" && len(format.trueValues) == 0 {
+				continue
+			}
+			if columnSettings.Timezone != "" {
+				var err error
+				if format.zone, err = time.LoadLocation(columnSettings.Timezone); err != nil {
+					return nil, fmt.Errorf("invalid timezone for column %s: %v", column, err)
+				}
+			}
+			f.formats[i] = format
+			configured = true
+			fmt.Printf("Reading column %s as %s\n", column, format.describe())
+		}
+	}
+	if !configured {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// describe returns the format in messages
+func (f *columnFormat) describe() string {
+	var parts []string
+	if f.dateLayout != "" {
+		parts = append(parts, fmt.Sprintf("dates %q in %s", f.dateLayout, f.zone))
+	}
+	if f.decimal != "" {
+		parts = append(parts, fmt.Sprintf("numbers with decimal separator %q and thousands separator %q", f.decimal, f.thousands))
+	}
+	if len(f.trueValues) > 0 {
+		parts = append(parts, fmt.Sprintf("booleans %s / %s", strings.Join(f.trueValues, ", "), strings.Join(f.falseValues, ", ")))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// hasNumbers tells whether the column at position i has number separators,
+// so numbers of JSON Lines files are left for transform as json.Number
+func (f *fileFormats) hasNumbers(i int) bool {
+	return f != nil && f.formats[i] != nil && f.formats[i].decimal != ""
+}
+
+// transform returns a row transform parsing the text values of the columns
+// with a format, or nil without formats. Empty strings are left as they are.
+// JSON numbers (json.Number) are passed as their text, as they are written
+// with a decimal point whatever the separators of the column.
+func (f *fileFormats) transform() func(values []interface{}) error {
+	if f == nil {
+		return nil
+	}
+	return func(values []interface{}) error {
+		for i, format := range f.formats {
+			if format == nil {
+				continue
+			}
+			switch v := values[i].(type) {
+			case json.Number:
+				values[i] = v.String()
+			case string:
+				if v == "" {
+					continue
+				}
+				value, err := format.parse(strings.TrimSpace(v))
+				if err != nil {
+					return fmt.Errorf("column %s: %v", f.columns[i], err)
+				}
+				values[i] = value
+			}
+		}
+		return nil
+	}
+}
+
+// parse converts a value written in the format to a time, a boolean or a
+// number as PostgreSQL reads it
+func (f *columnFormat) parse(s string) (interface{}, error) {
+	switch {
+	case f.dateLayout != "":
+		t, err := time.ParseInLocation(f.dateLayout, s, f.zone)
+		if err != nil {
+			return nil, fmt.Errorf("%q does not match date_layout %q", s, f.dateLayout)
+		}
+		return t, nil
+	case len(f.trueValues) > 0:
+		for _, token := range f.trueValues {
+			if strings.EqualFold(s, token) {
+				return true, nil
+			}
+		}
+		for _, token := range f.falseValues {
+			if strings.EqualFold(s, token) {
+				return false, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is none of the true_values or false_values", s)
+	}
+	if f.thousands != "" {
+		s = strings.ReplaceAll(s, f.thousands, "")
+	}
+	if f.decimal != "." {
+		if strings.Contains(s, ".") {
+			return nil, fmt.Errorf("%q has a decimal point, but the decimal separator is %q", s, f.decimal)
+		}
+		s = strings.Replace(s, f.decimal, ".", 1)
+	}
+	return s, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+func TestFileFormats(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	settings := dbmigrate.TableConfig{Columns: map[string]dbmigrate.ColumnConfig{
+		"orderdate":  {DateLayout: "02.01.2006 15:04"},
+		"ShippedAt":  {DateLayout: "2006-01-02T15:04:05Z07:00", Timezone: "America/New_York"},
+		"LocalTime":  {DateLayout: "01/02/2006 3:04 PM", Timezone: "America/New_York"},
+		"Amount":     {DecimalSeparator: ",", ThousandsSeparator: "."},
+		"Quantity":   {ThousandsSeparator: ","},
+		"Rate":       {DecimalSeparator: ","},
+		"Swiss":      {DecimalSeparator: ".", ThousandsSeparator: "'"},
+		"Shipped":    {TrueValues: []string{"ja", "j"}, FalseValues: []string{"nein", "n"}},
+		"NullPolicy": {NullPolicy: dbmigrate.NullPolicyEmptyToNull},
+	}}
+	columns := []string{"OrderDate", "ShippedAt", "LocalTime", "Amount", "Quantity", "Rate", "Swiss", "Shipped", "NullPolicy", "Name"}
+	formats, err := newFileFormats(settings, columns, berlin)
+	if err != nil {
+		t.Fatalf("newFileFormats() failed: %v", err)
+	}
+	for i, column := range columns {
+		if configured := formats.formats[i] != nil; configured != (i < 8) {
+			t.Errorf("column %s has a format: %v", column, configured)
+		}
+	}
+	transform := formats.transform()
+
+	tests := []struct {
+		name    string
+		column  string
+		value   interface{}
+		want    interface{}
+		wantErr string
+	}{
+		// Dates are read in the zone of the column, else in the file's
+		{"date in the default zone", "OrderDate", "31.03.2024 14:30", time.Date(2024, 3, 31, 14, 30, 0, 0, berlin), ""},
+		{"date in winter time", "OrderDate", "02.01.2024 08:00", time.Date(2024, 1, 2, 8, 0, 0, 0, berlin), ""},
+		{"date with an offset", "ShippedAt", "2024-03-31T14:30:00+02:00", time.Date(2024, 3, 31, 12, 30, 0, 0, time.UTC), ""},
+		{"date in the column's zone", "LocalTime", "11/03/2024 1:30 PM", time.Date(2024, 11, 3, 13, 30, 0, 0, newYork), ""},
+		{"surrounding spaces", "OrderDate", " 31.03.2024 14:30 ", time.Date(2024, 3, 31, 14, 30, 0, 0, berlin), ""},
+		{"date not matching", "OrderDate", "2024-03-31 14:30", nil, `does not match date_layout "02.01.2006 15:04"`},
+
+		// Numbers are passed as text with a decimal point
+		{"comma decimals with thousands", "Amount", "1.234.567,89", "1234567.89", ""},
+		{"negative comma decimals", "Amount", "-0,5", "-0.5", ""},
+		{"comma decimals without thousands", "Rate", "3,75", "3.75", ""},
+		{"decimal point in a comma column", "Rate", "3.75", nil, `has a decimal point, but the decimal separator is ","`},
+		{"thousands only", "Quantity", "12,500", "12500", ""},
+		{"thousands with point decimals", "Quantity", "1,234.5", "1234.5", ""},
+		{"apostrophe thousands", "Swiss", "1'234.50", "1234.50", ""},
+		{"JSON number", "Amount", json.Number("1234.5"), "1234.5", ""},
+
+		// Booleans match their tokens case-insensitively
+		{"true token", "Shipped", "JA", true, ""},
+		{"second true token", "Shipped", "j", true, ""},
+		{"false token", "Shipped", "Nein", false, ""},
+		{"unknown token", "Shipped", "vielleicht", nil, "is none of the true_values or false_values"},
+
+		// Empty strings and columns without formats are left as they are
+		{"empty date", "OrderDate", "", "", ""},
+		{"empty boolean", "Shipped", "", "", ""},
+		{"column without a format", "Name", "1.234,5", "1.234,5", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := make([]interface{}, len(columns))
+			index := -1
+			for i, column := range columns {
+				if column == tt.column {
+					index = i
+				}
+			}
+			values[index] = tt.value
+			err := transform(values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.HasPrefix(err.Error(), "column "+tt.column+":") {
+					t.Fatalf("transform(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transform(%q) failed: %v", tt.value, err)
+			}
+			got := values[index]
+			if want, ok := tt.want.(time.Time); ok {
+				if got, ok := got.(time.Time); !ok || !got.Equal(want) {
+					t.Errorf("transform(%q) = %v, want %v", tt.value, values[index], want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transform(%q) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileFormatsNone(t *testing.T) {
+	settings := dbmigrate.TableConfig{Columns: map[string]dbmigrate.ColumnConfig{"Name": {NullPolicy: dbmigrate.NullPolicyEmptyToNull}}}
+	formats, err := newFileFormats(settings, []string{"Name"}, time.UTC)
+	if err != nil || formats != nil {
+		t.Fatalf("newFileFormats() = %+v, %v, want nil", formats, err)
+	}
+	if formats.transform() != nil || formats.hasNumbers(0) {
+		t.Error("a nil fileFormats has a transform or number columns")
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// fileKeyPrefix prefixes the state store keys recording the rows loaded from
+// each file by load -files
+const fileKeyPrefix = "file:"
+
+// File formats read by load -files
+const (
+	fileFormatCSV   = "csv"
+	fileFormatJSONL = "jsonl"
+)
+
+// loadFile is a file of rows to load into a table
+type loadFile struct {
+	path   string
+	format string
+	table  string // schema.table, mapped like a source table
+}
+
+// listLoadFiles returns the files to load from path: the .csv and .jsonl files
+// (optionally .gz) of a directory, named <schema>.<table> as archive writes
+// them, or a single file, loaded into table if set. format overrides the
+// format told by the extension of a single file.
+func listLoadFiles(path, format, table string) ([]loadFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		name, fileFormat := splitLoadFileName(filepath.Base(path))
+		if format != "" {
+			fileFormat = format
+		}
+		if fileFormat == "" {
+			return nil, fmt.Errorf("cannot tell the format of %s from its name, use -format", path)
+		}
+		if table == "" {
+			table = name
+		}
+		if !strings.Contains(table, ".") {
+			return nil, fmt.Errorf("cannot tell the table of %s from its name, use -table schema.table", path)
+		}
+		return []loadFile{{path: path, format: fileFormat, table: table}}, nil
+	}
+	if table != "" {
+		return nil, fmt.Errorf("-table needs a single file, not a directory")
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []loadFile
+	for _, entry := range entries {
+		name, fileFormat := splitLoadFileName(entry.Name())
+		if entry.IsDir() || fileFormat == "" || (format != "" && fileFormat != format) || !strings.Contains(name, ".") {
+			continue
+		}
+		files = append(files, loadFile{path: filepath.Join(path, entry.Name()), format: fileFormat, table: name})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// splitLoadFileName splits a file name into the table name and the format
+// told by its extension, which is empty if unknown
+func splitLoadFileName(name string) (string, string) {
+	name = strings.TrimSuffix(name, ".gz")
+	for _, format := range []string{fileFormatCSV, fileFormatJSONL} {
+		if table, ok := strings.CutSuffix(name, "."+format); ok {
+			return table, format
+		}
+	}
+	if i := strings.LastIndexByte(name, '.'); i > 0 && strings.Count(name, ".") > 1 {
+		return name[:i], ""
+	}
+	return name, ""
+}
+
+// loadFiles loads files into their target tables, returning the rows loaded
+func (m *migrator) loadFiles(files []loadFile, csvOptions dbmigrate.CSVOptions, batchSize int) (int64, error) {
+	truncated := make(map[string]bool)
+	var total int64
+	for _, file := range files {
+		rows, err := m.loadFile(file, csvOptions, batchSize, truncated)
+		total += rows
+		if err != nil {
+			return total, fmt.Errorf("error loading %s: %v", file.path, err)
+		}
+	}
+	return total, nil
+}
+
+// loadFile loads a file with COPY in transactions of batchSize rows,
+// checkpointing the rows loaded so an interrupted load continues after them.
+// Naive timestamps are read in -source-timezone. Target tables are truncated
+// with -truncate before their first file is loaded.
+func (m *migrator) loadFile(file loadFile, csvOptions dbmigrate.CSVOptions, batchSize int, truncated map[string]bool) (int64, error) {
+	path, err := filepath.Abs(file.path)
+	if err != nil {
+		return 0, err
+	}
+	key := fileKeyPrefix + path
+	cp, hasCheckpoint := m.checkpoints[key]
+	if hasCheckpoint && cp.Completed {
+		fmt.Printf("Skipping file already loaded (checkpoint): %s\n", file.path)
+		return 0, nil
+	}
+
+	parts := strings.SplitN(file.table, ".", 2)
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+	targetColumns, err := loadTargetColumns(m.targetDb, targetSchema, targetTable, m.preserveCase)
+	if err != nil {
+		return 0, err
+	}
+	if len(targetColumns) == 0 {
+		return 0, fmt.Errorf("target table %s does not exist", target)
+	}
+
+	var readRow func() ([]interface{}, error)
+	var columns []string
+	var formats *fileFormats
+	settings := m.config.TableSettings(file.table)
+	switch file.format {
+	case fileFormatCSV:
+		reader, err := dbmigrate.OpenCSVTableFile(file.path, csvOptions)
+		if err != nil {
+			return 0, err
+		}
+		defer reader.Close()
+		columns = make([]string, len(targetColumns))
+		for i, column := range targetColumns {
+			columns[i] = column.name
+		}
+		if csvOptions.Header {
+			if columns, err = matchLoadColumns(reader.Columns, targetColumns, target); err != nil {
+				return 0, err
+			}
+		}
+		if formats, err = newFileFormats(settings, columns, m.sourceTimezone); err != nil {
+			return 0, err
+		}
+		readRow = func() ([]interface{}, error) {
+			line := reader.Line()
+			values, err := reader.ReadRow()
+			if err == nil && len(values) != len(columns) {
+				err = fmt.Errorf("line %d has %d fields, expected %d", line, len(values), len(columns))
+			}
+			return values, err
+		}
+	case fileFormatJSONL:
+		reader, err := dbmigrate.OpenJSONLTableFile(file.path)
+		if err != nil {
+			return 0, err
+		}
+		defer reader.Close()
+		first, err := reader.ReadObject()
+		if err == io.EOF {
+			fmt.Printf("No rows in %s\n", file.path)
+			m.saveCheckpoint(dbmigrate.Checkpoint{Table: key, Completed: true})
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		keys := make([]string, 0, len(first))
+		for key := range first {
+			keys = append(keys, key)
+		}
+		if columns, err = matchLoadColumns(keys, targetColumns, target); err != nil {
+			return 0, err
+		}
+		// Columns are in table order, whatever the order of the keys
+		sort.Slice(columns, func(i, j int) bool {
+			return loadColumnIndex(targetColumns, columns[i]) < loadColumnIndex(targetColumns, columns[j])
+		})
+		if formats, err = newFileFormats(settings, columns, m.sourceTimezone); err != nil {
+			return 0, err
+		}
+		readRow = jsonlRowReader(reader, first, columns, targetColumns, formats)
+	default:
+		return 0, fmt.Errorf("unknown format %s", file.format)
+	}
+
+	// A partially loaded file continues after the checkpointed rows
+	skip := cp.RowsMigrated
+	if skip > 0 {
+		fmt.Printf("File %s was partially loaded by a previous run (%d rows checkpointed), resuming it\n", file.path, skip)
+	} else if m.truncate && !truncated[target] {
+		if err := truncateTargetTable(m.targetDb, targetSchema, targetTable, m.preserveCase, m.restartIdentity); err != nil {
+			return 0, fmt.Errorf("error truncating %s: %v", target, err)
+		}
+		fmt.Printf("Truncated table: %s\n", target)
+	}
+	truncated[target] = true
+	fmt.Printf("Loading %s into %s\n", file.path, target)
+
+	columnList := make([]string, len(columns))
+	for i, column := range columns {
+		columnList[i] = dbmigrate.QuoteIdent(column, m.preserveCase)
+	}
+	copyQuery := fmt.Sprintf("COPY %s (%s) FROM STDIN", target, strings.Join(columnList, ", "))
+	setup := []string{fmt.Sprintf("SET LOCAL TimeZone = '%s'", strings.ReplaceAll(m.sourceTimezone.String(), "'", "''"))}
+	sanitized := make(map[string]int64)
+	transformRow := chainTransforms(sanitizeTransform(m.invalidText, columns, sanitized), formats.transform())
+	var onReject func(values []interface{}, err error) error
+	if m.rejects != nil {
+		onReject = func(values []interface{}, rowErr error) error {
+			return m.rejects.reject(target, rowKey(columns, nil, values), rowErr)
+		}
+	}
+
+	var loaded int64
+	var batch [][]interface{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		copied, err := copyChunk(m.ctx, m.targetDb, setup, copyQuery, columns, batch, transformRow, onReject)
+		if err != nil {
+			return err
+		}
+		loaded += int64(copied)
+		skip += int64(len(batch))
+		batch = batch[:0]
+		m.saveCheckpoint(dbmigrate.Checkpoint{Table: key, RowsMigrated: skip})
+		fmt.Printf("  Loaded %d rows...\n", skip)
+		return nil
+	}
+	for read := int64(0); ; read++ {
+		if m.ctx.Err() != nil {
+			return loaded, m.ctx.Err()
+		}
+		values, err := readRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if read < skip {
+			continue
+		}
+		batch = append(batch, values)
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return loaded, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return loaded, err
+	}
+	m.saveCheckpoint(dbmigrate.Checkpoint{Table: key, RowsMigrated: skip, Completed: true})
+	for column, count := range sanitized {
+		fmt.Printf("Cleaned %d values with NUL bytes or invalid UTF-8 in column %s (-invalid-text %s)\n", count, column, m.invalidText)
+	}
+	fmt.Printf("✅ Loaded %d rows from %s into %s\n", loaded, file.path, target)
+	return loaded, nil
+}
+
+// loadColumn is a column of a target table loaded from files
+type loadColumn struct {
+	name     string
+	dataType string
+}
+
+// loadTargetColumns returns the columns of a target table that can be
+// written, in table order; none if the table does not exist
+func loadTargetColumns(db *sql.DB, schema, table string, preserveCase bool) ([]loadColumn, error) {
+	if !preserveCase {
+		schema, table = strings.ToLower(schema), strings.ToLower(table)
+	}
+	rows, err := db.Query(`
+		SELECT column_name, data_type
+		FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = $2 AND is_generated = 'NEVER'
+		ORDER BY ordinal_position`, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns of %s.%s: %v", schema, table, err)
+	}
+	defer rows.Close()
+	var columns []loadColumn
+	for rows.Next() {
+		var column loadColumn
+		if err := rows.Scan(&column.name, &column.dataType); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// matchLoadColumns returns the target columns of the names of a header row or
+// of the keys of a JSON object, matched case-insensitively
+func matchLoadColumns(names []string, targetColumns []loadColumn, target string) ([]string, error) {
+	columns := make([]string, len(names))
+	for i, name := range names {
+		index := loadColumnIndex(targetColumns, name)
+		if index < 0 {
+			return nil, fmt.Errorf("column %s is not a column of %s", name, target)
+		}
+		columns[i] = targetColumns[index].name
+	}
+	return columns, nil
+}
+
+// loadColumnIndex returns the position of a column among the target columns,
+// or -1
+func loadColumnIndex(targetColumns []loadColumn, name string) int {
+	for i, column := range targetColumns {
+		if column.name == name {
+			return i
+		}
+	}
+	for i, column := range targetColumns {
+		if strings.EqualFold(column.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// jsonlRowReader returns a function reading the values of columns from the
+// objects of a JSON Lines file, starting with first. Missing keys are NULL.
+// Numbers keep their digits, strings of bytea columns are base64-decoded, and
+// arrays and objects are passed as JSON text, for json and jsonb columns.
+// Numbers of columns with number separators are left as json.Number for the
+// transform of formats, which parses the strings of these columns.
+func jsonlRowReader(reader *dbmigrate.JSONLTableReader, first map[string]interface{}, columns []string, targetColumns []loadColumn, formats *fileFormats) func() ([]interface{}, error) {
+	binary := make([]bool, len(columns))
+	known := make(map[string]int, len(columns))
+	for i, column := range columns {
+		binary[i] = targetColumns[loadColumnIndex(targetColumns, column)].dataType == "bytea"
+		known[strings.ToLower(column)] = i
+	}
+	next := first
+	row := 0
+	return func() ([]interface{}, error) {
+		object := next
+		if object == nil {
+			var err error
+			if object, err = reader.ReadObject(); err != nil {
+				return nil, err
+			}
+		}
+		next = nil
+		row++
+
+		values := make([]interface{}, len(columns))
+		for key, value := range object {
+			i, ok := known[strings.ToLower(key)]
+			if !ok {
+				return nil, fmt.Errorf("row %d has key %s, which is not a column of the first row", row, key)
+			}
+			switch v := value.(type) {
+			case json.Number:
+				if formats.hasNumbers(i) {
+					values[i] = v
+				} else {
+					values[i] = v.String()
+				}
+			case string:
+				if !binary[i] {
+					values[i] = v
+				} else if b, err := base64.StdEncoding.DecodeString(v); err != nil {
+					return nil, fmt.Errorf("row %d: column %s is not base64: %v", row, key, err)
+				} else {
+					values[i] = b
+				}
+			case map[string]interface{}, []interface{}:
+				text, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = string(text)
+			default:
+				values[i] = v
+			}
+		}
+		return values, nil
+	}
+}
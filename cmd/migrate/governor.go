@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Pauses between batches of the load governor
+const (
+	minThrottlePause = 250 * time.Millisecond
+	maxThrottlePause = 30 * time.Second
+)
+
+// loadGovernor paces the copy so the source and target stay below latency
+// thresholds. While a batch exceeds a threshold, the governor first lowers the
+// number of tables copied at the same time, one table per slow batch, down to
+// a single table; then it pauses between batches, doubling the pause with each
+// further slow batch. Once both latencies are back below half of their
+// thresholds, it halves the pause and then raises the number of tables again,
+// one per batch, until the copy runs at full speed. A nil governor never slows
+// the copy down.
+type loadGovernor struct {
+	maxSourceLatency time.Duration // 0 = not watched
+	maxCommitLatency time.Duration // 0 = not watched
+	maxWorkers       int           // tables copied at the same time at full speed
+	mu               sync.Mutex    // tables copied in parallel share the governor
+	workers          int           // tables that may be copied at the same time
+	pause            time.Duration
+	paused           time.Duration // total time paused
+}
+
+// newLoadGovernor returns a governor for the thresholds scaling between 1 and
+// maxWorkers tables, or nil if both thresholds are 0
+func newLoadGovernor(maxSourceLatency, maxCommitLatency time.Duration, maxWorkers int) *loadGovernor {
+	if maxSourceLatency <= 0 && maxCommitLatency <= 0 {
+		return nil
+	}
+	maxWorkers = max(maxWorkers, 1)
+	return &loadGovernor{maxSourceLatency: maxSourceLatency, maxCommitLatency: maxCommitLatency, maxWorkers: maxWorkers, workers: maxWorkers}
+}
+
+// observe adjusts the number of tables and the pause to the latencies of a
+// batch: the longest wait for a source row (including the time to the first row
+// of a query) and the time the target took to commit
+func (g *loadGovernor) observe(sourceLatency, commitLatency time.Duration) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sourceHigh := g.maxSourceLatency > 0 && sourceLatency > g.maxSourceLatency
+	commitHigh := g.maxCommitLatency > 0 && commitLatency > g.maxCommitLatency
+	switch {
+	case sourceHigh || commitHigh:
+		reason := fmt.Sprintf("source latency %s exceeds %s", sourceLatency.Round(time.Millisecond), g.maxSourceLatency)
+		if !sourceHigh {
+			reason = fmt.Sprintf("commit latency %s exceeds %s", commitLatency.Round(time.Millisecond), g.maxCommitLatency)
+		}
+		if g.workers > 1 {
+			g.workers--
+			tables := fmt.Sprintf("up to %d tables", g.workers)
+			if g.workers == 1 {
+				tables = "one table"
+			}
+			fmt.Printf("⚠️  Throttling: %s, copying %s at a time\n", reason, tables)
+			return
+		}
+		pause := min(max(g.pause*2, minThrottlePause), maxThrottlePause)
+		if pause != g.pause {
+			fmt.Printf("⚠️  Throttling: %s, pausing %s between batches\n", reason, pause)
+		}
+		g.pause = pause
+	case (g.maxSourceLatency <= 0 || sourceLatency <= g.maxSourceLatency/2) &&
+		(g.maxCommitLatency <= 0 || commitLatency <= g.maxCommitLatency/2):
+		switch {
+		case g.pause > 0:
+			if g.pause /= 2; g.pause < minThrottlePause {
+				g.pause = 0
+				if g.workers == g.maxWorkers {
+					fmt.Println("✅ Load back below the thresholds, copying at full speed")
+				}
+			}
+		case g.workers < g.maxWorkers:
+			g.workers++
+			if g.workers == g.maxWorkers {
+				fmt.Printf("✅ Load back below the thresholds, copying up to %d tables at a time\n", g.workers)
+			}
+		}
+	}
+}
+
+// workerLimit returns how many of at most maxWorkers tables may be copied at
+// the same time
+func (g *loadGovernor) workerLimit(maxWorkers int) int {
+	if g == nil {
+		return maxWorkers
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return min(g.workers, maxWorkers)
+}
+
+// wait pauses before the next batch, returning early if ctx is canceled
+func (g *loadGovernor) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	pause := g.pause
+	g.paused += pause
+	g.mu.Unlock()
+	if pause == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(pause):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
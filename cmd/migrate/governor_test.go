@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadGovernor(t *testing.T) {
+	if g := newLoadGovernor(0, 0, 4); g != nil {
+		t.Fatalf("newLoadGovernor without thresholds = %+v, want nil", g)
+	}
+	var none *loadGovernor
+	if limit := none.workerLimit(4); limit != 4 {
+		t.Errorf("workerLimit of a nil governor = %d, want 4", limit)
+	}
+
+	g := newLoadGovernor(0, 2*time.Second, 3)
+	slow, fast := 3*time.Second, 500*time.Millisecond
+	steps := []struct {
+		commit      time.Duration
+		wantWorkers int
+		wantPause   time.Duration
+	}{
+		// Fewer tables first, then pauses
+		{slow, 2, 0},
+		{slow, 1, 0},
+		{slow, 1, minThrottlePause},
+		{slow, 1, 2 * minThrottlePause},
+		// Between half the threshold and the threshold nothing changes
+		{1500 * time.Millisecond, 1, 2 * minThrottlePause},
+		// Pauses go first, then tables are added back
+		{fast, 1, minThrottlePause},
+		{fast, 1, 0},
+		{fast, 2, 0},
+		{fast, 3, 0},
+		{fast, 3, 0},
+	}
+	for i, step := range steps {
+		g.observe(0, step.commit)
+		if limit := g.workerLimit(3); limit != step.wantWorkers || g.pause != step.wantPause {
+			t.Fatalf("after batch %d (commit %s): %d tables, pause %s, want %d tables, pause %s",
+				i+1, step.commit, limit, g.pause, step.wantWorkers, step.wantPause)
+		}
+	}
+	if limit := g.workerLimit(2); limit != 2 {
+		t.Errorf("workerLimit(2) = %d, want 2", limit)
+	}
+
+	// The pause is capped
+	g = newLoadGovernor(time.Second, 0, 1)
+	for range 20 {
+		g.observe(2*time.Second, 0)
+	}
+	if g.pause != maxThrottlePause {
+		t.Errorf("pause = %s, want %s", g.pause, maxThrottlePause)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.wait(ctx); err == nil {
+		t.Error("wait() with a canceled context succeeded")
+	}
+}
+
+func TestCopyTablesInParallelGovernor(t *testing.T) {
+	// The governor lowers the tables copied at the same time to one
+	g := newLoadGovernor(0, time.Second, 3)
+	g.observe(0, 2*time.Second)
+	g.observe(0, 2*time.Second)
+	m := &migrator{
+		ctx:            context.Background(),
+		tables:         []string{"dbo.A", "dbo.B", "dbo.C", "dbo.D"},
+		parallelTables: 3,
+		governor:       g,
+	}
+	var mu sync.Mutex
+	running, most := 0, 0
+	err := m.copyTablesInParallel(func(table string) error {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("copyTablesInParallel() failed: %v", err)
+	}
+	if most != 1 {
+		t.Errorf("%d tables copied at the same time, want 1", most)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthServer exposes liveness and readiness endpoints for orchestrators such as Kubernetes
+type healthServer struct {
+	ready atomic.Bool
+
+	mu     sync.Mutex
+	cycles cycleStatus
+}
+
+// cycleStatus reports the cycles of a -daemon run
+type cycleStatus struct {
+	Cycles              int    `json:"cycles"`
+	LastSuccess         string `json:"last_success,omitempty"`
+	LastFailure         string `json:"last_failure,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// startHealthServer starts the HTTP listener in the background.
+// /healthz reports liveness, /readyz readiness and /status the daemon cycles as JSON.
+func startHealthServer(addr string) *healthServer {
+	h := &healthServer{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		status := h.cycles
+		h.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Warning: Health server stopped: %v", err)
+		}
+	}()
+	fmt.Printf("Health endpoints listening on %s (/healthz, /readyz, /status)\n", addr)
+
+	return h
+}
+
+// setReady marks the process as ready (or not) to do work. Safe to call on a nil server.
+func (h *healthServer) setReady(ready bool) {
+	if h != nil {
+		h.ready.Store(ready)
+	}
+}
+
+// recordCycle records the outcome of a -daemon cycle. Safe to call on a nil server.
+func (h *healthServer) recordCycle(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cycles.Cycles++
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		h.cycles.LastFailure = now
+		h.cycles.LastError = err.Error()
+		h.cycles.ConsecutiveFailures++
+		return
+	}
+	h.cycles.LastSuccess = now
+	h.cycles.ConsecutiveFailures = 0
+}
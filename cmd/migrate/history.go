@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// trendRuns is the number of recent successful runs averaged for the trend
+const trendRuns = 3
+
+// runHistory implements the "history" subcommand, which shows the duration and
+// throughput of previous runs recorded in the state store
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	stateFlag := fs.String("state", "", "State store holding the run history: file:<path> or target[:schema.table]")
+	targetDsnFlag := fs.String("target-dsn", "", "PostgreSQL connection string, for a state store in the target database (default: TARGET_DB_DSN environment variable)")
+	limitFlag := fs.Int("limit", 20, "Number of most recent runs to show (0 = all)")
+	rowsFlag := fs.Int64("rows", 0, "Predict the duration of a run migrating this many rows (default: the rows of the latest successful run)")
+	fs.Parse(args)
+
+	if *stateFlag == "" {
+		log.Fatal("No state store given. Use -state with the same value as the migration runs.")
+	}
+
+	var targetDb *sql.DB
+	if !strings.HasPrefix(*stateFlag, "file:") {
+		targetDsn := *targetDsnFlag
+		if targetDsn == "" {
+			targetDsn = os.Getenv("TARGET_DB_DSN")
+		}
+		if targetDsn == "" {
+			log.Fatal("No target database connection. Set TARGET_DB_DSN environment variable or use -target-dsn flag.")
+		}
+		var err error
+		targetDb, err = sql.Open("postgres", targetDsn)
+		if err != nil {
+			log.Fatalf("Error connecting to target database: %v", err)
+		}
+		defer targetDb.Close()
+	}
+
+	stateStore, err := dbmigrate.OpenStateStore(*stateFlag, targetDb, true)
+	if err != nil {
+		log.Fatalf("Error opening state store: %v", err)
+	}
+	records, err := dbmigrate.LoadRunHistory(stateStore)
+	if err != nil {
+		log.Fatalf("Error loading run history: %v", err)
+	}
+	if len(records) == 0 {
+		fmt.Println("No runs recorded yet. Runs are recorded when -state is used.")
+		return
+	}
+
+	shown := records
+	if *limitFlag > 0 && len(shown) > *limitFlag {
+		shown = shown[len(shown)-*limitFlag:]
+	}
+	fmt.Printf("%-20s  %-36s  %-11s  %10s  %12s  %10s  %9s\n", "STARTED", "RUN ID", "STATUS", "DURATION", "ROWS", "ROWS/S", "MB/S")
+	for _, record := range shown {
+		mbPerSecond := 0.0
+		if seconds := record.Duration().Seconds(); seconds > 0 {
+			mbPerSecond = float64(record.Bytes) / (1024 * 1024) / seconds
+		}
+		fmt.Printf("%-20s  %-36s  %-11s  %10s  %12d  %10.0f  %9.2f\n",
+			record.StartedAt.Local().Format("2006-01-02 15:04:05"), record.RunID, record.Status,
+			record.Duration().Round(time.Second), record.Rows, record.RowsPerSecond(), mbPerSecond)
+	}
+
+	// Average the throughput of the most recent successful runs
+	var recent []dbmigrate.RunRecord
+	for i := len(records) - 1; i >= 0 && len(recent) < trendRuns; i-- {
+		if records[i].Status == dbmigrate.StatusSucceeded && records[i].Rows > 0 {
+			recent = append(recent, records[i])
+		}
+	}
+	if len(recent) == 0 {
+		fmt.Println("\nNo successful runs yet, cannot predict a duration")
+		return
+	}
+	var rowsPerSecond float64
+	for _, record := range recent {
+		rowsPerSecond += record.RowsPerSecond()
+	}
+	rowsPerSecond /= float64(len(recent))
+
+	fmt.Printf("\nAverage throughput of the last %d successful runs: %.0f rows/s\n", len(recent), rowsPerSecond)
+	if len(recent) > 1 {
+		latest, oldest := recent[0].RowsPerSecond(), recent[len(recent)-1].RowsPerSecond()
+		if oldest > 0 {
+			fmt.Printf("Throughput trend: %+.0f%% (from %.0f to %.0f rows/s)\n", (latest-oldest)/oldest*100, oldest, latest)
+		}
+	}
+
+	rows := *rowsFlag
+	if rows == 0 {
+		rows = recent[0].Rows
+	}
+	if rowsPerSecond > 0 {
+		predicted := time.Duration(float64(rows) / rowsPerSecond * float64(time.Second))
+		fmt.Printf("Predicted duration for %d rows: %s\n", rows, predicted.Round(time.Second))
+	}
+}
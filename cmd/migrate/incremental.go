@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watermarkKeyPrefix prefixes the state store keys recording the highest
+// watermark value copied of each table with -incremental
+const watermarkKeyPrefix = "watermark:"
+
+// incrementalRange selects the rows of a table an incremental run copies
+type incrementalRange struct {
+	column string
+	// where selects the rows with a watermark from the recorded one up to
+	// high, or is empty if no watermark was recorded yet
+	where string
+	// high is the highest watermark value when the run started, recorded once
+	// the table is copied; nil if the column has no values
+	high []interface{}
+}
+
+// incrementalRange returns the rows of a table to copy with -incremental, or
+// nil if the table has no -watermark-column and is copied whole. Rows with the
+// recorded watermark value are copied again, as rows changed later in the same
+// instant may have been missed; with upserts this is harmless.
+func (m *migrator) incrementalRange(table string, columns []string) (*incrementalRange, error) {
+	r := &incrementalRange{}
+	for _, column := range columns {
+		if strings.EqualFold(column, m.watermarkColumn) {
+			r.column = column
+		}
+	}
+	if r.column == "" {
+		log.Printf("Warning: Table %s has no watermark column %s, copying all rows", table, m.watermarkColumn)
+		return nil, nil
+	}
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	sourceType := ""
+	for _, column := range columnTypes {
+		if column[0] == r.column {
+			sourceType = strings.ToLower(column[1])
+		}
+	}
+
+	// Rows changed while the table is copied are copied by the next run
+	parts := strings.SplitN(table, ".", 2)
+	var high interface{}
+	query := fmt.Sprintf("SELECT MAX([%s]) FROM [%s].[%s]", r.column, parts[0], parts[1])
+	if err := m.sourceDb.QueryRowContext(m.ctx, query).Scan(&high); err != nil {
+		return nil, fmt.Errorf("error reading the highest %s of %s: %v", r.column, table, err)
+	}
+	if b, ok := high.([]byte); ok && numericTypes[sourceType] {
+		high = string(b)
+	}
+	if high != nil {
+		r.high = []interface{}{high}
+	}
+
+	cp, ok := m.checkpoint(watermarkKeyPrefix + table)
+	if !ok {
+		fmt.Printf("No watermark recorded for %s, copying all rows\n", table)
+		return r, nil
+	}
+	low, err := decodeKey(cp.Value)
+	if err != nil || len(low) != 1 {
+		return nil, fmt.Errorf("error reading the watermark of %s: %v", table, err)
+	}
+	lowLiteral, err := watermarkLiteral(low[0], sourceType)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the watermark of %s: %v", table, err)
+	}
+	r.where = fmt.Sprintf("[%s] >= %s", r.column, lowLiteral)
+	if high != nil {
+		highLiteral, err := watermarkLiteral(high, sourceType)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the highest %s of %s: %v", r.column, table, err)
+		}
+		r.where += fmt.Sprintf(" AND [%s] <= %s", r.column, highLiteral)
+	}
+	fmt.Printf("Copying rows of %s with %s\n", table, r.where)
+	return r, nil
+}
+
+// watermarkLiteral returns a T-SQL literal of a watermark value of a column of
+// the given source type
+func watermarkLiteral(value interface{}, sourceType string) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		if sourceType == "datetimeoffset" {
+			return fmt.Sprintf("CAST('%s' AS DATETIMEOFFSET(7))", v.Format("2006-01-02T15:04:05.9999999-07:00")), nil
+		}
+		return fmt.Sprintf("CAST('%s' AS DATETIME2(7))", v.Format("2006-01-02T15:04:05.9999999")), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []byte:
+		return "0x" + hex.EncodeToString(v), nil
+	case string:
+		if numericTypes[sourceType] {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return "", fmt.Errorf("invalid numeric watermark %q", v)
+			}
+			return v, nil
+		}
+		return "N'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	}
+	return "", fmt.Errorf("unsupported watermark type %T", value)
+}
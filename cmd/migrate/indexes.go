@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// indexKeyPrefix prefixes the state store keys recording the loaded tables
+// whose indexes are queued to the index workers, completed once they are built
+const indexKeyPrefix = "indexes:"
+
+// indexStatements returns the CREATE INDEX statements for the secondary indexes
+// of a source table, printing the indexes that cannot be created. Index names
+// are unique per schema in PostgreSQL, so an index whose name is taken by an
+// index of another table, in the target or earlier in this run, is prefixed
+// with its table name.
+func (m *migrator) indexStatements(table string) ([]string, error) {
+	indexes, err := m.source.Indexes(m.sourceDb, table, m.schemaOptions())
+	if err != nil {
+		return nil, err
+	}
+	// Tables copied in parallel must not pick the same name
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.indexNames == nil {
+		m.indexNames = make(map[string]bool)
+	}
+
+	parts := strings.SplitN(table, ".", 2)
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	var statements []string
+	for _, index := range indexes {
+		if index.Definition == "" {
+			fmt.Printf("⚠️  Skipping index %s of %s: %s\n", index.Name, table, index.Reason)
+			continue
+		}
+		name := index.Name
+		taken, err := m.indexNameTaken(targetSchema, targetTable, name)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			name = targetTable + "_" + name
+		}
+		m.indexNames[strings.ToLower(targetSchema+"."+name)] = true
+		if m.indexConcurrently {
+			statements = append(statements, index.StatementConcurrently(name, m.preserveCase))
+		} else {
+			statements = append(statements, index.Statement(name, m.preserveCase))
+		}
+	}
+	return statements, nil
+}
+
+// indexNameTaken tells whether an index name is used by another table of the
+// target schema
+func (m *migrator) indexNameTaken(schema, table, name string) (bool, error) {
+	if m.indexNames[strings.ToLower(schema+"."+name)] {
+		return true, nil
+	}
+	// Archives have no target; only the names of this run are checked
+	if m.targetDb == nil {
+		return false, nil
+	}
+	if !m.preserveCase {
+		schema, table, name = strings.ToLower(schema), strings.ToLower(table), strings.ToLower(name)
+	}
+	var count int
+	err := m.targetDb.QueryRowContext(m.ctx, `
+		SELECT COUNT(*) FROM pg_indexes WHERE schemaname = $1 AND indexname = $2 AND tablename <> $3`,
+		schema, name, table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking index name %s: %v", name, err)
+	}
+	return count > 0, nil
+}
+
+// createIndexes creates the secondary indexes of a table once its data is
+// loaded (-indexes after-data)
+func (m *migrator) createIndexes(table string) error {
+	statements, err := m.indexStatements(table)
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(table, ".", 2)
+	targetSchema, _ := m.mapper.Map(parts[0], parts[1])
+	for _, statement := range statements {
+		if err := m.createIndex(m.ctx, targetSchema, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIndex runs a CREATE INDEX statement for a table of the target schema.
+// With -index-concurrently, an invalid index left by a failed or interrupted
+// concurrent build is dropped first, since IF NOT EXISTS would keep it, and
+// again if the build fails.
+func (m *migrator) createIndex(ctx context.Context, targetSchema, statement string) error {
+	qualified := ""
+	if m.indexConcurrently {
+		if match := indexNamePattern.FindStringSubmatch(statement); match != nil {
+			qualified = dbmigrate.QuoteIdent(targetSchema, m.preserveCase) + "." + match[1]
+		}
+		if err := m.dropInvalidIndex(ctx, qualified); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	if _, err := m.targetDb.ExecContext(ctx, statement); err != nil {
+		if derr := m.dropInvalidIndex(context.Background(), qualified); derr != nil {
+			log.Printf("Warning: Could not drop the invalid index %s: %v", qualified, derr)
+		}
+		return fmt.Errorf("error creating index %q: %v", statement, m.targetPlatform.explain(err))
+	}
+	fmt.Printf("Created index in %s: %s\n", time.Since(start).Round(time.Millisecond), statement)
+	return nil
+}
+
+// dropInvalidIndex drops an index (schema.name, quoted) if it exists but is
+// invalid. Does nothing for an empty name.
+func (m *migrator) dropInvalidIndex(ctx context.Context, qualified string) error {
+	if qualified == "" {
+		return nil
+	}
+	var invalid bool
+	err := m.targetDb.QueryRowContext(ctx, "SELECT NOT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)", qualified).Scan(&invalid)
+	if err == sql.ErrNoRows || (err == nil && !invalid) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking index %s: %v", qualified, err)
+	}
+	if _, err := m.targetDb.ExecContext(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+qualified); err != nil {
+		return fmt.Errorf("error dropping invalid index %s: %v", qualified, err)
+	}
+	fmt.Printf("⚠️  Dropped the invalid index %s left by a failed concurrent build\n", qualified)
+	return nil
+}
+
+// buildIndexes creates the secondary indexes of a loaded table, right away or,
+// with -index-workers, on the index workers. In that case the table is
+// recorded in the state store until its indexes are built, so a run resuming
+// after a failed build builds them again.
+func (m *migrator) buildIndexes(table string) error {
+	if m.indexBuilder == nil {
+		if err := m.createIndexes(table); err != nil {
+			return err
+		}
+		if cp, ok := m.checkpoint(indexKeyPrefix + table); ok && !cp.Completed {
+			m.recordCheckpoint(dbmigrate.Checkpoint{Table: indexKeyPrefix + table, Completed: true})
+		}
+		return nil
+	}
+	statements, err := m.indexStatements(table)
+	if err != nil {
+		return err
+	}
+	if len(statements) == 0 {
+		if cp, ok := m.checkpoint(indexKeyPrefix + table); ok && !cp.Completed {
+			m.recordCheckpoint(dbmigrate.Checkpoint{Table: indexKeyPrefix + table, Completed: true})
+		}
+		return nil
+	}
+	m.recordCheckpoint(dbmigrate.Checkpoint{Table: indexKeyPrefix + table})
+	fmt.Printf("Queued %d indexes of table %s\n", len(statements), table)
+	m.indexBuilder.queue(m, table, statements)
+	return nil
+}
+
+// indexBuilder builds the secondary indexes of loaded tables on up to
+// -index-workers target connections, so the next tables are copied while the
+// indexes of the previous ones are built, and the indexes of several tables
+// are built at the same time
+type indexBuilder struct {
+	ctx     context.Context
+	slots   chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	pending map[string]int   // builds left by table
+	failed  map[string]error // first failed build by table
+}
+
+func newIndexBuilder(ctx context.Context, workers int) *indexBuilder {
+	return &indexBuilder{
+		ctx:     ctx,
+		slots:   make(chan struct{}, workers),
+		pending: make(map[string]int),
+		failed:  make(map[string]error),
+	}
+}
+
+// queue starts building the indexes of a table. Once they are all built, the
+// table is recorded as done; the builds after a failed one are skipped.
+func (b *indexBuilder) queue(m *migrator, table string, statements []string) {
+	parts := strings.SplitN(table, ".", 2)
+	targetSchema, _ := m.mapper.Map(parts[0], parts[1])
+	b.mu.Lock()
+	b.pending[table] += len(statements)
+	b.mu.Unlock()
+	for _, statement := range statements {
+		b.wg.Add(1)
+		go func(statement string) {
+			defer b.wg.Done()
+			b.slots <- struct{}{}
+			b.mu.Lock()
+			err := b.failed[table]
+			b.mu.Unlock()
+			if err == nil {
+				if err = b.ctx.Err(); err == nil {
+					err = m.createIndex(b.ctx, targetSchema, statement)
+				}
+			}
+			<-b.slots
+
+			b.mu.Lock()
+			if err != nil && b.failed[table] == nil {
+				b.failed[table] = err
+			}
+			b.pending[table]--
+			done := b.pending[table] == 0 && b.failed[table] == nil
+			b.mu.Unlock()
+			if done {
+				m.recordCheckpoint(dbmigrate.Checkpoint{Table: indexKeyPrefix + table, Completed: true})
+				fmt.Printf("✅ Created the indexes of table: %s\n", table)
+			}
+		}(statement)
+	}
+}
+
+// finishIndexBuilds waits for the indexes queued to the index workers and
+// reports the tables whose indexes failed (see indexFailure)
+func (m *migrator) finishIndexBuilds() error {
+	b := m.indexBuilder
+	if b == nil {
+		return nil
+	}
+	m.indexBuilder = nil
+	b.wg.Wait()
+	var firstErr error
+	for _, table := range m.tables {
+		if err := b.failed[table]; err != nil {
+			if err := m.indexFailure(table, err); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// indexFailure marks a table whose data was loaded but whose indexes could not
+// be built as failed in the report. The error is returned, unless
+// -continue-on-error records the table in failedTables, so the data phase runs
+// again.
+func (m *migrator) indexFailure(table string, err error) error {
+	m.metrics.recordError()
+	m.mu.Lock()
+	var tableReport dbmigrate.TableReport
+	for i := len(m.report.Tables) - 1; i >= 0; i-- {
+		if m.report.Tables[i].Table == table {
+			m.report.Tables[i].Status = dbmigrate.StatusFailed
+			m.report.Tables[i].Error = err.Error()
+			tableReport = m.report.Tables[i]
+			break
+		}
+	}
+	m.mu.Unlock()
+	if !m.continueOnError {
+		return fmt.Errorf("error creating indexes of table %s: %v", table, err)
+	}
+	fmt.Printf("❌ Error creating indexes of table %s, continuing: %v\n", table, err)
+	m.mu.Lock()
+	m.failedTables = append(m.failedTables, tableReport)
+	m.mu.Unlock()
+	return nil
+}
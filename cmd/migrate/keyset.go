@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// numericTypes are the source types the driver returns as decimal text in a []byte
+var numericTypes = map[string]bool{"decimal": true, "numeric": true, "money": true, "smallmoney": true}
+
+// keyValue is the JSON form of a single key value in a checkpoint. The type is
+// kept so the value can be passed back to the source unchanged.
+type keyValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+// encodeKey encodes the primary key values of a row for storing in a checkpoint
+func encodeKey(values []interface{}) (string, error) {
+	encoded := make([]keyValue, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case int64:
+			encoded[i] = keyValue{"int", strconv.FormatInt(v, 10)}
+		case uint64:
+			encoded[i] = keyValue{"uint", strconv.FormatUint(v, 10)}
+		case float64:
+			encoded[i] = keyValue{"float", strconv.FormatFloat(v, 'g', -1, 64)}
+		case bool:
+			encoded[i] = keyValue{"bool", strconv.FormatBool(v)}
+		case string:
+			encoded[i] = keyValue{"string", v}
+		case []byte:
+			encoded[i] = keyValue{"bytes", base64.StdEncoding.EncodeToString(v)}
+		case time.Time:
+			encoded[i] = keyValue{"time", v.Format(time.RFC3339Nano)}
+		default:
+			return "", fmt.Errorf("unsupported key type %T", value)
+		}
+	}
+	data, err := json.Marshal(encoded)
+	return string(data), err
+}
+
+// decodeKey decodes primary key values stored by encodeKey
+func decodeKey(data string) ([]interface{}, error) {
+	var encoded []keyValue
+	if err := json.Unmarshal([]byte(data), &encoded); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint key: %v", err)
+	}
+	values := make([]interface{}, len(encoded))
+	for i, key := range encoded {
+		var err error
+		switch key.Type {
+		case "int":
+			values[i], err = strconv.ParseInt(key.Value, 10, 64)
+		case "uint":
+			values[i], err = strconv.ParseUint(key.Value, 10, 64)
+		case "float":
+			values[i], err = strconv.ParseFloat(key.Value, 64)
+		case "bool":
+			values[i], err = strconv.ParseBool(key.Value)
+		case "string":
+			values[i] = key.Value
+		case "bytes":
+			values[i], err = base64.StdEncoding.DecodeString(key.Value)
+		case "time":
+			values[i], err = time.Parse(time.RFC3339Nano, key.Value)
+		default:
+			err = fmt.Errorf("unknown type %s", key.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing checkpoint key: %v", err)
+		}
+	}
+	return values, nil
+}
+
+// keysetScan reads a source table in pages ordered by its primary key, each page
+// starting after the last key of the previous one
+type keysetScan struct {
+	*primaryKey
+	source dbmigrate.Source
+	exprs  []string      // source expressions of the key columns, for WHERE and ORDER BY
+	after  []interface{} // resume after this key, nil to start at the beginning
+	// skipExisting inserts with ON CONFLICT DO NOTHING even without after, for
+	// a time slice resumed from its start
+	skipExisting bool
+}
+
+// newKeysetScan returns a keyset scan of a source table, or nil if the table has
+// no primary key or a key column is not migrated
+func (m *migrator) newKeysetScan(table string, columns []string, exprs map[string]string) (*keysetScan, error) {
+	key, err := m.primaryKey(table, columns)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	scan := &keysetScan{primaryKey: key, source: m.source}
+	for _, column := range key.columns {
+		// Compare columns read through an expression as they are read, so the
+		// order matches the keys
+		expr := m.source.QuoteIdent(column)
+		if e, ok := exprs[column]; ok {
+			expr = e
+		}
+		scan.exprs = append(scan.exprs, expr)
+	}
+	return scan, nil
+}
+
+// query returns the query reading the page after key, which is nil for the first
+// page, of the rows matching where (all rows if empty)
+func (k *keysetScan) query(selectList, table, where string, pageSize int, key []interface{}) string {
+	switch {
+	case where != "" && key != nil:
+		where = "(" + where + ") AND (" + k.source.KeyCondition(k.exprs) + ")"
+	case key != nil:
+		where = k.source.KeyCondition(k.exprs)
+	}
+	return k.source.PageQuery(selectList, table, where, strings.Join(k.exprs, ", "), pageSize)
+}
+
+// targetHasPrimaryKey tells whether the target table has a primary key, which
+// makes re-inserting rows after a resume safe with ON CONFLICT DO NOTHING
+func targetHasPrimaryKey(db *sql.DB, qualifiedTable string) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM pg_index WHERE indrelid = to_regclass($1) AND indisprimary)"
+	if err := db.QueryRow(query, qualifiedTable).Scan(&exists); err != nil {
+		return false, fmt.Errorf("error checking primary key of %s: %v", qualifiedTable, err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/tendant/dbmigrate"
+)
+
+// lockTimeoutError is the SQL Server error number for an exceeded LOCK_TIMEOUT
+const lockTimeoutError = 1222
+
+// deferredKeyPrefix prefixes the state store keys recording the tables that
+// were deferred because they were locked
+const deferredKeyPrefix = "deferred:"
+
+// tableLocked tells whether a source table stays locked for longer than
+// m.lockTimeout. It reads one row with LOCK_TIMEOUT set, which fails if a lock
+// that blocks readers (e.g., the schema modification lock of an index rebuild
+// or an exclusive table lock of a batch job) is held throughout.
+func (m *migrator) tableLocked(table string) (bool, error) {
+	parts := strings.SplitN(table, ".", 2)
+	conn, err := m.sourceDb.Conn(m.ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(m.ctx, fmt.Sprintf("SET LOCK_TIMEOUT %d", m.lockTimeout.Milliseconds())); err != nil {
+		return false, fmt.Errorf("error setting lock timeout: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), "SET LOCK_TIMEOUT -1")
+
+	var one int
+	err = conn.QueryRowContext(m.ctx, fmt.Sprintf("SELECT TOP (1) 1 FROM [%s].[%s] WITH (READCOMMITTEDLOCK)", parts[0], parts[1])).Scan(&one)
+	var sqlErr mssql.Error
+	switch {
+	case err == nil || err == sql.ErrNoRows:
+		return false, nil
+	case errors.As(err, &sqlErr) && sqlErr.Number == lockTimeoutError:
+		return true, nil
+	}
+	return false, fmt.Errorf("error checking locks on %s: %v", table, err)
+}
+
+// deferTable records that a locked table was skipped, so a later run with
+// -only-deferred migrates it
+func (m *migrator) deferTable(table string) {
+	reason := fmt.Sprintf("locked for more than %s", m.lockTimeout)
+	fmt.Printf("⏸️  Deferring table %s: %s\n", table, reason)
+	m.mu.Lock()
+	m.deferredTables = append(m.deferredTables, table)
+	m.mu.Unlock()
+	m.addTableReport(dbmigrate.TableReport{Table: table, Status: dbmigrate.StatusDeferred, Reason: reason})
+	m.saveCheckpoint(dbmigrate.Checkpoint{Table: deferredKeyPrefix + table, Value: reason})
+}
+
+// deferredTables returns the tables of a previous run's deferrals that have
+// not been migrated since, in the order of tables
+func deferredTables(tables []string, checkpoints map[string]dbmigrate.Checkpoint) []string {
+	var deferred []string
+	for _, table := range tables {
+		if cp, ok := checkpoints[deferredKeyPrefix+table]; ok && !cp.Completed {
+			deferred = append(deferred, table)
+		}
+	}
+	return deferred
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// migrationMetrics tracks migration progress and serves it in the Prometheus
+// text exposition format. All methods are safe to call on a nil value.
+type migrationMetrics struct {
+	mu            sync.Mutex
+	startTime     time.Time
+	expectedRows  int64
+	expectedBytes int64
+	currentTable  string
+	tableRows     map[string]int64
+	tableBytes    map[string]int64
+	batches       int64
+	errors        int64
+}
+
+// startMetricsServer starts the HTTP listener for /metrics in the background
+func startMetricsServer(addr string) *migrationMetrics {
+	m := &migrationMetrics{
+		startTime:  time.Now(),
+		tableRows:  make(map[string]int64),
+		tableBytes: make(map[string]int64),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveHTTP)
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Warning: Metrics server stopped: %v", err)
+		}
+	}()
+	fmt.Printf("Prometheus metrics listening on %s (/metrics)\n", addr)
+
+	return m
+}
+
+// setExpected sets the estimated rows and data size to migrate, used for the
+// progress fraction
+func (m *migrationMetrics) setExpected(rows, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectedRows, m.expectedBytes = rows, bytes
+}
+
+// startTable records the table currently being migrated
+func (m *migrationMetrics) startTable(table string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.currentTable = table
+	m.tableRows[table] = 0
+	m.tableBytes[table] = 0
+}
+
+// recordCommit records a committed batch with the table's cumulative row and byte counts
+func (m *migrationMetrics) recordCommit(table string, rows int64, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+	m.tableRows[table] = rows
+	m.tableBytes[table] = bytes
+}
+
+// recordSkipped counts rows migrated by a previous run towards the progress
+func (m *migrationMetrics) recordSkipped(table string, rows int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tableRows[table] = rows
+}
+
+// recordError counts a failed table
+func (m *migrationMetrics) recordError() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors++
+}
+
+func (m *migrationMetrics) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	tables := make([]string, 0, len(m.tableRows))
+	for table := range m.tableRows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var totalRows, totalBytes int64
+	metric("dbmigrate_rows_migrated_total", "counter", "Rows committed to the target, per source table.")
+	for _, table := range tables {
+		fmt.Fprintf(&b, "dbmigrate_rows_migrated_total{table=%q} %d\n", table, m.tableRows[table])
+		totalRows += m.tableRows[table]
+	}
+	for _, bytes := range m.tableBytes {
+		totalBytes += bytes
+	}
+
+	metric("dbmigrate_batches_committed_total", "counter", "Batches committed to the target.")
+	fmt.Fprintf(&b, "dbmigrate_batches_committed_total %d\n", m.batches)
+
+	metric("dbmigrate_errors_total", "counter", "Tables that failed to migrate.")
+	fmt.Fprintf(&b, "dbmigrate_errors_total %d\n", m.errors)
+
+	metric("dbmigrate_current_table", "gauge", "Table currently being migrated (value 1).")
+	if m.currentTable != "" {
+		fmt.Fprintf(&b, "dbmigrate_current_table{table=%q} 1\n", m.currentTable)
+	}
+
+	metric("dbmigrate_bytes_migrated_total", "counter", "Approximate bytes of source data committed to the target.")
+	fmt.Fprintf(&b, "dbmigrate_bytes_migrated_total %d\n", totalBytes)
+
+	metric("dbmigrate_bytes_per_second", "gauge", "Average transfer rate since the start of the run.")
+	rate := 0.0
+	if elapsed := time.Since(m.startTime).Seconds(); elapsed > 0 {
+		rate = float64(totalBytes) / elapsed
+	}
+	fmt.Fprintf(&b, "dbmigrate_bytes_per_second %g\n", rate)
+
+	metric("dbmigrate_progress_ratio", "gauge", "Fraction of the estimated data size (or rows, without size statistics) migrated (0 to 1).")
+	progress := 0.0
+	if m.expectedBytes > 0 {
+		progress = min(float64(totalBytes)/float64(m.expectedBytes), 1)
+	} else if m.expectedRows > 0 {
+		progress = min(float64(totalRows)/float64(m.expectedRows), 1)
+	}
+	fmt.Fprintf(&b, "dbmigrate_progress_ratio %g\n", progress)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
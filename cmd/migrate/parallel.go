@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tableDone is the outcome of a table copied by copyTablesInParallel
+type tableDone struct {
+	queue int
+	err   error
+}
+
+// copyTablesInParallel copies the tables with up to parallelTables of them at
+// the same time. Tables connected by foreign keys (fkGroups) are fenced: they
+// are copied one after the other in dependency order, so no row is inserted
+// before the rows it references, while unrelated tables load alongside.
+// -fast-load does not check foreign keys while copying, so its tables are not
+// fenced. The load governor may lower the number of tables copied at the same
+// time, which is checked whenever a table finishes and every second. After the
+// first error no further table is started and the tables being copied are
+// interrupted.
+func (m *migrator) copyTablesInParallel(copyTable func(table string) error) error {
+	var queues [][]string
+	queueOf := make(map[int]int) // foreign key group -> queue
+	for _, table := range m.tables {
+		group, ok := m.fkGroups[strings.ToLower(table)]
+		if ok && !m.fastLoad {
+			if i, seen := queueOf[group]; seen {
+				queues[i] = append(queues[i], table)
+				continue
+			}
+			queueOf[group] = len(queues)
+		}
+		queues = append(queues, []string{table})
+	}
+	fmt.Printf("Copying up to %d tables at a time\n", m.parallelTables)
+	for _, queue := range queues {
+		if len(queue) > 1 {
+			fmt.Printf("Linked by foreign keys, copied one at a time: %s\n", strings.Join(queue, ", "))
+		}
+	}
+
+	// The tables read the context of the migrator, which is canceled on the
+	// first error
+	parent := m.ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	m.ctx = ctx
+	defer func() { m.ctx = parent }()
+
+	next := make([]int, len(queues)) // next table of each queue
+	running := make([]bool, len(queues))
+	done := make(chan tableDone)
+	active := 0
+	var firstErr error
+	var recheck <-chan time.Time
+	if m.governor != nil {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		recheck = ticker.C
+	}
+	for {
+		// Free slots go to the queues in table order
+		limit := m.governor.workerLimit(m.parallelTables)
+		for i := 0; i < len(queues) && active < limit && ctx.Err() == nil; i++ {
+			if running[i] || next[i] == len(queues[i]) {
+				continue
+			}
+			running[i] = true
+			active++
+			go func(queue int, table string) {
+				done <- tableDone{queue: queue, err: copyTable(table)}
+			}(i, queues[i][next[i]])
+		}
+		if active == 0 {
+			break
+		}
+		var result tableDone
+		select {
+		case result = <-done:
+		case <-recheck:
+			continue
+		}
+		active--
+		running[result.queue] = false
+		next[result.queue]++
+		if result.err != nil && firstErr == nil {
+			firstErr = result.err
+			cancel()
+		}
+	}
+	if firstErr == nil && parent.Err() != nil {
+		return fmt.Errorf("migration interrupted: %w", parent.Err())
+	}
+	return firstErr
+}
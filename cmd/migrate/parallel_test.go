@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tableRuns records when the tables copied by copyTablesInParallel start and
+// finish
+type tableRuns struct {
+	mu       sync.Mutex
+	running  map[string]bool
+	started  []string
+	overlaps map[[2]string]bool // tables copied at the same time
+}
+
+// copy copies a table by waiting a moment, or fails with err
+func (r *tableRuns) copy(table string, err error) error {
+	r.mu.Lock()
+	if r.running == nil {
+		r.running, r.overlaps = make(map[string]bool), make(map[[2]string]bool)
+	}
+	for other := range r.running {
+		r.overlaps[[2]string{other, table}] = true
+		r.overlaps[[2]string{table, other}] = true
+	}
+	r.running[table] = true
+	r.started = append(r.started, table)
+	r.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	r.mu.Lock()
+	delete(r.running, table)
+	r.mu.Unlock()
+	return err
+}
+
+func TestCopyTablesInParallelForeignKeys(t *testing.T) {
+	// Orders references Customers and OrderLines references Orders; the
+	// tables are in dependency order
+	tables := []string{"dbo.Customers", "dbo.Products", "dbo.Orders", "dbo.Logs", "dbo.OrderLines"}
+	fkGroups := map[string]int{"dbo.customers": 1, "dbo.orders": 1, "dbo.orderlines": 1}
+	linked := []string{"dbo.Customers", "dbo.Orders", "dbo.OrderLines"}
+
+	m := &migrator{ctx: context.Background(), tables: tables, fkGroups: fkGroups, parallelTables: 3}
+	var runs tableRuns
+	if err := m.copyTablesInParallel(func(table string) error { return runs.copy(table, nil) }); err != nil {
+		t.Fatalf("copyTablesInParallel() failed: %v", err)
+	}
+	if len(runs.started) != len(tables) {
+		t.Fatalf("copied %v, want %v", runs.started, tables)
+	}
+	// A child table never runs with its parent, and runs after it
+	for i, parent := range linked {
+		for _, child := range linked[i+1:] {
+			if runs.overlaps[[2]string{parent, child}] {
+				t.Errorf("%s copied at the same time as %s", child, parent)
+			}
+		}
+	}
+	var order []string
+	for _, table := range runs.started {
+		if fkGroups[strings.ToLower(table)] == 1 {
+			order = append(order, table)
+		}
+	}
+	if !reflect.DeepEqual(order, linked) {
+		t.Errorf("linked tables copied in order %v, want %v", order, linked)
+	}
+	// Unrelated tables load alongside
+	if !runs.overlaps[[2]string{"dbo.Customers", "dbo.Products"}] || !runs.overlaps[[2]string{"dbo.Customers", "dbo.Logs"}] {
+		t.Errorf("unrelated tables not copied alongside dbo.Customers: %v", runs.overlaps)
+	}
+
+	// With -fast-load foreign keys are not checked while copying, so the
+	// linked tables are not fenced
+	m = &migrator{ctx: context.Background(), tables: tables, fkGroups: fkGroups, parallelTables: 5, fastLoad: true}
+	runs = tableRuns{}
+	if err := m.copyTablesInParallel(func(table string) error { return runs.copy(table, nil) }); err != nil {
+		t.Fatalf("copyTablesInParallel() with -fast-load failed: %v", err)
+	}
+	if !runs.overlaps[[2]string{"dbo.Customers", "dbo.Orders"}] {
+		t.Error("linked tables not copied at the same time with -fast-load")
+	}
+}
+
+func TestCopyTablesInParallelError(t *testing.T) {
+	// After a failure the rest of the group is not started
+	m := &migrator{
+		ctx:            context.Background(),
+		tables:         []string{"dbo.Customers", "dbo.Orders", "dbo.OrderLines"},
+		fkGroups:       map[string]int{"dbo.customers": 1, "dbo.orders": 1, "dbo.orderlines": 1},
+		parallelTables: 2,
+	}
+	failed := errors.New("copy failed")
+	var runs tableRuns
+	err := m.copyTablesInParallel(func(table string) error {
+		if table == "dbo.Orders" {
+			return runs.copy(table, failed)
+		}
+		return runs.copy(table, nil)
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("copyTablesInParallel() error = %v, want %v", err, failed)
+	}
+	if want := []string{"dbo.Customers", "dbo.Orders"}; !reflect.DeepEqual(runs.started, want) {
+		t.Errorf("copied %v, want %v", runs.started, want)
+	}
+}
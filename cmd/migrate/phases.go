@@ -0,0 +1,1214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// Migration phases in the order they run
+const (
+	phaseSchema   = "schema"
+	phaseData     = "data"
+	phasePostload = "postload"
+	phaseSync     = "sync"
+	phaseVerify   = "verify"
+	// phaseReverseSync copies the changes made in the target back to the source
+	phaseReverseSync = "reverse-sync"
+)
+
+var allPhases = []string{phaseSchema, phaseData, phasePostload, phaseSync, phaseVerify, phaseReverseSync}
+
+// parsePhases parses the -phases flag and returns the selected phases in run order
+func parsePhases(value string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, phase := range strings.Split(value, ",") {
+		phase = strings.ToLower(strings.TrimSpace(phase))
+		if phase == "" {
+			continue
+		}
+		valid := false
+		for _, known := range allPhases {
+			if phase == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown phase: %s (expected %s)", phase, strings.Join(allPhases, ", "))
+		}
+		selected[phase] = true
+	}
+
+	var phases []string
+	for _, phase := range allPhases {
+		if selected[phase] {
+			phases = append(phases, phase)
+		}
+	}
+	if len(phases) == 0 {
+		return nil, fmt.Errorf("no phases selected")
+	}
+	return phases, nil
+}
+
+// withPostload adds the postload phase after the data phase if it is selected
+func withPostload(phases []string) []string {
+	var result []string
+	for _, phase := range phases {
+		if phase == phasePostload {
+			return phases
+		}
+		result = append(result, phase)
+		if phase == phaseData {
+			result = append(result, phasePostload)
+		}
+	}
+	return result
+}
+
+// parseCheckpointInterval parses the -checkpoint flag and returns the number of
+// batches between checkpoints, where 0 means only when a table completes
+func parseCheckpointInterval(value string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "batch":
+		return 1, nil
+	case "table":
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid checkpoint interval: %s (expected batch, table or a positive number of batches)", value)
+	}
+	return n, nil
+}
+
+// migrator holds the connections and settings shared by all migration phases
+type migrator struct {
+	ctx        context.Context
+	source     dbmigrate.Source // SQL Server, MySQL or PostgreSQL, see dbmigrate.SourceForDsn
+	sourceDb   *sql.DB
+	targetDb   *sql.DB
+	mapper     *dbmigrate.NameMapper
+	typeMapper *dbmigrate.TypeMapper
+	// coerceUnknownTypes creates columns of unmapped types as TEXT instead of failing
+	coerceUnknownTypes bool
+	config             *dbmigrate.Config // optional
+	stateStore         dbmigrate.StateStore
+	checkpoints        map[string]dbmigrate.Checkpoint
+
+	schemas              []string
+	tables               []string
+	batchSize            int
+	smallTableRows       int64 // tables with fewer estimated rows are copied in one transaction
+	blobChunkSize        int64 // larger binary values are copied in chunks of this size, 0 = whole
+	truncate             bool
+	restartIdentity      bool   // truncations reset the sequences owned by the table
+	quarantineSchema     string // partially loaded tables are moved here, see quarantineTable
+	checkpointBatches    int    // save progress every N batches, 0 = only when a table completes
+	provenanceColumn     string
+	updatedAtColumn      string
+	computedColumns      string
+	columnSets           string
+	invalidText          string // handling of NUL bytes and invalid UTF-8, see sanitizeTransform
+	datetimeType         string
+	xmlType              string
+	rowversion           string
+	hierarchyid          string
+	postgis              bool
+	sourceTimezone       *time.Location // zone of naive datetime values, unless configured per column
+	auditBackfill        *time.Time     // value of NULL audit columns, see auditBackfillTransform
+	verifyChars          bool
+	verifySampleSize     int     // rows per table compared value by value, 0 = disabled
+	verifyRecentShare    float64 // share of the sample taken from the most recently modified rows
+	verifyDiffFormat     string  // format of the printed mismatched sampled rows
+	verifyDiffs          *diffWriter
+	watermarkColumn      string // column holding the last modification time of a row
+	continueOnError      bool
+	runID                string
+	preserveCase         bool
+	includeSystemSchemas bool
+
+	report         *dbmigrate.Report
+	metrics        *migrationMetrics
+	progress       *progressDisplay
+	rowEstimates   map[string]int64 // estimated source row counts by table
+	byteEstimates  map[string]int64 // estimated source data sizes by table
+	failedTables   []dbmigrate.TableReport
+	lockTimeout    time.Duration // defer tables locked for longer, 0 = wait for locks
+	deferredTables []string
+	sliceLimit     time.Duration // start no new time slice after this long, 0 = no limit
+	governor       *loadGovernor // pauses between batches under load, nil = never
+	pausedTables   []string      // sliced tables stopped by sliceLimit
+	rejects        *rejectWriter
+
+	// deferConstraints defers the foreign keys of tables in cyclicTables
+	// (lowercase schema.table) to the end of each batch
+	deferConstraints bool
+	cyclicTables     map[string]bool
+	// fastLoad copies with session_replication_role = replica and revalidates
+	// the foreign keys of the loaded tables afterwards
+	fastLoad bool
+	// targetPlatform is the detected offering of the target, which explains
+	// permission errors
+	targetPlatform *targetPlatform
+	// targets are the target databases of -target-databases, the -target-dsn
+	// database first; the phases run for each in turn (see forEachTarget),
+	// which sets targetDb, targetPlatform, tables and targetName
+	targets    []*targetDatabase
+	targetName string
+	// indexes is when secondary indexes are created (dbmigrate.Indexes*)
+	indexes    string
+	indexNames map[string]bool // lowercase schema.name of the indexes created
+	// indexWorkers builds the indexes of -indexes after-data on that many
+	// connections alongside the copy (0 = by the table copy, after its rows);
+	// indexConcurrently builds them with CREATE INDEX CONCURRENTLY
+	indexWorkers      int
+	indexConcurrently bool
+	indexBuilder      *indexBuilder // during the data phase with indexWorkers
+	// chunkChecksums checks each batch against the target after it commits and
+	// records its checksum; verifyChunkCount recorded chunks per table are
+	// checked again in the verify phase
+	chunkChecksums   bool
+	verifyChunkCount int
+	// partitionRows creates larger tables partitioned in the schema phase
+	partitionRows int64
+	// citus distributes the configured tables on a Citus cluster in the schema
+	// phase and propagates the batch settings to the workers
+	citus bool
+	// writeMode is how rows already in the target are handled (writeMode*)
+	writeMode string
+	// incremental copies only the rows changed since the previous run by
+	// watermarkColumn, with the data phase and tables never skipped as completed
+	incremental bool
+	// changeTracked holds the source tables with change tracking, whose copies
+	// record the change tracking version the sync phase starts from
+	changeTracked map[string]bool
+	// syncInterval repeats the sync phase until interrupted if not 0
+	syncInterval time.Duration
+	// columnTypes holds the column types of tables loaded from a spool, which
+	// are read from its manifest instead of the source
+	columnTypes map[string][][2]string
+	// refreshTables are truncated and copied whole by every run, even if
+	// completed or with -incremental
+	refreshTables map[string]bool
+	// parallelTables is the number of tables copied at the same time; tables of
+	// the same fkGroups group (lowercase schema.table) are copied one at a time
+	parallelTables int
+	fkGroups       map[string]int
+	// mu guards the state tables copied in parallel update: checkpoints,
+	// report, indexNames and the failed, deferred and paused tables
+	mu sync.Mutex
+}
+
+// runPhase runs a single phase. Completed phases are recorded in the state store
+// so a restarted run does not repeat them.
+func (m *migrator) runPhase(phase string) error {
+	fmt.Printf("\n=== Phase: %s ===\n", phase)
+	return m.forEachTarget(func() error { return m.runTargetPhase(phase) })
+}
+
+// runTargetPhase runs a phase for the tables of the current target database,
+// unless a previous run completed it there
+func (m *migrator) runTargetPhase(phase string) error {
+	key := "phase:" + phase
+	if m.targetName != "" {
+		key += "@" + m.targetName
+	}
+	repeatable := phase == phaseVerify || phase == phasePostload || phase == phaseSync || phase == phaseReverseSync || (phase == phaseData && (m.incremental || len(m.refreshTables) > 0))
+	if cp, ok := m.checkpoints[key]; ok && cp.Completed && !repeatable {
+		fmt.Printf("Skipping phase already completed (checkpoint): %s\n", phase)
+		return nil
+	}
+
+	var err error
+	switch phase {
+	case phaseSchema:
+		err = m.runSchemaPhase()
+	case phaseData:
+		err = m.runDataPhase()
+	case phasePostload:
+		err = m.runPostloadPhase()
+	case phaseSync:
+		err = m.runSyncPhase()
+	case phaseVerify:
+		err = m.runVerifyPhase()
+	case phaseReverseSync:
+		err = m.runReverseSyncPhase()
+	}
+	if err != nil {
+		return err
+	}
+
+	// A data phase that skipped failed or locked tables, or stopped sliced tables, must run again
+	if phase == phaseData && (len(m.failedTables) > 0 || len(m.deferredTables) > 0 || len(m.pausedTables) > 0) {
+		return nil
+	}
+	m.saveCheckpoint(dbmigrate.Checkpoint{Table: key, Completed: true})
+	return nil
+}
+
+// saveCheckpoint records a checkpoint if a state store is configured
+func (m *migrator) saveCheckpoint(cp dbmigrate.Checkpoint) {
+	if m.stateStore == nil {
+		return
+	}
+	cp.RunID = m.runID
+	if err := m.stateStore.Save(cp); err != nil {
+		log.Printf("Warning: Could not save checkpoint: %v", err)
+	}
+}
+
+// smallTable tells whether a table is small enough by its row count estimate
+// to be copied in one transaction. Tables without an estimate, or with an
+// estimate of 0, which is also what statistics that were never gathered look
+// like, are not.
+func (m *migrator) smallTable(table string) bool {
+	estimate, ok := m.rowEstimates[table]
+	return ok && estimate > 0 && estimate < m.smallTableRows
+}
+
+// smallTableMaxRows is the most rows a table chosen as small by its estimate
+// is copied in one transaction with; larger ones are copied in batches
+func smallTableMaxRows(smallTableRows int64) int {
+	return int(2 * smallTableRows)
+}
+
+// estimatedBytes estimates the data size of rows of a table from the
+// partition statistics
+func (m *migrator) estimatedBytes(table string, rows int64) int64 {
+	if m.rowEstimates[table] == 0 {
+		return 0
+	}
+	share := min(float64(rows)/float64(m.rowEstimates[table]), 1)
+	return int64(share * float64(m.byteEstimates[table]))
+}
+
+// checkpoint returns the checkpoint of the run or loaded from the state store
+// under key
+func (m *migrator) checkpoint(key string) (dbmigrate.Checkpoint, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp, ok := m.checkpoints[key]
+	return cp, ok
+}
+
+// recordCheckpoint saves a checkpoint and keeps it for the rest of the run
+func (m *migrator) recordCheckpoint(cp dbmigrate.Checkpoint) {
+	m.saveCheckpoint(cp)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoints[cp.Table] = cp
+}
+
+// addTableReport adds the outcome of a table to the run report
+func (m *migrator) addTableReport(table dbmigrate.TableReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.AddTable(table)
+}
+
+// schemaOptions returns the options the target tables are created with
+func (m *migrator) schemaOptions() dbmigrate.SchemaOptions {
+	return dbmigrate.SchemaOptions{
+		Schemas:              m.schemas,
+		Tables:               m.tables,
+		IncludeSystemSchemas: m.includeSystemSchemas,
+		PreserveCase:         m.preserveCase,
+		IfNotExists:          true,
+		Mapper:               m.mapper,
+		TypeMapper:           m.typeMapper,
+		Config:               m.config,
+		ComputedColumns:      m.computedColumns,
+		ColumnSets:           m.columnSets,
+		DatetimeType:         m.datetimeType,
+		XmlType:              m.xmlType,
+		Rowversion:           m.rowversion,
+		Hierarchyid:          m.hierarchyid,
+		PostGIS:              m.postgis,
+		ProvenanceColumn:     m.provenanceColumn,
+		UpdatedAtColumn:      m.updatedAtColumn,
+		CoerceUnknownTypes:   m.coerceUnknownTypes,
+		PartitionRows:        m.partitionRows,
+		Citus:                m.citus,
+		AuditBackfill:        m.auditBackfill != nil,
+	}
+}
+
+// generateSchema returns the PostgreSQL DDL for the selected tables, with their
+// secondary indexes for -indexes schema
+func (m *migrator) generateSchema() ([]string, error) {
+	statements, err := m.source.GenerateSchema(m.sourceDb, m.schemaOptions())
+	if err != nil || m.indexes != dbmigrate.IndexesSchema {
+		return statements, withTypeMappingHint(err)
+	}
+	for _, table := range m.tables {
+		indexes, err := m.indexStatements(table)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, indexes...)
+	}
+	return statements, nil
+}
+
+// withTypeMappingHint adds to an error listing unmapped source types how to
+// map them or keep the TEXT fallback
+func withTypeMappingHint(err error) error {
+	var unmapped dbmigrate.UnmappedTypesError
+	if errors.As(err, &unmapped) {
+		return fmt.Errorf("%w\nMap these types with -type-map or type_map in the config file, or create them as TEXT with -coerce-unknown-to-text", err)
+	}
+	return err
+}
+
+// runSchemaPhase generates the PostgreSQL DDL for the selected tables and applies it to the target
+func (m *migrator) runSchemaPhase() error {
+	statements, err := m.generateSchema()
+	if err != nil {
+		return fmt.Errorf("error generating schema: %v", err)
+	}
+	if err := m.targetPlatform.checkExtensions(m.ctx, m.targetDb, statements); err != nil {
+		return err
+	}
+
+	tx, err := m.targetDb.BeginTx(m.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(m.ctx, statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying schema statement %q: %v", firstLine(statement), m.targetPlatform.explain(err))
+		}
+		fmt.Printf("Applied: %s\n", firstLine(statement))
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing schema: %v", err)
+	}
+
+	fmt.Printf("✅ Applied %d schema statements\n", len(statements))
+	return nil
+}
+
+// runDataPhase copies the data of every selected table, one at a time or
+// with -parallel-tables several at a time
+func (m *migrator) runDataPhase() error {
+	startTime := time.Now()
+	totalRows := 0
+	var loaded [][2]string // target tables written to, for -fast-load
+	var mu sync.Mutex
+	copyTable := func(table string) error {
+		rows, written, err := m.migrateTable(table, startTime)
+		mu.Lock()
+		defer mu.Unlock()
+		totalRows += rows
+		if written {
+			parts := strings.SplitN(table, ".", 2)
+			targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+			loaded = append(loaded, [2]string{targetSchema, targetTable})
+		}
+		return err
+	}
+
+	if m.indexWorkers > 0 && m.indexes == dbmigrate.IndexesAfterData {
+		m.indexBuilder = newIndexBuilder(m.ctx, m.indexWorkers)
+		fmt.Printf("Building up to %d indexes at a time\n", m.indexWorkers)
+	}
+	var err error
+	if m.parallelTables > 1 {
+		err = m.copyTablesInParallel(copyTable)
+	} else {
+		for _, table := range m.tables {
+			if err = copyTable(table); err != nil {
+				break
+			}
+		}
+	}
+	// Indexes being built are waited for, even after an error
+	if indexErr := m.finishIndexBuilds(); err == nil {
+		err = indexErr
+	}
+	if err != nil {
+		return err
+	}
+
+	duration := time.Since(startTime)
+	fmt.Printf("\n✅ Migration completed in %s\n", duration)
+	fmt.Printf("✅ Total rows migrated: %d\n", totalRows)
+
+	if m.fastLoad {
+		return m.revalidateForeignKeys(loaded)
+	}
+	return nil
+}
+
+// migrateTable copies the data of a table. It returns the rows copied and
+// whether the target table was written to. Errors stop the data phase; with
+// -continue-on-error, failed tables are recorded in failedTables instead.
+func (m *migrator) migrateTable(table string, startTime time.Time) (int, bool, error) {
+	// Skip tables completed by a previous run
+	checkpoint, hasCheckpoint := m.checkpoint(table)
+	refresh := m.refreshTables[table]
+	if hasCheckpoint && checkpoint.Completed && !m.incremental && !refresh {
+		fmt.Printf("Skipping table already migrated (checkpoint): %s\n", table)
+		m.metrics.recordSkipped(table, checkpoint.RowsMigrated)
+		m.progress.skipTable(table, m.byteEstimates[table])
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		m.addTableReport(dbmigrate.TableReport{Table: table, TargetTable: targetSchema + "." + targetTable,
+			Status: dbmigrate.StatusSkipped, Reason: "completed by a previous run"})
+		// Indexes queued to the index workers may not have been built
+		if cp, ok := m.checkpoint(indexKeyPrefix + table); ok && !cp.Completed && m.indexes == dbmigrate.IndexesAfterData {
+			fmt.Printf("Creating the indexes of table %s left unbuilt by a previous run\n", table)
+			if err := m.buildIndexes(table); err != nil {
+				return 0, false, m.indexFailure(table, err)
+			}
+		}
+		return 0, false, nil
+	}
+
+	// Tables locked by another process are deferred instead of waited for
+	if m.lockTimeout > 0 {
+		locked, err := m.tableLocked(table)
+		if err != nil {
+			return 0, false, err
+		}
+		if locked {
+			m.deferTable(table)
+			return 0, false, nil
+		}
+	}
+
+	fmt.Printf("Migrating table: %s\n", table)
+	m.metrics.startTable(table)
+	m.progress.startTable(table, m.rowEstimates[table], m.byteEstimates[table])
+	if warning, err := m.checkKeyDistribution(table, m.rowEstimates[table]); err != nil {
+		log.Printf("Warning: Could not check key distribution of %s: %v", table, err)
+	} else if warning != "" {
+		log.Printf("Warning: %s: %s", table, warning)
+	}
+	tableStart := time.Now()
+
+	// Get column information
+	columns, err := m.sourceColumns(table)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Non-Unicode columns in legacy code pages (e.g., 1252) are converted to
+	// UTF-8; the MySQL driver reads text as UTF-8
+	var transcode map[string]int
+	if m.source == dbmigrate.SQLServer {
+		if transcode, err = getTranscodeColumns(m.sourceDb, table); err != nil {
+			return 0, false, err
+		}
+	}
+	for column, codePage := range transcode {
+		fmt.Printf("Converting column %s from code page %d to UTF-8\n", column, codePage)
+	}
+	exprs, err := m.sourceExprs(table, transcode)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Convert values by type and apply the per-column settings
+	nullConversions := make(map[string]int64)
+	sanitized := make(map[string]int64)
+	unexpectedTypes := make(map[string]string)
+	transformRow, err := m.rowTransform(table, columns, nullConversions, sanitized, unexpectedTypes)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Resolve the target table name
+	parts := strings.Split(table, ".")
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+	}
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	if targetSchema != parts[0] || targetTable != parts[1] {
+		fmt.Printf("Target table: %s.%s\n", targetSchema, targetTable)
+	}
+
+	// Tables with a primary key are read in key order, so a partially loaded
+	// table can be resumed after its last checkpointed key
+	keyset, err := m.newKeysetScan(table, columns, exprs)
+	if err != nil {
+		return 0, false, err
+	}
+	// Incremental runs copy the rows changed since the last run; other
+	// append-only tables with a slice_column are copied in date ranges
+	var changes *incrementalRange
+	var slices []timeSlice
+	if m.incremental && !refresh {
+		changes, err = m.incrementalRange(table, columns)
+	} else {
+		slices, err = m.planSlices(table, keyset)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	where := ""
+	if changes != nil {
+		where = changes.where
+	}
+
+	// Incremental runs upsert, so an interrupted run is simply repeated
+	var previousRows int64
+	if hasCheckpoint && !m.truncate && !m.incremental && !refresh {
+		var after []interface{}
+		var resumable bool
+		if slices != nil {
+			var remaining []timeSlice
+			remaining, after, resumable, err = m.resumeSlices(checkpoint, slices, keyset, targetSchema, targetTable)
+			if resumable {
+				slices = remaining
+			}
+		} else {
+			after, err = m.resumeKey(checkpoint, keyset, targetSchema, targetTable)
+			resumable = after != nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if resumable {
+			keyset.after = after
+			keyset.skipExisting = true
+			previousRows = checkpoint.RowsMigrated
+			m.progress.skipTable(table, m.estimatedBytes(table, previousRows))
+			fmt.Printf("Table %s was partially migrated by a previous run (%d rows checkpointed), resuming it\n", table, previousRows)
+		} else {
+			fmt.Printf("Table %s was partially migrated by a previous run (%d rows checkpointed), restarting it\n", table, checkpoint.RowsMigrated)
+		}
+	}
+	resumed := keyset != nil && keyset.skipExisting
+
+	// Large binary values are copied in chunks rather than read whole
+	blobs, err := newBlobStreamer(m.sourceDb, table, columns, m.blobChunkSize, dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase), m.preserveCase)
+	if err != nil {
+		return 0, false, err
+	}
+	if blobs != nil {
+		blobs.onChunk = func(bytes int64) { m.progress.streamed(table, bytes) }
+	}
+
+	// Truncate target table if specified, or if a previous run left it partially loaded
+	if m.truncate || refresh || (hasCheckpoint && !resumed && !m.incremental) {
+		if err := truncateTargetTable(m.targetDb, targetSchema, targetTable, m.preserveCase, m.restartIdentity); err != nil {
+			log.Printf("Warning: Could not truncate table %s: %v", table, err)
+		} else {
+			fmt.Printf("Truncated table: %s\n", table)
+		}
+	}
+
+	// Mark the table as started, so a crash before the next checkpoint still
+	// causes the partially loaded table to be restarted
+	if !resumed {
+		m.saveCheckpoint(dbmigrate.Checkpoint{Table: table})
+		if err := m.recordChangeBaseline(table); err != nil {
+			return 0, false, err
+		}
+	}
+
+	// Hash each batch as it is written and check it against the target
+	var checksums *chunkChecksums
+	if m.chunkChecksums {
+		checksums, err = m.newChunkChecksums(table, dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase), m.chunkGeneration(table, resumed), columns, blobs)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	// Record the nulls, lengths, truncations and conversions of each column
+	stats := newColumnStats(columns, blobs)
+	transformRow = stats.wrap(transformRow)
+	if checksums != nil {
+		transformRow = checksums.wrap(transformRow)
+		var after []interface{}
+		if keyset != nil {
+			after = keyset.after
+		}
+		checksums.start(where, after)
+	}
+
+	// Record a checkpoint after every checkpointBatches committed batches,
+	// with the last committed key (and slice) for resuming
+	batches := 0
+	var bytes int64
+	committedKey := checkpoint.Value
+	currentSlice := ""
+	var sliceRows int
+	var sliceBytes int64
+	checkpointValue := func() string {
+		if slices == nil {
+			return committedKey
+		}
+		return sliceCheckpointValue(currentSlice, committedKey)
+	}
+	if slices != nil {
+		committedKey = ""
+		if keyset.after != nil {
+			committedKey, _ = encodeKey(keyset.after)
+		}
+	}
+	onCommit := func(rows int, committedBytes int64, lastKey []interface{}) {
+		// Counts are per call of migrateTableData, which runs once per slice
+		rows += sliceRows
+		committedBytes += sliceBytes
+		bytes = committedBytes
+		m.metrics.recordCommit(table, int64(rows), committedBytes)
+		m.progress.update(table, int64(rows), committedBytes)
+		if lastKey != nil {
+			var keyErr error
+			if committedKey, keyErr = encodeKey(lastKey); keyErr != nil {
+				log.Printf("Warning: Could not record the last key of %s, it will be restarted if interrupted: %v", table, keyErr)
+				committedKey = ""
+			}
+		}
+		if checksums != nil {
+			checksums.commit(lastKey)
+		}
+		batches++
+		if m.checkpointBatches > 0 && batches%m.checkpointBatches == 0 {
+			m.saveCheckpoint(dbmigrate.Checkpoint{Table: table, RowsMigrated: previousRows + int64(rows), Value: checkpointValue()})
+		}
+	}
+
+	// Write rows that fail to insert to the reject file instead of failing the batch
+	var onReject func(values []interface{}, err error) error
+	var rejected int64
+	if m.rejects != nil {
+		pkColumns, err := m.source.PrimaryKey(m.sourceDb, table)
+		if err != nil {
+			return 0, false, err
+		}
+		onReject = func(values []interface{}, rowErr error) error {
+			rejected++
+			return m.rejects.reject(table, rowKey(columns, pkColumns, values), rowErr)
+		}
+		if checksums != nil {
+			reject := onReject
+			onReject = func(values []interface{}, rowErr error) error {
+				checksums.reject(values)
+				return reject(values, rowErr)
+			}
+		}
+	}
+
+	// Foreign keys of tables in a cycle are checked when a batch commits
+	settings := batchSettings{
+		deferConstraints: m.deferConstraints && m.cyclicTables[strings.ToLower(table)],
+		replicaRole:      m.fastLoad,
+		citus:            m.citus,
+	}
+	if settings.deferConstraints {
+		count, err := makeForeignKeysDeferrable(m.targetDb, targetSchema, targetTable, m.preserveCase)
+		if err != nil {
+			return 0, false, err
+		}
+		if count > 0 {
+			fmt.Printf("Made %d foreign keys of %s deferrable\n", count, table)
+		}
+	}
+
+	// Rows already in the target are updated or skipped with -write-mode
+	onConflict := ""
+	if m.writeMode != writeModeInsert {
+		pkColumns, err := m.source.PrimaryKey(m.sourceDb, table)
+		if err != nil {
+			return 0, false, err
+		}
+		if len(pkColumns) == 0 {
+			log.Printf("Warning: Table %s has no primary key, -write-mode %s falls back to plain inserts", table, m.writeMode)
+		}
+		onConflict = conflictClause(m.writeMode, pkColumns, columns, m.provenanceColumn, m.preserveCase)
+	}
+
+	// Migrate data; small tables are copied in one go unless they are resumed,
+	// rows may be already present, or blobs are streamed, which need
+	// row-by-row inserts. A table with far more rows than its estimate is
+	// copied in batches after all.
+	var rowCount int
+	var pausedAt *timeSlice
+	opts := copyOptions{
+		source:           m.source,
+		fullTableName:    table,
+		targetSchema:     targetSchema,
+		targetTable:      targetTable,
+		columns:          columns,
+		exprs:            exprs,
+		where:            where,
+		onConflict:       onConflict,
+		keyset:           keyset,
+		blobs:            blobs,
+		batchSize:        m.batchSize,
+		transformRow:     transformRow,
+		preserveCase:     m.preserveCase,
+		provenanceColumn: m.provenanceColumn,
+		runID:            m.runID,
+		governor:         m.governor,
+		settings:         settings,
+		onCommit:         onCommit,
+		onReject:         onReject,
+	}
+	small := m.smallTable(table) && slices == nil && !resumed && blobs == nil && onConflict == ""
+	if small {
+		fmt.Printf("Small table (~%d rows), copying in one transaction\n", m.rowEstimates[table])
+		smallOpts := opts
+		smallOpts.maxRows = smallTableMaxRows(m.smallTableRows)
+		rowCount, err = copyTableData(m.ctx, m.sourceDb, m.targetDb, smallOpts)
+		if errors.Is(err, errNotSmall) {
+			fmt.Printf("Table %s has more than %d rows, copying it in batches\n", table, smallOpts.maxRows)
+			small = false
+		}
+	}
+	if !small && slices != nil {
+		// Each slice is checkpointed when it is done; after -slice-time-limit
+		// no new slice is started, but every run copies at least one
+		for i := range slices {
+			if i > 0 && m.sliceLimit > 0 && time.Since(startTime) > m.sliceLimit {
+				pausedAt = &slices[i]
+				break
+			}
+			currentSlice = slices[i].start
+			fmt.Printf("Migrating slice %s of %s\n", slices[i].label, table)
+			if checksums != nil {
+				checksums.start(slices[i].where, keyset.after)
+			}
+			var sliceCount int
+			sliceOpts := opts
+			sliceOpts.where = slices[i].where
+			sliceCount, err = migrateTableData(m.ctx, m.sourceDb, m.targetDb, sliceOpts)
+			rowCount += sliceCount
+			if err != nil {
+				break
+			}
+			sliceRows, sliceBytes = rowCount, bytes
+			keyset.after, keyset.skipExisting = nil, false
+			committedKey = ""
+			if i+1 < len(slices) {
+				currentSlice = slices[i+1].start
+				m.saveCheckpoint(dbmigrate.Checkpoint{Table: table, RowsMigrated: previousRows + int64(rowCount), Value: checkpointValue()})
+			}
+		}
+	} else if !small {
+		rowCount, err = migrateTableData(m.ctx, m.sourceDb, m.targetDb, opts)
+	}
+	if checksums != nil {
+		checksums.flush()
+	}
+	if err == nil && checksums != nil && checksums.failed > 0 {
+		err = fmt.Errorf("%d of %d batches differ in the target after writing", checksums.failed, checksums.chunks)
+	}
+
+	// Secondary indexes are built once, after the rows are loaded
+	if err == nil && pausedAt == nil && m.indexes == dbmigrate.IndexesAfterData {
+		err = m.buildIndexes(table)
+	}
+	tableReport := dbmigrate.TableReport{
+		Table:           table,
+		TargetDatabase:  m.targetName,
+		TargetTable:     targetSchema + "." + targetTable,
+		Status:          dbmigrate.StatusSucceeded,
+		Rows:            int64(rowCount),
+		Rejected:        rejected,
+		NullConversions: nullConversions,
+		SanitizedValues: sanitized,
+		UnexpectedTypes: unexpectedTypes,
+		Columns:         stats.byColumn(table),
+		Bytes:           bytes,
+		Duration:        time.Since(tableStart).Round(time.Millisecond).String(),
+	}
+	m.progress.finishTable(table)
+	if errors.Is(err, context.Canceled) {
+		m.saveCheckpoint(dbmigrate.Checkpoint{Table: table, RowsMigrated: previousRows + int64(rowCount), Value: checkpointValue()})
+		tableReport.Status = dbmigrate.StatusInterrupted
+		m.addTableReport(tableReport)
+		return rowCount, true, fmt.Errorf("migration interrupted during table %s after %d committed rows: %w", table, rowCount, err)
+	}
+	if err != nil {
+		m.metrics.recordError()
+		tableReport.Status = dbmigrate.StatusFailed
+		tableReport.Error = err.Error()
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			tableReport.Batch = rowErr.batch
+			tableReport.KeyRange = rowErr.keyRange
+			tableReport.Column = rowErr.column
+			tableReport.SampleRow = rowErr.row
+		}
+		// Readers of the target must not see the rows copied so far; tables
+		// written with -write-mode upsert or ignore keep the rows of earlier runs
+		if m.quarantineSchema != "" && m.writeMode == writeModeInsert {
+			quarantined, qerr := quarantineTable(m.targetDb, m.quarantineSchema, targetSchema, targetTable, m.preserveCase, m.restartIdentity)
+			if qerr != nil {
+				log.Printf("Warning: Could not quarantine table %s: %v", table, qerr)
+			} else {
+				fmt.Printf("⚠️  Moved the rows of the partially loaded table %s to %s, leaving it empty\n", tableReport.TargetTable, quarantined)
+				tableReport.Reason = "partially loaded rows quarantined in " + quarantined
+				// The next run copies the empty table from the start
+				m.recordCheckpoint(dbmigrate.Checkpoint{Table: table})
+			}
+		}
+		m.addTableReport(tableReport)
+		if !m.continueOnError {
+			return 0, true, fmt.Errorf("error migrating data for table %s: %v", table, err)
+		}
+		fmt.Printf("❌ Error migrating data for table %s, continuing with the next table: %v\n", table, err)
+		m.mu.Lock()
+		m.failedTables = append(m.failedTables, tableReport)
+		m.mu.Unlock()
+		return 0, true, nil
+	}
+
+	// A sliced table stopped by -slice-time-limit continues with its next slice
+	if pausedAt != nil {
+		tableReport.Status = dbmigrate.StatusDeferred
+		tableReport.Reason = fmt.Sprintf("-slice-time-limit %s reached, continues with slice %s", m.sliceLimit, pausedAt.label)
+		m.addTableReport(tableReport)
+		m.mu.Lock()
+		m.pausedTables = append(m.pausedTables, table)
+		m.mu.Unlock()
+		fmt.Printf("⏸️  Migrated %d rows from table %s, stopping at slice %s (-slice-time-limit)\n", rowCount, table, pausedAt.label)
+		return rowCount, true, nil
+	}
+
+	if changes != nil && changes.high != nil {
+		value, err := encodeKey(changes.high)
+		if err != nil {
+			log.Printf("Warning: Could not record the watermark of %s, the next run copies all rows: %v", table, err)
+		} else {
+			m.saveCheckpoint(dbmigrate.Checkpoint{Table: watermarkKeyPrefix + table, Completed: true, Value: value})
+			fmt.Printf("Recorded watermark %s = %v for the next run\n", changes.column, changes.high[0])
+		}
+	}
+	m.saveCheckpoint(dbmigrate.Checkpoint{Table: table, RowsMigrated: previousRows + int64(rowCount), Completed: true})
+	m.completeChangeBaseline(table)
+	if cp, ok := m.checkpoint(deferredKeyPrefix + table); ok && !cp.Completed {
+		m.saveCheckpoint(dbmigrate.Checkpoint{Table: deferredKeyPrefix + table, Completed: true})
+	}
+	m.addTableReport(tableReport)
+
+	for column, count := range sanitized {
+		fmt.Printf("Cleaned %d values with NUL bytes or invalid UTF-8 in column %s (-invalid-text %s)\n", count, column, m.invalidText)
+	}
+	fmt.Printf("✅ Migrated %d rows from table: %s\n", rowCount, table)
+	return rowCount, true, nil
+}
+
+// revalidateForeignKeys checks the loaded target tables against their foreign
+// keys, which -fast-load skipped while copying
+func (m *migrator) revalidateForeignKeys(tables [][2]string) error {
+	fmt.Printf("\nValidating foreign keys of %d loaded tables (-fast-load)\n", len(tables))
+	checked := 0
+	var failed []error
+	for _, table := range tables {
+		count, tableFailed, err := revalidateForeignKeys(m.targetDb, table[0], table[1], m.preserveCase)
+		if err != nil {
+			return err
+		}
+		checked += count
+		failed = append(failed, tableFailed...)
+	}
+	for _, err := range failed {
+		fmt.Printf("❌ %v\n", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d foreign keys have rows violating them; they were left NOT VALID", len(failed), checked)
+	}
+	fmt.Printf("✅ Validated %d foreign keys\n", checked)
+	return nil
+}
+
+// resumeKey returns the key to resume a partially migrated table after, or nil
+// if the table has to be restarted: resuming needs a checkpointed key and a
+// primary key in the target table to skip rows committed after the checkpoint
+func (m *migrator) resumeKey(checkpoint dbmigrate.Checkpoint, keyset *keysetScan, targetSchema, targetTable string) ([]interface{}, error) {
+	if keyset == nil || checkpoint.Value == "" {
+		return nil, nil
+	}
+	hasKey, err := targetHasPrimaryKey(m.targetDb, dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase))
+	if err != nil || !hasKey {
+		return nil, err
+	}
+	key, err := decodeKey(checkpoint.Value)
+	if err == nil && len(key) != len(keyset.columns) {
+		err = fmt.Errorf("expected %d key columns, found %d", len(keyset.columns), len(key))
+	}
+	if err != nil {
+		log.Printf("Warning: Ignoring the checkpointed key of %s: %v", checkpoint.Table, err)
+		return nil, nil
+	}
+	return key, nil
+}
+
+// runVerifyPhase compares the row counts of the source and target tables
+func (m *migrator) runVerifyPhase() error {
+	deferred := make(map[string]bool, len(m.deferredTables)+len(m.pausedTables))
+	for _, table := range append(m.deferredTables, m.pausedTables...) {
+		deferred[table] = true
+	}
+
+	// Chunks recorded by this run are not among the checkpoints loaded at start
+	var chunkCheckpoints map[string]dbmigrate.Checkpoint
+	if m.verifyChunkCount > 0 {
+		var err error
+		if chunkCheckpoints, err = m.stateStore.Load(); err != nil {
+			return fmt.Errorf("error loading chunk checksums: %v", err)
+		}
+	}
+
+	mismatches, verified := 0, 0
+	for _, table := range m.tables {
+		if deferred[table] {
+			fmt.Printf("Skipping deferred table: %s\n", table)
+			continue
+		}
+		verified++
+		parts := strings.Split(table, ".")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+		}
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+
+		var sourceCount, targetCount int64
+		if err := m.sourceDb.QueryRowContext(m.ctx, m.source.CountQuery(table)).Scan(&sourceCount); err != nil {
+			return fmt.Errorf("error counting source rows for %s: %v", table, err)
+		}
+		targetQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s", dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase))
+		if err := m.targetDb.QueryRowContext(m.ctx, targetQuery).Scan(&targetCount); err != nil {
+			return fmt.Errorf("error counting target rows for %s: %v", table, err)
+		}
+
+		failed := sourceCount != targetCount
+		if failed {
+			fmt.Printf("❌ %s: %d source rows, %d target rows\n", table, sourceCount, targetCount)
+		} else {
+			fmt.Printf("✅ %s: %d rows\n", table, sourceCount)
+		}
+
+		// Optionally check that no characters were lost or split in text columns
+		if m.verifyChars {
+			charMismatches, err := m.verifyCharacterCounts(table)
+			if err != nil {
+				return err
+			}
+			failed = failed || charMismatches > 0
+		}
+
+		// Optionally compare a sample of rows value by value
+		if m.verifySampleSize > 0 {
+			sampleMismatches, err := m.verifySample(table)
+			if err != nil {
+				return err
+			}
+			failed = failed || sampleMismatches > 0
+		}
+
+		// Optionally re-check recorded chunks against their checksums
+		if m.verifyChunkCount > 0 {
+			chunkMismatches, err := m.verifyChunks(table, chunkCheckpoints)
+			if err != nil {
+				return err
+			}
+			failed = failed || chunkMismatches > 0
+		}
+		if failed {
+			mismatches++
+		}
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("verification failed: %d of %d tables have mismatched row counts, character counts, sampled rows or chunks", mismatches, verified)
+	}
+	fmt.Printf("✅ Verified %d tables\n", verified)
+	return nil
+}
+
+// withoutComputedColumns removes the computed columns of a source table from
+// columns: all of them with ComputedDrop, the generated ones with ComputedGenerate
+func (m *migrator) withoutComputedColumns(table string, columns []string) ([]string, error) {
+	computed, err := dbmigrate.ComputedColumns(m.sourceDb, table)
+	if err != nil {
+		return nil, err
+	}
+	label := "computed"
+	if m.computedColumns == dbmigrate.ComputedGenerate {
+		definitions, err := dbmigrate.ComputedColumnDefinitions(m.sourceDb, table, m.preserveCase)
+		if err != nil {
+			return nil, err
+		}
+		computed, label = nil, "generated"
+		for _, definition := range definitions {
+			if definition.Generated != "" {
+				computed = append(computed, definition.Name)
+			}
+		}
+	}
+	if len(computed) == 0 {
+		return columns, nil
+	}
+	skip := make(map[string]bool, len(computed))
+	for _, column := range computed {
+		skip[column] = true
+	}
+	var kept []string
+	for _, column := range columns {
+		if !skip[column] {
+			kept = append(kept, column)
+		}
+	}
+	fmt.Printf("Skipping %s columns: %s\n", label, strings.Join(computed, ", "))
+	return kept, nil
+}
+
+// rowTransform returns the transform applied to each row of a table before it is
+// inserted: the Go types returned by the driver are checked, values are
+// converted by source type (by the source for MySQL and PostgreSQL), then the null_policy of each column and
+// -audit-backfill are applied and NUL bytes and invalid UTF-8 are handled,
+// counting the changed values by column in nullConversions and sanitized and recording unexpected Go types by column
+// in unexpectedTypes
+func (m *migrator) rowTransform(table string, columns []string, nullConversions, sanitized map[string]int64, unexpectedTypes map[string]string) (func(values []interface{}) error, error) {
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+
+	// User-defined target types (-type-map and the config file)
+	targets := make(map[string]string)
+	for _, column := range columnTypes {
+		if target, ok := m.typeMapper.Lookup(table, column[0], column[1]); ok {
+			targets[column[0]] = target
+		}
+	}
+
+	settings := m.config.TableSettings(table)
+	zones, err := m.datetimeZones(table, columnTypes, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	backfill, err := m.auditBackfillTransform(table, columns, nullConversions)
+	if err != nil {
+		return nil, err
+	}
+
+	var convert func(values []interface{}) error
+	if m.source == dbmigrate.SQLServer {
+		convert = chainTransforms(
+			driverTypeTransform(table, columns, columnTypes, unexpectedTypes),
+			typeConversionTransform(columns, columnTypes, targets, zones, m.hierarchyid == dbmigrate.HierarchyidLtree),
+		)
+	} else {
+		sourceColumns, err := m.source.Columns(m.sourceDb, table, m.schemaOptions())
+		if err != nil {
+			return nil, err
+		}
+		convert = sourceConversionTransform(m.source, sourceColumns, columns, zones)
+	}
+
+	return chainTransforms(
+		convert,
+		nullPolicyTransform(settings, columns, nullConversions),
+		backfill,
+		sanitizeTransform(m.invalidText, columns, sanitized),
+	), nil
+}
+
+// datetimeZones returns the time zone of each naive datetime column of a table
+// stored as TIMESTAMPTZ, other than UTC: the source time zone unless configured
+// per column. A user-defined target type takes precedence over -datetime-type.
+func (m *migrator) datetimeZones(table string, columnTypes [][2]string, targets map[string]string) (map[string]*time.Location, error) {
+	settings := m.config.TableSettings(table)
+	zones := make(map[string]*time.Location)
+	for _, column := range columnTypes {
+		if !dbmigrate.IsNaiveDatetime(column[1]) {
+			continue
+		}
+		withoutZone := m.datetimeType == dbmigrate.DatetimeTimestamp
+		if target, ok := targets[column[0]]; ok {
+			withoutZone = dbmigrate.IsTimestampWithoutTimeZone(target)
+		}
+		if withoutZone {
+			continue
+		}
+		zone := m.sourceTimezone
+		for name, columnSettings := range settings.Columns {
+			if strings.EqualFold(name, column[0]) && columnSettings.Timezone != "" {
+				var err error
+				if zone, err = time.LoadLocation(columnSettings.Timezone); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if zone != nil && zone != time.UTC {
+			zones[column[0]] = zone
+		}
+	}
+	return zones, nil
+}
+
+// nullPolicyTransform returns a row transform applying the null_policy of each
+// configured column, counting the converted values by column in counts, or nil
+// if no column has a policy
+func nullPolicyTransform(settings dbmigrate.TableConfig, columns []string, counts map[string]int64) func(values []interface{}) error {
+	policies := make(map[int]string)
+	for i, column := range columns {
+		for name, columnSettings := range settings.Columns {
+			if strings.EqualFold(name, column) && columnSettings.NullPolicy != "" {
+				policies[i] = columnSettings.NullPolicy
+			}
+		}
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	for i, policy := range policies {
+		fmt.Printf("Applying null_policy %s to column %s\n", policy, columns[i])
+	}
+	return func(values []interface{}) error {
+		for i, policy := range policies {
+			switch policy {
+			case dbmigrate.NullPolicyEmptyToNull:
+				if s, ok := values[i].(string); ok && s == "" {
+					values[i] = nil
+					counts[columns[i]]++
+				}
+			case dbmigrate.NullPolicyNullToEmpty:
+				if values[i] == nil {
+					values[i] = ""
+					counts[columns[i]]++
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// printErrorReport prints the tables that failed with -on-error continue
+func (m *migrator) printErrorReport() {
+	fmt.Printf("\n=== Failed tables (%d) ===\n", len(m.failedTables))
+	for _, table := range m.failedTables {
+		fmt.Printf("%s: %s\n", table.Table, table.Error)
+		if table.SampleRow != "" {
+			fmt.Printf("  Row: %s\n", table.SampleRow)
+		}
+	}
+}
+
+// firstLine returns the first line of a statement for logging
+func firstLine(statement string) string {
+	if i := strings.Index(statement, "\n"); i >= 0 {
+		return strings.TrimSuffix(statement[:i], " (")
+	}
+	return statement
+}
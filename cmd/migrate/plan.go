@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// printPlan prints what the selected phases would do without writing anything to the target
+func (m *migrator) printPlan(phases []string) error {
+	selected := make(map[string]bool)
+	for _, phase := range phases {
+		selected[phase] = true
+	}
+
+	fmt.Printf("\n=== Dry run: migration plan (phases: %s) ===\n", strings.Join(phases, ", "))
+
+	// Row counts and sizes come from the partition stats (the table statistics
+	// of MySQL and PostgreSQL), which are cheap to query
+	sizes, err := sourceTableSizes(m.source, m.sourceDb, m.schemas)
+	if err != nil {
+		return fmt.Errorf("error getting table statistics: %v", err)
+	}
+
+	conversions := make(map[string]int)
+	var unmapped dbmigrate.UnmappedTypesError
+	var totalRows, totalKB int64
+	for _, table := range m.tables {
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+
+		var exists bool
+		if err := m.targetDb.QueryRowContext(m.ctx, "SELECT to_regclass($1) IS NOT NULL", target).Scan(&exists); err != nil {
+			return fmt.Errorf("error checking target table %s: %v", target, err)
+		}
+
+		checkpoint, hasCheckpoint := m.checkpoints[table]
+		var actions []string
+		if selected[phaseSchema] && !exists {
+			actions = append(actions, "create")
+			if distribution := citusDistribution(m.config, table); m.citus && distribution != "" {
+				actions = append(actions, distribution)
+			}
+			if column := m.config.TableSettings(table).HypertableColumn; column != "" {
+				actions = append(actions, "hypertable by "+column)
+			}
+		}
+		if selected[phaseData] {
+			copyAction := "copy"
+			switch m.writeMode {
+			case writeModeUpsert:
+				copyAction = "copy (upsert)"
+			case writeModeIgnore:
+				copyAction = "copy (skip existing rows)"
+			}
+			switch {
+			case m.refreshTables[table]:
+				actions = append(actions, "truncate", copyAction+" (refreshed on every run)")
+			case m.incremental:
+				if _, ok := m.checkpoints[watermarkKeyPrefix+table]; ok {
+					actions = append(actions, copyAction+" of the rows changed since the previous run")
+				} else {
+					actions = append(actions, copyAction+" of all rows (no watermark recorded yet)")
+				}
+			case hasCheckpoint && checkpoint.Completed:
+				actions = append(actions, "skip (completed by a previous run)")
+			case hasCheckpoint && !m.truncate && checkpoint.Value != "" && exists:
+				hasKey, err := targetHasPrimaryKey(m.targetDb, target)
+				if err != nil {
+					return err
+				}
+				if hasKey {
+					actions = append(actions, fmt.Sprintf("resume after %d rows", checkpoint.RowsMigrated))
+				} else {
+					actions = append(actions, "truncate", copyAction)
+				}
+			default:
+				if m.truncate || hasCheckpoint {
+					actions = append(actions, "truncate")
+				}
+				actions = append(actions, copyAction)
+			}
+		}
+		if selected[phasePostload] {
+			actions = append(actions, "analyze")
+		}
+		if selected[phaseSync] {
+			if version, ok := m.syncedVersion(table); ok {
+				actions = append(actions, fmt.Sprintf("sync changes since version %d", version))
+			} else {
+				actions = append(actions, "skip sync (no change tracking version recorded)")
+			}
+		}
+		if selected[phaseReverseSync] {
+			if last, ok := m.reverseSyncedUpTo(table); ok {
+				actions = append(actions, "sync changes back since "+formatReverseWatermark(last))
+			} else {
+				actions = append(actions, "sync all rows back")
+			}
+		}
+		if selected[phaseVerify] {
+			actions = append(actions, "verify")
+		}
+		if !exists && !selected[phaseSchema] && selected[phaseData] {
+			actions = append(actions, "(target table does not exist)")
+		}
+
+		size := sizes[table]
+		sizeKB := size.dataKB + size.indexKB
+		totalRows += size.rows
+		totalKB += sizeKB
+		fmt.Printf("%s -> %s: ~%d rows, %s: %s\n", table, target, size.rows, formatSizeKB(sizeKB), strings.Join(actions, ", "))
+		if warning, err := m.checkKeyDistribution(table, size.rows); err != nil {
+			return err
+		} else if warning != "" {
+			fmt.Printf("  ⚠️  %s\n", warning)
+		}
+		if err := m.printPartitioning(table, size.rows, selected[phaseSchema] && !exists); err != nil {
+			return err
+		}
+
+		// Type conversions involved for this table; the source maps the types
+		// of other sources than SQL Server
+		if m.source != dbmigrate.SQLServer {
+			sourceColumns, err := m.source.Columns(m.sourceDb, table, m.schemaOptions())
+			if err != nil {
+				return err
+			}
+			for _, column := range sourceColumns {
+				if column.TargetType != "" {
+					conversions[column.SourceType+" -> "+column.TargetType]++
+				}
+			}
+			continue
+		}
+		columns, err := m.getColumnTypes(table)
+		if err != nil {
+			return err
+		}
+		for _, column := range columns {
+			pgType, ok := m.typeMapper.Lookup(table, column[0], column[1])
+			if !ok {
+				pgType, ok = dbmigrate.TypeMapping[strings.ToLower(column[1])]
+			}
+			if !ok {
+				pgType = "TEXT (unmapped type)"
+				if !m.coerceUnknownTypes {
+					unmapped = append(unmapped, dbmigrate.UnmappedColumn{Table: table, Column: column[0], Type: column[1]})
+				}
+			}
+			conversions[strings.ToLower(column[1])+" -> "+pgType]++
+		}
+	}
+
+	fmt.Printf("\nTotal: %d tables, ~%d rows, %s\n", len(m.tables), totalRows, formatSizeKB(totalKB))
+
+	fmt.Println("\nType conversions:")
+	keys := make([]string, 0, len(conversions))
+	for key := range conversions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("  %s (%d columns)\n", key, conversions[key])
+	}
+	if len(unmapped) > 0 {
+		return withTypeMappingHint(unmapped)
+	}
+
+	// Run the schema DDL in a rolled-back transaction to catch statements the target would reject
+	if selected[phaseSchema] {
+		statements, err := m.generateSchema()
+		if err != nil {
+			return fmt.Errorf("error generating schema: %v", err)
+		}
+		failures, err := dbmigrate.ValidateDDL(m.ctx, m.targetDb, statements)
+		if err != nil {
+			return fmt.Errorf("error validating schema: %v", err)
+		}
+		if len(failures) > 0 {
+			fmt.Printf("\n❌ %d of %d schema statements were rejected by the target database:\n", len(failures), len(statements))
+			for _, failure := range failures {
+				fmt.Printf("  - %s\n", failure)
+			}
+			return fmt.Errorf("schema validation failed")
+		}
+		fmt.Printf("\n✅ Validated %d schema statements against the target database (rolled back)\n", len(statements))
+	}
+
+	fmt.Println("\nDry run complete, nothing was written to the target database")
+	return nil
+}
+
+// getColumnTypes returns the name and data type of each column of a source table
+func (m *migrator) getColumnTypes(fullTableName string) ([][2]string, error) {
+	if columns, ok := m.columnTypes[fullTableName]; ok {
+		return columns, nil
+	}
+	if m.source != dbmigrate.SQLServer {
+		sourceColumns, err := m.source.Columns(m.sourceDb, fullTableName, m.schemaOptions())
+		if err != nil {
+			return nil, err
+		}
+		columns := make([][2]string, len(sourceColumns))
+		for i, column := range sourceColumns {
+			columns[i] = [2]string{column.Name, column.SourceType}
+		}
+		return columns, nil
+	}
+	parts := strings.SplitN(fullTableName, ".", 2)
+	query := `
+		SELECT c.COLUMN_NAME, ` + dbmigrate.DataTypeExpr + `
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		WHERE c.TABLE_SCHEMA = @p1 AND c.TABLE_NAME = @p2
+		ORDER BY c.ORDINAL_POSITION`
+
+	rows, err := m.sourceDb.QueryContext(m.ctx, query, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting column types for table %s: %v", fullTableName, err)
+	}
+	defer rows.Close()
+
+	var columns [][2]string
+	for rows.Next() {
+		var column [2]string
+		if err := rows.Scan(&column[0], &column[1]); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// printPartitioning suggests range partitioning for a table with more rows than
+// -partition-rows, or than dbmigrate.PartitionSuggestionRows if not set; created
+// tells whether the schema phase would create the table
+func (m *migrator) printPartitioning(table string, rows int64, created bool) error {
+	threshold := m.partitionRows
+	if threshold == 0 {
+		threshold = dbmigrate.PartitionSuggestionRows
+	}
+	if rows <= threshold || m.source != dbmigrate.SQLServer {
+		return nil
+	}
+	partitioning, err := dbmigrate.SuggestPartitioning(m.sourceDb, table, rows)
+	if err != nil {
+		return err
+	}
+	switch {
+	case partitioning.Column == "":
+		fmt.Printf("  ⚠️  Large table, but no partitioning can be suggested: %s\n", partitioning.Reason)
+	case m.partitionRows > 0 && created:
+		fmt.Printf("  Partitioned: %s\n", partitioning)
+	default:
+		fmt.Printf("  ⚠️  Large table, consider partitioning it (-partition-rows): %s\n", partitioning)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// postLoadTable updates the planner statistics of a loaded target table with
+// ANALYZE and moves each sequence owned by one of its columns (serial and
+// identity columns) past the highest value in the column, so the next insert
+// does not collide with a migrated row. It returns the number of sequences set.
+func postLoadTable(ctx context.Context, targetDb *sql.DB, qualified string) (int, error) {
+	if _, err := targetDb.ExecContext(ctx, "ANALYZE "+qualified); err != nil {
+		return 0, fmt.Errorf("error analyzing %s: %v", qualified, err)
+	}
+
+	rows, err := targetDb.QueryContext(ctx, `
+		SELECT a.attname, pg_get_serial_sequence($1, a.attname)
+		FROM pg_attribute a
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		AND pg_get_serial_sequence($1, a.attname) IS NOT NULL`, qualified)
+	if err != nil {
+		return 0, fmt.Errorf("error querying sequences of %s: %v", qualified, err)
+	}
+	var columns, sequences []string
+	for rows.Next() {
+		var column, sequence string
+		if err := rows.Scan(&column, &sequence); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning sequence: %v", err)
+		}
+		columns = append(columns, column)
+		sequences = append(sequences, sequence)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error querying sequences of %s: %v", qualified, err)
+	}
+
+	for i, column := range columns {
+		// With is_called false, the next value is MAX + 1, or 1 for an empty table
+		quoted := dbmigrate.QuoteIdent(column, true)
+		var next int64
+		if err := targetDb.QueryRowContext(ctx, fmt.Sprintf("SELECT setval($1, COALESCE(MAX(%s), 0) + 1, false) FROM %s", quoted, qualified),
+			sequences[i]).Scan(&next); err != nil {
+			return i, fmt.Errorf("error setting sequence %s: %v", sequences[i], err)
+		}
+		fmt.Printf("Sequence %s of %s.%s continues at %d\n", sequences[i], qualified, column, next)
+	}
+	return len(columns), nil
+}
+
+// runPostloadPhase analyzes the migrated tables and synchronizes their sequences
+func (m *migrator) runPostloadPhase() error {
+	start := time.Now()
+	analyzed, synced := 0, 0
+	for _, table := range m.tables {
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		count, err := postLoadTable(m.ctx, m.targetDb, dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase))
+		if err != nil {
+			return err
+		}
+		analyzed++
+		synced += count
+	}
+	fmt.Printf("✅ Analyzed %d tables and set %d sequences in %s\n", analyzed, synced, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// runPostload implements the "postload" subcommand, which analyzes the tables
+// of target schemas and synchronizes their sequences after a migration done
+// without the postload phase, or after loading data by other means
+func runPostload(args []string) {
+	fs := flag.NewFlagSet("postload", flag.ExitOnError)
+	targetDsnFlag := fs.String("target-dsn", "", "PostgreSQL connection string (default: TARGET_DB_DSN environment variable)")
+	schemasFlag := fs.String("schemas", "public", "Comma-separated list of target schemas whose tables to process")
+	tablesFlag := fs.String("tables", "", "Comma-separated list of tables (schema.table) to process instead of all tables of -schemas")
+	fs.Parse(args)
+
+	targetDsn := *targetDsnFlag
+	if targetDsn == "" {
+		targetDsn = os.Getenv("TARGET_DB_DSN")
+	}
+	if targetDsn == "" {
+		log.Fatal("No target database connection. Set TARGET_DB_DSN environment variable or use -target-dsn flag.")
+	}
+	targetDb, err := sql.Open("postgres", targetDsn)
+	if err != nil {
+		log.Fatalf("Error connecting to target database: %v", err)
+	}
+	defer targetDb.Close()
+
+	var tables []string
+	if *tablesFlag != "" {
+		for _, table := range strings.Split(*tablesFlag, ",") {
+			if table = strings.TrimSpace(table); table != "" {
+				tables = append(tables, table)
+			}
+		}
+	} else {
+		var schemas []string
+		for _, schema := range strings.Split(*schemasFlag, ",") {
+			if schema = strings.TrimSpace(schema); schema != "" {
+				schemas = append(schemas, schema)
+			}
+		}
+		rows, err := targetDb.Query(`
+			SELECT format('%I.%I', schemaname, tablename) FROM pg_tables
+			WHERE schemaname = ANY(string_to_array($1, ','))
+			ORDER BY schemaname, tablename`, strings.Join(schemas, ","))
+		if err != nil {
+			log.Fatalf("Error listing tables: %v", err)
+		}
+		for rows.Next() {
+			var table string
+			if err := rows.Scan(&table); err != nil {
+				log.Fatalf("Error listing tables: %v", err)
+			}
+			tables = append(tables, table)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			log.Fatalf("Error listing tables: %v", err)
+		}
+	}
+	if len(tables) == 0 {
+		log.Fatal("No tables found to process")
+	}
+
+	start := time.Now()
+	synced := 0
+	for _, table := range tables {
+		count, err := postLoadTable(context.Background(), targetDb, table)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		synced += count
+	}
+	fmt.Printf("✅ Analyzed %d tables and set %d sequences in %s\n", len(tables), synced, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// connectionWarmupTimeout bounds how long opening the target connections may take
+const connectionWarmupTimeout = 30 * time.Second
+
+// preflightTargetConnections checks that the target can accept want connections
+// before any data is written: it compares the request with the server, role and
+// database connection limits, then opens the connections once to catch limits
+// that are not visible in the catalog (e.g., RDS Proxy or PgBouncer pool sizes).
+// On Amazon RDS and Aurora, the connections reserved for rds_superuser are not
+// available to other users either.
+func preflightTargetConnections(ctx context.Context, db *sql.DB, want int, platform *targetPlatform) error {
+	var maxConnections, reserved, rdsReserved, inUse, roleLimit, roleInUse, dbLimit, dbInUse int
+	query := `
+		SELECT current_setting('max_connections')::int,
+			current_setting('superuser_reserved_connections')::int,
+			COALESCE(NULLIF(current_setting('rds.rds_superuser_reserved_connections', true), ''), '0')::int,
+			(SELECT count(*) FROM pg_stat_activity),
+			(SELECT rolconnlimit FROM pg_roles WHERE rolname = current_user),
+			(SELECT count(*) FROM pg_stat_activity WHERE usename = current_user),
+			(SELECT datconnlimit FROM pg_database WHERE datname = current_database()),
+			(SELECT count(*) FROM pg_stat_activity WHERE datname = current_database())`
+	if err := db.QueryRowContext(ctx, query).Scan(&maxConnections, &reserved, &rdsReserved, &inUse, &roleLimit, &roleInUse, &dbLimit, &dbInUse); err != nil {
+		return fmt.Errorf("error checking connection limits: %v", err)
+	}
+
+	reservedName := "superuser_reserved_connections"
+	if platform.managed() && !platform.rdsSuperuser {
+		reserved += rdsReserved
+		reservedName += " and rds.rds_superuser_reserved_connections"
+	}
+
+	// Connections this process already holds count towards the request
+	open := db.Stats().OpenConnections
+	needed := want - open
+	limits := []struct {
+		name         string
+		limit, inUse int
+	}{
+		{"max_connections (minus " + reservedName + ")", maxConnections - reserved, inUse},
+		{"the connection limit of the current role", roleLimit, roleInUse},
+		{"the connection limit of the database", dbLimit, dbInUse},
+	}
+	for _, l := range limits {
+		if l.limit < 0 {
+			continue
+		}
+		if free := l.limit - l.inUse; needed > free {
+			return fmt.Errorf("the target database cannot accept %d connections: %s is %d with %d in use, leaving %d. "+
+				"Lower -max-connections, stop other clients, raise the limit, or connect through a connection pooler",
+				want, l.name, l.limit, l.inUse, free+open)
+		}
+	}
+
+	// Open all connections once, holding them until the last one is established
+	ctx, cancel := context.WithTimeout(ctx, connectionWarmupTimeout)
+	defer cancel()
+	conns := make([]*sql.Conn, 0, want)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for len(conns) < want {
+		conn, err := db.Conn(ctx)
+		if err == nil {
+			if err = conn.PingContext(ctx); err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("could only open %d of %d connections to the target database: %v. "+
+				"Lower -max-connections or raise the connection limit of the server or proxy", len(conns), want, err)
+		}
+		conns = append(conns, conn)
+	}
+	return nil
+}
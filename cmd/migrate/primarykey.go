@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// primaryKey locates the primary key of a source table in the columns read
+// from it. It is shared by everything that pages, resumes, looks up or
+// reports rows by key (keyset pagination and its checkpoints, streamed blobs,
+// chunk checksums, sampled verification, the change tracking sync and
+// rejected rows), so composite keys are handled alike: as all of their
+// columns, in key order.
+type primaryKey struct {
+	columns []string // key columns, in key order
+	indexes []int    // positions of the key columns in the selected columns
+	text    []bool   // key columns the driver returns as text in a []byte
+}
+
+// newPrimaryKey locates the key columns pkColumns in columns. Returns nil if
+// there are no key columns or one of them is not among columns.
+func newPrimaryKey(pkColumns, columns []string) *primaryKey {
+	if len(pkColumns) == 0 {
+		return nil
+	}
+	key := &primaryKey{columns: pkColumns}
+	for _, pk := range pkColumns {
+		index := -1
+		for i, column := range columns {
+			if column == pk {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil
+		}
+		key.indexes = append(key.indexes, index)
+		key.text = append(key.text, false)
+	}
+	return key
+}
+
+// primaryKey returns the primary key of a source table located in columns, or
+// nil if the table has none or a key column is not among columns
+func (m *migrator) primaryKey(table string, columns []string) (*primaryKey, error) {
+	pkColumns, err := m.source.PrimaryKey(m.sourceDb, table)
+	if err != nil {
+		return nil, err
+	}
+	key := newPrimaryKey(pkColumns, columns)
+	if key == nil {
+		return nil, nil
+	}
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(columnTypes))
+	for _, column := range columnTypes {
+		types[column[0]] = strings.ToLower(column[1])
+	}
+	for i, column := range key.columns {
+		key.text[i] = keyAsText(m.source, types[column])
+	}
+	return key, nil
+}
+
+// keyAsText tells whether the []byte values the driver returns for a key
+// column of a source type are text: decimals for SQL Server, all non-binary
+// types for MySQL and the types the PostgreSQL driver does not decode
+func keyAsText(source dbmigrate.Source, sourceType string) bool {
+	switch source {
+	case dbmigrate.MySQL:
+		return !dbmigrate.MySQLBinaryTypes[sourceType]
+	case dbmigrate.Postgres:
+		return sourceType != "bytea"
+	}
+	return numericTypes[sourceType]
+}
+
+// values returns the key of a row in key order. Text returned as bytes is
+// passed back as a string, since []byte parameters are sent as binary.
+func (k *primaryKey) values(row []interface{}) []interface{} {
+	key := make([]interface{}, len(k.indexes))
+	for i, index := range k.indexes {
+		key[i] = row[index]
+		if b, ok := key[i].([]byte); ok && k.text[i] {
+			key[i] = string(b)
+		}
+	}
+	return key
+}
+
+// targetCondition returns the condition matching the key in the target table,
+// e.g. "a = $1 AND b = $2" for first 1
+func (k *primaryKey) targetCondition(first int, preserveCase bool) string {
+	conditions := make([]string, len(k.columns))
+	for i, column := range k.columns {
+		conditions[i] = fmt.Sprintf("%s = $%d", dbmigrate.QuoteIdent(column, preserveCase), first+i)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// format formats the key of a row, e.g. "a=1, b=x"
+func (k *primaryKey) format(row []interface{}) string {
+	return formatSampleRow(k.columns, k.values(row))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+func TestNewPrimaryKey(t *testing.T) {
+	columns := []string{"Name", "OrderID", "LineNo", "Amount"}
+	tests := []struct {
+		name        string
+		pkColumns   []string
+		wantIndexes []int
+	}{
+		{"single", []string{"OrderID"}, []int{1}},
+		{"composite in column order", []string{"OrderID", "LineNo"}, []int{1, 2}},
+		{"composite out of column order", []string{"LineNo", "Name"}, []int{2, 0}},
+		{"no key", nil, nil},
+		{"key column not migrated", []string{"OrderID", "Region"}, nil},
+		{"case must match", []string{"orderid"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := newPrimaryKey(tt.pkColumns, columns)
+			if tt.wantIndexes == nil {
+				if key != nil {
+					t.Fatalf("newPrimaryKey(%v) = %+v, want nil", tt.pkColumns, key)
+				}
+				return
+			}
+			if key == nil {
+				t.Fatalf("newPrimaryKey(%v) = nil", tt.pkColumns)
+			}
+			if !reflect.DeepEqual(key.indexes, tt.wantIndexes) {
+				t.Errorf("indexes = %v, want %v", key.indexes, tt.wantIndexes)
+			}
+			if !reflect.DeepEqual(key.columns, tt.pkColumns) {
+				t.Errorf("columns = %v, want %v", key.columns, tt.pkColumns)
+			}
+		})
+	}
+}
+
+func TestPrimaryKeyValues(t *testing.T) {
+	row := []interface{}{"widget", int64(42), []byte("7.50"), []byte{0xde, 0xad}}
+	tests := []struct {
+		name    string
+		indexes []int
+		text    []bool
+		want    []interface{}
+	}{
+		{"key order, not column order", []int{1, 0}, []bool{false, false}, []interface{}{int64(42), "widget"}},
+		{"text as bytes becomes a string", []int{2, 1}, []bool{true, false}, []interface{}{"7.50", int64(42)}},
+		{"binary stays bytes", []int{3, 2}, []bool{false, true}, []interface{}{[]byte{0xde, 0xad}, "7.50"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &primaryKey{columns: make([]string, len(tt.indexes)), indexes: tt.indexes, text: tt.text}
+			if got := key.values(row); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysetQuery(t *testing.T) {
+	key := &primaryKey{columns: []string{"OrderID", "LineNo"}, indexes: []int{1, 0}, text: []bool{false, false}}
+	tests := []struct {
+		name   string
+		source dbmigrate.Source
+		exprs  []string
+		where  string
+		after  []interface{}
+		want   string
+	}{
+		{"sqlserver first page", dbmigrate.SQLServer, []string{"[OrderID]", "[LineNo]"}, "", nil,
+			"SELECT TOP (10) * FROM [sales].[Lines] ORDER BY [OrderID], [LineNo]"},
+		{"sqlserver next page in slice", dbmigrate.SQLServer, []string{"[OrderID]", "[LineNo]"}, "[Day] >= @from", []interface{}{int64(1), int64(2)},
+			"SELECT TOP (10) * FROM [sales].[Lines] WHERE ([Day] >= @from) AND (([OrderID] > @p1) OR ([OrderID] = @p1 AND [LineNo] > @p2)) ORDER BY [OrderID], [LineNo]"},
+		{"mysql next page", dbmigrate.MySQL, []string{"`OrderID`", "`LineNo`"}, "", []interface{}{int64(1), int64(2)},
+			"SELECT * FROM `sales`.`Lines` WHERE (`OrderID`, `LineNo`) > (?, ?) ORDER BY `OrderID`, `LineNo` LIMIT 10"},
+		{"postgres next page", dbmigrate.Postgres, []string{`"OrderID"`, `"LineNo"`}, "", []interface{}{int64(1), int64(2)},
+			`SELECT * FROM "sales"."Lines" WHERE ("OrderID", "LineNo") > ($1, $2) ORDER BY "OrderID", "LineNo" LIMIT 10`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scan := &keysetScan{primaryKey: key, source: tt.source, exprs: tt.exprs}
+			if got := scan.query("*", "sales.Lines", tt.where, 10, tt.after); got != tt.want {
+				t.Errorf("query() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  []interface{}
+	}{
+		{"int", []interface{}{int64(-42)}},
+		{"uint", []interface{}{uint64(18446744073709551615)}},
+		{"float", []interface{}{0.1}},
+		{"bool", []interface{}{true}},
+		{"string", []interface{}{`quote " and comma ,`}},
+		{"bytes", []interface{}{[]byte{0, 1, 0xff}}},
+		{"time", []interface{}{time.Date(2024, 2, 29, 23, 59, 59, 123456789, time.UTC)}},
+		{"composite of all types", []interface{}{int64(1), uint64(2), 3.5, false, "x", []byte("y"), time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := encodeKey(tt.key)
+			if err != nil {
+				t.Fatalf("encodeKey(%#v) failed: %v", tt.key, err)
+			}
+			decoded, err := decodeKey(encoded)
+			if err != nil {
+				t.Fatalf("decodeKey(%s) failed: %v", encoded, err)
+			}
+			if len(decoded) != len(tt.key) {
+				t.Fatalf("decodeKey(%s) = %#v, want %#v", encoded, decoded, tt.key)
+			}
+			for i := range tt.key {
+				if want, ok := tt.key[i].(time.Time); ok {
+					if got, ok := decoded[i].(time.Time); !ok || !got.Equal(want) {
+						t.Errorf("value %d = %#v, want %v", i, decoded[i], want)
+					}
+				} else if !reflect.DeepEqual(decoded[i], tt.key[i]) {
+					t.Errorf("value %d = %#v, want %#v", i, decoded[i], tt.key[i])
+				}
+			}
+		})
+	}
+
+	if _, err := encodeKey([]interface{}{int32(1)}); err == nil {
+		t.Error("encodeKey(int32) succeeded, want an unsupported type error")
+	}
+	for _, data := range []string{"not json", `[{"t":"int","v":"x"}]`, `[{"t":"decimal","v":"1"}]`} {
+		if _, err := decodeKey(data); err == nil {
+			t.Errorf("decodeKey(%s) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestConflictClause(t *testing.T) {
+	columns := []string{"OrderID", "LineNo", "Amount", "Note"}
+	tests := []struct {
+		name       string
+		mode       string
+		pkColumns  []string
+		provenance string
+		want       string
+	}{
+		{"insert", writeModeInsert, []string{"OrderID"}, "", ""},
+		{"no key", writeModeUpsert, nil, "", ""},
+		{"ignore composite", writeModeIgnore, []string{"OrderID", "LineNo"}, "",
+			" ON CONFLICT (OrderID, LineNo) DO NOTHING"},
+		{"upsert composite in key order", writeModeUpsert, []string{"LineNo", "OrderID"}, "",
+			" ON CONFLICT (LineNo, OrderID) DO UPDATE SET Amount = EXCLUDED.Amount, Note = EXCLUDED.Note"},
+		{"upsert with provenance", writeModeUpsert, []string{"OrderID", "LineNo"}, "migrated_by",
+			" ON CONFLICT (OrderID, LineNo) DO UPDATE SET Amount = EXCLUDED.Amount, Note = EXCLUDED.Note, migrated_by = EXCLUDED.migrated_by"},
+		{"upsert of key columns only", writeModeUpsert, []string{"OrderID", "LineNo", "Amount", "Note"}, "",
+			" ON CONFLICT (OrderID, LineNo, Amount, Note) DO NOTHING"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := conflictClause(tt.mode, tt.pkColumns, columns, tt.provenance, false); got != tt.want {
+				t.Errorf("conflictClause() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The provenance column is not appended to the caller's columns
+	conflictClause(writeModeUpsert, []string{"OrderID"}, columns[:2], "migrated_by", false)
+	if columns[2] != "Amount" {
+		t.Errorf("conflictClause modified the columns: %v", columns)
+	}
+	if got := conflictClause(writeModeIgnore, []string{"Order"}, []string{"Order"}, "", true); got != ` ON CONFLICT ("Order") DO NOTHING` {
+		t.Errorf("conflictClause() with preserveCase = %q", got)
+	}
+}
+
+func TestTargetCondition(t *testing.T) {
+	tests := []struct {
+		name         string
+		columns      []string
+		first        int
+		preserveCase bool
+		want         string
+	}{
+		{"single", []string{"id"}, 1, false, "id = $1"},
+		{"composite after other parameters", []string{"OrderID", "LineNo"}, 3, false, "OrderID = $3 AND LineNo = $4"},
+		{"preserved case", []string{"OrderID", "LineNo"}, 1, true, `"OrderID" = $1 AND "LineNo" = $2`},
+		{"reserved word", []string{"user", "order"}, 1, false, `"user" = $1 AND "order" = $2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &primaryKey{columns: tt.columns}
+			if got := key.targetCondition(tt.first, tt.preserveCase); got != tt.want {
+				t.Errorf("targetCondition() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressDisplay renders per-table and overall progress bars with an ETA.
+// Progress is measured in bytes copied against the data size of the tables in
+// the partition statistics, so a table of a few huge binary values advances
+// with its data rather than its rows; tables without size statistics show
+// their rows instead. When disabled (e.g., output is not a terminal), it
+// prints a plain line per committed batch instead, which reads better in log
+// files. With several tables copied at the same time (-parallel-tables), the
+// bar shows the overall progress only. All methods are safe to call on a nil
+// value and from several goroutines.
+type progressDisplay struct {
+	mu           sync.Mutex
+	enabled      bool
+	totalBytes   int64 // estimated data size of all tables
+	doneBytes    int64 // bytes of finished tables, at their estimated size
+	skippedBytes int64 // bytes migrated by a previous run, excluded from the rate
+	startTime    time.Time
+
+	tables map[string]*tableProgress // tables being copied
+}
+
+// tableProgress is the progress of a table being copied
+type tableProgress struct {
+	expectedRows  int64
+	expectedBytes int64
+	skippedBytes  int64 // migrated by a previous run
+	rows          int64 // committed by this run
+	bytes         int64 // committed by this run
+	streaming     int64 // large binary value chunks of the current batch
+}
+
+// copied returns the bytes of the table copied by this run, up to its
+// estimated size
+func (t *tableProgress) copied() int64 {
+	return max(min(t.bytes+t.streaming, t.expectedBytes-t.skippedBytes), 0)
+}
+
+// newProgressDisplay returns a display for totalBytes of estimated data. Bars
+// are only drawn when enabled and stdout is a terminal.
+func newProgressDisplay(enabled bool, totalBytes int64) *progressDisplay {
+	if enabled {
+		info, err := os.Stdout.Stat()
+		enabled = err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressDisplay{enabled: enabled, totalBytes: totalBytes, startTime: time.Now(), tables: make(map[string]*tableProgress)}
+}
+
+// startTable starts tracking a table with the given estimated rows and size
+func (p *progressDisplay) startTable(table string, expectedRows, expectedBytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tables[table] = &tableProgress{expectedRows: expectedRows, expectedBytes: expectedBytes}
+}
+
+// update records the committed rows and bytes of a table being copied
+func (p *progressDisplay) update(table string, rows, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, ok := p.tables[table]
+	if !ok {
+		return
+	}
+	current.rows, current.bytes, current.streaming = rows, bytes, 0
+	if !p.enabled {
+		if len(p.tables) > 1 {
+			fmt.Printf("  Migrated %d rows (%s) of %s...\n", rows, formatSizeKB(bytes/1024), table)
+		} else {
+			fmt.Printf("  Migrated %d rows (%s)...\n", rows, formatSizeKB(bytes/1024))
+		}
+		return
+	}
+	p.render(current)
+}
+
+// streamed records a chunk of a large binary value copied in the current
+// batch of a table, which only moves the bars
+func (p *progressDisplay) streamed(table string, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, ok := p.tables[table]
+	if !ok {
+		return
+	}
+	current.streaming += bytes
+	if p.enabled {
+		p.render(current)
+	}
+}
+
+// render draws the bars, with the table's own bar if it is the only one being
+// copied
+func (p *progressDisplay) render(current *tableProgress) {
+	overall := p.doneBytes
+	for _, t := range p.tables {
+		overall += t.copied()
+	}
+	line := fmt.Sprintf("  %d tables | overall %s", len(p.tables), progressBar(overall, p.totalBytes, 20))
+	if len(p.tables) == 1 {
+		table := fmt.Sprintf("%s %d/%d rows", progressBar(current.rows, current.expectedRows, 20), current.rows, current.expectedRows)
+		if current.expectedBytes > 0 {
+			done := current.skippedBytes + current.copied()
+			table = fmt.Sprintf("%s %s/%s, %d rows", progressBar(done, current.expectedBytes, 20),
+				formatSizeKB(done/1024), formatSizeKB(current.expectedBytes/1024), current.rows)
+		}
+		line = fmt.Sprintf("  %s | overall %s", table, progressBar(overall, p.totalBytes, 20))
+	}
+	if eta := p.eta(overall); eta > 0 {
+		line += " ETA " + eta.Round(time.Second).String()
+	}
+	fmt.Printf("\r%s\033[K", line)
+}
+
+// finishTable ends a table's bar and counts it towards the overall progress
+// at its estimated size
+func (p *progressDisplay) finishTable(table string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, ok := p.tables[table]
+	if !ok {
+		return
+	}
+	if p.enabled {
+		fmt.Print("\r\033[K")
+	}
+	delete(p.tables, table)
+	p.doneBytes += max(current.expectedBytes-current.skippedBytes, 0)
+}
+
+// skipTable counts bytes migrated by a previous run towards the overall
+// progress: a whole table completed before, or the part of a table being
+// resumed
+func (p *progressDisplay) skipTable(table string, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if current, ok := p.tables[table]; ok {
+		current.skippedBytes += bytes
+	}
+	p.doneBytes += bytes
+	p.skippedBytes += bytes
+}
+
+// eta estimates the remaining time from the average rate since the start
+func (p *progressDisplay) eta(done int64) time.Duration {
+	copied := done - p.skippedBytes
+	if copied <= 0 || p.totalBytes <= done {
+		return 0
+	}
+	elapsed := time.Since(p.startTime)
+	return time.Duration(float64(elapsed) * float64(p.totalBytes-done) / float64(copied))
+}
+
+// progressBar renders "[#####-----]  50%" for done out of total
+func progressBar(done, total int64, width int) string {
+	ratio := 0.0
+	if total > 0 {
+		ratio = float64(done) / float64(total)
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * float64(width))
+	return fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("#", filled), strings.Repeat("-", width-filled), ratio*100)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// pushTable is a PostgreSQL table pushed to a target and its columns, whose
+// TargetType holds the target type
+type pushTable struct {
+	source, target string
+	columns        []dbmigrate.Column
+}
+
+// pushColumns reads the columns of a PostgreSQL table and maps their types to
+// those of the target: the -type-map override of the source type, else the
+// built-in mapping, else NVARCHAR(MAX) with coerce. Returns the columns
+// without a mapping.
+func pushColumns(sourceDb *sql.DB, target dbmigrate.Target, table string, typeMapper *dbmigrate.TypeMapper, coerce bool) ([]dbmigrate.Column, dbmigrate.UnmappedTypesError, error) {
+	columns, err := dbmigrate.Postgres.Columns(sourceDb, table, dbmigrate.SchemaOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var unmapped dbmigrate.UnmappedTypesError
+	for i, column := range columns {
+		// Without a type mapper, the target type of the source is the
+		// PostgreSQL type with its modifiers, or TEXT for user-defined types
+		targetType, ok := typeMapper.Lookup(table, column.Name, column.SourceType)
+		if !ok {
+			targetType, ok = target.ColumnType(column.TargetType)
+		}
+		if !ok && coerce {
+			targetType, ok = "NVARCHAR(MAX)", true
+		}
+		if !ok {
+			unmapped = append(unmapped, dbmigrate.UnmappedColumn{Table: table, Column: column.Name, Type: column.TargetType})
+		}
+		columns[i].TargetType = targetType
+	}
+	return columns, unmapped, nil
+}
+
+// pushRows copies the rows of a PostgreSQL table to the target table, with a
+// bulk load committed every batchSize rows. Returns the number of rows copied.
+func pushRows(sourceDb, targetDb *sql.DB, target dbmigrate.Target, table pushTable, batchSize int) (int64, error) {
+	selects := make([]string, len(table.columns))
+	names := make([]string, len(table.columns))
+	for i, column := range table.columns {
+		selects[i] = dbmigrate.Postgres.QuoteIdent(column.Name)
+		// money is read as a number rather than in the format of lc_monetary
+		if column.SourceType == "money" {
+			selects[i] += "::numeric"
+		}
+		names[i] = column.Name
+	}
+	rows, err := sourceDb.Query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), dbmigrate.Postgres.QuoteTable(table.source)))
+	if err != nil {
+		return 0, fmt.Errorf("error querying %s: %v", table.source, err)
+	}
+	defer rows.Close()
+
+	var copied int64
+	var tx *sql.Tx
+	var stmt *sql.Stmt
+	pending := 0
+	// flush sends the pending rows and commits them
+	flush := func() error {
+		if stmt == nil {
+			return nil
+		}
+		_, err := stmt.Exec()
+		stmt.Close()
+		stmt = nil
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error bulk loading %s: %v", table.target, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing %s: %v", table.target, err)
+		}
+		copied += int64(pending)
+		pending = 0
+		return nil
+	}
+
+	values := make([]interface{}, len(table.columns))
+	pointers := make([]interface{}, len(table.columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			if tx != nil {
+				tx.Rollback()
+			}
+			return copied, fmt.Errorf("error scanning row of %s: %v", table.source, err)
+		}
+		if stmt == nil {
+			if tx, err = targetDb.Begin(); err != nil {
+				return copied, fmt.Errorf("error starting transaction: %v", err)
+			}
+			if stmt, err = target.CopyIn(tx, table.target, names); err != nil {
+				tx.Rollback()
+				return copied, fmt.Errorf("error preparing bulk load of %s: %v", table.target, err)
+			}
+		}
+		row := make([]interface{}, len(values))
+		for i, column := range table.columns {
+			value, err := dbmigrate.Postgres.ConvertValue(column, values[i])
+			if err == nil && value != nil {
+				value, err = target.ConvertValue(column.TargetType, value)
+			}
+			if err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return copied, fmt.Errorf("error converting %s.%s: %v", table.source, column.Name, err)
+			}
+			row[i] = value
+		}
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return copied, fmt.Errorf("error bulk loading %s: %v", table.target, err)
+		}
+		if pending++; pending >= batchSize {
+			if err := flush(); err != nil {
+				return copied, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if stmt != nil {
+			stmt.Close()
+			tx.Rollback()
+		}
+		return copied, fmt.Errorf("error querying %s: %v", table.source, err)
+	}
+	return copied, flush()
+}
+
+// runPush implements the "push" subcommand, which copies tables from a
+// PostgreSQL database back to SQL Server: it maps the column types, creates
+// the missing target tables with -create-tables and bulk loads the rows
+func runPush(args []string) {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	sourceDsnFlag := fs.String("source-dsn", "", "PostgreSQL connection string (default: SOURCE_DB_DSN environment variable)")
+	targetDsnFlag := fs.String("target-dsn", "", "SQL Server connection string (default: TARGET_DB_DSN environment variable)")
+	schemasFlag := fs.String("schemas", "public", "Comma-separated list of PostgreSQL schemas whose tables to push")
+	tablesFlag := fs.String("tables", "", "Comma-separated list of tables (schema.table) to push instead of all tables of -schemas")
+	schemaMapFlag := fs.String("schema-map", "", "Comma-separated list of source=target schema mappings (e.g., public=dbo)")
+	typeMapFlag := fs.String("type-map", "", "Comma-separated list of PostgreSQL=SQL Server type mappings overriding the built-in ones (e.g., text=NVARCHAR(4000))")
+	coerceUnknownFlag := fs.Bool("coerce-unknown-to-text", false, "Create and load columns of PostgreSQL types without a mapping as NVARCHAR(MAX) instead of failing")
+	createTablesFlag := fs.Bool("create-tables", false, "Create the target schemas and tables that do not exist")
+	truncateFlag := fs.Bool("truncate", false, "Truncate the target tables before loading them")
+	batchSizeFlag := fs.Int("batch-size", 10000, "Number of rows per bulk load transaction")
+	fs.Parse(args)
+
+	if *batchSizeFlag <= 0 {
+		log.Fatal("-batch-size must be positive")
+	}
+	sourceDsn := *sourceDsnFlag
+	if sourceDsn == "" {
+		sourceDsn = os.Getenv("SOURCE_DB_DSN")
+	}
+	if sourceDsn == "" {
+		log.Fatal("No source database connection. Set SOURCE_DB_DSN environment variable or use -source-dsn flag.")
+	}
+	if !dbmigrate.IsPostgresDsn(sourceDsn) {
+		log.Fatal("push reads from PostgreSQL: -source-dsn must be a postgres:// connection string")
+	}
+	targetDsn := *targetDsnFlag
+	if targetDsn == "" {
+		targetDsn = os.Getenv("TARGET_DB_DSN")
+	}
+	if targetDsn == "" {
+		log.Fatal("No target database connection. Set TARGET_DB_DSN environment variable or use -target-dsn flag.")
+	}
+	targetDsn, err := dbmigrate.PrepareSqlServerDsn(targetDsn, "")
+	if err != nil {
+		log.Fatalf("Error preparing target connection string: %v", err)
+	}
+	mapper, err := dbmigrate.NewNameMapper(nil, *schemaMapFlag)
+	if err != nil {
+		log.Fatalf("Error parsing schema map: %v", err)
+	}
+	typeMapper, err := dbmigrate.NewTypeMapper(nil, *typeMapFlag)
+	if err != nil {
+		log.Fatalf("Error parsing type map: %v", err)
+	}
+	target := dbmigrate.SQLServerTarget
+
+	sourceDb, err := sql.Open("postgres", sourceDsn)
+	if err != nil {
+		log.Fatalf("Error connecting to source database: %v", err)
+	}
+	defer sourceDb.Close()
+	targetDb, err := sql.Open("sqlserver", targetDsn)
+	if err != nil {
+		log.Fatalf("Error connecting to target database: %v", err)
+	}
+	defer targetDb.Close()
+	if err := targetDb.Ping(); err != nil {
+		log.Fatalf("Error connecting to target database: %v", err)
+	}
+
+	var tables []string
+	if *tablesFlag != "" {
+		for _, table := range strings.Split(*tablesFlag, ",") {
+			if table = strings.TrimSpace(table); table != "" {
+				tables = append(tables, table)
+			}
+		}
+	} else {
+		var schemas []string
+		for _, schema := range strings.Split(*schemasFlag, ",") {
+			if schema = strings.TrimSpace(schema); schema != "" {
+				schemas = append(schemas, schema)
+			}
+		}
+		if tables, err = dbmigrate.Postgres.Tables(sourceDb, schemas); err != nil {
+			log.Fatalf("Error listing tables: %v", err)
+		}
+	}
+	if len(tables) == 0 {
+		log.Fatal("No tables found to push")
+	}
+
+	// Referenced tables are loaded first, so existing foreign keys of the
+	// target tables are satisfied
+	dependencies, err := dbmigrate.Postgres.ForeignKeys(sourceDb)
+	if err != nil {
+		log.Fatalf("Error reading foreign keys: %v", err)
+	}
+	tables, cycles := dbmigrate.OrderByDependencies(tables, dependencies)
+	for _, cycle := range cycles {
+		log.Printf("Warning: tables %s reference each other; their foreign keys in %s may reject the rows", strings.Join(cycle, ", "), target.Name())
+	}
+
+	// Map all types before writing anything
+	var pushTables []pushTable
+	var unmapped dbmigrate.UnmappedTypesError
+	for _, table := range tables {
+		columns, tableUnmapped, err := pushColumns(sourceDb, target, table, typeMapper, *coerceUnknownFlag)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		unmapped = append(unmapped, tableUnmapped...)
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := mapper.Map(parts[0], parts[1])
+		pushTables = append(pushTables, pushTable{source: table, target: targetSchema + "." + targetTable, columns: columns})
+	}
+	if len(unmapped) > 0 {
+		log.Fatalf("❌ %v", unmapped)
+	}
+
+	start := time.Now()
+	var total int64
+	for _, table := range pushTables {
+		if *createTablesFlag {
+			for _, statement := range target.CreateTable(table.target, table.columns) {
+				if _, err := targetDb.Exec(statement); err != nil {
+					log.Fatalf("❌ Error creating %s: %v", table.target, err)
+				}
+			}
+		}
+		if *truncateFlag {
+			if _, err := targetDb.Exec(target.TruncateTable(table.target)); err != nil {
+				log.Fatalf("❌ Error truncating %s: %v", table.target, err)
+			}
+		}
+		tableStart := time.Now()
+		copied, err := pushRows(sourceDb, targetDb, target, table, *batchSizeFlag)
+		if err != nil {
+			log.Fatalf("❌ %v (%d rows of %s committed)", err, copied, table.target)
+		}
+		total += copied
+		fmt.Printf("✅ Pushed %d rows of %s to %s in %s\n", copied, table.source, table.target, time.Since(tableStart).Round(time.Millisecond))
+	}
+	fmt.Printf("✅ Pushed %d rows of %d tables to %s in %s\n", total, len(pushTables), target.Name(), time.Since(start).Round(time.Millisecond))
+}
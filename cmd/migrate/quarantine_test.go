@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuarantineTable(t *testing.T) {
+	target := &fakeDB{}
+	quarantined, err := quarantineTable(openFakeDB(t, target), "quarantine", "public", "orders", false, false)
+	if err != nil {
+		t.Fatalf("quarantineTable() failed: %v", err)
+	}
+	if quarantined != "quarantine.public_orders" {
+		t.Errorf("quarantineTable() = %q, want quarantine.public_orders", quarantined)
+	}
+	// The rows are copied and the table emptied in place, so its foreign keys,
+	// sequences and grants stay with it
+	want := []string{
+		"BEGIN",
+		"CREATE SCHEMA IF NOT EXISTS quarantine",
+		"DROP TABLE IF EXISTS quarantine.public_orders",
+		"CREATE TABLE quarantine.public_orders AS SELECT * FROM public.orders",
+		"TRUNCATE TABLE public.orders",
+		"COMMIT",
+	}
+	if got := target.executed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+
+	target = &fakeDB{}
+	if _, err := quarantineTable(openFakeDB(t, target), "Quarantine", "Sales", "Order", true, true); err != nil {
+		t.Fatalf("quarantineTable() failed: %v", err)
+	}
+	executed := strings.Join(target.executed(), "\n")
+	for _, statement := range []string{
+		`CREATE TABLE "Quarantine"."Sales_Order" AS SELECT * FROM "Sales"."Order"`,
+		`TRUNCATE TABLE "Sales"."Order" RESTART IDENTITY`,
+	} {
+		if !strings.Contains(executed, statement) {
+			t.Errorf("statements do not include %s:\n%s", statement, executed)
+		}
+	}
+}
+
+func TestQuarantineTableRollsBack(t *testing.T) {
+	// A table referenced by foreign keys cannot be truncated; the copy of its
+	// rows is rolled back with it
+	target := &fakeDB{exec: func(query string, args []driver.Value) error {
+		if strings.HasPrefix(query, "TRUNCATE") {
+			return errors.New(`cannot truncate a table referenced in a foreign key constraint`)
+		}
+		return nil
+	}}
+	if _, err := quarantineTable(openFakeDB(t, target), "quarantine", "public", "orders", false, false); err == nil {
+		t.Fatal("quarantineTable() succeeded, want an error")
+	}
+	executed := target.executed()
+	if last := executed[len(executed)-1]; last != "ROLLBACK" {
+		t.Errorf("last statement = %q, want ROLLBACK", last)
+	}
+	for _, statement := range executed {
+		if statement == "COMMIT" {
+			t.Errorf("statements include COMMIT: %q", executed)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// rejectWriter records rows that could not be inserted, as CSV or JSON Lines
+// depending on the file extension (.jsonl or .ndjson for JSON Lines)
+type rejectWriter struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	csv        *csv.Writer
+	jsonl      bool
+	count      int
+	maxRejects int // 0 = unlimited
+}
+
+// rejectRecord is a rejected row as written to the reject file
+type rejectRecord struct {
+	Table string `json:"table"`
+	Key   string `json:"key"` // primary key, or the whole row if the table has none
+	Error string `json:"error"`
+}
+
+// newRejectWriter creates (or truncates) the reject file
+func newRejectWriter(path string, maxRejects int) (*rejectWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating reject file: %v", err)
+	}
+	w := &rejectWriter{path: path, file: file, maxRejects: maxRejects}
+	lower := strings.ToLower(path)
+	w.jsonl = strings.HasSuffix(lower, ".jsonl") || strings.HasSuffix(lower, ".ndjson")
+	if !w.jsonl {
+		w.csv = csv.NewWriter(file)
+		w.csv.Write([]string{"table", "key", "error"})
+		w.csv.Flush()
+	}
+	return w, nil
+}
+
+// reject records a row and returns an error once more than maxRejects rows were rejected
+func (w *rejectWriter) reject(table, key string, rowErr error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	record := rejectRecord{Table: table, Key: key, Error: rowErr.Error()}
+	if w.jsonl {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := w.file.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("error writing reject file: %v", err)
+		}
+	} else {
+		w.csv.Write([]string{record.Table, record.Key, record.Error})
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return fmt.Errorf("error writing reject file: %v", err)
+		}
+	}
+
+	w.count++
+	if w.maxRejects > 0 && w.count > w.maxRejects {
+		return fmt.Errorf("more than %d rows rejected (see %s), last error: %v", w.maxRejects, w.path, rowErr)
+	}
+	return nil
+}
+
+// close closes the reject file and returns the number of rejected rows
+func (w *rejectWriter) close() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.file.Close()
+	return w.count
+}
+
+// rowKey formats the primary key values of a row, or the whole row if the table
+// has no primary key or a key column is not among columns
+func rowKey(columns, pkColumns []string, values []interface{}) string {
+	if key := newPrimaryKey(pkColumns, columns); key != nil {
+		return key.format(values)
+	}
+	return formatSampleRow(columns, values)
+}
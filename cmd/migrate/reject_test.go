@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tendant/dbmigrate"
+)
+
+func TestRejectFile(t *testing.T) {
+	for _, tt := range []struct {
+		file string
+		want string
+	}{
+		{"rejects.csv", "table,key,error\n" +
+			`dbo.Lookup,id=5,"pq: new row for relation ""lookup"" violates check constraint ""lookup_name_check"""` + "\n"},
+		{"rejects.jsonl", `{"table":"dbo.Lookup","key":"id=5","error":"pq: new row for relation \"lookup\" violates check constraint \"lookup_name_check\""}` + "\n"},
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			rejects, err := newRejectWriter(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			columns := []string{"id", "name"}
+			opts := copyOptions{
+				source:        dbmigrate.SQLServer,
+				fullTableName: "dbo.Lookup",
+				targetSchema:  "dbo",
+				targetTable:   "lookup",
+				columns:       columns,
+				onReject: func(values []interface{}, err error) error {
+					return rejects.reject("dbo.Lookup", rowKey(columns, []string{"id"}, values), err)
+				},
+			}
+			target := newCopyTarget(5)
+			count, err := copyTableData(context.Background(), openFakeDB(t, sourceRows(8)), openFakeDB(t, target.fakeDB), opts)
+			if err != nil || count != 7 {
+				t.Fatalf("copyTableData() = %d, %v, want 7 rows", count, err)
+			}
+			if n := rejects.close(); n != 1 {
+				t.Errorf("close() = %d rejected rows, want 1", n)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("reject file:\n%s\nwant:\n%s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectFileLimit(t *testing.T) {
+	rejects, err := newRejectWriter(filepath.Join(t.TempDir(), "rejects.csv"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejects.close()
+	columns := []string{"id", "name"}
+	opts := copyOptions{
+		source:        dbmigrate.SQLServer,
+		fullTableName: "dbo.Lookup",
+		targetSchema:  "dbo",
+		targetTable:   "lookup",
+		columns:       columns,
+		onReject: func(values []interface{}, err error) error {
+			return rejects.reject("dbo.Lookup", rowKey(columns, []string{"id"}, values), err)
+		},
+	}
+	target := newCopyTarget(2, 6)
+	if _, err := copyTableData(context.Background(), openFakeDB(t, sourceRows(8)), openFakeDB(t, target.fakeDB), opts); err == nil {
+		t.Fatal("copyTableData() with more rejected rows than the limit succeeded")
+	}
+	if len(target.committed) != 0 {
+		t.Errorf("committed rows %v, want none", target.ids())
+	}
+}
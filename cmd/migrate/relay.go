@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// runExtract implements the extract subcommand, which serves the source
+// database to a data migration run in another network (-source-relay), or with
+// -spool writes the source tables to a spool directory for the load subcommand.
+// The source is opened read-only, as it is exposed on the network.
+func runExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	spoolFlag := fs.String("spool", "", "Write the selected tables to this spool directory instead of serving relay connections")
+	schemasFlag := fs.String("schemas", "dbo", "With -spool, comma-separated list of schemas to extract")
+	tablesFlag := fs.String("tables", "", "With -spool, comma-separated list of tables to extract (default: all tables of -schemas)")
+	chunkRowsFlag := fs.Int("chunk-rows", 100000, "With -spool, number of rows per chunk file")
+	listenFlag := fs.String("listen", ":7443", "Address to accept relay connections on")
+	sourceDsnFlag := fs.String("source-dsn", "", "SQL Server connection string (default: SOURCE_DB_DSN environment variable)")
+	endpointProfileFlag := fs.String("endpoint-profile", dbmigrate.EndpointAuto, "Connection parameter preset for the SQL Server host: auto (detect from the host name), none, aws-rds, azure-sql or gcp-cloudsql")
+	certFlag := fs.String("relay-cert", "", "TLS certificate file (PEM) presented to relay clients")
+	keyFlag := fs.String("relay-key", "", "TLS private key file (PEM) of -relay-cert")
+	tokenFlag := fs.String("relay-token", "", "Secret relay clients must present (default: RELAY_TOKEN environment variable)")
+	maxConnectionsFlag := fs.Int("max-connections", 10, "Maximum number of source connections")
+	fs.Parse(args)
+
+	sourceDsn := *sourceDsnFlag
+	if sourceDsn == "" {
+		sourceDsn = os.Getenv("SOURCE_DB_DSN")
+	}
+	if sourceDsn == "" {
+		log.Fatal("No source database connection. Set SOURCE_DB_DSN environment variable or use -source-dsn flag.")
+	}
+	if *spoolFlag != "" && *chunkRowsFlag < 1 {
+		log.Fatalf("Invalid -chunk-rows value: %d", *chunkRowsFlag)
+	}
+	var token string
+	var cert tls.Certificate
+	if *spoolFlag == "" {
+		token = relayToken(*tokenFlag)
+		if *certFlag == "" || *keyFlag == "" {
+			log.Fatal("-relay-cert and -relay-key are required, relay connections are always encrypted")
+		}
+		var err error
+		cert, err = tls.LoadX509KeyPair(*certFlag, *keyFlag)
+		if err != nil {
+			log.Fatalf("Error loading relay certificate: %v", err)
+		}
+	}
+
+	sourceDsn, err := dbmigrate.PrepareSqlServerDsn(sourceDsn, *endpointProfileFlag)
+	if err != nil {
+		log.Fatalf("Error in source connection string: %v", err)
+	}
+	sourceDsn = dbmigrate.TagSqlServerDsn(sourceDsn, "dbmigrate-extract")
+	sourceDb, err := dbmigrate.OpenReadOnlySqlServer(sourceDsn)
+	if err != nil {
+		log.Fatalf("Error connecting to source database: %v", err)
+	}
+	defer sourceDb.Close()
+	sourceDb.SetMaxOpenConns(*maxConnectionsFlag)
+	if err := sourceDb.Ping(); err != nil {
+		log.Fatalf("Error connecting to source database: %v", err)
+	}
+	fmt.Println("✅ Connected to SQL Server source database (read-only)")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if *spoolFlag != "" {
+		tables, err := spoolTables(sourceDb, *schemasFlag, *tablesFlag)
+		if err != nil {
+			log.Fatalf("Error getting tables: %v", err)
+		}
+		if err := extractToSpool(ctx, sourceDb, *spoolFlag, tables, *chunkRowsFlag); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+	}
+
+	listener, err := tls.Listen("tcp", *listenFlag, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	if err != nil {
+		log.Fatalf("Error listening on %s: %v", *listenFlag, err)
+	}
+	fmt.Printf("Serving the source to relay clients on %s\n", listener.Addr())
+
+	if err := dbmigrate.ServeRelay(ctx, listener, sourceDb, token); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println("Relay stopped")
+}
+
+// relayToken returns the relay secret from the flag or the RELAY_TOKEN
+// environment variable, which is required
+func relayToken(value string) string {
+	if value == "" {
+		value = os.Getenv("RELAY_TOKEN")
+	}
+	if value == "" {
+		log.Fatal("No relay token. Set RELAY_TOKEN environment variable or use -relay-token flag.")
+	}
+	return value
+}
+
+// relayTLSConfig returns the TLS settings for connecting to the relay at addr,
+// trusting the certificates in caFile if set, or else the system's
+func relayTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading relay CA file: %v", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return config, nil
+}
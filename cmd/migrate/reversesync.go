@@ -0,0 +1,326 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// reverseWatermarkKeyPrefix prefixes the state store keys recording the
+// -updated-at-column value each table is synchronized back up to
+const reverseWatermarkKeyPrefix = "reverse-watermark:"
+
+// reverseUnsupportedTypes are the source types whose converted values cannot
+// be written back to SQL Server; such columns keep their source values
+var reverseUnsupportedTypes = map[string]bool{
+	"hierarchyid": true,
+	"geometry":    true,
+	"geography":   true,
+	"sql_variant": true,
+	"timestamp":   true,
+	"rowversion":  true,
+}
+
+// reverseNativeTypes are the source types whose target values the drivers
+// pass as they are; the values of other columns are read as text, which SQL
+// Server converts to the column type
+var reverseNativeTypes = map[string]bool{
+	"bigint": true, "int": true, "smallint": true, "tinyint": true, "bit": true,
+	"float": true, "real": true,
+	"binary": true, "varbinary": true, "image": true,
+	"date": true, "datetime": true, "datetime2": true, "smalldatetime": true, "datetimeoffset": true,
+}
+
+// runReverseSyncPhase copies the rows changed in the target since the last
+// reverse sync, by their -updated-at-column, back to the source, so the source
+// can take over again if the cutover is rolled back; with -sync-interval it
+// repeats until interrupted
+func (m *migrator) runReverseSyncPhase() error {
+	return m.repeatSync(m.reverseSyncChanges)
+}
+
+// reverseSyncChanges synchronizes every table back once
+func (m *migrator) reverseSyncChanges() error {
+	startTime := time.Now()
+	var total int
+	for _, table := range m.tables {
+		parts := strings.SplitN(table, ".", 2)
+		targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+		target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+
+		high, err := m.reverseWatermark(target)
+		if err != nil {
+			return err
+		}
+		if high == nil {
+			fmt.Printf("No rows in %s\n", table)
+			continue
+		}
+		last, ok := m.reverseSyncedUpTo(table)
+		if !ok {
+			fmt.Printf("No reverse sync recorded for %s, copying all its rows back\n", table)
+		}
+
+		upserted, err := m.reverseSyncTable(table, target, last)
+		if err != nil {
+			if !m.continueOnError {
+				return fmt.Errorf("error synchronizing table %s back: %v", table, err)
+			}
+			m.metrics.recordError()
+			fmt.Printf("❌ Error synchronizing table %s back, continuing with the next table: %v\n", table, err)
+			continue
+		}
+		m.recordCheckpoint(dbmigrate.Checkpoint{Table: reverseWatermarkKeyPrefix + table, Completed: true, Value: high.Format(time.RFC3339Nano)})
+		total += upserted
+		fmt.Printf("✅ Synchronized %s back: %d rows upserted (changed since %s)\n", table, upserted, formatReverseWatermark(last))
+	}
+	fmt.Printf("✅ Reverse sync completed in %s: %d rows upserted\n", time.Since(startTime).Round(time.Millisecond), total)
+	return nil
+}
+
+// reverseWatermark returns the value to record once a target table is
+// synchronized back: its highest -updated-at-column value, or the start of the
+// oldest open transaction if earlier, as the trigger sets the column to the
+// start time of the transaction and rows of open transactions are committed
+// later. It returns nil for an empty table.
+func (m *migrator) reverseWatermark(target string) (*time.Time, error) {
+	var high, oldest sql.NullTime
+	err := m.targetDb.QueryRowContext(m.ctx, fmt.Sprintf(`
+		SELECT (SELECT MAX(%s) FROM %s),
+			(SELECT MIN(xact_start) FROM pg_stat_activity WHERE datname = current_database() AND pid <> pg_backend_pid())`,
+		dbmigrate.QuoteIdent(m.updatedAtColumn, m.preserveCase), target)).Scan(&high, &oldest)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the highest %s of %s: %v", m.updatedAtColumn, target, err)
+	}
+	if !high.Valid {
+		return nil, nil
+	}
+	if oldest.Valid && oldest.Time.Before(high.Time) {
+		return &oldest.Time, nil
+	}
+	return &high.Time, nil
+}
+
+// reverseSyncedUpTo returns the -updated-at-column value a table is
+// synchronized back up to, or false if it was not synchronized back yet
+func (m *migrator) reverseSyncedUpTo(table string) (*time.Time, bool) {
+	cp, ok := m.checkpoints[reverseWatermarkKeyPrefix+table]
+	if !ok || !cp.Completed {
+		return nil, false
+	}
+	last, err := time.Parse(time.RFC3339Nano, cp.Value)
+	if err != nil {
+		log.Printf("Warning: Invalid reverse sync watermark recorded for %s: %q", table, cp.Value)
+		return nil, false
+	}
+	return &last, true
+}
+
+// formatReverseWatermark formats a recorded watermark for the messages
+func formatReverseWatermark(last *time.Time) string {
+	if last == nil {
+		return "the beginning"
+	}
+	return last.Format(time.RFC3339)
+}
+
+// reverseSyncTable upserts the rows of a target table changed since last (all
+// rows if nil) into the source table with MERGE, by the primary key of the
+// source table, in transactions of batchSize rows. Rows deleted in the target
+// are not deleted in the source.
+func (m *migrator) reverseSyncTable(table, target string, last *time.Time) (int, error) {
+	parts := strings.SplitN(table, ".", 2)
+	source := fmt.Sprintf("[%s].[%s]", parts[0], parts[1])
+	pkColumns, err := m.source.PrimaryKey(m.sourceDb, table)
+	if err != nil {
+		return 0, err
+	}
+	if len(pkColumns) == 0 {
+		return 0, fmt.Errorf("table %s has no primary key to match the rows by", table)
+	}
+
+	// Computed columns are filled in by SQL Server
+	columns, err := m.sourceColumns(table)
+	if err != nil {
+		return 0, err
+	}
+	computed, err := dbmigrate.ComputedColumns(m.sourceDb, table)
+	if err != nil {
+		return 0, err
+	}
+	if m.columnSets == dbmigrate.ColumnSetsKeep {
+		// The sparse columns are written individually
+		if columns, err = m.withoutColumnSets(table, columns); err != nil {
+			return 0, err
+		}
+	}
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return 0, err
+	}
+	types := make(map[string]string, len(columnTypes))
+	for _, column := range columnTypes {
+		types[column[0]] = strings.ToLower(column[1])
+	}
+	var unsupported []string
+	columns = slices.DeleteFunc(columns, func(column string) bool {
+		if reverseUnsupportedTypes[types[column]] {
+			unsupported = append(unsupported, column)
+			return true
+		}
+		return slices.Contains(computed, column)
+	})
+	if len(unsupported) > 0 {
+		log.Printf("Warning: Columns %s of %s cannot be written back and keep their source values", strings.Join(unsupported, ", "), table)
+	}
+	for _, key := range pkColumns {
+		if !slices.Contains(columns, key) {
+			return 0, fmt.Errorf("primary key column %s of %s cannot be written back", key, table)
+		}
+	}
+
+	// Naive datetimes stored as TIMESTAMPTZ are converted back to their zone
+	targets := make(map[string]string)
+	for _, column := range columnTypes {
+		if target, ok := m.typeMapper.Lookup(table, column[0], column[1]); ok {
+			targets[column[0]] = target
+		}
+	}
+	zones, err := m.datetimeZones(table, columnTypes, targets)
+	if err != nil {
+		return 0, err
+	}
+
+	var identity bool
+	if err := m.sourceDb.QueryRowContext(m.ctx, "SELECT OBJECTPROPERTY(OBJECT_ID(@p1), 'TableHasIdentity')", source).Scan(&identity); err != nil {
+		return 0, fmt.Errorf("error checking the identity column of %s: %v", table, err)
+	}
+
+	// Values the drivers do not pass as they are (numeric, uuid, text, ...)
+	// are read as text
+	selectList := make([]string, len(columns))
+	valueList := make([]string, len(columns))
+	insertList := make([]string, len(columns))
+	var updates []string
+	for i, column := range columns {
+		selectList[i] = dbmigrate.QuoteIdent(column, m.preserveCase)
+		if !reverseNativeTypes[types[column]] {
+			selectList[i] += "::text"
+		}
+		valueList[i] = fmt.Sprintf("@p%d AS [%s]", i+1, column)
+		insertList[i] = fmt.Sprintf("s.[%s]", column)
+		if !slices.Contains(pkColumns, column) {
+			updates = append(updates, fmt.Sprintf("t.[%s] = s.[%s]", column, column))
+		}
+	}
+	conditions := make([]string, len(pkColumns))
+	for i, key := range pkColumns {
+		conditions[i] = fmt.Sprintf("t.[%s] = s.[%s]", key, key)
+	}
+	merge := fmt.Sprintf("MERGE %s WITH (HOLDLOCK) AS t USING (SELECT %s) AS s ON %s", source, strings.Join(valueList, ", "), strings.Join(conditions, " AND "))
+	if len(updates) > 0 {
+		merge += " WHEN MATCHED THEN UPDATE SET " + strings.Join(updates, ", ")
+	}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = "[" + column + "]"
+	}
+	merge += fmt.Sprintf(" WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);", strings.Join(quoted, ", "), strings.Join(insertList, ", "))
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectList, ", "), target)
+	var args []interface{}
+	if last != nil {
+		query += fmt.Sprintf(" WHERE %s >= $1", dbmigrate.QuoteIdent(m.updatedAtColumn, m.preserveCase))
+		args = append(args, *last)
+	}
+	rows, err := m.targetDb.QueryContext(m.ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error querying changed rows of %s: %v", target, err)
+	}
+	defer rows.Close()
+
+	var tx *sql.Tx
+	begin := func() error {
+		if tx, err = m.sourceDb.BeginTx(m.ctx, nil); err != nil {
+			return fmt.Errorf("error starting transaction: %v", err)
+		}
+		if identity {
+			if _, err := tx.ExecContext(m.ctx, fmt.Sprintf("SET IDENTITY_INSERT %s ON", source)); err != nil {
+				return fmt.Errorf("error enabling identity inserts: %v", err)
+			}
+		}
+		return nil
+	}
+	commit := func() error {
+		if identity {
+			if _, err := tx.ExecContext(m.ctx, fmt.Sprintf("SET IDENTITY_INSERT %s OFF", source)); err != nil {
+				return fmt.Errorf("error disabling identity inserts: %v", err)
+			}
+		}
+		err := tx.Commit()
+		tx = nil
+		if err != nil {
+			return fmt.Errorf("error committing transaction: %v", err)
+		}
+		return nil
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	if err := begin(); err != nil {
+		return 0, err
+	}
+
+	upserted, batchCount := 0, 0
+	for rows.Next() {
+		if m.ctx.Err() != nil {
+			return 0, m.ctx.Err()
+		}
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return 0, fmt.Errorf("error scanning row: %v", err)
+		}
+		for i, column := range columns {
+			if t, ok := values[i].(time.Time); ok && dbmigrate.IsNaiveDatetime(types[column]) {
+				zone := zones[column]
+				if zone == nil {
+					zone = time.UTC
+				}
+				values[i] = t.In(zone)
+			}
+		}
+		if _, err := tx.ExecContext(m.ctx, merge, values...); err != nil {
+			return 0, fmt.Errorf("error upserting row %s: %v", formatSampleRow(columns, values), err)
+		}
+		upserted++
+
+		batchCount++
+		if batchCount >= m.batchSize {
+			if err := commit(); err != nil {
+				return 0, err
+			}
+			if err := begin(); err != nil {
+				return 0, err
+			}
+			batchCount = 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error reading changed rows of %s: %v", target, err)
+	}
+	if err := commit(); err != nil {
+		return 0, err
+	}
+	return upserted, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Handling of text values PostgreSQL cannot store, for -invalid-text
+const (
+	invalidTextFail    = "fail"
+	invalidTextStrip   = "strip"
+	invalidTextReplace = "replace"
+)
+
+// parseInvalidText validates an -invalid-text value
+func parseInvalidText(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case invalidTextFail, invalidTextStrip, invalidTextReplace:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid value: %s (expected %s, %s or %s)", value, invalidTextFail, invalidTextStrip, invalidTextReplace)
+}
+
+// sanitizeTransform returns a row transform handling text values with NUL bytes
+// or invalid UTF-8 according to mode, counting the sanitized values by column in
+// counts. With invalidTextFail, the first such value is returned as an error.
+func sanitizeTransform(mode string, columns []string, counts map[string]int64) func(values []interface{}) error {
+	return func(values []interface{}) error {
+		for i, value := range values {
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			hasNul, valid := strings.IndexByte(s, 0) >= 0, utf8.ValidString(s)
+			if !hasNul && valid {
+				continue
+			}
+			switch mode {
+			case invalidTextStrip:
+				values[i] = strings.ReplaceAll(strings.ToValidUTF8(s, ""), "\x00", "")
+			case invalidTextReplace:
+				values[i] = strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "")
+			default:
+				if hasNul {
+					return fmt.Errorf("column %s contains a NUL byte, which PostgreSQL cannot store (see -invalid-text)", columns[i])
+				}
+				return fmt.Errorf("column %s contains invalid UTF-8 (see -invalid-text)", columns[i])
+			}
+			counts[columns[i]]++
+		}
+		return nil
+	}
+}
+
+// chainTransforms returns a row transform applying each non-nil transform in
+// order, or nil if there are none
+func chainTransforms(transforms ...func(values []interface{}) error) func(values []interface{}) error {
+	var chain []func(values []interface{}) error
+	for _, transform := range transforms {
+		if transform != nil {
+			chain = append(chain, transform)
+		}
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return func(values []interface{}) error {
+		for _, transform := range chain {
+			if err := transform(values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// sliceCheckpointPrefix starts the checkpoint value of a table copied in time
+// slices: "slice=<start>;<key>", with the start of the slice being copied and
+// the last committed key within it (empty at the start of the slice)
+const sliceCheckpointPrefix = "slice="
+
+// nullSlice is the start of the last slice, holding the rows without a date
+const nullSlice = "null"
+
+// sliceLiteral formats slice bounds as SQL Server literals, which are read the
+// same way for every date/time type and DATEFORMAT setting
+const sliceLiteral = "2006-01-02T15:04:05"
+
+// sliceColumnTypes are the source types a table can be sliced by
+var sliceColumnTypes = map[string]bool{"date": true, "datetime": true, "datetime2": true, "smalldatetime": true, "datetimeoffset": true}
+
+// timeSlice is a date range of a table copied in time slices
+type timeSlice struct {
+	start string // RFC 3339 start of the range (UTC), or nullSlice
+	label string // start as shown to the user, e.g. 2021-03
+	where string // source condition selecting the rows of the slice
+}
+
+// planSlices returns the slices a table with a slice_column is copied in: the
+// date ranges of the column from its minimum to its maximum, oldest first,
+// followed by the rows where the column is NULL. Returns nil if the table is
+// not sliced or cannot be, since slices are read in primary key order, or if
+// most rows are NULL (see sliceNullWarning); such tables are copied in
+// primary key order instead.
+// Hypertables are sliced by week of their time column by default, so their
+// rows are inserted roughly in time order, chunk after chunk.
+func (m *migrator) planSlices(table string, keyset *keysetScan) ([]timeSlice, error) {
+	settings := m.config.TableSettings(table)
+	hypertable := false
+	if settings.SliceColumn == "" && settings.HypertableColumn != "" {
+		settings.SliceColumn, hypertable = settings.HypertableColumn, true
+		if settings.SliceInterval == "" {
+			settings.SliceInterval = dbmigrate.SliceWeek
+		}
+	}
+	if settings.SliceColumn == "" {
+		return nil, nil
+	}
+	if keyset == nil {
+		log.Printf("Warning: Table %s has no primary key, migrating it in one piece instead of slices of %s", table, settings.SliceColumn)
+		return nil, nil
+	}
+	if m.source != dbmigrate.SQLServer {
+		log.Printf("Warning: Slices are not supported for %s sources, migrating %s in one piece", m.source.Name(), table)
+		return nil, nil
+	}
+	interval := settings.SliceInterval
+	if interval == "" {
+		interval = dbmigrate.SliceMonth
+	}
+
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	column := ""
+	for _, c := range columnTypes {
+		if strings.EqualFold(c[0], settings.SliceColumn) {
+			column = c[0]
+			if !sliceColumnTypes[strings.ToLower(c[1])] {
+				if hypertable {
+					fmt.Printf("Time column %s of hypertable %s is %s, migrating it in primary key order\n", column, table, c[1])
+					return nil, nil
+				}
+				return nil, fmt.Errorf("slice_column %s of %s is %s, expected a date or time column", column, table, c[1])
+			}
+		}
+	}
+	if column == "" {
+		return nil, fmt.Errorf("slice_column %s not found in %s", settings.SliceColumn, table)
+	}
+
+	parts := strings.SplitN(table, ".", 2)
+	var minValue, maxValue sql.NullTime
+	var rows, values int64
+	query := fmt.Sprintf("SELECT MIN([%[1]s]), MAX([%[1]s]), COUNT_BIG(*), COUNT_BIG([%[1]s]) FROM [%[2]s].[%[3]s]", column, parts[0], parts[1])
+	if err := m.sourceDb.QueryRowContext(m.ctx, query).Scan(&minValue, &maxValue, &rows, &values); err != nil {
+		return nil, fmt.Errorf("error getting the range of %s in %s: %v", column, table, err)
+	}
+	if warning := sliceNullWarning(column, rows, rows-values); warning != "" {
+		log.Printf("Warning: %s: %s, migrating it in primary key order instead of slices", table, warning)
+		return nil, nil
+	}
+
+	var slices []timeSlice
+	if minValue.Valid {
+		last := maxValue.Time.UTC()
+		for start := sliceStart(minValue.Time.UTC(), interval); !start.After(last); {
+			end := nextSliceStart(start, interval)
+			slices = append(slices, timeSlice{
+				start: start.Format(time.RFC3339),
+				label: sliceLabel(start, interval),
+				where: fmt.Sprintf("[%s] >= '%s' AND [%s] < '%s'", column, start.Format(sliceLiteral), column, end.Format(sliceLiteral)),
+			})
+			start = end
+		}
+	}
+	slices = append(slices, timeSlice{start: nullSlice, label: "without " + column, where: fmt.Sprintf("[%s] IS NULL", column)})
+	fmt.Printf("Copying %s in %d slices by %s of %s\n", table, len(slices), interval, column)
+	return slices, nil
+}
+
+// sliceStart returns the start of the slice t falls in
+func sliceStart(t time.Time, interval string) time.Time {
+	switch interval {
+	case dbmigrate.SliceDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case dbmigrate.SliceWeek:
+		// Weeks start on Monday
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case dbmigrate.SliceYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// nextSliceStart returns the start of the slice after the one starting at start
+func nextSliceStart(start time.Time, interval string) time.Time {
+	switch interval {
+	case dbmigrate.SliceDay:
+		return start.AddDate(0, 0, 1)
+	case dbmigrate.SliceWeek:
+		return start.AddDate(0, 0, 7)
+	case dbmigrate.SliceYear:
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// sliceLabel formats the start of a slice at the precision of the interval
+func sliceLabel(start time.Time, interval string) string {
+	switch interval {
+	case dbmigrate.SliceMonth:
+		return start.Format("2006-01")
+	case dbmigrate.SliceYear:
+		return start.Format("2006")
+	}
+	return start.Format("2006-01-02")
+}
+
+// sliceCheckpointValue returns the checkpoint value of a sliced table
+func sliceCheckpointValue(start, key string) string {
+	return sliceCheckpointPrefix + start + ";" + key
+}
+
+// resumeSlices returns the slices left to copy of a partially migrated sliced
+// table and the key to resume the first of them after (nil to start at its
+// beginning). ok is false if the table has to be restarted: resuming needs a
+// slice checkpoint of the same plan and a primary key in the target table.
+func (m *migrator) resumeSlices(checkpoint dbmigrate.Checkpoint, slices []timeSlice, keyset *keysetScan, targetSchema, targetTable string) (remaining []timeSlice, after []interface{}, ok bool, err error) {
+	if !strings.HasPrefix(checkpoint.Value, sliceCheckpointPrefix) {
+		return nil, nil, false, nil
+	}
+	start, key, _ := strings.Cut(strings.TrimPrefix(checkpoint.Value, sliceCheckpointPrefix), ";")
+	hasKey, err := targetHasPrimaryKey(m.targetDb, dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase))
+	if err != nil || !hasKey {
+		return nil, nil, false, err
+	}
+	for i, slice := range slices {
+		if slice.start != start {
+			continue
+		}
+		if key != "" {
+			after, err = m.resumeKey(dbmigrate.Checkpoint{Table: checkpoint.Table, Value: key}, keyset, targetSchema, targetTable)
+			if err != nil {
+				return nil, nil, false, err
+			}
+		}
+		return slices[i:], after, true, nil
+	}
+	log.Printf("Warning: The checkpointed slice %s of %s is not in the current plan (was slice_interval changed?)", start, checkpoint.Table)
+	return nil, nil, false, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+func TestPlanSlicesBoundaries(t *testing.T) {
+	date := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	const nullWhere = "[CreatedAt] IS NULL"
+	tests := []struct {
+		name     string
+		interval string
+		minMax   []driver.Value // MIN, MAX, COUNT_BIG(*), COUNT_BIG(column)
+		want     []string       // conditions of the slices, nil if not sliced
+	}{
+		{"empty table", "", []driver.Value{nil, nil, int64(0), int64(0)}, []string{nullWhere}},
+		{"only NULL dates", "", []driver.Value{nil, nil, int64(3), int64(0)}, nil},
+		{"single value", "", []driver.Value{date(2021, 3, 15, 10), date(2021, 3, 15, 10), int64(40), int64(40)}, []string{
+			"[CreatedAt] >= '2021-03-01T00:00:00' AND [CreatedAt] < '2021-04-01T00:00:00'",
+			nullWhere,
+		}},
+		{"maximum at the start of a slice", "", []driver.Value{date(2021, 3, 15, 10), date(2021, 5, 1, 0), int64(40), int64(38)}, []string{
+			"[CreatedAt] >= '2021-03-01T00:00:00' AND [CreatedAt] < '2021-04-01T00:00:00'",
+			"[CreatedAt] >= '2021-04-01T00:00:00' AND [CreatedAt] < '2021-05-01T00:00:00'",
+			"[CreatedAt] >= '2021-05-01T00:00:00' AND [CreatedAt] < '2021-06-01T00:00:00'",
+			nullWhere,
+		}},
+		{"weeks across the turn of the year", dbmigrate.SliceWeek, []driver.Value{date(2020, 12, 27, 23), date(2021, 1, 4, 0), int64(40), int64(40)}, []string{
+			"[CreatedAt] >= '2020-12-21T00:00:00' AND [CreatedAt] < '2020-12-28T00:00:00'",
+			"[CreatedAt] >= '2020-12-28T00:00:00' AND [CreatedAt] < '2021-01-04T00:00:00'",
+			"[CreatedAt] >= '2021-01-04T00:00:00' AND [CreatedAt] < '2021-01-11T00:00:00'",
+			nullWhere,
+		}},
+		{"offsets are sliced in UTC", dbmigrate.SliceDay, []driver.Value{
+			time.Date(2021, 4, 1, 1, 0, 0, 0, time.FixedZone("", 2*3600)),
+			time.Date(2021, 4, 1, 1, 0, 0, 0, time.FixedZone("", 2*3600)),
+			int64(1), int64(1)}, []string{
+			"[CreatedAt] >= '2021-03-31T00:00:00' AND [CreatedAt] < '2021-04-01T00:00:00'",
+			nullWhere,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &migrator{
+				ctx:         context.Background(),
+				source:      dbmigrate.SQLServer,
+				sourceDb:    openFakeDB(t, sqlServerStats(tt.minMax)),
+				config:      &dbmigrate.Config{Tables: map[string]dbmigrate.TableConfig{"audit.Events": {SliceColumn: "createdat", SliceInterval: tt.interval}}},
+				columnTypes: map[string][][2]string{"audit.Events": {{"id", "bigint"}, {"CreatedAt", "datetime2"}}},
+			}
+			slices, err := m.planSlices("audit.Events", &keysetScan{})
+			if err != nil {
+				t.Fatalf("planSlices() failed: %v", err)
+			}
+			var got []string
+			for _, slice := range slices {
+				got = append(got, slice.where)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planSlices() slices:\n%q\nwant:\n%q", got, tt.want)
+			}
+			if len(slices) > 0 && slices[len(slices)-1].start != nullSlice {
+				t.Errorf("last slice starts at %s, want %s", slices[len(slices)-1].start, nullSlice)
+			}
+		})
+	}
+}
+
+func TestPlanSlicesNotSliced(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns [][2]string
+		keyset  *keysetScan
+		wantErr bool
+	}{
+		{"no primary key", [][2]string{{"CreatedAt", "datetime2"}}, nil, false},
+		{"not a date", [][2]string{{"CreatedAt", "varchar"}}, &keysetScan{}, true},
+		{"missing column", [][2]string{{"UpdatedAt", "datetime2"}}, &keysetScan{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := sqlServerStats(nil)
+			m := &migrator{
+				ctx:         context.Background(),
+				source:      dbmigrate.SQLServer,
+				sourceDb:    openFakeDB(t, source),
+				config:      &dbmigrate.Config{Tables: map[string]dbmigrate.TableConfig{"audit.Events": {SliceColumn: "CreatedAt"}}},
+				columnTypes: map[string][][2]string{"audit.Events": tt.columns},
+			}
+			slices, err := m.planSlices("audit.Events", tt.keyset)
+			if slices != nil || (err != nil) != tt.wantErr {
+				t.Errorf("planSlices() = %d slices, %v, want none and error %v", len(slices), err, tt.wantErr)
+			}
+			if executed := source.executed(); len(executed) != 0 {
+				t.Errorf("queried the source: %q", executed)
+			}
+		})
+	}
+}
+
+func TestSliceStart(t *testing.T) {
+	tests := []struct {
+		interval  string
+		t         string
+		wantStart string
+		wantNext  string
+		wantLabel string
+	}{
+		{dbmigrate.SliceDay, "2021-03-15T23:59:59Z", "2021-03-15", "2021-03-16", "2021-03-15"},
+		{dbmigrate.SliceDay, "2021-02-28T00:00:00Z", "2021-02-28", "2021-03-01", "2021-02-28"},
+		{dbmigrate.SliceWeek, "2021-03-15T12:00:00Z", "2021-03-15", "2021-03-22", "2021-03-15"}, // Monday
+		{dbmigrate.SliceWeek, "2021-03-21T23:59:59Z", "2021-03-15", "2021-03-22", "2021-03-15"}, // Sunday
+		{dbmigrate.SliceWeek, "2021-01-01T00:00:00Z", "2020-12-28", "2021-01-04", "2020-12-28"},
+		{dbmigrate.SliceMonth, "2021-01-31T12:00:00Z", "2021-01-01", "2021-02-01", "2021-01"},
+		{dbmigrate.SliceMonth, "2021-12-31T23:59:59Z", "2021-12-01", "2022-01-01", "2021-12"},
+		{"", "2020-02-29T00:00:00Z", "2020-02-01", "2020-03-01", "2020-02"},
+		{dbmigrate.SliceYear, "2020-02-29T00:00:00Z", "2020-01-01", "2021-01-01", "2020"},
+	}
+	for _, tt := range tests {
+		value, err := time.Parse(time.RFC3339, tt.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := sliceStart(value, tt.interval)
+		next := nextSliceStart(start, tt.interval)
+		if start.Format("2006-01-02") != tt.wantStart || next.Format("2006-01-02") != tt.wantNext {
+			t.Errorf("%s slice of %s: %s to %s, want %s to %s", tt.interval, tt.t, start.Format("2006-01-02"), next.Format("2006-01-02"), tt.wantStart, tt.wantNext)
+		}
+		if tt.interval != "" {
+			if label := sliceLabel(start, tt.interval); label != tt.wantLabel {
+				t.Errorf("sliceLabel(%s, %s) = %s, want %s", start, tt.interval, label, tt.wantLabel)
+			}
+		}
+	}
+}
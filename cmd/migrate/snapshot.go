@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// sourceSnapshotCreate is the -source-snapshot value that creates a database
+// snapshot for the run
+const sourceSnapshotCreate = "create"
+
+// sourceSnapshot is a database snapshot of the source database the tables are
+// read from, so that all of them reflect the same point in time even when
+// copying them takes hours
+type sourceSnapshot struct {
+	name    string
+	created bool // created by this run, and dropped at its end
+}
+
+// createSourceSnapshot creates a database snapshot of the source database,
+// with a sparse file next to each of its data files
+func createSourceSnapshot(sourceDb *sql.DB, dbName string) (*sourceSnapshot, error) {
+	rows, err := sourceDb.Query("SELECT name, physical_name FROM sys.database_files WHERE type = 0")
+	if err != nil {
+		return nil, fmt.Errorf("error listing the data files of the source database: %v", err)
+	}
+	defer rows.Close()
+	suffix := time.Now().Format("20060102150405")
+	var files []string
+	for rows.Next() {
+		var name, path string
+		if err := rows.Scan(&name, &path); err != nil {
+			return nil, fmt.Errorf("error scanning data file: %v", err)
+		}
+		path = strings.TrimSuffix(path, filepath.Ext(path)) + "_dbmigrate_" + suffix + ".ss"
+		files = append(files, fmt.Sprintf("(NAME = [%s], FILENAME = N'%s')", name, strings.ReplaceAll(path, "'", "''")))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing the data files of the source database: %v", err)
+	}
+
+	snapshot := &sourceSnapshot{name: dbName + "_dbmigrate_" + suffix, created: true}
+	statement := fmt.Sprintf("CREATE DATABASE [%s] ON %s AS SNAPSHOT OF [%s]", snapshot.name, strings.Join(files, ", "), dbName)
+	if _, err := sourceDb.Exec(statement); err != nil {
+		return nil, fmt.Errorf("error creating a database snapshot of %s (database snapshots are not available on Azure SQL Database and Amazon RDS): %v", dbName, err)
+	}
+	fmt.Printf("✅ Created database snapshot %s of %s\n", snapshot.name, dbName)
+	return snapshot, nil
+}
+
+// checkSourceSnapshot checks that an existing database snapshot is a snapshot
+// of the source database
+func checkSourceSnapshot(sourceDb *sql.DB, name string) (*sourceSnapshot, error) {
+	var isSnapshot bool
+	var created time.Time
+	err := sourceDb.QueryRow(`
+		SELECT CASE WHEN source_database_id = DB_ID() THEN 1 ELSE 0 END, create_date
+		FROM sys.databases WHERE name = @p1`, name).Scan(&isSnapshot, &created)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("database snapshot %s does not exist", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking database snapshot %s: %v", name, err)
+	}
+	if !isSnapshot {
+		return nil, fmt.Errorf("%s is not a database snapshot of the source database", name)
+	}
+	fmt.Printf("Reading from database snapshot %s, created %s\n", name, created.Format(time.DateTime))
+	return &sourceSnapshot{name: name}, nil
+}
+
+// open connects to the snapshot through the connection string of the source
+// database
+func (s *sourceSnapshot) open(sourceDsn string, readOnly bool) (*sql.DB, error) {
+	dsn, err := dbmigrate.SqlServerDsnWithDatabase(sourceDsn, s.name)
+	if err != nil {
+		return nil, err
+	}
+	var db *sql.DB
+	if readOnly {
+		db, err = dbmigrate.OpenReadOnlySqlServer(dsn)
+	} else {
+		db, err = sql.Open("sqlserver", dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// drop removes a snapshot created by the run; existing snapshots are kept
+func (s *sourceSnapshot) drop(sourceDb *sql.DB) {
+	if !s.created {
+		return
+	}
+	if _, err := sourceDb.Exec(fmt.Sprintf("DROP DATABASE [%s]", s.name)); err != nil {
+		log.Printf("Warning: Could not drop database snapshot %s, drop it with DROP DATABASE [%s]: %v", s.name, s.name, err)
+		return
+	}
+	s.created = false
+	fmt.Printf("Dropped database snapshot %s\n", s.name)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// sourceColumns returns the columns of a source table that are migrated: all
+// columns except dropped or generated computed columns, skipped column sets and
+// excluded rowversion columns
+func (m *migrator) sourceColumns(table string) ([]string, error) {
+	if m.source != dbmigrate.SQLServer {
+		// Dropped generated columns have no target type
+		sourceColumns, err := m.source.Columns(m.sourceDb, table, m.schemaOptions())
+		if err != nil {
+			return nil, fmt.Errorf("error getting columns for table %s: %v", table, err)
+		}
+		var columns []string
+		for _, column := range sourceColumns {
+			if column.TargetType != "" {
+				columns = append(columns, column.Name)
+			}
+		}
+		return columns, nil
+	}
+	columns, err := getTableColumns(m.sourceDb, table)
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns for table %s: %v", table, err)
+	}
+	if m.computedColumns != dbmigrate.ComputedMaterialize {
+		if columns, err = m.withoutComputedColumns(table, columns); err != nil {
+			return nil, err
+		}
+	}
+	if m.columnSets != dbmigrate.ColumnSetsKeep {
+		if columns, err = m.withoutColumnSets(table, columns); err != nil {
+			return nil, err
+		}
+	}
+	if m.rowversion == dbmigrate.RowversionBytea {
+		return columns, nil
+	}
+
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool)
+	for _, column := range columnTypes {
+		if dbmigrate.IsRowversion(column[1]) {
+			skip[column[0]] = true
+		}
+	}
+	if len(skip) == 0 {
+		return columns, nil
+	}
+	var kept, skipped []string
+	for _, column := range columns {
+		if skip[column] {
+			skipped = append(skipped, column)
+		} else {
+			kept = append(kept, column)
+		}
+	}
+	fmt.Printf("Skipping rowversion columns: %s\n", strings.Join(skipped, ", "))
+	return kept, nil
+}
+
+// withoutColumnSets removes the column sets of a source table from columns; their
+// sparse columns are copied individually
+func (m *migrator) withoutColumnSets(table string, columns []string) ([]string, error) {
+	columnSets, err := dbmigrate.ColumnSets(m.sourceDb, table)
+	if err != nil || len(columnSets) == 0 {
+		return columns, err
+	}
+	skip := make(map[string]bool, len(columnSets))
+	for _, column := range columnSets {
+		skip[column] = true
+	}
+	var kept []string
+	for _, column := range columns {
+		if !skip[column] {
+			kept = append(kept, column)
+		}
+	}
+	fmt.Printf("Skipping column sets: %s\n", strings.Join(columnSets, ", "))
+	return kept, nil
+}
+
+// sqlVariantExpr reads a sql_variant column as text: binary values in hex (0x...),
+// dates and times in ISO 8601, float and real values with 16 significant digits
+// and other values in their default string form
+const sqlVariantExpr = `CASE
+	WHEN SQL_VARIANT_PROPERTY([%[1]s], 'BaseType') IN ('binary', 'varbinary') THEN CONVERT(NVARCHAR(MAX), CONVERT(VARBINARY(8000), [%[1]s]), 1)
+	WHEN SQL_VARIANT_PROPERTY([%[1]s], 'BaseType') IN ('date', 'time', 'datetime', 'datetime2', 'smalldatetime', 'datetimeoffset') THEN CONVERT(NVARCHAR(MAX), [%[1]s], 126)
+	WHEN SQL_VARIANT_PROPERTY([%[1]s], 'BaseType') IN ('float', 'real') THEN CONVERT(NVARCHAR(MAX), [%[1]s], 2)
+	ELSE CONVERT(NVARCHAR(MAX), [%[1]s]) END`
+
+// sourceExprs returns the SELECT expressions of the source columns that are not
+// read as they are, keyed by column name: columns in a legacy code page (see
+// getTranscodeColumns) are read as NVARCHAR, hierarchyid columns as their path
+// string, sql_variant columns as text and geometry and geography columns as
+// extended WKT, which PostGIS accepts as input
+func (m *migrator) sourceExprs(table string, transcode map[string]int) (map[string]string, error) {
+	exprs := make(map[string]string)
+	if m.source != dbmigrate.SQLServer {
+		return exprs, nil
+	}
+	for column := range transcode {
+		exprs[column] = fmt.Sprintf("CAST([%s] AS NVARCHAR(MAX))", column)
+	}
+
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columnTypes {
+		switch strings.ToLower(column[1]) {
+		case "hierarchyid":
+			exprs[column[0]] = fmt.Sprintf("[%s].ToString()", column[0])
+		case "sql_variant":
+			log.Printf("Warning: Column %s.%s is sql_variant; its values are migrated as text without their base type", table, column[0])
+			exprs[column[0]] = fmt.Sprintf(sqlVariantExpr, column[0])
+		case "geometry", "geography":
+			exprs[column[0]] = dbmigrate.SpatialEWKTExpr(column[0])
+		}
+	}
+	return exprs, nil
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// spoolKeyPrefix prefixes the state store keys recording the chunks of each
+// spooled table loaded into the target, as "generation:chunks"
+const spoolKeyPrefix = "spool:"
+
+// extractToSpool writes the rows of the tables to chunk files of chunkRows rows
+// in a spool directory (see dbmigrate.SpoolManifest). Tables completed by a
+// previous extraction are skipped; incomplete ones are extracted again. Columns
+// are read as the data migration reads them by default: computed columns are
+// materialized, and column sets and rowversion columns are left out.
+func extractToSpool(ctx context.Context, sourceDb *sql.DB, dir string, tables []string, chunkRows int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating spool directory: %v", err)
+	}
+	manifest, err := dbmigrate.LoadSpoolManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest.Source == "" {
+		if err := sourceDb.QueryRowContext(ctx, "SELECT DB_NAME()").Scan(&manifest.Source); err != nil {
+			return fmt.Errorf("error reading the source database name: %v", err)
+		}
+	}
+	manifest.Complete = false
+
+	m := &migrator{
+		ctx:             ctx,
+		source:          dbmigrate.SQLServer,
+		sourceDb:        sourceDb,
+		computedColumns: dbmigrate.ComputedMaterialize,
+		columnSets:      dbmigrate.ColumnSetsSkip,
+		rowversion:      dbmigrate.RowversionExclude,
+	}
+	start := time.Now()
+	var totalRows int64
+	for _, name := range tables {
+		table := manifest.Table(name)
+		if table != nil && table.Complete {
+			fmt.Printf("Skipping table already extracted: %s\n", name)
+			continue
+		}
+		if table == nil {
+			table = &dbmigrate.SpoolTable{Table: name}
+			manifest.Tables = append(manifest.Tables, table)
+		}
+		table.Generation++
+		table.Chunks, table.Rows = nil, 0
+		fmt.Printf("Extracting table: %s\n", name)
+
+		columns, err := m.sourceColumns(name)
+		if err != nil {
+			return err
+		}
+		columnTypes, err := m.getColumnTypes(name)
+		if err != nil {
+			return err
+		}
+		table.Columns = nil
+		for _, column := range columns {
+			for _, columnType := range columnTypes {
+				if columnType[0] == column {
+					table.Columns = append(table.Columns, dbmigrate.SpoolColumn{Name: column, Type: columnType[1]})
+				}
+			}
+		}
+		transcode, err := getTranscodeColumns(sourceDb, name)
+		if err != nil {
+			return err
+		}
+		exprs, err := m.sourceExprs(name, transcode)
+		if err != nil {
+			return err
+		}
+		selectList := make([]string, len(columns))
+		for i, column := range columns {
+			selectList[i] = sourceColumnExpr(m.source, column, exprs)
+		}
+
+		// The manifest is saved after every chunk, so the load can follow
+		var chunk [][]interface{}
+		flush := func() error {
+			file := fmt.Sprintf("%s/g%d-%06d.chunk", name, table.Generation, len(table.Chunks)+1)
+			written, err := dbmigrate.WriteSpoolChunk(dir, file, chunk)
+			if err != nil {
+				return err
+			}
+			table.Chunks = append(table.Chunks, written)
+			table.Rows += written.Rows
+			chunk = nil
+			return dbmigrate.SaveSpoolManifest(dir, manifest)
+		}
+
+		parts := strings.SplitN(name, ".", 2)
+		rows, err := sourceDb.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM [%s].[%s]", strings.Join(selectList, ", "), parts[0], parts[1]))
+		if err != nil {
+			return fmt.Errorf("error querying source table %s: %v", name, err)
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			valuePtrs := make([]interface{}, len(columns))
+			for i := range values {
+				valuePtrs[i] = &values[i]
+			}
+			if err := rows.Scan(valuePtrs...); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning row: %v", err)
+			}
+			chunk = append(chunk, values)
+			if len(chunk) >= chunkRows {
+				if err := flush(); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("error reading source rows of %s: %v", name, err)
+		}
+		if len(chunk) > 0 || len(table.Chunks) == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		table.Complete = true
+		if err := dbmigrate.SaveSpoolManifest(dir, manifest); err != nil {
+			return err
+		}
+		totalRows += table.Rows
+		fmt.Printf("✅ Extracted %d rows from table %s in %d chunks\n", table.Rows, name, len(table.Chunks))
+	}
+
+	manifest.Complete = true
+	if err := dbmigrate.SaveSpoolManifest(dir, manifest); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Extracted %d rows from %d tables to %s in %s\n", totalRows, len(tables), dir, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// spoolTables returns the source tables of the schemas (comma-separated),
+// without system tables, or only those listed in tables if set
+func spoolTables(sourceDb *sql.DB, schemas, tables string) ([]string, error) {
+	var schemaList []string
+	for _, schema := range strings.Split(schemas, ",") {
+		if schema = strings.TrimSpace(schema); schema != "" {
+			schemaList = append(schemaList, schema)
+		}
+	}
+	all, err := dbmigrate.SQLServer.Tables(sourceDb, schemaList)
+	if err != nil {
+		return nil, err
+	}
+	var selected []string
+	for _, table := range all {
+		if !dbmigrate.IsSystemTable(strings.SplitN(table, ".", 2)[1]) {
+			selected = append(selected, table)
+		}
+	}
+	if tables != "" {
+		matched, unmatched, err := dbmigrate.ResolveTableNames(strings.Split(tables, ","), selected)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range unmatched {
+			log.Printf("Warning: -tables entry %s matched no table in schemas %s", name, schemas)
+		}
+		selected = matched
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no tables found")
+	}
+	return selected, nil
+}
+
+// runLoad implements the load subcommand, which copies the tables of a spool
+// written by extract -spool into the target database. The chunks loaded are
+// recorded in the state store, so an interrupted load continues with the next
+// chunk, and with -follow the load keeps up with a running extraction.
+func runLoad(args []string) {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	spoolFlag := fs.String("spool", "", "Spool directory written by extract -spool")
+	filesFlag := fs.String("files", "", "Load CSV or JSON Lines files instead of a spool: a directory of <schema>.<table>.csv or .jsonl files (optionally .gz), as written by -output csv or jsonl, or a single file")
+	formatFlag := fs.String("format", "", "With -files, the format of the files: csv or jsonl (default: from the file extension)")
+	tableFlag := fs.String("table", "", "With a single file for -files, the table to load it into as schema.table, mapped like a source table (default: from the file name)")
+	batchSizeFlag := fs.Int("batch-size", 10000, "With -files, number of rows loaded in each transaction")
+	csvDelimiterFlag := fs.String("csv-delimiter", ",", "With -files, the field delimiter of CSV files: a single character, or tab")
+	csvHeaderFlag := fs.Bool("csv-header", true, "With -files, CSV files start with a header row of column names; without it, the fields are all columns of the target table in order")
+	csvNullFlag := fs.String("csv-null", "", "With -files, the unquoted field value of NULL in CSV files")
+	targetDsnFlag := fs.String("target-dsn", "", "PostgreSQL connection string (default: TARGET_DB_DSN environment variable)")
+	stateFlag := fs.String("state", "target", "Checkpoint store recording the loaded chunks: file:<path> or target[:schema.table]")
+	followFlag := fs.Bool("follow", false, "Keep loading new chunks until the extraction is complete")
+	pollIntervalFlag := fs.Duration("poll-interval", 10*time.Second, "How often -follow checks the spool for new chunks")
+	truncateFlag := fs.Bool("truncate", false, "Truncate target tables before loading their first chunk")
+	truncateRestartIdentityFlag := fs.Bool("truncate-restart-identity", false, "Reset the sequences owned by the columns of target tables whenever they are truncated (TRUNCATE ... RESTART IDENTITY)")
+	configFlag := fs.String("config", "", "Path to a YAML configuration file")
+	schemaMapFlag := fs.String("schema-map", "", "Comma-separated list of source=target schema mappings (e.g., dbo=public)")
+	typeMapFlag := fs.String("type-map", "", "Comma-separated list of source=target type mappings overriding the built-in ones")
+	preserveCaseFlag := fs.Bool("preserve-case", false, "Preserve case sensitivity of identifiers using double quotes")
+	datetimeTypeFlag := fs.String("datetime-type", "timestamptz", "Target type of datetime and datetime2 columns: timestamptz or timestamp (without time zone)")
+	sourceTimezoneFlag := fs.String("source-timezone", "UTC", "Time zone of the source datetime and datetime2 values (with -datetime-type timestamptz)")
+	hierarchyidFlag := fs.String("hierarchyid", "text", "Target type of hierarchyid columns: text or ltree")
+	invalidTextFlag := fs.String("invalid-text", "fail", "How to handle text values with NUL bytes or invalid UTF-8: fail, strip or replace")
+	rejectFileFlag := fs.String("reject-file", "", "Write rows that fail to load to this file (.csv, or .jsonl for JSON Lines) and load the rest of the chunk (default: disabled)")
+	maxRejectsFlag := fs.Int("max-rejects", 0, "Fail the load once more than this many rows were rejected with -reject-file (0 = no limit)")
+	fs.Parse(args)
+
+	if (*spoolFlag == "") == (*filesFlag == "") {
+		log.Fatal("Either -spool or -files is required")
+	}
+	if *filesFlag != "" && *followFlag {
+		log.Fatal("-follow only applies to -spool")
+	}
+	if *formatFlag != "" && *formatFlag != fileFormatCSV && *formatFlag != fileFormatJSONL {
+		log.Fatalf("Invalid -format value: %s (expected csv or jsonl)", *formatFlag)
+	}
+	if *batchSizeFlag < 1 {
+		log.Fatalf("Invalid -batch-size value: %d", *batchSizeFlag)
+	}
+	csvOptions, err := parseCSVOptions(*csvDelimiterFlag, *csvHeaderFlag, *csvNullFlag)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var files []loadFile
+	if *filesFlag != "" {
+		if files, err = listLoadFiles(*filesFlag, *formatFlag, *tableFlag); err != nil {
+			log.Fatalf("Error in -files: %v", err)
+		}
+		if len(files) == 0 {
+			log.Fatalf("No .csv or .jsonl files in %s", *filesFlag)
+		}
+	}
+	targetDsn := *targetDsnFlag
+	if targetDsn == "" {
+		targetDsn = os.Getenv("TARGET_DB_DSN")
+	}
+	if targetDsn == "" {
+		log.Fatal("No target database connection. Set TARGET_DB_DSN environment variable or use -target-dsn flag.")
+	}
+
+	var cfg *dbmigrate.Config
+	if *configFlag != "" {
+		if cfg, err = dbmigrate.LoadConfig(*configFlag); err != nil {
+			log.Fatalf("Error loading config file: %v", err)
+		}
+	}
+	mapper, err := dbmigrate.NewNameMapper(cfg, *schemaMapFlag)
+	if err != nil {
+		log.Fatalf("Error parsing name mappings: %v", err)
+	}
+	typeMapper, err := dbmigrate.NewTypeMapper(cfg, *typeMapFlag)
+	if err != nil {
+		log.Fatalf("Error parsing type mappings: %v", err)
+	}
+	datetimeType, err := dbmigrate.ParseDatetimeType(*datetimeTypeFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -datetime-type: %v", err)
+	}
+	hierarchyid, err := dbmigrate.ParseHierarchyid(*hierarchyidFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -hierarchyid: %v", err)
+	}
+	sourceTimezone, err := time.LoadLocation(*sourceTimezoneFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -source-timezone: %v", err)
+	}
+	invalidText, err := parseInvalidText(*invalidTextFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -invalid-text: %v", err)
+	}
+
+	targetDb, err := sql.Open("postgres", targetDsn)
+	if err != nil {
+		log.Fatalf("Error connecting to target database: %v", err)
+	}
+	defer targetDb.Close()
+	if err := targetDb.Ping(); err != nil {
+		log.Fatalf("Error connecting to target database: %v", err)
+	}
+	fmt.Println("✅ Connected to PostgreSQL target database")
+	stateStore, err := dbmigrate.OpenStateStore(*stateFlag, targetDb, false)
+	if err != nil {
+		log.Fatalf("Error opening state store: %v", err)
+	}
+	checkpoints, err := stateStore.Load()
+	if err != nil {
+		log.Fatalf("Error loading checkpoints: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	m := &migrator{
+		ctx:             ctx,
+		source:          dbmigrate.SQLServer,
+		targetDb:        targetDb,
+		mapper:          mapper,
+		typeMapper:      typeMapper,
+		config:          cfg,
+		stateStore:      stateStore,
+		checkpoints:     checkpoints,
+		truncate:        *truncateFlag,
+		restartIdentity: *truncateRestartIdentityFlag,
+		invalidText:     invalidText,
+		datetimeType:    datetimeType,
+		hierarchyid:     hierarchyid,
+		sourceTimezone:  sourceTimezone,
+		preserveCase:    *preserveCaseFlag,
+		columnTypes:     make(map[string][][2]string),
+	}
+	if *rejectFileFlag != "" {
+		if m.rejects, err = newRejectWriter(*rejectFileFlag, *maxRejectsFlag); err != nil {
+			log.Fatalf("Error opening reject file: %v", err)
+		}
+		defer func() {
+			if count := m.rejects.close(); count > 0 {
+				fmt.Printf("⚠️  %d rows were rejected, see %s\n", count, *rejectFileFlag)
+			}
+		}()
+	}
+
+	start := time.Now()
+	if files != nil {
+		totalRows, err := m.loadFiles(files, csvOptions, *batchSizeFlag)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("✅ Loaded %d rows in %s\n", totalRows, time.Since(start).Round(time.Millisecond))
+		return
+	}
+	var totalRows int64
+	for {
+		manifest, err := dbmigrate.LoadSpoolManifest(*spoolFlag)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		loaded := 0
+		for _, table := range manifest.Tables {
+			chunks, rows, err := m.loadSpoolTable(*spoolFlag, table)
+			if err != nil {
+				log.Fatalf("❌ Error loading table %s: %v", table.Table, err)
+			}
+			loaded += chunks
+			totalRows += rows
+		}
+		if manifest.Complete || !*followFlag {
+			if !manifest.Complete {
+				fmt.Println("⚠️  The extraction is not complete yet, run load again (or use -follow) for the remaining chunks")
+			}
+			break
+		}
+		if loaded == 0 {
+			select {
+			case <-ctx.Done():
+				fmt.Println("Load stopped")
+				return
+			case <-time.After(*pollIntervalFlag):
+			}
+		}
+	}
+	fmt.Printf("✅ Loaded %d rows in %s\n", totalRows, time.Since(start).Round(time.Millisecond))
+}
+
+// loadSpoolTable loads the chunks of a spooled table not loaded yet, each in
+// one transaction, returning the number of chunks and rows loaded. A new
+// generation of the table replaces the rows loaded from the previous one.
+func (m *migrator) loadSpoolTable(dir string, table *dbmigrate.SpoolTable) (int, int64, error) {
+	key := spoolKeyPrefix + table.Table
+	cp, hasCheckpoint := m.checkpoints[key]
+	generation, next := 0, 0
+	if hasCheckpoint {
+		if _, err := fmt.Sscanf(cp.Value, "%d:%d", &generation, &next); err != nil {
+			return 0, 0, fmt.Errorf("invalid spool checkpoint %q", cp.Value)
+		}
+	}
+	if generation == table.Generation && next >= len(table.Chunks) {
+		return 0, 0, nil
+	}
+
+	parts := strings.SplitN(table.Table, ".", 2)
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+	if generation != table.Generation {
+		if (hasCheckpoint && next > 0) || m.truncate {
+			if err := truncateTargetTable(m.targetDb, targetSchema, targetTable, m.preserveCase, m.restartIdentity); err != nil {
+				return 0, 0, fmt.Errorf("error truncating %s: %v", target, err)
+			}
+			fmt.Printf("Truncated table: %s\n", target)
+		}
+		generation, next = table.Generation, 0
+		cp = dbmigrate.Checkpoint{Table: key, Value: strconv.Itoa(generation) + ":0"}
+		m.saveCheckpoint(cp)
+		m.checkpoints[key] = cp
+	}
+
+	columns := make([]string, len(table.Columns))
+	var columnTypes [][2]string
+	for i, column := range table.Columns {
+		columns[i] = column.Name
+		columnTypes = append(columnTypes, [2]string{column.Name, column.Type})
+	}
+	m.columnTypes[table.Table] = columnTypes
+	transformRow, err := m.rowTransform(table.Table, columns, make(map[string]int64), make(map[string]int64), make(map[string]string))
+	if err != nil {
+		return 0, 0, err
+	}
+	columnList := make([]string, len(columns))
+	for i, column := range columns {
+		columnList[i] = dbmigrate.QuoteIdent(column, m.preserveCase)
+	}
+	copyQuery := fmt.Sprintf("COPY %s (%s) FROM STDIN", target, strings.Join(columnList, ", "))
+
+	// With -reject-file, rows that fail are rejected and the others loaded
+	var onReject func(values []interface{}, err error) error
+	if m.rejects != nil {
+		onReject = func(values []interface{}, rowErr error) error {
+			return m.rejects.reject(table.Table, rowKey(columns, nil, values), rowErr)
+		}
+	}
+
+	loaded := 0
+	var loadedRows int64
+	for ; next < len(table.Chunks); next++ {
+		if m.ctx.Err() != nil {
+			return loaded, loadedRows, m.ctx.Err()
+		}
+		chunk := table.Chunks[next]
+		rows, err := dbmigrate.ReadSpoolChunk(dir, chunk)
+		if err != nil {
+			return loaded, loadedRows, err
+		}
+		copied, err := copyChunk(m.ctx, m.targetDb, nil, copyQuery, columns, rows, transformRow, onReject)
+		if err != nil {
+			return loaded, loadedRows, fmt.Errorf("error loading chunk %s of %s: %v", chunk.File, table.Table, err)
+		}
+		loaded++
+		loadedRows += int64(copied)
+		cp = dbmigrate.Checkpoint{
+			Table:        key,
+			RowsMigrated: cp.RowsMigrated + int64(copied),
+			Completed:    table.Complete && next+1 == len(table.Chunks),
+			Value:        strconv.Itoa(generation) + ":" + strconv.Itoa(next+1),
+		}
+		m.saveCheckpoint(cp)
+		m.checkpoints[key] = cp
+	}
+	if cp.Completed {
+		fmt.Printf("✅ Loaded table %s: %d rows\n", table.Table, cp.RowsMigrated)
+	} else {
+		fmt.Printf("Loaded %d chunks of %s (%d rows so far)\n", loaded, table.Table, cp.RowsMigrated)
+	}
+	return loaded, loadedRows, nil
+}
+
+// copyChunk copies rows into the target with a single COPY in one
+// transaction, after the setup statements (e.g., SET LOCAL), returning the
+// number of rows copied. With onReject, rows that fail are isolated by
+// copyRows and rejected, and the others are copied.
+func copyChunk(ctx context.Context, targetDb *sql.DB, setup []string, copyQuery string, columns []string, rows [][]interface{}, transformRow func(values []interface{}) error, onReject func(values []interface{}, err error) error) (int, error) {
+	tx, err := targetDb.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, statement := range setup {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return 0, fmt.Errorf("error running %q: %v", statement, err)
+		}
+	}
+
+	if onReject != nil {
+		valid := rows[:0:0]
+		for _, values := range rows {
+			if transformRow != nil {
+				if err := transformRow(values); err != nil {
+					if rejectErr := onReject(values, err); rejectErr != nil {
+						return 0, rejectErr
+					}
+					continue
+				}
+			}
+			valid = append(valid, values)
+		}
+		copied, err := copyRows(ctx, tx, copyQuery, valid, onReject)
+		if err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("error committing transaction: %v", err)
+		}
+		return copied, nil
+	}
+
+	stmt, err := tx.Prepare(copyQuery)
+	if err != nil {
+		return 0, fmt.Errorf("error starting COPY: %v", err)
+	}
+	defer stmt.Close()
+
+	for i, values := range rows {
+		if transformRow != nil {
+			if err := transformRow(values); err != nil {
+				return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", i+1), row: formatSampleRow(columns, values), err: err}
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", i+1), row: formatSampleRow(columns, values), err: fmt.Errorf("error copying row: %v", err)}
+		}
+	}
+	// Flush the COPY; errors in the copied data are reported here
+	if _, err := stmt.Exec(); err != nil {
+		return 0, &rowError{batch: 1, keyRange: fmt.Sprintf("rows 1..%d", len(rows)), column: offendingColumn(err, columns, nil), err: fmt.Errorf("error copying rows: %v", err)}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return len(rows), nil
+}
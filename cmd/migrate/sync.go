@@ -0,0 +1,363 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// changeVersionKeyPrefix prefixes the state store keys recording the SQL Server
+// change tracking version each table is synchronized up to
+const changeVersionKeyPrefix = "change-version:"
+
+// changeRetention is the change tracking retention -enable-change-tracking
+// sets on the source database; syncs must run at least this often
+const changeRetention = "2 DAYS"
+
+// enableChangeTracking enables change tracking on the source database and on
+// the selected tables that do not have it yet
+func (m *migrator) enableChangeTracking() error {
+	var enabled int
+	if err := m.sourceDb.QueryRowContext(m.ctx, "SELECT COUNT(*) FROM sys.change_tracking_databases WHERE database_id = DB_ID()").Scan(&enabled); err != nil {
+		return fmt.Errorf("error checking change tracking of the source database: %v", err)
+	}
+	if enabled == 0 {
+		statement := fmt.Sprintf("ALTER DATABASE CURRENT SET CHANGE_TRACKING = ON (CHANGE_RETENTION = %s, AUTO_CLEANUP = ON)", changeRetention)
+		if _, err := m.sourceDb.ExecContext(m.ctx, statement); err != nil {
+			return fmt.Errorf("error enabling change tracking on the source database: %v", err)
+		}
+		fmt.Printf("✅ Enabled change tracking on the source database (retention %s)\n", strings.ToLower(changeRetention))
+	}
+
+	tracked, err := changeTrackedTables(m.sourceDb)
+	if err != nil {
+		return err
+	}
+	for _, table := range m.tables {
+		if tracked[table] {
+			continue
+		}
+		parts := strings.SplitN(table, ".", 2)
+		if _, err := m.sourceDb.ExecContext(m.ctx, fmt.Sprintf("ALTER TABLE [%s].[%s] ENABLE CHANGE_TRACKING", parts[0], parts[1])); err != nil {
+			return fmt.Errorf("error enabling change tracking on %s: %v", table, err)
+		}
+		fmt.Printf("✅ Enabled change tracking on %s\n", table)
+	}
+	return nil
+}
+
+// changeTrackedTables returns the source tables (schema.table) with change tracking enabled
+func changeTrackedTables(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT s.name, t.name
+		FROM sys.change_tracking_tables ct
+		JOIN sys.tables t ON t.object_id = ct.object_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying change tracked tables: %v", err)
+	}
+	defer rows.Close()
+
+	tracked := make(map[string]bool)
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			return nil, fmt.Errorf("error scanning change tracked table: %v", err)
+		}
+		tracked[schema+"."+table] = true
+	}
+	return tracked, rows.Err()
+}
+
+// currentChangeVersion returns the current change tracking version of the source database
+func (m *migrator) currentChangeVersion() (int64, error) {
+	var version sql.NullInt64
+	if err := m.sourceDb.QueryRowContext(m.ctx, "SELECT CHANGE_TRACKING_CURRENT_VERSION()").Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading the change tracking version: %v", err)
+	}
+	if !version.Valid {
+		return 0, fmt.Errorf("change tracking is not enabled on the source database (use -enable-change-tracking)")
+	}
+	return version.Int64, nil
+}
+
+// syncedVersion returns the change tracking version a table is synchronized
+// up to, or false if its copy has not completed
+func (m *migrator) syncedVersion(table string) (int64, bool) {
+	cp, ok := m.checkpoints[changeVersionKeyPrefix+table]
+	if !ok || !cp.Completed {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(cp.Value, 10, 64)
+	if err != nil {
+		log.Printf("Warning: Invalid change tracking version recorded for %s: %q", table, cp.Value)
+		return 0, false
+	}
+	return version, true
+}
+
+// runSyncPhase applies the inserts, updates and deletes recorded by change
+// tracking since the last sync (or since the copy) of each table to the
+// target; with -sync-interval it repeats until interrupted
+func (m *migrator) runSyncPhase() error {
+	return m.repeatSync(m.syncChanges)
+}
+
+// repeatSync runs sync once, or with -sync-interval repeatedly until interrupted
+func (m *migrator) repeatSync(sync func() error) error {
+	for {
+		if err := sync(); err != nil {
+			return err
+		}
+		if m.syncInterval == 0 {
+			return nil
+		}
+		fmt.Printf("Next sync in %s\n", m.syncInterval)
+		select {
+		case <-m.ctx.Done():
+			fmt.Println("Sync stopped")
+			return nil
+		case <-time.After(m.syncInterval):
+		}
+	}
+}
+
+// syncChanges synchronizes every table once
+func (m *migrator) syncChanges() error {
+	startTime := time.Now()
+	// Changes made while the tables are synchronized are applied again by the
+	// next sync; as rows are upserted this is harmless
+	current, err := m.currentChangeVersion()
+	if err != nil {
+		return err
+	}
+
+	var totalUpserted, totalDeleted int
+	for _, table := range m.tables {
+		last, ok := m.syncedVersion(table)
+		if !ok {
+			fmt.Printf("⚠️  Skipping %s: no change tracking version recorded, copy it with the data phase first\n", table)
+			continue
+		}
+		if last >= current {
+			fmt.Printf("No changes to %s since version %d\n", table, last)
+			continue
+		}
+
+		upserted, deleted, err := m.syncTable(table, last)
+		if err != nil {
+			if !m.continueOnError {
+				return fmt.Errorf("error synchronizing table %s: %v", table, err)
+			}
+			m.metrics.recordError()
+			fmt.Printf("❌ Error synchronizing table %s, continuing with the next table: %v\n", table, err)
+			continue
+		}
+		m.recordCheckpoint(dbmigrate.Checkpoint{Table: changeVersionKeyPrefix + table, Completed: true, Value: strconv.FormatInt(current, 10)})
+		totalUpserted += upserted
+		totalDeleted += deleted
+		fmt.Printf("✅ Synchronized %s: %d rows upserted, %d deleted (version %d to %d)\n", table, upserted, deleted, last, current)
+	}
+	fmt.Printf("✅ Sync completed in %s: %d rows upserted, %d deleted\n", time.Since(startTime).Round(time.Millisecond), totalUpserted, totalDeleted)
+	return nil
+}
+
+// syncTable applies the changes to a table since version last to the target,
+// returning the number of upserted and deleted rows. Each changed key is
+// upserted with the current values of its source row, or deleted if the row
+// no longer exists, in transactions of batchSize changes.
+func (m *migrator) syncTable(table string, last int64) (upserted, deleted int, err error) {
+	parts := strings.SplitN(table, ".", 2)
+	source := fmt.Sprintf("[%s].[%s]", parts[0], parts[1])
+
+	// Changes older than the retention period are cleaned up
+	var minValid sql.NullInt64
+	if err := m.sourceDb.QueryRowContext(m.ctx, "SELECT CHANGE_TRACKING_MIN_VALID_VERSION(OBJECT_ID(@p1))", source).Scan(&minValid); err != nil {
+		return 0, 0, fmt.Errorf("error reading the change tracking versions of %s: %v", table, err)
+	}
+	if !minValid.Valid {
+		return 0, 0, fmt.Errorf("change tracking is not enabled on %s (use -enable-change-tracking)", table)
+	}
+	if minValid.Int64 > last {
+		// Mark the table as partially copied, so the next data phase copies it again
+		m.saveCheckpoint(dbmigrate.Checkpoint{Table: table})
+		m.saveCheckpoint(dbmigrate.Checkpoint{Table: "phase:" + phaseData})
+		return 0, 0, fmt.Errorf("changes since version %d were already cleaned up (oldest version %d), the next run of the data phase copies the table again", last, minValid.Int64)
+	}
+
+	columns, err := m.sourceColumns(table)
+	if err != nil {
+		return 0, 0, err
+	}
+	transcode, err := getTranscodeColumns(m.sourceDb, table)
+	if err != nil {
+		return 0, 0, err
+	}
+	exprs, err := m.sourceExprs(table, transcode)
+	if err != nil {
+		return 0, 0, err
+	}
+	transformRow, err := m.rowTransform(table, columns, make(map[string]int64), make(map[string]int64), make(map[string]string))
+	if err != nil {
+		return 0, 0, err
+	}
+	pkColumns, err := m.source.PrimaryKey(m.sourceDb, table)
+	if err != nil {
+		return 0, 0, err
+	}
+	key := newPrimaryKey(pkColumns, columns)
+	if key == nil {
+		return 0, 0, fmt.Errorf("primary key (%s) of %s is not copied", strings.Join(pkColumns, ", "), table)
+	}
+
+	// The source row is joined by its unconverted key columns; a change without
+	// a source row is a delete
+	sourceList := make([]string, len(columns))
+	selectList := make([]string, len(columns))
+	for i, column := range columns {
+		sourceList[i] = sourceColumnExpr(m.source, column, exprs)
+		selectList[i] = fmt.Sprintf("t.[%s]", column)
+	}
+	keyList := make([]string, len(pkColumns))
+	join := make([]string, len(pkColumns))
+	for i, key := range pkColumns {
+		sourceList = append(sourceList, fmt.Sprintf("[%s] AS [dbmigrate_key_%d]", key, i))
+		keyList[i] = fmt.Sprintf("ct.[%s]", key)
+		join[i] = fmt.Sprintf("t.[dbmigrate_key_%d] = ct.[%s]", i, key)
+	}
+	query := fmt.Sprintf("SELECT CASE WHEN t.[dbmigrate_key_0] IS NULL THEN 0 ELSE 1 END, %s, %s FROM CHANGETABLE(CHANGES %s, @p1) AS ct LEFT JOIN (SELECT %s FROM %s) AS t ON %s",
+		strings.Join(keyList, ", "), strings.Join(selectList, ", "), source, strings.Join(sourceList, ", "), source, strings.Join(join, " AND "))
+
+	// Target statements
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+	columnList := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		columnList[i] = dbmigrate.QuoteIdent(column, m.preserveCase)
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	if m.provenanceColumn != "" {
+		columnList = append(columnList, dbmigrate.QuoteIdent(m.provenanceColumn, m.preserveCase))
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(columns)+1))
+	}
+	upsertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)%s", target, strings.Join(columnList, ", "), strings.Join(placeholders, ", "),
+		conflictClause(writeModeUpsert, pkColumns, columns, m.provenanceColumn, m.preserveCase))
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE %s", target, key.targetCondition(1, m.preserveCase))
+
+	rows, err := m.sourceDb.QueryContext(m.ctx, query, last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error querying changes of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	tx, err := beginBatch(m.targetDb, batchSettings{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	batchCount := 0
+	for rows.Next() {
+		if m.ctx.Err() != nil {
+			return 0, 0, m.ctx.Err()
+		}
+		var present int
+		keys := make([]interface{}, len(pkColumns))
+		values := make([]interface{}, len(columns))
+		dest := []interface{}{&present}
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return 0, 0, fmt.Errorf("error scanning change: %v", err)
+		}
+
+		// Deleted rows only have their key, converted like the copied rows
+		if present == 0 {
+			values = make([]interface{}, len(columns))
+			for i, index := range key.indexes {
+				values[index] = keys[i]
+			}
+		}
+		if transformRow != nil {
+			if err := transformRow(values); err != nil {
+				return 0, 0, fmt.Errorf("error converting row %s: %v", formatSampleRow(columns, values), err)
+			}
+		}
+		if present == 0 {
+			keyValues := key.values(values)
+			if _, err := tx.ExecContext(m.ctx, deleteQuery, keyValues...); err != nil {
+				return 0, 0, fmt.Errorf("error deleting row %s: %v", key.format(values), err)
+			}
+			deleted++
+		} else {
+			if m.provenanceColumn != "" {
+				values = append(values, m.runID)
+			}
+			if _, err := tx.ExecContext(m.ctx, upsertQuery, values...); err != nil {
+				return 0, 0, fmt.Errorf("error upserting row %s: %v", formatSampleRow(columns, values), err)
+			}
+			upserted++
+		}
+
+		batchCount++
+		if batchCount >= m.batchSize {
+			if err := tx.Commit(); err != nil {
+				tx = nil
+				return 0, 0, fmt.Errorf("error committing transaction: %v", err)
+			}
+			if tx, err = beginBatch(m.targetDb, batchSettings{}); err != nil {
+				return 0, 0, fmt.Errorf("error starting transaction: %v", err)
+			}
+			batchCount = 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error reading changes of %s: %v", table, err)
+	}
+	err = tx.Commit()
+	tx = nil
+	if err != nil {
+		return 0, 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return upserted, deleted, nil
+}
+
+// recordChangeBaseline records the change tracking version a table copy starts
+// from, so the sync phase applies the changes made during and after the copy.
+// Tables without change tracking are skipped.
+func (m *migrator) recordChangeBaseline(table string) error {
+	if m.changeTracked == nil || !m.changeTracked[table] {
+		return nil
+	}
+	version, err := m.currentChangeVersion()
+	if err != nil {
+		return err
+	}
+	m.recordCheckpoint(dbmigrate.Checkpoint{Table: changeVersionKeyPrefix + table, Value: strconv.FormatInt(version, 10)})
+	return nil
+}
+
+// completeChangeBaseline marks the baseline version of a copied table as
+// ready for the sync phase
+func (m *migrator) completeChangeBaseline(table string) {
+	cp, ok := m.checkpoint(changeVersionKeyPrefix + table)
+	if !ok || cp.Completed {
+		return
+	}
+	cp.Completed = true
+	m.recordCheckpoint(cp)
+	fmt.Printf("Recorded change tracking version %s of %s for the sync phase\n", cp.Value, table)
+}
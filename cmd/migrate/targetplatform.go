@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// targetPlatform describes the PostgreSQL offering of the target. Amazon RDS
+// and Aurora do not give the master user superuser rights: operations that
+// need them are allowed to members of the rds_superuser role, if at all, and
+// only the extensions listed in rds.extensions can be installed.
+type targetPlatform struct {
+	// name is the offering, or "" for a self-managed server
+	name         string
+	superuser    bool
+	rdsSuperuser bool // member of rds_superuser
+	// extensions holds the extensions that can be installed (rds.extensions),
+	// or nil if any extension available on the server can be
+	extensions map[string]bool
+}
+
+// Names of the detected offerings
+const (
+	platformAurora = "Amazon Aurora PostgreSQL"
+	platformRDS    = "Amazon RDS for PostgreSQL"
+)
+
+// detectTargetPlatform tells whether the target is Amazon RDS or Aurora, by
+// the aurora_version() function and the rds_superuser role, and what the
+// current user may do there
+func detectTargetPlatform(ctx context.Context, db *sql.DB) (*targetPlatform, error) {
+	var aurora, rds bool
+	var extensions sql.NullString
+	p := &targetPlatform{}
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pg_proc WHERE proname = 'aurora_version'),
+			EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'rds_superuser'),
+			(SELECT rolsuper FROM pg_roles WHERE rolname = current_user),
+			current_setting('rds.extensions', true)`).Scan(&aurora, &rds, &p.superuser, &extensions)
+	if err != nil {
+		return nil, fmt.Errorf("error detecting the target platform: %v", err)
+	}
+	switch {
+	case aurora:
+		p.name = platformAurora
+	case rds:
+		p.name = platformRDS
+	default:
+		return p, nil
+	}
+	if err := db.QueryRowContext(ctx, "SELECT pg_has_role(current_user, 'rds_superuser', 'MEMBER')").Scan(&p.rdsSuperuser); err != nil {
+		return nil, fmt.Errorf("error checking rds_superuser membership: %v", err)
+	}
+	if extensions.Valid && extensions.String != "" {
+		p.extensions = make(map[string]bool)
+		for _, extension := range strings.Split(extensions.String, ",") {
+			p.extensions[strings.TrimSpace(extension)] = true
+		}
+	}
+	return p, nil
+}
+
+// managed tells whether the target is a hosted offering without superuser rights
+func (p *targetPlatform) managed() bool {
+	return p != nil && p.name != "" && !p.superuser
+}
+
+// describe returns the offering and the rights of the current user for the
+// connection messages
+func (p *targetPlatform) describe() string {
+	switch {
+	case p.superuser:
+		return p.name + " (superuser)"
+	case p.rdsSuperuser:
+		return p.name + " (member of rds_superuser)"
+	}
+	return p.name + " (not a member of rds_superuser)"
+}
+
+// explain adds to a permission error of a managed target what the user lacks,
+// instead of the generic "permission denied" or "must be superuser"
+func (p *targetPlatform) explain(err error) error {
+	var pqErr *pq.Error
+	if err == nil || !p.managed() || !errors.As(err, &pqErr) || pqErr.Code.Name() != "insufficient_privilege" {
+		return err
+	}
+	if p.rdsSuperuser {
+		return fmt.Errorf("%w (%s reserves this operation for its rdsadmin user, even for members of rds_superuser)", err, p.name)
+	}
+	return fmt.Errorf("%w (on %s the master user is not a superuser: grant the target user the rds_superuser role, or have a member of it run the operation)", err, p.name)
+}
+
+// checkExtensions checks that the extensions the schema statements create can
+// be installed on the target, so the schema phase does not fail halfway
+func (p *targetPlatform) checkExtensions(ctx context.Context, db *sql.DB, statements []string) error {
+	if !p.managed() {
+		return nil
+	}
+	for _, statement := range statements {
+		name, ok := strings.CutPrefix(statement, "CREATE EXTENSION IF NOT EXISTS ")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		var installed, trusted bool
+		err := db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = $1),
+				COALESCE((SELECT bool_or((to_jsonb(v)->>'trusted')::boolean) FROM pg_available_extension_versions v WHERE name = $1), false)`,
+			name).Scan(&installed, &trusted)
+		if err != nil {
+			return fmt.Errorf("error checking extension %s: %v", name, err)
+		}
+		switch {
+		case installed:
+		case p.extensions != nil && !p.extensions[name]:
+			return fmt.Errorf("extension %s is not supported by this %s instance (see rds.extensions)", name, p.name)
+		case !p.rdsSuperuser && !trusted:
+			return fmt.Errorf("extension %s can only be installed by a member of rds_superuser on %s: "+
+				"run CREATE EXTENSION %s in the target database as the master user first", name, p.name, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// targetDatabase is a PostgreSQL database the tables of some source schemas
+// are migrated to. With -target-databases, a run writes to several of them:
+// the tables of the listed schemas go to theirs, the other tables to the
+// -target-dsn database, which also holds -state target.
+type targetDatabase struct {
+	// name is the source schemas joined by "+", which names the database in
+	// messages and phase checkpoints, or "" for the -target-dsn database
+	name     string
+	schemas  []string // lowercase
+	dsn      string
+	db       *sql.DB
+	platform *targetPlatform
+	tables   []string // source tables migrated to the database
+}
+
+// parseTargetDatabases reads the target databases of source schemas from the
+// config file (may be nil) and the -target-databases flag value, a
+// comma-separated list of schema=DSN entries, which take precedence. ${VAR}
+// in a connection string is replaced by the environment variable VAR. Schemas
+// with the same connection string share a target database.
+func parseTargetDatabases(cfg *dbmigrate.Config, value string) ([]*targetDatabase, error) {
+	dsns := make(map[string]string)
+	if cfg != nil {
+		for schema, dsn := range cfg.TargetDatabases {
+			dsns[strings.ToLower(schema)] = dsn
+		}
+	}
+	for _, entry := range splitList(value) {
+		schema, dsn, ok := strings.Cut(entry, "=")
+		if schema, dsn = strings.TrimSpace(schema), strings.TrimSpace(dsn); !ok || schema == "" || dsn == "" {
+			// The entry is not printed, as it may hold a password
+			return nil, fmt.Errorf("invalid -target-databases entry for %q (expected schema=DSN)", schema)
+		}
+		dsns[strings.ToLower(schema)] = dsn
+	}
+
+	schemas := make([]string, 0, len(dsns))
+	for schema := range dsns {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+	var targets []*targetDatabase
+	byDsn := make(map[string]*targetDatabase)
+	for _, schema := range schemas {
+		dsn := os.ExpandEnv(dsns[schema])
+		if !dbmigrate.IsPostgresDsn(dsn) {
+			return nil, fmt.Errorf("the target database of schema %s must be a postgres:// connection string (is an environment variable unset?)", schema)
+		}
+		target := byDsn[dsn]
+		if target == nil {
+			target = &targetDatabase{dsn: dsn}
+			byDsn[dsn] = target
+			targets = append(targets, target)
+		}
+		target.schemas = append(target.schemas, schema)
+	}
+	for _, target := range targets {
+		target.name = strings.Join(target.schemas, "+")
+	}
+	return targets, nil
+}
+
+// connectTarget opens a target database with sessions tagged sessionName,
+// checks the connection and detects its platform
+func connectTarget(dsn, sessionName string, maxConnections int) (*sql.DB, *targetPlatform, error) {
+	db, err := sql.Open("postgres", dbmigrate.TagPostgresDsn(dsn, sessionName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to target database: %v", err)
+	}
+	db.SetMaxOpenConns(maxConnections)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(time.Minute * 5)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("error connecting to target database: %v", err)
+	}
+	platform, err := detectTargetPlatform(context.Background(), db)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, platform, nil
+}
+
+// describeTarget names a target database in messages
+func describeTarget(target *targetDatabase) string {
+	if target.name == "" {
+		return "-target-dsn database"
+	}
+	return "target database of " + strings.Join(target.schemas, ", ")
+}
+
+// targetTitle names a target database at the start of a message
+func targetTitle(target *targetDatabase) string {
+	if target.name == "" {
+		return "Target database"
+	}
+	return "Target database of " + strings.Join(target.schemas, ", ")
+}
+
+// targetOf returns the target database of a source table (schema.table)
+func targetOf(targets []*targetDatabase, table string) *targetDatabase {
+	schema, _, _ := strings.Cut(strings.ToLower(table), ".")
+	for _, target := range targets {
+		for _, s := range target.schemas {
+			if s == schema {
+				return target
+			}
+		}
+	}
+	return targets[0]
+}
+
+// warnCrossTargetReferences warns about the foreign keys between tables
+// migrated to different target databases, which PostgreSQL cannot enforce
+func warnCrossTargetReferences(targets []*targetDatabase, tables []string, dependencies map[string][]string) {
+	if len(targets) <= 1 {
+		return
+	}
+	migrated := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		migrated[strings.ToLower(table)] = true
+	}
+	referencing := make([]string, 0, len(dependencies))
+	for table := range dependencies {
+		referencing = append(referencing, table)
+	}
+	sort.Strings(referencing)
+	for _, table := range referencing {
+		if !migrated[strings.ToLower(table)] {
+			continue
+		}
+		for _, referenced := range dependencies[table] {
+			if migrated[strings.ToLower(referenced)] && targetOf(targets, table) != targetOf(targets, referenced) {
+				log.Printf("Warning: %s references %s, which is migrated to another target database; PostgreSQL cannot enforce the foreign key across databases", table, referenced)
+			}
+		}
+	}
+}
+
+// forEachTarget calls run for each target database with tables, with the
+// migrator switched to it: its connection, platform and tables. Without
+// -target-databases, run is called once as is.
+func (m *migrator) forEachTarget(run func() error) error {
+	if len(m.targets) <= 1 {
+		return run()
+	}
+	tables, targetDb, platform := m.tables, m.targetDb, m.targetPlatform
+	defer func() {
+		m.tables, m.targetDb, m.targetPlatform, m.targetName = tables, targetDb, platform, ""
+	}()
+	for _, target := range m.targets {
+		if len(target.tables) == 0 {
+			continue
+		}
+		m.tables, m.targetDb, m.targetPlatform, m.targetName = target.tables, target.db, target.platform, target.name
+		fmt.Printf("\n--- Migrating %d tables to the %s ---\n", len(target.tables), describeTarget(target))
+		if err := run(); err != nil {
+			return fmt.Errorf("migrating to the %s: %w", describeTarget(target), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// maxReportedCharMismatches limits how many mismatched rows are printed per column
+const maxReportedCharMismatches = 10
+
+// textTypes are the source types whose character counts are verified
+var textTypes = map[string]bool{
+	"char": true, "varchar": true, "text": true,
+	"nchar": true, "nvarchar": true, "ntext": true,
+}
+
+// sourceCharCountExpr counts the characters (code points) of a source column.
+// The _SC collation makes LEN count a surrogate pair as one character, and the
+// appended 'x' keeps LEN from ignoring trailing spaces.
+func sourceCharCountExpr(column string) string {
+	return fmt.Sprintf("LEN((CAST([%s] AS NVARCHAR(MAX)) + N'x') COLLATE Latin1_General_100_CI_AS_SC) - 1", column)
+}
+
+// verifyCharacterCounts compares the character counts of the text columns of a
+// table between source and target, so characters outside the Basic Multilingual
+// Plane (e.g., emoji) that were split or replaced during the UTF-16 to UTF-8
+// conversion are detected. Columns whose totals differ are compared row by row
+// using the primary key. Returns the number of mismatched columns.
+func (m *migrator) verifyCharacterCounts(table string) (int, error) {
+	parts := strings.SplitN(table, ".", 2)
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return 0, err
+	}
+
+	mismatches := 0
+	for _, column := range columnTypes {
+		if !textTypes[strings.ToLower(column[1])] {
+			continue
+		}
+		name := column[0]
+
+		var sourceChars, targetChars sql.NullInt64
+		sourceQuery := fmt.Sprintf("SELECT SUM(CAST(%s AS BIGINT)) FROM [%s].[%s]", sourceCharCountExpr(name), parts[0], parts[1])
+		if err := m.sourceDb.QueryRowContext(m.ctx, sourceQuery).Scan(&sourceChars); err != nil {
+			return mismatches, fmt.Errorf("error counting source characters for %s.%s: %v", table, name, err)
+		}
+		targetQuery := fmt.Sprintf("SELECT SUM(char_length(%s)) FROM %s", dbmigrate.QuoteIdent(name, m.preserveCase), target)
+		if err := m.targetDb.QueryRowContext(m.ctx, targetQuery).Scan(&targetChars); err != nil {
+			return mismatches, fmt.Errorf("error counting target characters for %s.%s: %v", table, name, err)
+		}
+		if sourceChars.Int64 == targetChars.Int64 {
+			continue
+		}
+
+		mismatches++
+		fmt.Printf("❌ %s.%s: %d source characters, %d target characters\n", table, name, sourceChars.Int64, targetChars.Int64)
+		if err := m.reportCharMismatchRows(table, target, name); err != nil {
+			return mismatches, err
+		}
+	}
+	return mismatches, nil
+}
+
+// reportCharMismatchRows prints the primary keys of the rows whose character
+// count of column differs between source and target
+func (m *migrator) reportCharMismatchRows(table, target, column string) error {
+	parts := strings.SplitN(table, ".", 2)
+	pkColumns, err := m.source.PrimaryKey(m.sourceDb, table)
+	if err != nil {
+		return err
+	}
+	if len(pkColumns) == 0 {
+		fmt.Printf("  (table has no primary key, mismatched rows cannot be identified)\n")
+		return nil
+	}
+
+	sourceKeys := make([]string, len(pkColumns))
+	targetKeys := make([]string, len(pkColumns))
+	for i, pk := range pkColumns {
+		sourceKeys[i] = fmt.Sprintf("CAST([%s] AS NVARCHAR(4000))", pk)
+		targetKeys[i] = dbmigrate.QuoteIdent(pk, m.preserveCase) + "::text"
+	}
+
+	// Collect the source counts by key, then compare each target row
+	sourceQuery := fmt.Sprintf("SELECT %s, %s FROM [%s].[%s]", strings.Join(sourceKeys, ", "), sourceCharCountExpr(column), parts[0], parts[1])
+	sourceCounts, err := m.queryCharCounts(m.sourceDb, sourceQuery, len(pkColumns))
+	if err != nil {
+		return fmt.Errorf("error reading source characters for %s.%s: %v", table, column, err)
+	}
+	targetQuery := fmt.Sprintf("SELECT %s, char_length(%s) FROM %s", strings.Join(targetKeys, ", "), dbmigrate.QuoteIdent(column, m.preserveCase), target)
+	targetCounts, err := m.queryCharCounts(m.targetDb, targetQuery, len(pkColumns))
+	if err != nil {
+		return fmt.Errorf("error reading target characters for %s.%s: %v", table, column, err)
+	}
+
+	flagged := 0
+	for key, sourceCount := range sourceCounts {
+		targetCount, ok := targetCounts[key]
+		if !ok || targetCount == sourceCount {
+			continue
+		}
+		flagged++
+		if flagged <= maxReportedCharMismatches {
+			fmt.Printf("  - row (%s) = (%s): %d source characters, %d target characters\n",
+				strings.Join(pkColumns, ", "), strings.ReplaceAll(key, "\x00", ", "), sourceCount, targetCount)
+		}
+	}
+	if flagged > maxReportedCharMismatches {
+		fmt.Printf("  ... and %d more rows\n", flagged-maxReportedCharMismatches)
+	}
+	return nil
+}
+
+// queryCharCounts runs a query returning key columns followed by a character
+// count and returns the counts by key. Keys are compared case-insensitively,
+// since e.g. uniqueidentifier values are upper case in SQL Server but lower case
+// in PostgreSQL.
+func (m *migrator) queryCharCounts(db *sql.DB, query string, keyColumns int) (map[string]int64, error) {
+	rows, err := db.QueryContext(m.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	keys := make([]sql.NullString, keyColumns)
+	var count sql.NullInt64
+	dest := make([]interface{}, keyColumns+1)
+	for i := range keys {
+		dest[i] = &keys[i]
+	}
+	dest[keyColumns] = &count
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		values := make([]string, keyColumns)
+		for i, key := range keys {
+			values[i] = strings.ToLower(key.String)
+		}
+		counts[strings.Join(values, "\x00")] = count.Int64
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formats of the mismatched sampled rows printed by the verify phase
+const (
+	diffUnified    = "unified"
+	diffSideBySide = "side-by-side"
+)
+
+// maxDiffValueLength truncates the values printed in a diff
+const maxDiffValueLength = 60
+
+// parseDiffFormat validates a -verify-diff-format value
+func parseDiffFormat(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case diffUnified, diffSideBySide:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid diff format: %s (expected %s or %s)", value, diffUnified, diffSideBySide)
+}
+
+// sampleDiff is a sampled row that differs between source and target
+type sampleDiff struct {
+	key     string // primary key, e.g. Id=5
+	missing bool   // the row is missing in the target
+	columns []string
+	source  []string // normalized source values of columns
+	target  []string // normalized target values of columns
+}
+
+// print prints the differing values of the row: in the unified format as
+// removed (source) and added (target) lines, side by side in two columns
+// otherwise
+func (d sampleDiff) print(format string) {
+	if d.missing {
+		fmt.Printf("  %s: missing in target\n", d.key)
+		return
+	}
+	fmt.Printf("  %s: differs in %s\n", d.key, strings.Join(d.columns, ", "))
+	if format == diffUnified {
+		for i, column := range d.columns {
+			fmt.Printf("    - %s: %s\n", column, truncateDiffValue(d.source[i]))
+			fmt.Printf("    + %s: %s\n", column, truncateDiffValue(d.target[i]))
+		}
+		return
+	}
+
+	columnWidth, sourceWidth := len("column"), len("source")
+	for i, column := range d.columns {
+		columnWidth = max(columnWidth, utf8.RuneCountInString(column))
+		sourceWidth = max(sourceWidth, utf8.RuneCountInString(truncateDiffValue(d.source[i])))
+	}
+	fmt.Printf("    %-*s  %-*s | %s\n", columnWidth, "column", sourceWidth, "source", "target")
+	for i, column := range d.columns {
+		fmt.Printf("    %-*s  %-*s | %s\n", columnWidth, column, sourceWidth, truncateDiffValue(d.source[i]), truncateDiffValue(d.target[i]))
+	}
+}
+
+// truncateDiffValue shortens a value for printing, keeping it on one line
+func truncateDiffValue(value string) string {
+	value = strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(value)
+	if runes := []rune(value); len(runes) > maxDiffValueLength {
+		return string(runes[:maxDiffValueLength]) + "..."
+	}
+	return value
+}
+
+// diffWriter exports the differing values of mismatched sampled rows as CSV,
+// one line per table, key and column
+type diffWriter struct {
+	path  string
+	file  *os.File
+	csv   *csv.Writer
+	count int
+}
+
+// newDiffWriter creates (or truncates) the diff file
+func newDiffWriter(path string) (*diffWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating diff file: %v", err)
+	}
+	w := &diffWriter{path: path, file: file, csv: csv.NewWriter(file)}
+	w.csv.Write([]string{"table", "key", "column", "source", "target"})
+	return w, nil
+}
+
+// write records a mismatched row; a row missing in the target has no column
+func (w *diffWriter) write(table string, d sampleDiff) error {
+	if d.missing {
+		w.csv.Write([]string{table, d.key, "", "", "(missing)"})
+	}
+	for i, column := range d.columns {
+		w.csv.Write([]string{table, d.key, column, d.source[i], d.target[i]})
+	}
+	w.count++
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return fmt.Errorf("error writing diff file: %v", err)
+	}
+	return nil
+}
+
+// close closes the diff file and returns the number of rows written
+func (w *diffWriter) close() int {
+	w.csv.Flush()
+	w.file.Close()
+	return w.count
+}
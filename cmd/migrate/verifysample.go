@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// maxReportedSampleMismatches limits how many mismatched sampled rows are printed per table
+const maxReportedSampleMismatches = 10
+
+// verifySample compares a sample of rows of a table value by value between
+// source and target, looking rows up in the target by primary key. With a
+// watermark column, m.verifyRecentShare of the sample are the most recently
+// modified rows and the rest are random. Returns the number of mismatched rows.
+func (m *migrator) verifySample(table string) (int, error) {
+	parts := strings.SplitN(table, ".", 2)
+	targetSchema, targetTable := m.mapper.Map(parts[0], parts[1])
+	target := dbmigrate.QuoteQualified(targetSchema, targetTable, m.preserveCase)
+
+	columns, err := m.sourceColumns(table)
+	if err != nil {
+		return 0, err
+	}
+	key, err := m.primaryKey(table, columns)
+	if err != nil {
+		return 0, err
+	}
+	if key == nil {
+		fmt.Printf("  %s: no migrated primary key, sampled rows cannot be looked up in the target\n", table)
+		return 0, nil
+	}
+	columnTypes, err := m.getColumnTypes(table)
+	if err != nil {
+		return 0, err
+	}
+	types := make(map[string]string, len(columnTypes))
+	for _, column := range columnTypes {
+		types[column[0]] = strings.ToLower(column[1])
+	}
+	transcode, err := getTranscodeColumns(m.sourceDb, table)
+	if err != nil {
+		return 0, err
+	}
+	exprs, err := m.sourceExprs(table, transcode)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read GUIDs as text, since the driver returns them in SQL Server's byte order
+	sourceColumns := make([]string, len(columns))
+	targetColumns := make([]string, len(columns))
+	for i, column := range columns {
+		sourceColumns[i] = sourceColumnExpr(m.source, column, exprs)
+		if types[column] == "uniqueidentifier" {
+			sourceColumns[i] = fmt.Sprintf("CONVERT(NVARCHAR(36), [%s]) AS [%s]", column, column)
+		}
+		targetColumns[i] = dbmigrate.QuoteIdent(column, m.preserveCase)
+	}
+	selectList := strings.Join(sourceColumns, ", ")
+
+	// Split the sample between recently modified and random rows
+	watermark := ""
+	for _, column := range columns {
+		if m.watermarkColumn != "" && strings.EqualFold(column, m.watermarkColumn) {
+			watermark = column
+		}
+	}
+	recent := 0
+	if watermark != "" {
+		recent = int(float64(m.verifySampleSize)*m.verifyRecentShare + 0.5)
+	}
+	var sample [][]interface{}
+	seen := make(map[string]bool)
+	addRows := func(query string) error {
+		rows, err := m.sourceDb.QueryContext(m.ctx, query)
+		if err != nil {
+			return fmt.Errorf("error sampling rows of %s: %v", table, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			valuePtrs := make([]interface{}, len(columns))
+			for i := range values {
+				valuePtrs[i] = &values[i]
+			}
+			if err := rows.Scan(valuePtrs...); err != nil {
+				return err
+			}
+			if formatted := key.format(values); !seen[formatted] {
+				seen[formatted] = true
+				sample = append(sample, values)
+			}
+		}
+		return rows.Err()
+	}
+	if recent > 0 {
+		if err := addRows(fmt.Sprintf("SELECT TOP (%d) %s FROM [%s].[%s] ORDER BY [%s] DESC", recent, selectList, parts[0], parts[1], watermark)); err != nil {
+			return 0, err
+		}
+	}
+	recent = len(sample)
+	if random := m.verifySampleSize - recent; random > 0 {
+		if err := addRows(fmt.Sprintf("SELECT TOP (%d) %s FROM [%s].[%s] ORDER BY NEWID()", random, selectList, parts[0], parts[1])); err != nil {
+			return 0, err
+		}
+	}
+
+	// Look each sampled row up in the target by primary key
+	targetQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(targetColumns, ", "), target, key.targetCondition(1, m.preserveCase))
+
+	// Apply the same value conversions as the data phase before comparing
+	transform, err := m.rowTransform(table, columns, make(map[string]int64), make(map[string]int64), make(map[string]string))
+	if err != nil {
+		return 0, err
+	}
+
+	mismatches := 0
+	for _, sourceValues := range sample {
+		if transform != nil {
+			transform(sourceValues)
+		}
+		targetValues := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range targetValues {
+			valuePtrs[i] = &targetValues[i]
+		}
+
+		diff := sampleDiff{key: key.format(sourceValues)}
+		err := m.targetDb.QueryRowContext(m.ctx, targetQuery, key.values(sourceValues)...).Scan(valuePtrs...)
+		switch {
+		case err == sql.ErrNoRows:
+			diff.missing = true
+		case err != nil:
+			return mismatches, fmt.Errorf("error reading sampled row of %s from the target: %v", table, err)
+		default:
+			for i, column := range columns {
+				// PostGIS formats geometries differently from SQL Server
+				if m.postgis && dbmigrate.IsSpatial(types[column]) {
+					continue
+				}
+				source, target := normalizeSampleValue(sourceValues[i], types[column]), normalizeSampleValue(targetValues[i], types[column])
+				if source != target {
+					diff.columns = append(diff.columns, column)
+					diff.source = append(diff.source, source)
+					diff.target = append(diff.target, target)
+				}
+			}
+		}
+		if !diff.missing && len(diff.columns) == 0 {
+			continue
+		}
+		mismatches++
+		if mismatches <= maxReportedSampleMismatches {
+			diff.print(m.verifyDiffFormat)
+		}
+		if m.verifyDiffs != nil {
+			if err := m.verifyDiffs.write(table, diff); err != nil {
+				return mismatches, err
+			}
+		}
+	}
+
+	source := "random"
+	if watermark != "" {
+		source = fmt.Sprintf("%d most recent by %s, %d random", recent, watermark, len(sample)-recent)
+	}
+	if mismatches > 0 {
+		fmt.Printf("❌ %s: %d of %d sampled rows differ (%s)\n", table, mismatches, len(sample), source)
+	} else {
+		fmt.Printf("✅ %s: %d sampled rows match (%s)\n", table, len(sample), source)
+	}
+	return mismatches, nil
+}
+
+// normalizeSampleValue formats a value read from either database so that equal
+// values compare equal, using the source type for values the drivers return
+// differently
+func normalizeSampleValue(value interface{}, sourceType string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		if sourceType == "time" {
+			return v.Format("15:04:05.999999")
+		}
+		// PostgreSQL stores microseconds, SQL Server datetime2 100 nanoseconds
+		return v.UTC().Round(time.Microsecond).Format("2006-01-02 15:04:05.999999")
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		if sourceType == "bit" {
+			return strconv.FormatBool(v != 0)
+		}
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if sourceType == "real" {
+			return strconv.FormatFloat(v, 'g', -1, 32)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		return normalizeSampleValue(string(v), sourceType)
+	case string:
+		switch {
+		case numericTypes[sourceType]:
+			if r, ok := new(big.Rat).SetString(v); ok {
+				return r.RatString()
+			}
+		case sourceType == "uniqueidentifier":
+			return strings.ToLower(v)
+		case sourceType == "time" && strings.Contains(v, "."):
+			return strings.TrimSuffix(strings.TrimRight(v, "0"), ".")
+		}
+		return v
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tendant/dbmigrate"
+)
+
+// Write modes of the -write-mode flag
+const (
+	// writeModeInsert inserts rows and fails on rows already present
+	writeModeInsert = "insert"
+	// writeModeUpsert updates rows already present by primary key
+	writeModeUpsert = "upsert"
+	// writeModeIgnore skips rows already present by primary key
+	writeModeIgnore = "ignore"
+)
+
+// parseWriteMode validates a -write-mode value
+func parseWriteMode(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case writeModeInsert, writeModeUpsert, writeModeIgnore:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid write mode: %s (expected %s, %s or %s)", value, writeModeInsert, writeModeUpsert, writeModeIgnore)
+}
+
+// conflictClause returns the ON CONFLICT clause of the inserts into a table
+// for the write mode, or "" for plain inserts. Rows conflict on the primary key
+// columns; an upsert updates all other columns, including the provenance column
+// if set, with the values of the inserted row.
+func conflictClause(mode string, pkColumns, columns []string, provenanceColumn string, preserveCase bool) string {
+	if mode == writeModeInsert || len(pkColumns) == 0 {
+		return ""
+	}
+	keys := make([]string, len(pkColumns))
+	isKey := make(map[string]bool, len(pkColumns))
+	for i, column := range pkColumns {
+		keys[i] = dbmigrate.QuoteIdent(column, preserveCase)
+		isKey[column] = true
+	}
+	target := " ON CONFLICT (" + strings.Join(keys, ", ") + ")"
+
+	var updates []string
+	if mode == writeModeUpsert {
+		if provenanceColumn != "" {
+			columns = append(columns[:len(columns):len(columns)], provenanceColumn)
+		}
+		for _, column := range columns {
+			if !isKey[column] {
+				quoted := dbmigrate.QuoteIdent(column, preserveCase)
+				updates = append(updates, quoted+" = EXCLUDED."+quoted)
+			}
+		}
+	}
+	if len(updates) == 0 {
+		return target + " DO NOTHING"
+	}
+	return target + " DO UPDATE SET " + strings.Join(updates, ", ")
+}
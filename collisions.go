@@ -0,0 +1,108 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ListColumns returns the column names of every base table in the given schemas,
+// keyed by schema-qualified table name and in ordinal order
+func ListColumns(db *sql.DB, schemas []string) (map[string][]string, error) {
+	schemaFilter := ""
+	schemaParams := make([]interface{}, len(schemas))
+	for i, schema := range schemas {
+		if i > 0 {
+			schemaFilter += " OR "
+		}
+		schemaFilter += "c.TABLE_SCHEMA = @p" + fmt.Sprintf("%d", i+1)
+		schemaParams[i] = schema
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.TABLE_SCHEMA, c.TABLE_NAME, c.COLUMN_NAME
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		JOIN INFORMATION_SCHEMA.TABLES t ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME
+		WHERE t.TABLE_TYPE = 'BASE TABLE'
+		AND (%s)
+		ORDER BY c.TABLE_SCHEMA, c.TABLE_NAME, c.ORDINAL_POSITION`, schemaFilter)
+
+	rows, err := db.Query(query, schemaParams...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying columns: %v", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string][]string)
+	for rows.Next() {
+		var schema, table, column string
+		if err := rows.Scan(&schema, &table, &column); err != nil {
+			return nil, fmt.Errorf("error scanning column: %v", err)
+		}
+		columns[schema+"."+table] = append(columns[schema+"."+table], column)
+	}
+	return columns, rows.Err()
+}
+
+// CheckNameCollisions reports source tables and columns that would end up with
+// the same PostgreSQL name. Without preserveCase, unquoted identifiers are folded
+// to lowercase, so "UserID" and "userid" collide; renames can also cause collisions.
+// tables maps schema-qualified source table names to their column names.
+func CheckNameCollisions(tables map[string][]string, mapper *NameMapper, preserveCase bool) []string {
+	normalize := func(name string) string {
+		if preserveCase {
+			return name
+		}
+		return foldIdent(name)
+	}
+
+	tableNames := make([]string, 0, len(tables))
+	for table := range tables {
+		tableNames = append(tableNames, table)
+	}
+	sort.Strings(tableNames)
+
+	var collisions []string
+
+	// Tables that map to the same target name
+	targets := make(map[string][]string)
+	var targetOrder []string
+	for _, table := range tableNames {
+		parts := strings.SplitN(table, ".", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		schema, name := mapper.Map(parts[0], parts[1])
+		target := normalize(schema) + "." + normalize(name)
+		if _, ok := targets[target]; !ok {
+			targetOrder = append(targetOrder, target)
+		}
+		targets[target] = append(targets[target], table)
+	}
+	for _, target := range targetOrder {
+		if sources := targets[target]; len(sources) > 1 {
+			collisions = append(collisions, fmt.Sprintf("tables %s all map to %s", strings.Join(sources, ", "), target))
+		}
+	}
+
+	// Columns within a table that map to the same target name
+	for _, table := range tableNames {
+		seen := make(map[string][]string)
+		var order []string
+		for _, column := range tables[table] {
+			key := normalize(column)
+			if _, ok := seen[key]; !ok {
+				order = append(order, key)
+			}
+			seen[key] = append(seen[key], column)
+		}
+		for _, key := range order {
+			if sources := seen[key]; len(sources) > 1 {
+				collisions = append(collisions, fmt.Sprintf("columns %s in table %s all map to %s", strings.Join(sources, ", "), table, key))
+			}
+		}
+	}
+
+	return collisions
+}
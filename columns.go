@@ -0,0 +1,119 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Column describes a column of a source table and how it is migrated
+type Column struct {
+	Name string
+	// SourceType is the SQL Server data type, with alias types resolved
+	SourceType string
+	// AliasType is the schema-qualified alias type the column is declared with, if any
+	AliasType string
+	// TargetType is the PostgreSQL type the column is created as, or empty if
+	// the column is left out of the target table (dropped computed columns,
+	// skipped column sets and excluded rowversion columns)
+	TargetType string
+	Nullable   bool
+	// Length is the maximum length in characters (text) or bytes (binary), -1
+	// for (max) types, or 0 for other types
+	Length int64
+	// Precision and Scale are set for exact numeric types; Scale also holds the
+	// fractional seconds precision of datetime2, datetimeoffset and time
+	Precision int
+	Scale     int
+	Identity  bool
+	Computed  bool
+	// Sparse columns are created as regular nullable columns
+	Sparse bool
+	// ColumnSet is set for the xml column set of a table with sparse columns
+	ColumnSet bool
+	// Default is the SQL Server default expression, e.g. (getdate()), if any
+	Default string
+	// Description is the MS_Description extended property, which GenerateSchema
+	// turns into a column comment
+	Description string
+	// PrimaryKeyOrdinal is the 1-based position of the column in the primary key,
+	// or 0 if it is not part of it
+	PrimaryKeyOrdinal int
+}
+
+// TableColumns returns the columns of a source table (schema.table) in their
+// order in the table, with the target types GenerateSchema creates for opts
+func TableColumns(db *sql.DB, table string, opts SchemaOptions) ([]Column, error) {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.COLUMN_NAME, %s, %s, c.IS_NULLABLE,
+			c.CHARACTER_MAXIMUM_LENGTH, c.NUMERIC_PRECISION, c.NUMERIC_SCALE, c.DATETIME_PRECISION,
+			ISNULL(COLUMNPROPERTY(OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME)), c.COLUMN_NAME, 'IsIdentity'), 0),
+			ISNULL(%s, 0), ISNULL(%s, 0), ISNULL(%s, 0), c.COLUMN_DEFAULT, %s,
+			ISNULL((SELECT ic.key_ordinal
+				FROM sys.indexes i
+				JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+				JOIN sys.columns sc ON sc.object_id = ic.object_id AND sc.column_id = ic.column_id
+				WHERE i.object_id = OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME))
+				AND i.is_primary_key = 1 AND sc.name = c.COLUMN_NAME), 0)
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		WHERE c.TABLE_SCHEMA = @p1 AND c.TABLE_NAME = @p2
+		ORDER BY c.ORDINAL_POSITION`, DataTypeExpr, aliasTypeExpr, computedColumnExpr,
+		columnPropertyExpr("IsSparse"), columnPropertyExpr("IsColumnSet"), columnDescriptionExpr)
+
+	rows, err := db.Query(query, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		var alias, defaultValue, description sql.NullString
+		var nullable string
+		var length, precision, scale, datetimePrecision sql.NullInt64
+		var identity, computed, sparse, columnSet int
+		if err := rows.Scan(&column.Name, &column.SourceType, &alias, &nullable,
+			&length, &precision, &scale, &datetimePrecision,
+			&identity, &computed, &sparse, &columnSet, &defaultValue, &description, &column.PrimaryKeyOrdinal); err != nil {
+			return nil, fmt.Errorf("error scanning column: %v", err)
+		}
+		column.AliasType = alias.String
+		column.Nullable = nullable == "YES"
+		column.Length = length.Int64
+		column.Identity = identity == 1
+		column.Computed = computed == 1
+		column.Sparse = sparse == 1
+		column.ColumnSet = columnSet == 1
+		column.Default = defaultValue.String
+		column.Description = description.String
+		switch strings.ToLower(column.SourceType) {
+		case "decimal", "numeric":
+			column.Precision, column.Scale = int(precision.Int64), int(scale.Int64)
+		case "datetime2", "datetimeoffset", "time":
+			column.Scale = int(datetimePrecision.Int64)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error getting columns of %s: %v", table, err)
+	}
+
+	// Resolve the target types after reading, as spatial types query the table
+	for i, column := range columns {
+		if (column.Computed && opts.ComputedColumns == ComputedDrop) ||
+			(column.ColumnSet && opts.ColumnSets != ColumnSetsKeep) ||
+			(IsRowversion(column.SourceType) && opts.Rowversion != RowversionBytea) {
+			continue
+		}
+		if columns[i].TargetType, _, err = targetType(db, table, column.Name, column.SourceType, opts); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
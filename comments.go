@@ -0,0 +1,47 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// columnDescriptionExpr is the SQL Server expression for the MS_Description
+// extended property of column c of an INFORMATION_SCHEMA.COLUMNS row, or NULL
+const columnDescriptionExpr = `(SELECT CAST(ep.value AS NVARCHAR(MAX)) FROM sys.extended_properties ep
+				WHERE ep.class = 1 AND ep.name = 'MS_Description'
+				AND ep.major_id = OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME))
+				AND ep.minor_id = COLUMNPROPERTY(OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME)), c.COLUMN_NAME, 'ColumnId'))`
+
+// tableDescriptions returns the MS_Description extended properties of the
+// source tables by schema.table
+func tableDescriptions(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT s.name, t.name, CAST(ep.value AS NVARCHAR(MAX))
+		FROM sys.extended_properties ep
+		JOIN sys.tables t ON t.object_id = ep.major_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		WHERE ep.class = 1 AND ep.minor_id = 0 AND ep.name = 'MS_Description'`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying table descriptions: %v", err)
+	}
+	defer rows.Close()
+
+	descriptions := make(map[string]string)
+	for rows.Next() {
+		var schema, table string
+		var description sql.NullString
+		if err := rows.Scan(&schema, &table, &description); err != nil {
+			return nil, fmt.Errorf("error scanning table description: %v", err)
+		}
+		if description.String != "" {
+			descriptions[schema+"."+table] = description.String
+		}
+	}
+	return descriptions, rows.Err()
+}
+
+// QuoteLiteral formats a string literal for PostgreSQL
+func QuoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,38 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Computed column handling for SchemaOptions.ComputedColumns and the -computed-columns flag
+const (
+	// ComputedMaterialize creates computed columns as plain columns holding the
+	// values computed at migration time
+	ComputedMaterialize = "materialize"
+	// ComputedDrop leaves computed columns out of the target table
+	ComputedDrop = "drop"
+	// ComputedGenerate creates computed columns whose expression can be translated
+	// as PostgreSQL generated columns, which the data migration leaves out, and
+	// materializes the others
+	ComputedGenerate = "generate"
+)
+
+// ParseComputedColumns validates a -computed-columns value
+func ParseComputedColumns(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case ComputedMaterialize, ComputedDrop, ComputedGenerate:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid computed column handling: %s (expected %s, %s or %s)", value, ComputedMaterialize, ComputedDrop, ComputedGenerate)
+}
+
+// computedColumnExpr is the SQL Server expression telling whether column c of
+// an INFORMATION_SCHEMA.COLUMNS row is computed (1) or not (0)
+const computedColumnExpr = "COLUMNPROPERTY(OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME)), c.COLUMN_NAME, 'IsComputed')"
+
+// ComputedColumns returns the names of the computed columns of a source table (schema.table)
+func ComputedColumns(db *sql.DB, table string) ([]string, error) {
+	return columnsWithProperty(db, table, "IsComputed")
+}
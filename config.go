@@ -0,0 +1,205 @@
+// Package dbmigrate holds the logic shared by the schema generator and the
+// data migrator, such as the optional configuration file and name mapping.
+package dbmigrate
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional configuration file shared by both tools
+type Config struct {
+	// Schemas lists the source schemas to include (overridden by -schemas)
+	Schemas []string `yaml:"schemas" json:"schemas,omitempty"`
+	// IncludeTables lists the source tables to migrate (overridden by -tables)
+	IncludeTables []string `yaml:"include_tables" json:"include_tables,omitempty"`
+	// ExcludeTables lists table patterns to skip, in addition to -exclude-tables
+	ExcludeTables []string `yaml:"exclude_tables" json:"exclude_tables,omitempty"`
+	// SchemaMap maps source schema names to target schema names (e.g., dbo: public)
+	SchemaMap map[string]string `yaml:"schema_map" json:"schema_map,omitempty"`
+	// TypeMap maps source types to PostgreSQL types, overriding the built-in
+	// mapping (e.g., money: MONEY); -type-map takes precedence
+	TypeMap map[string]string `yaml:"type_map" json:"type_map,omitempty"`
+	// Tables holds per-table settings keyed by the source name (schema.table)
+	Tables map[string]TableConfig `yaml:"tables" json:"tables,omitempty"`
+	// MaterializedViews lists the reporting views to create as materialized
+	// views, in addition to -materialized-views
+	MaterializedViews []string `yaml:"materialized_views" json:"materialized_views,omitempty"`
+	// TargetDatabases maps source schemas to the connection strings of the
+	// target databases their tables are migrated to instead of the -target-dsn
+	// database; ${VAR} is replaced by the environment variable VAR. Left out of
+	// reports, as connection strings may hold passwords.
+	TargetDatabases map[string]string `yaml:"target_databases" json:"-"`
+}
+
+// TableConfig holds the settings for a single source table
+type TableConfig struct {
+	// Rename is the target table name, either "table" or "schema.table"
+	Rename string `yaml:"rename" json:"rename,omitempty"`
+	// Columns holds per-column settings keyed by the source column name
+	Columns map[string]ColumnConfig `yaml:"columns" json:"columns,omitempty"`
+	// TypeMap maps source types to PostgreSQL types for the columns of this table
+	TypeMap map[string]string `yaml:"type_map" json:"type_map,omitempty"`
+	// SliceColumn is a date/time column of an append-only table; the table is
+	// copied in date ranges of it, oldest first, with a checkpoint per range
+	SliceColumn string `yaml:"slice_column" json:"slice_column,omitempty"`
+	// SliceInterval is the length of the date ranges: day, week, month (default) or year
+	SliceInterval string `yaml:"slice_interval" json:"slice_interval,omitempty"`
+	// Tablespace is the target tablespace the table and its primary key index are
+	// created in (default: the database's default tablespace)
+	Tablespace string `yaml:"tablespace" json:"tablespace,omitempty"`
+	// DistributionColumn distributes the table on a Citus cluster by this column
+	// (with -citus)
+	DistributionColumn string `yaml:"distribution_column" json:"distribution_column,omitempty"`
+	// ColocateWith is the source table (schema.table) whose shards the shards
+	// of this distributed table are placed with (default: Citus' choice)
+	ColocateWith string `yaml:"colocate_with" json:"colocate_with,omitempty"`
+	// ReferenceTable copies the table to every node of a Citus cluster (with -citus)
+	ReferenceTable bool `yaml:"reference_table" json:"reference_table,omitempty"`
+	// HypertableColumn makes the table a TimescaleDB hypertable partitioned by
+	// this time column, which is also the default slice_column
+	HypertableColumn string `yaml:"hypertable_column" json:"hypertable_column,omitempty"`
+	// HypertableChunkInterval is the time range of each chunk of the
+	// hypertable, e.g. "1 day", or a number for integer time columns
+	// (default: TimescaleDB's, 7 days)
+	HypertableChunkInterval string `yaml:"hypertable_chunk_interval" json:"hypertable_chunk_interval,omitempty"`
+}
+
+// Intervals for TableConfig.SliceInterval
+const (
+	SliceDay   = "day"
+	SliceWeek  = "week"
+	SliceMonth = "month"
+	SliceYear  = "year"
+)
+
+// NULL policies for ColumnConfig.NullPolicy
+const (
+	NullPolicyEmptyToNull = "empty_to_null"
+	NullPolicyNullToEmpty = "null_to_empty"
+)
+
+// ColumnConfig holds the settings for a single source column
+type ColumnConfig struct {
+	// NullPolicy converts empty strings to NULL (empty_to_null) or NULL to
+	// empty strings (null_to_empty) during migration
+	NullPolicy string `yaml:"null_policy" json:"null_policy,omitempty"`
+	// Timezone is the IANA time zone (e.g., Europe/Berlin) in which the values of
+	// a datetime column are interpreted, overriding -source-timezone
+	Timezone string `yaml:"timezone" json:"timezone,omitempty"`
+	// Type is the PostgreSQL type of the column, overriding any type mapping
+	Type string `yaml:"type" json:"type,omitempty"`
+
+	// Formats of the values of the column in files loaded with load -files.
+	// DateLayout is a Go time layout (e.g., 02.01.2006 15:04) the values are
+	// parsed with, in the column's Timezone or -source-timezone.
+	DateLayout string `yaml:"date_layout" json:"date_layout,omitempty"`
+	// DecimalSeparator (default ".") and ThousandsSeparator are the characters
+	// numbers are written with (e.g., "," and "." for 1.234,56)
+	DecimalSeparator   string `yaml:"decimal_separator" json:"decimal_separator,omitempty"`
+	ThousandsSeparator string `yaml:"thousands_separator" json:"thousands_separator,omitempty"`
+	// TrueValues and FalseValues are the tokens of boolean values (e.g., ja
+	// and nein), matched case-insensitively
+	TrueValues  []string `yaml:"true_values" json:"true_values,omitempty"`
+	FalseValues []string `yaml:"false_values" json:"false_values,omitempty"`
+}
+
+// layoutCheckTime checks date layouts: a layout formatting it as itself has no
+// date or time elements. Any time but the reference time of layouts, which
+// every layout formats as itself, will do.
+var layoutCheckTime = time.Date(1999, 12, 31, 23, 59, 58, 0, time.UTC)
+
+// TableSettings returns the settings of a source table (schema.table), matched
+// case-insensitively. Safe to call on a nil config.
+func (c *Config) TableSettings(table string) TableConfig {
+	if c == nil {
+		return TableConfig{}
+	}
+	for key, settings := range c.Tables {
+		if strings.EqualFold(key, table) {
+			return settings
+		}
+	}
+	return TableConfig{}
+}
+
+// LoadConfig reads a YAML configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	for key, table := range cfg.Tables {
+		if !strings.Contains(key, ".") {
+			return nil, fmt.Errorf("invalid table key in config: %s (expected schema.table)", key)
+		}
+		switch table.SliceInterval {
+		case "", SliceDay, SliceWeek, SliceMonth, SliceYear:
+		default:
+			return nil, fmt.Errorf("invalid slice_interval for %s: %s (expected %s, %s, %s or %s)",
+				key, table.SliceInterval, SliceDay, SliceWeek, SliceMonth, SliceYear)
+		}
+		if table.SliceInterval != "" && table.SliceColumn == "" {
+			return nil, fmt.Errorf("slice_interval for %s requires slice_column", key)
+		}
+		if table.ReferenceTable && table.DistributionColumn != "" {
+			return nil, fmt.Errorf("%s cannot be both a reference table and distributed by %s", key, table.DistributionColumn)
+		}
+		if table.ColocateWith != "" && (table.DistributionColumn == "" || !strings.Contains(table.ColocateWith, ".")) {
+			return nil, fmt.Errorf("colocate_with for %s requires distribution_column and a table named schema.table", key)
+		}
+		if table.HypertableChunkInterval != "" && table.HypertableColumn == "" {
+			return nil, fmt.Errorf("hypertable_chunk_interval for %s requires hypertable_column", key)
+		}
+		if table.HypertableColumn != "" && (table.ReferenceTable || table.DistributionColumn != "") {
+			return nil, fmt.Errorf("%s cannot be both a hypertable and a Citus table", key)
+		}
+		for column, settings := range table.Columns {
+			switch settings.NullPolicy {
+			case "", NullPolicyEmptyToNull, NullPolicyNullToEmpty:
+			default:
+				return nil, fmt.Errorf("invalid null_policy for %s.%s: %s (expected %s or %s)",
+					key, column, settings.NullPolicy, NullPolicyEmptyToNull, NullPolicyNullToEmpty)
+			}
+			if settings.Timezone != "" {
+				if _, err := time.LoadLocation(settings.Timezone); err != nil {
+					return nil, fmt.Errorf("invalid timezone for %s.%s: %v", key, column, err)
+				}
+			}
+			if settings.DateLayout != "" && layoutCheckTime.Format(settings.DateLayout) == settings.DateLayout {
+				return nil, fmt.Errorf("invalid date_layout for %s.%s: %s has no date or time elements (expected a Go layout such as 02.01.2006)", key, column, settings.DateLayout)
+			}
+			if len(settings.DecimalSeparator) > 1 || len(settings.ThousandsSeparator) > 1 {
+				return nil, fmt.Errorf("decimal_separator and thousands_separator for %s.%s must be single characters", key, column)
+			}
+			if decimal := settings.DecimalSeparator; settings.ThousandsSeparator != "" && (settings.ThousandsSeparator == decimal || decimal == "" && settings.ThousandsSeparator == ".") {
+				return nil, fmt.Errorf("decimal_separator and thousands_separator for %s.%s must differ", key, column)
+			}
+			if (len(settings.TrueValues) == 0) != (len(settings.FalseValues) == 0) {
+				return nil, fmt.Errorf("true_values for %s.%s require false_values and the reverse", key, column)
+			}
+			// A column is read as a date, a number or a boolean
+			formats := 0
+			for _, set := range []bool{settings.DateLayout != "", settings.DecimalSeparator != "" || settings.ThousandsSeparator != "", len(settings.TrueValues) > 0} {
+				if set {
+					formats++
+				}
+			}
+			if formats > 1 {
+				return nil, fmt.Errorf("only one of date_layout, decimal_separator/thousands_separator and true_values/false_values can be set for %s.%s", key, column)
+			}
+		}
+	}
+
+	return &cfg, nil
+}
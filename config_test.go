@@ -0,0 +1,56 @@
+package dbmigrate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadConfigText loads a config file with the given contents
+func loadConfigText(t *testing.T, text string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dbmigrate.yaml")
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestLoadConfigColumnFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		column  string
+		wantErr string // "" if the config is valid
+	}{
+		{"date layout", "date_layout: 02.01.2006 15:04\n        timezone: Europe/Berlin", ""},
+		{"comma decimals", `decimal_separator: ","` + "\n        " + `thousands_separator: "."`, ""},
+		{"thousands separator only", `thousands_separator: ","`, ""},
+		{"boolean tokens", "true_values: [ja]\n        false_values: [nein]", ""},
+		{"layout without elements", "date_layout: dd.mm.yyyy", "has no date or time elements"},
+		{"long separator", `decimal_separator: ",,"`, "must be single characters"},
+		{"same separators", `decimal_separator: ","` + "\n        " + `thousands_separator: ","`, "must differ"},
+		{"thousands point with default decimal", `thousands_separator: "."`, "must differ"},
+		{"true values only", "true_values: [ja]", "require false_values"},
+		{"date and number", "date_layout: 2006-01-02\n        " + `decimal_separator: ","`, "only one of"},
+		{"date and boolean", "date_layout: 2006-01-02\n        true_values: [ja]\n        false_values: [nein]", "only one of"},
+		{"number and boolean", `thousands_separator: " "` + "\n        true_values: [ja]\n        false_values: [nein]", "only one of"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfigText(t, "tables:\n  sales.Orders:\n    columns:\n      Amount:\n        "+tt.column+"\n")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig() failed: %v", err)
+				}
+				if _, ok := cfg.TableSettings("SALES.ORDERS").Columns["Amount"]; !ok {
+					t.Errorf("column settings not loaded: %+v", cfg.Tables)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package dbmigrate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVOptions configures the files written by a CSVTableFile
+type CSVOptions struct {
+	// Delimiter separates the fields of a row
+	Delimiter byte
+	// Header writes the column names as the first row
+	Header bool
+	// Null represents NULL values; fields equal to it that are not NULL are
+	// quoted, as COPY ... WITH (FORMAT csv) expects
+	Null string
+}
+
+// A CSVTableFile writes the rows of a table as CSV, optionally
+// gzip-compressed, in the format COPY ... WITH (FORMAT csv) reads: values are
+// formatted as in PostgreSQL text output and quoted where needed
+type CSVTableFile struct {
+	file   *os.File
+	gzip   *gzip.Writer
+	buffer *bufio.Writer
+	opts   CSVOptions
+	line   []byte
+	Rows   int64
+}
+
+// CreateCSVTableFile creates the CSV file of a table, with the column names
+// (as in the target) as the header row if opts.Header is set
+func CreateCSVTableFile(path string, columns []string, opts CSVOptions, compress bool) (*CSVTableFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", path, err)
+	}
+	f := &CSVTableFile{file: file, opts: opts}
+	var w io.Writer = file
+	if compress {
+		f.gzip = gzip.NewWriter(file)
+		w = f.gzip
+	}
+	f.buffer = bufio.NewWriterSize(w, 256*1024)
+	if opts.Header {
+		for i, column := range columns {
+			if i > 0 {
+				f.line = append(f.line, opts.Delimiter)
+			}
+			f.line = f.appendField(f.line, column)
+		}
+		f.line = append(f.line, '\n')
+		if _, err := f.buffer.Write(f.line); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error writing %s: %v", path, err)
+		}
+	}
+	return f, nil
+}
+
+// WriteRow writes a row as a line of CSV
+func (f *CSVTableFile) WriteRow(values []interface{}) error {
+	f.line = f.line[:0]
+	for i, value := range values {
+		if i > 0 {
+			f.line = append(f.line, f.opts.Delimiter)
+		}
+		value, err := driver.DefaultParameterConverter.ConvertValue(value)
+		if err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case nil:
+			f.line = append(f.line, f.opts.Null...)
+		case int64:
+			f.line = strconv.AppendInt(f.line, v, 10)
+		case float64:
+			if s, ok := specialFloatText(v); ok {
+				f.line = append(f.line, s...)
+			} else {
+				f.line = strconv.AppendFloat(f.line, v, 'f', -1, 64)
+			}
+		case bool:
+			f.line = strconv.AppendBool(f.line, v)
+		case []byte:
+			f.line = append(f.line, `\x`...)
+			f.line = hex.AppendEncode(f.line, v)
+		case time.Time:
+			f.line = append(f.line, formatCopyTimestamp(v)...)
+		case string:
+			f.line = f.appendField(f.line, v)
+		default:
+			return fmt.Errorf("unsupported value type %T", value)
+		}
+	}
+	f.line = append(f.line, '\n')
+	if _, err := f.buffer.Write(f.line); err != nil {
+		return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+	}
+	f.Rows++
+	return nil
+}
+
+// Close flushes the rows and closes the file
+func (f *CSVTableFile) Close() error {
+	defer f.file.Close()
+	if err := f.buffer.Flush(); err != nil {
+		return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+	}
+	if f.gzip != nil {
+		if err := f.gzip.Close(); err != nil {
+			return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+		}
+	}
+	return f.file.Close()
+}
+
+// appendField appends a string field, quoted if it holds the delimiter, a
+// quote or a line break, or could be taken for NULL
+func (f *CSVTableFile) appendField(buf []byte, s string) []byte {
+	if s != f.opts.Null && !strings.ContainsAny(s, string([]byte{f.opts.Delimiter, '"', '\n', '\r'})) {
+		return append(buf, s...)
+	}
+	buf = append(buf, '"')
+	buf = append(buf, strings.ReplaceAll(s, `"`, `""`)...)
+	return append(buf, '"')
+}
+
+// A CSVTableReader reads the rows of a CSV file in the format a CSVTableFile
+// writes, or that of other tools with the same options (e.g., bcp -c with a
+// tab delimiter and no header), gzip-compressed if its name ends in .gz.
+// Unquoted fields equal to the NULL string are NULL; other fields are strings.
+type CSVTableReader struct {
+	file   *os.File
+	gzip   *gzip.Reader
+	reader *bufio.Reader
+	opts   CSVOptions
+	line   int64
+	field  []byte
+	// Columns are the names in the header row, if opts.Header is set
+	Columns []string
+}
+
+// OpenCSVTableFile opens a CSV file and reads its header row if opts.Header
+// is set
+func OpenCSVTableFile(path string, opts CSVOptions) (*CSVTableReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	r := &CSVTableReader{file: file, opts: opts, line: 1}
+	var rd io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		if r.gzip, err = gzip.NewReader(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		rd = r.gzip
+	}
+	r.reader = bufio.NewReaderSize(rd, 256*1024)
+	if opts.Header {
+		header, err := r.ReadRow()
+		if err == io.EOF {
+			err = fmt.Errorf("no header row")
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		for _, name := range header {
+			column, _ := name.(string)
+			r.Columns = append(r.Columns, column)
+		}
+	}
+	return r, nil
+}
+
+// ReadRow reads the next row, returning io.EOF after the last one
+func (r *CSVTableReader) ReadRow() ([]interface{}, error) {
+	var values []interface{}
+	r.field = r.field[:0]
+	started, quoted, inQuotes := false, false, false
+	for {
+		c, err := r.reader.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, fmt.Errorf("%s: unterminated quoted field starting before line %d", r.file.Name(), r.line)
+			}
+			if !started {
+				return nil, io.EOF
+			}
+			return append(values, r.value(quoted)), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", r.file.Name(), err)
+		}
+		started = true
+		switch {
+		case inQuotes:
+			if c == '"' {
+				// A doubled quote stands for a quote
+				if next, err := r.reader.Peek(1); err == nil && next[0] == '"' {
+					r.reader.ReadByte()
+					r.field = append(r.field, '"')
+				} else {
+					inQuotes = false
+				}
+				continue
+			}
+			if c == '\n' {
+				r.line++
+			}
+			r.field = append(r.field, c)
+		case c == '"' && len(r.field) == 0 && !quoted:
+			inQuotes, quoted = true, true
+		case c == r.opts.Delimiter:
+			values = append(values, r.value(quoted))
+			r.field, quoted = r.field[:0], false
+		case c == '\r':
+			if next, err := r.reader.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+			r.field = append(r.field, c)
+		case c == '\n':
+			r.line++
+			return append(values, r.value(quoted)), nil
+		default:
+			r.field = append(r.field, c)
+		}
+	}
+}
+
+// Line returns the line number the next row starts at
+func (r *CSVTableReader) Line() int64 {
+	return r.line
+}
+
+// value returns the field read, nil for NULL
+func (r *CSVTableReader) value(quoted bool) interface{} {
+	if !quoted && string(r.field) == r.opts.Null {
+		return nil
+	}
+	return string(r.field)
+}
+
+// Close closes the file
+func (r *CSVTableReader) Close() error {
+	if r.gzip != nil {
+		r.gzip.Close()
+	}
+	return r.file.Close()
+}
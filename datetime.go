@@ -0,0 +1,35 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target types for SQL Server datetime and datetime2 columns, which have no time
+// zone, for SchemaOptions.DatetimeType and the -datetime-type flag
+const (
+	// DatetimeTimestampTZ stores the values as instants, interpreting them in
+	// the source time zone (-source-timezone)
+	DatetimeTimestampTZ = "timestamptz"
+	// DatetimeTimestamp stores the values unchanged as TIMESTAMP WITHOUT TIME ZONE
+	DatetimeTimestamp = "timestamp"
+)
+
+// ParseDatetimeType validates a -datetime-type value
+func ParseDatetimeType(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case DatetimeTimestampTZ, DatetimeTimestamp:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid datetime type: %s (expected %s or %s)", value, DatetimeTimestampTZ, DatetimeTimestamp)
+}
+
+// IsNaiveDatetime tells whether a SQL Server type holds a date and time without
+// a time zone that is mapped according to the datetime type
+func IsNaiveDatetime(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "datetime", "datetime2":
+		return true
+	}
+	return false
+}
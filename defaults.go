@@ -0,0 +1,105 @@
+package dbmigrate
+
+import (
+	"strings"
+)
+
+// Current date and time functions of SQL Server and MySQL, by whether they
+// return UTC
+var dateDefaultFunctions = map[string]bool{
+	"getdate()":           false,
+	"current_timestamp":   false,
+	"sysdatetime()":       false,
+	"sysdatetimeoffset()": false,
+	"getutcdate()":        true,
+	"sysutcdatetime()":    true,
+	"current_timestamp()": false,
+	"now()":               false,
+	"localtimestamp":      false,
+	"localtimestamp()":    false,
+	"utc_timestamp()":     true,
+}
+
+// Current date functions of MySQL, by whether they return UTC
+var currentDateFunctions = map[string]bool{
+	"curdate()":      false,
+	"current_date":   false,
+	"current_date()": false,
+	"utc_date()":     true,
+}
+
+// TranslateDateDefault translates the SQL Server or MySQL default of a date or
+// time column, such as (getdate()) on a CreatedAt column, to a PostgreSQL default
+// for the column's target type pgType. It returns false for other defaults and
+// target types. GETDATE() and SYSDATETIME() return the local time of the
+// source server, which a TIMESTAMP column gets as LOCALTIMESTAMP, the local
+// time of the target session.
+func TranslateDateDefault(definition, pgType string) (string, bool) {
+	expr := strings.ToLower(strings.Join(strings.Fields(definition), ""))
+	for len(expr) > 1 && expr[0] == '(' && expr[len(expr)-1] == ')' {
+		expr = expr[1 : len(expr)-1]
+	}
+	// CONVERT([date],getdate()) or CAST(getdate() AS date)
+	toDate := false
+	if inner, ok := strings.CutPrefix(expr, "convert([date],"); ok {
+		expr, toDate = strings.TrimSuffix(inner, ")"), true
+	} else if inner, ok := strings.CutPrefix(expr, "cast("); ok {
+		for _, suffix := range []string{"as[date])", "asdate)"} {
+			if function, ok := strings.CutSuffix(inner, suffix); ok {
+				expr, toDate = function, true
+			}
+		}
+	}
+	// MySQL gives the fractional seconds precision: CURRENT_TIMESTAMP(3)
+	if function, precision, ok := strings.Cut(expr, "("); ok && strings.Trim(precision, "0123456789") == ")" && precision != ")" {
+		expr = function + "()"
+	}
+	utc, ok := dateDefaultFunctions[expr]
+	if !ok {
+		if utc, ok = currentDateFunctions[expr]; !ok {
+			return "", false
+		}
+		toDate = true
+	}
+
+	switch baseType := baseTypeName(pgType); {
+	case baseType == "DATE":
+		if utc {
+			return "((now() AT TIME ZONE 'UTC')::date)", true
+		}
+		return "CURRENT_DATE", true
+	case toDate:
+		return "", false
+	case baseType == "TIMESTAMPTZ" || baseType == "TIMESTAMP WITH TIME ZONE":
+		return "now()", true
+	case IsTimestampWithoutTimeZone(pgType):
+		if utc {
+			return "(now() AT TIME ZONE 'UTC')", true
+		}
+		return "LOCALTIMESTAMP", true
+	}
+	return "", false
+}
+
+// TranslateUUIDDefault translates the SQL Server default (newid()) or
+// (newsequentialid()) of a uniqueidentifier column to gen_random_uuid() for
+// the column's target type pgType: UUID, or text when it is mapped to a
+// character type. It returns false for other defaults and target types.
+// gen_random_uuid() is built into PostgreSQL 13 and later and provided by
+// pgcrypto before. Its UUIDs are random, not sequential.
+func TranslateUUIDDefault(definition, pgType string) (string, bool) {
+	expr := strings.ToLower(strings.Join(strings.Fields(definition), ""))
+	for len(expr) > 1 && expr[0] == '(' && expr[len(expr)-1] == ')' {
+		expr = expr[1 : len(expr)-1]
+	}
+	if expr != "newid()" && expr != "newsequentialid()" {
+		return "", false
+	}
+	switch baseTypeName(pgType) {
+	case "UUID":
+		return "gen_random_uuid()", true
+	case "TEXT", "VARCHAR", "CHARACTER VARYING", "CHAR", "CHARACTER", "BPCHAR":
+		return "(gen_random_uuid()::text)", true
+	}
+	return "", false
+}
@@ -0,0 +1,160 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ForeignKeyDependencies returns, for each source table (schema.table) with
+// foreign keys, the tables it references, including itself for self-references
+func ForeignKeyDependencies(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT OBJECT_SCHEMA_NAME(fk.parent_object_id), OBJECT_NAME(fk.parent_object_id),
+			OBJECT_SCHEMA_NAME(fk.referenced_object_id), OBJECT_NAME(fk.referenced_object_id)
+		FROM sys.foreign_keys fk`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying foreign keys: %v", err)
+	}
+	defer rows.Close()
+
+	dependencies := make(map[string][]string)
+	for rows.Next() {
+		var schema, table, referencedSchema, referencedTable string
+		if err := rows.Scan(&schema, &table, &referencedSchema, &referencedTable); err != nil {
+			return nil, fmt.Errorf("error scanning foreign key: %v", err)
+		}
+		key := schema + "." + table
+		dependencies[key] = append(dependencies[key], referencedSchema+"."+referencedTable)
+	}
+	return dependencies, rows.Err()
+}
+
+// OrderByDependencies orders tables so that each comes after the tables it
+// references (within tables), keeping the given order where there is no
+// dependency. Tables in a foreign key cycle cannot be ordered this way; they are
+// returned as groups in cycles, each placed together in the order. Table names
+// are matched case-insensitively.
+func OrderByDependencies(tables []string, dependencies map[string][]string) (ordered []string, cycles [][]string) {
+	index := make(map[string]int, len(tables))
+	for i, table := range tables {
+		index[strings.ToLower(table)] = i
+	}
+	references := make([][]int, len(tables))
+	selfReferencing := make([]bool, len(tables))
+	for table, referenced := range dependencies {
+		from, ok := index[strings.ToLower(table)]
+		if !ok {
+			continue
+		}
+		for _, target := range referenced {
+			to, ok := index[strings.ToLower(target)]
+			switch {
+			case !ok:
+			case to == from:
+				selfReferencing[from] = true
+			default:
+				references[from] = append(references[from], to)
+			}
+		}
+	}
+
+	// Tarjan's algorithm finds the strongly connected components in an order
+	// where every component comes after the components it references
+	counter := 0
+	order := make([]int, len(tables)) // visit order + 1, 0 = not visited
+	low := make([]int, len(tables))
+	onStack := make([]bool, len(tables))
+	var stack []int
+	var visit func(i int)
+	visit = func(i int) {
+		counter++
+		order[i], low[i] = counter, counter
+		stack = append(stack, i)
+		onStack[i] = true
+		for _, j := range references[i] {
+			if order[j] == 0 {
+				visit(j)
+				low[i] = min(low[i], low[j])
+			} else if onStack[j] {
+				low[i] = min(low[i], order[j])
+			}
+		}
+		if low[i] != order[i] {
+			return
+		}
+		var component []int
+		for {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[j] = false
+			component = append(component, j)
+			if j == i {
+				break
+			}
+		}
+		// Members of a component keep their given order
+		for a := 1; a < len(component); a++ {
+			for b := a; b > 0 && component[b] < component[b-1]; b-- {
+				component[b], component[b-1] = component[b-1], component[b]
+			}
+		}
+		var group []string
+		for _, j := range component {
+			group = append(group, tables[j])
+		}
+		if len(component) > 1 || selfReferencing[i] {
+			cycles = append(cycles, group)
+		}
+		ordered = append(ordered, group...)
+	}
+	for i := range tables {
+		if order[i] == 0 {
+			visit(i)
+		}
+	}
+	return ordered, cycles
+}
+
+// ForeignKeyGroups groups tables connected by foreign keys (within tables),
+// directly or through other tables, returning for each table (lowercase) the
+// index of its group. Groups are numbered in the order of their first table.
+func ForeignKeyGroups(tables []string, dependencies map[string][]string) map[string]int {
+	index := make(map[string]int, len(tables))
+	for i, table := range tables {
+		index[strings.ToLower(table)] = i
+	}
+	parent := make([]int, len(tables))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for table, referenced := range dependencies {
+		from, ok := index[strings.ToLower(table)]
+		if !ok {
+			continue
+		}
+		for _, target := range referenced {
+			if to, ok := index[strings.ToLower(target)]; ok {
+				parent[find(from)] = find(to)
+			}
+		}
+	}
+
+	groups := make(map[string]int, len(tables))
+	numbers := make(map[int]int)
+	for i, table := range tables {
+		root := find(i)
+		if _, ok := numbers[root]; !ok {
+			numbers[root] = len(numbers)
+		}
+		groups[strings.ToLower(table)] = numbers[root]
+	}
+	return groups
+}
@@ -0,0 +1,149 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Endpoint profile names that are not profiles themselves
+const (
+	// EndpointAuto selects the profile matching the host of the connection string
+	EndpointAuto = "auto"
+	// EndpointNone applies no profile
+	EndpointNone = "none"
+)
+
+// EndpointProfile is a preset of connection parameters for a hosted SQL Server
+// offering. The parameters are added to a connection string only if it does not
+// set them itself.
+type EndpointProfile struct {
+	Name        string
+	Description string
+	// HostSuffixes are the host name suffixes the profile is selected for with
+	// EndpointAuto; profiles without suffixes must be selected by name
+	HostSuffixes []string
+	// Params are the connection parameters to add; "{host}" in a value is
+	// replaced with the host of the connection string
+	Params map[string]string
+}
+
+// endpointProfiles holds the registered profiles by name
+var endpointProfiles = map[string]EndpointProfile{}
+
+func init() {
+	RegisterEndpointProfile(EndpointProfile{
+		Name:         "aws-rds",
+		Description:  "Amazon RDS for SQL Server",
+		HostSuffixes: []string{".rds.amazonaws.com"},
+		Params: map[string]string{
+			"server sni": "disable",
+			// Use the host from the connection string, not a localhost resolution
+			"server": "{host}",
+		},
+	})
+	RegisterEndpointProfile(EndpointProfile{
+		Name:         "azure-sql",
+		Description:  "Azure SQL Database and Azure SQL Managed Instance",
+		HostSuffixes: []string{".database.windows.net", ".database.usgovcloudapi.net", ".database.chinacloudapi.cn"},
+		Params: map[string]string{
+			// Azure SQL rejects unencrypted connections
+			"encrypt":                "true",
+			"TrustServerCertificate": "false",
+			"hostNameInCertificate":  "{host}",
+		},
+	})
+	RegisterEndpointProfile(EndpointProfile{
+		Name:        "gcp-cloudsql",
+		Description: "Google Cloud SQL for SQL Server",
+		// Cloud SQL is reached by IP address, so there are no host suffixes
+		Params: map[string]string{
+			// The server certificate is signed by the instance's own CA; pass
+			// certificate=<server-ca.pem> and TrustServerCertificate=false to verify it
+			"encrypt":                "true",
+			"TrustServerCertificate": "true",
+		},
+	})
+}
+
+// RegisterEndpointProfile adds a profile, replacing any profile with the same name
+func RegisterEndpointProfile(profile EndpointProfile) {
+	endpointProfiles[profile.Name] = profile
+}
+
+// EndpointProfiles returns the registered profiles sorted by name
+func EndpointProfiles() []EndpointProfile {
+	profiles := make([]EndpointProfile, 0, len(endpointProfiles))
+	for _, profile := range endpointProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// EndpointProfileNames returns the values accepted by -endpoint-profile
+func EndpointProfileNames() []string {
+	names := []string{EndpointAuto, EndpointNone}
+	for _, profile := range EndpointProfiles() {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// ResolveEndpointProfile returns the profile to use for a host: the named one,
+// the one matching the host with EndpointAuto (or an empty name), or nil.
+func ResolveEndpointProfile(name string, host string) (*EndpointProfile, error) {
+	switch name {
+	case EndpointNone:
+		return nil, nil
+	case "", EndpointAuto:
+		host = strings.ToLower(host)
+		for _, profile := range EndpointProfiles() {
+			for _, suffix := range profile.HostSuffixes {
+				if strings.HasSuffix(host, suffix) {
+					return &profile, nil
+				}
+			}
+		}
+		return nil, nil
+	}
+	profile, ok := endpointProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown endpoint profile %q (expected one of: %s)", name, strings.Join(EndpointProfileNames(), ", "))
+	}
+	return &profile, nil
+}
+
+// apply adds the profile's parameters that are not set in params. Parameter
+// names are compared case-insensitively, as the driver does.
+func (p *EndpointProfile) apply(params map[string]string, host string) {
+	set := make(map[string]bool, len(params))
+	for key := range params {
+		set[strings.ToLower(key)] = true
+	}
+	for key, value := range p.Params {
+		if !set[strings.ToLower(key)] {
+			params[key] = strings.ReplaceAll(value, "{host}", host)
+		}
+	}
+}
+
+// sqlServerHost returns the host name of a SQL Server connection string
+func sqlServerHost(dsn string) string {
+	if i := strings.Index(dsn, "?"); i >= 0 {
+		dsn = dsn[:i]
+	}
+	if i := strings.Index(dsn, "://"); i >= 0 {
+		dsn = dsn[i+3:]
+	}
+	if i := strings.LastIndex(dsn, "@"); i >= 0 {
+		dsn = dsn[i+1:]
+	}
+	if i := strings.IndexAny(dsn, "/\\"); i >= 0 {
+		dsn = dsn[:i]
+	}
+	if i := strings.Index(dsn, ":"); i >= 0 {
+		dsn = dsn[:i]
+	}
+	return dsn
+}
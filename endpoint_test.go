@@ -0,0 +1,155 @@
+package dbmigrate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// splitDsn splits a connection string into its base and its parameters, which
+// PrepareSqlServerDsn writes in no particular order
+func splitDsn(t *testing.T, dsn string) (string, map[string]string) {
+	t.Helper()
+	base, query, _ := strings.Cut(dsn, "?")
+	params := make(map[string]string)
+	for _, param := range strings.Split(query, "&") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			t.Fatalf("invalid parameter %q in %s", param, dsn)
+		}
+		if _, ok := params[key]; ok {
+			t.Fatalf("parameter %q repeated in %s", key, dsn)
+		}
+		params[key] = value
+	}
+	return base, params
+}
+
+// withDefaults returns params with the parameters PrepareSqlServerDsn adds to
+// every connection string that does not set them
+func withDefaults(params map[string]string) map[string]string {
+	all := map[string]string{"database": "sales", "connection timeout": "30", "dial timeout": "10", "browser": "disable", "encrypt": "disable"}
+	for key, value := range params {
+		for existing := range all {
+			if strings.EqualFold(existing, key) {
+				delete(all, existing)
+			}
+		}
+		all[key] = value
+	}
+	return all
+}
+
+func TestPrepareSqlServerDsnEndpointProfiles(t *testing.T) {
+	const (
+		rdsHost   = "orders.c1x2y3.us-east-1.rds.amazonaws.com"
+		azureHost = "orders.database.windows.net"
+	)
+	tests := []struct {
+		name    string
+		dsn     string
+		profile string
+		want    map[string]string
+	}{
+		// Auto-detection by host name
+		{"auto detects RDS", "sqlserver://sa:pw@" + rdsHost + ":1433?database=sales", EndpointAuto,
+			withDefaults(map[string]string{"server sni": "disable", "server": rdsHost})},
+		{"empty profile is auto", "sqlserver://sa:pw@" + rdsHost + "?database=sales", "",
+			withDefaults(map[string]string{"server sni": "disable", "server": rdsHost})},
+		{"auto detects RDS case-insensitively", "sqlserver://sa:pw@ORDERS.C1X2Y3.US-EAST-1.RDS.AMAZONAWS.COM?database=sales", EndpointAuto,
+			withDefaults(map[string]string{"server sni": "disable", "server": "ORDERS.C1X2Y3.US-EAST-1.RDS.AMAZONAWS.COM"})},
+		{"auto detects Azure SQL", "sqlserver://sa:pw@" + azureHost + "?database=sales", EndpointAuto,
+			withDefaults(map[string]string{"encrypt": "true", "TrustServerCertificate": "false", "hostNameInCertificate": azureHost})},
+		{"auto detects Azure Government", "sqlserver://sa:pw@orders.database.usgovcloudapi.net?database=sales", EndpointAuto,
+			withDefaults(map[string]string{"encrypt": "true", "TrustServerCertificate": "false", "hostNameInCertificate": "orders.database.usgovcloudapi.net"})},
+		{"Cloud SQL is reached by IP and not detected", "sqlserver://sa:pw@10.20.0.3?database=sales", EndpointAuto,
+			withDefaults(nil)},
+		{"other hosts get no profile", "sqlserver://sa:pw@localhost:1433?database=sales", EndpointAuto,
+			withDefaults(nil)},
+		{"suffix must match the end of the host", "sqlserver://sa:pw@rds.amazonaws.com.example.org?database=sales", EndpointAuto,
+			withDefaults(nil)},
+
+		// Explicit profiles
+		{"none on an RDS host", "sqlserver://sa:pw@" + rdsHost + "?database=sales", EndpointNone,
+			withDefaults(nil)},
+		{"aws-rds on a CNAME", "sqlserver://sa:pw@db.example.com?database=sales", "aws-rds",
+			withDefaults(map[string]string{"server sni": "disable", "server": "db.example.com"})},
+		{"azure-sql on a private endpoint", "sqlserver://sa:pw@10.1.0.4?database=sales", "azure-sql",
+			withDefaults(map[string]string{"encrypt": "true", "TrustServerCertificate": "false", "hostNameInCertificate": "10.1.0.4"})},
+		{"gcp-cloudsql", "sqlserver://sa:pw@10.20.0.3:1433?database=sales", "gcp-cloudsql",
+			withDefaults(map[string]string{"encrypt": "true", "TrustServerCertificate": "true"})},
+		{"explicit profile wins over the host", "sqlserver://sa:pw@" + azureHost + "?database=sales", "gcp-cloudsql",
+			withDefaults(map[string]string{"encrypt": "true", "TrustServerCertificate": "true"})},
+
+		// Parameters of the connection string take precedence
+		{"user encrypt kept", "sqlserver://sa:pw@" + azureHost + "?database=sales&encrypt=strict", EndpointAuto,
+			withDefaults(map[string]string{"encrypt": "strict", "TrustServerCertificate": "false", "hostNameInCertificate": azureHost})},
+		{"user parameters matched case-insensitively", "sqlserver://sa:pw@" + azureHost + "?database=sales&Encrypt=strict&trustservercertificate=true", EndpointAuto,
+			withDefaults(map[string]string{"Encrypt": "strict", "trustservercertificate": "true", "hostNameInCertificate": azureHost})},
+		{"user server kept", "sqlserver://sa:pw@" + rdsHost + "?database=sales&server=10.0.0.5&server sni=enable", EndpointAuto,
+			withDefaults(map[string]string{"server sni": "enable", "server": "10.0.0.5"})},
+		{"user defaults kept without a profile", "sqlserver://sa:pw@localhost?database=sales&connection timeout=5&Dial Timeout=3", EndpointNone,
+			withDefaults(map[string]string{"connection timeout": "5", "Dial Timeout": "3"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PrepareSqlServerDsn(tt.dsn, tt.profile)
+			if err != nil {
+				t.Fatalf("PrepareSqlServerDsn(%q, %q) failed: %v", tt.dsn, tt.profile, err)
+			}
+			wantBase, _, _ := strings.Cut(tt.dsn, "?")
+			base, params := splitDsn(t, got)
+			if base != wantBase {
+				t.Errorf("base = %q, want %q", base, wantBase)
+			}
+			if !reflect.DeepEqual(params, tt.want) {
+				t.Errorf("parameters = %v, want %v", params, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareSqlServerDsnUnknownProfile(t *testing.T) {
+	for _, profile := range []string{"aws", "AWS-RDS", "azure"} {
+		_, err := PrepareSqlServerDsn("sqlserver://sa:pw@localhost?database=sales", profile)
+		if err == nil {
+			t.Errorf("PrepareSqlServerDsn with profile %q succeeded, want an error", profile)
+			continue
+		}
+		for _, name := range EndpointProfileNames() {
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("error %q does not list profile %s", err, name)
+			}
+		}
+	}
+}
+
+func TestResolveEndpointProfile(t *testing.T) {
+	tests := []struct {
+		name, profile, host string
+		want                string // "" for no profile
+		wantErr             bool
+	}{
+		{"auto RDS", EndpointAuto, "x.rds.amazonaws.com", "aws-rds", false},
+		{"auto Azure China", EndpointAuto, "x.database.chinacloudapi.cn", "azure-sql", false},
+		{"auto unknown host", EndpointAuto, "sql.internal", "", false},
+		{"none", EndpointNone, "x.rds.amazonaws.com", "", false},
+		{"named", "gcp-cloudsql", "", "gcp-cloudsql", false},
+		{"unknown", "oracle-cloud", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := ResolveEndpointProfile(tt.profile, tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveEndpointProfile(%q, %q) error = %v, want error %v", tt.profile, tt.host, err, tt.wantErr)
+			}
+			got := ""
+			if profile != nil {
+				got = profile.Name
+			}
+			if got != tt.want {
+				t.Errorf("ResolveEndpointProfile(%q, %q) = %q, want %q", tt.profile, tt.host, got, tt.want)
+			}
+		})
+	}
+}
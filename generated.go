@@ -0,0 +1,361 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ComputedColumn is a computed column of a source table
+type ComputedColumn struct {
+	Name string
+	// Definition is the SQL Server expression of the column
+	Definition string
+	// Generated is the PostgreSQL expression the column is generated from with
+	// ComputedGenerate, or empty if Definition cannot be translated
+	Generated string
+	// Reason tells why Definition cannot be translated
+	Reason string
+}
+
+// ComputedColumnDefinitions returns the computed columns of a source table
+// (schema.table) with their expressions translated to PostgreSQL where possible
+func ComputedColumnDefinitions(db *sql.DB, table string, preserveCase bool) ([]ComputedColumn, error) {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.COLUMN_NAME, %s, cc.definition
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		LEFT JOIN sys.computed_columns cc
+			ON cc.object_id = OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME))
+			AND cc.name = c.COLUMN_NAME
+		WHERE c.TABLE_SCHEMA = @p1 AND c.TABLE_NAME = @p2
+		ORDER BY c.ORDINAL_POSITION`, DataTypeExpr)
+
+	rows, err := db.Query(query, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting computed columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	var computed []ComputedColumn
+	for rows.Next() {
+		var column, dataType string
+		var definition sql.NullString
+		if err := rows.Scan(&column, &dataType, &definition); err != nil {
+			return nil, err
+		}
+		if definition.Valid {
+			computed = append(computed, ComputedColumn{Name: column, Definition: definition.String})
+			continue
+		}
+		types[strings.ToLower(column)] = strings.ToLower(dataType)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range computed {
+		generated, err := translateComputed(computed[i].Definition, types, preserveCase)
+		if err != nil {
+			computed[i].Reason = err.Error()
+			continue
+		}
+		computed[i].Generated = generated
+	}
+	return computed, nil
+}
+
+// Kinds of the values of a translated expression
+const (
+	kindNumber = "number"
+	kindText   = "text"
+)
+
+// textTypes and numberTypes are the column types a translated expression may use
+var (
+	textTypes   = map[string]bool{"char": true, "varchar": true, "nchar": true, "nvarchar": true}
+	numberTypes = map[string]bool{"tinyint": true, "smallint": true, "int": true, "bigint": true, "decimal": true,
+		"numeric": true, "money": true, "smallmoney": true, "float": true, "real": true}
+)
+
+// translateComputed translates a computed column expression to PostgreSQL. Only
+// arithmetic on numeric columns, concatenation of text columns and a few
+// functions are supported; columns are looked up in types, which holds the
+// types of the columns that are not computed by lowercase name.
+func translateComputed(definition string, types map[string]string, preserveCase bool) (string, error) {
+	tokens, err := tokenizeComputed(definition)
+	if err != nil {
+		return "", err
+	}
+	p := &computedParser{tokens: tokens, types: types, preserveCase: preserveCase}
+	expr, _, err := p.expr()
+	if err != nil {
+		return "", err
+	}
+	if p.pos < len(p.tokens) {
+		return "", fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// tokenizeComputed splits an expression into identifiers ([name] or bare words),
+// numbers, string literals and operators
+func tokenizeComputed(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated identifier")
+			}
+			tokens = append(tokens, s[i:i+end+1])
+			i += end + 1
+		case c == '\'' || ((c == 'N' || c == 'n') && i+1 < len(s) && s[i+1] == '\''):
+			start := i
+			if c != '\'' {
+				i++
+			}
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			tokens = append(tokens, s[start:i+1])
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			start := i
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(s) && (unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i])) || s[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		case strings.ContainsRune("()+-*/%,", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unsupported character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// computedParser translates the tokens of a computed column expression
+type computedParser struct {
+	tokens       []string
+	pos          int
+	types        map[string]string
+	preserveCase bool
+}
+
+// peek returns the next token, or "" at the end
+func (p *computedParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// expect consumes the next token, which must be want
+func (p *computedParser) expect(want string) error {
+	if p.peek() != want {
+		return fmt.Errorf("expected %s", want)
+	}
+	p.pos++
+	return nil
+}
+
+// expr parses additions and subtractions; + concatenates text
+func (p *computedParser) expr() (string, string, error) {
+	left, kind, err := p.term()
+	if err != nil {
+		return "", "", err
+	}
+	for op := p.peek(); op == "+" || op == "-"; op = p.peek() {
+		p.pos++
+		right, rightKind, err := p.term()
+		if err != nil {
+			return "", "", err
+		}
+		switch {
+		case kind != rightKind:
+			return "", "", fmt.Errorf("mixes text and numbers")
+		case kind == kindText && op == "-":
+			return "", "", fmt.Errorf("subtracts text")
+		case kind == kindText:
+			op = "||"
+		}
+		left = left + " " + op + " " + right
+	}
+	return left, kind, nil
+}
+
+// term parses multiplications, divisions and modulo
+func (p *computedParser) term() (string, string, error) {
+	left, kind, err := p.unary()
+	if err != nil {
+		return "", "", err
+	}
+	for op := p.peek(); op == "*" || op == "/" || op == "%"; op = p.peek() {
+		p.pos++
+		right, rightKind, err := p.unary()
+		if err != nil {
+			return "", "", err
+		}
+		if kind != kindNumber || rightKind != kindNumber {
+			return "", "", fmt.Errorf("uses %s on text", op)
+		}
+		left = left + " " + op + " " + right
+	}
+	return left, kind, nil
+}
+
+// unary parses a negation or a primary expression
+func (p *computedParser) unary() (string, string, error) {
+	if p.peek() != "-" {
+		return p.primary()
+	}
+	p.pos++
+	operand, kind, err := p.unary()
+	if err != nil {
+		return "", "", err
+	}
+	if kind != kindNumber {
+		return "", "", fmt.Errorf("negates text")
+	}
+	if strings.HasPrefix(operand, "-") {
+		// "--" starts a comment
+		return "-(" + operand + ")", kind, nil
+	}
+	return "-" + operand, kind, nil
+}
+
+// primary parses parentheses, literals, columns and function calls
+func (p *computedParser) primary() (string, string, error) {
+	token := p.peek()
+	if token == "" {
+		return "", "", fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	switch {
+	case token == "(":
+		inner, kind, err := p.expr()
+		if err != nil {
+			return "", "", err
+		}
+		if err := p.expect(")"); err != nil {
+			return "", "", err
+		}
+		return "(" + inner + ")", kind, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		return token, kindNumber, nil
+	case token[0] == '\'':
+		return token, kindText, nil
+	case token[0] == 'N' || token[0] == 'n':
+		if len(token) > 1 && token[1] == '\'' {
+			return token[1:], kindText, nil
+		}
+	}
+	if p.peek() == "(" {
+		return p.call(strings.ToLower(token))
+	}
+	if token[0] != '[' {
+		return "", "", fmt.Errorf("uses the unsupported keyword %s", token)
+	}
+
+	name := strings.Trim(token, "[]")
+	dataType, ok := p.types[strings.ToLower(name)]
+	switch {
+	case !ok:
+		return "", "", fmt.Errorf("references %s, which is not a plain column of the table", name)
+	case textTypes[dataType]:
+		return QuoteIdent(name, p.preserveCase), kindText, nil
+	case numberTypes[dataType]:
+		return QuoteIdent(name, p.preserveCase), kindNumber, nil
+	}
+	return "", "", fmt.Errorf("references %s of type %s", name, dataType)
+}
+
+// call parses the arguments of a function call and translates the function
+func (p *computedParser) call(name string) (string, string, error) {
+	p.pos++ // (
+	var args, kinds []string
+	for p.peek() != ")" {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return "", "", err
+			}
+		}
+		arg, kind, err := p.expr()
+		if err != nil {
+			return "", "", err
+		}
+		args = append(args, arg)
+		kinds = append(kinds, kind)
+	}
+	p.pos++ // )
+
+	// sameKind tells whether there are count arguments (any number if 0) of the
+	// kind of the first one
+	sameKind := func(count int) bool {
+		if len(args) == 0 || (count > 0 && len(args) != count) {
+			return false
+		}
+		for _, kind := range kinds {
+			if kind != kinds[0] {
+				return false
+			}
+		}
+		return true
+	}
+	switch name {
+	case "isnull":
+		if sameKind(2) {
+			return "COALESCE(" + strings.Join(args, ", ") + ")", kinds[0], nil
+		}
+	case "coalesce":
+		if sameKind(0) {
+			return "COALESCE(" + strings.Join(args, ", ") + ")", kinds[0], nil
+		}
+	case "upper", "lower", "ltrim", "rtrim":
+		if sameKind(1) && kinds[0] == kindText {
+			return name + "(" + args[0] + ")", kindText, nil
+		}
+	case "abs":
+		if sameKind(1) && kinds[0] == kindNumber {
+			return "abs(" + args[0] + ")", kindNumber, nil
+		}
+	case "round":
+		if sameKind(2) && kinds[0] == kindNumber {
+			return "round((" + args[0] + ")::numeric, " + args[1] + ")", kindNumber, nil
+		}
+	case "substring":
+		if len(args) == 3 && kinds[0] == kindText && kinds[1] == kindNumber && kinds[2] == kindNumber {
+			return "substring(" + strings.Join(args, ", ") + ")", kindText, nil
+		}
+	default:
+		return "", "", fmt.Errorf("uses the unsupported function %s", name)
+	}
+	return "", "", fmt.Errorf("calls %s with unsupported arguments", name)
+}
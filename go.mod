@@ -2,11 +2,16 @@ module github.com/tendant/dbmigrate
 
 go 1.24.2
 
-require github.com/denisenkom/go-mssqldb v0.12.3
+require (
+	github.com/denisenkom/go-mssqldb v0.12.3
+	github.com/go-sql-driver/mysql v1.10.1
+	github.com/lib/pq v1.10.9
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
+	filippo.io/edwards25519 v1.2.0 // indirect
 	github.com/golang-sql/civil v0.0.0-20190719163853-cb61b32ac6fe // indirect
 	github.com/golang-sql/sqlexp v0.1.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 )
@@ -0,0 +1,91 @@
+package dbmigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyKeyPrefix prefixes the state store entries holding run history
+const historyKeyPrefix = "history:"
+
+// RunRecord holds the metrics of a finished run, kept in the state store to
+// show trends across runs
+type RunRecord struct {
+	RunID      string    `json:"run_id"`
+	Status     string    `json:"status"`
+	Database   string    `json:"database,omitempty"`
+	Phases     []string  `json:"phases,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Tables     int       `json:"tables"`
+	Rows       int64     `json:"rows"`
+	Bytes      int64     `json:"bytes"`
+}
+
+// Duration returns the run duration
+func (r RunRecord) Duration() time.Duration {
+	return time.Duration(r.DurationMs) * time.Millisecond
+}
+
+// RowsPerSecond returns the average throughput of the run
+func (r RunRecord) RowsPerSecond() float64 {
+	if r.DurationMs <= 0 {
+		return 0
+	}
+	return float64(r.Rows) / (float64(r.DurationMs) / 1000)
+}
+
+// IsHistoryKey tells whether a state store key holds run history rather than a checkpoint
+func IsHistoryKey(key string) bool {
+	return strings.HasPrefix(key, historyKeyPrefix)
+}
+
+// SaveRunHistory records the metrics of a finished run in the state store
+func SaveRunHistory(store StateStore, report *Report) error {
+	migrated := 0
+	for _, table := range report.Tables {
+		if table.Status == StatusSucceeded {
+			migrated++
+		}
+	}
+	record := RunRecord{
+		RunID:      report.RunID,
+		Status:     report.Status,
+		Database:   report.Database,
+		Phases:     report.Phases,
+		StartedAt:  report.StartedAt.UTC(),
+		DurationMs: report.FinishedAt.Sub(report.StartedAt).Milliseconds(),
+		Tables:     migrated,
+		Rows:       report.TotalRows,
+		Bytes:      report.TotalBytes,
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return store.Save(Checkpoint{Table: historyKeyPrefix + report.RunID, Completed: true, Value: string(data), RunID: report.RunID})
+}
+
+// LoadRunHistory returns the recorded runs, oldest first
+func LoadRunHistory(store StateStore) ([]RunRecord, error) {
+	checkpoints, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	var records []RunRecord
+	for key, cp := range checkpoints {
+		if !IsHistoryKey(key) {
+			continue
+		}
+		var record RunRecord
+		if err := json.Unmarshal([]byte(cp.Value), &record); err != nil {
+			return nil, fmt.Errorf("error parsing run history %s: %v", key, err)
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].StartedAt.Before(records[j].StartedAt) })
+	return records, nil
+}
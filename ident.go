@@ -0,0 +1,87 @@
+package dbmigrate
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// reservedWords lists the PostgreSQL reserved key words that cannot be used as
+// unquoted identifiers (see "SQL Key Words" in the PostgreSQL documentation)
+var reservedWords = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true,
+	"array": true, "as": true, "asc": true, "asymmetric": true, "authorization": true,
+	"binary": true, "both": true, "case": true, "cast": true, "check": true,
+	"collate": true, "collation": true, "column": true, "concurrently": true, "constraint": true,
+	"create": true, "cross": true, "current_catalog": true, "current_date": true, "current_role": true,
+	"current_schema": true, "current_time": true, "current_timestamp": true, "current_user": true, "default": true,
+	"deferrable": true, "desc": true, "distinct": true, "do": true, "else": true,
+	"end": true, "except": true, "false": true, "fetch": true, "for": true,
+	"foreign": true, "freeze": true, "from": true, "full": true, "grant": true,
+	"group": true, "having": true, "ilike": true, "in": true, "initially": true,
+	"inner": true, "intersect": true, "into": true, "is": true, "isnull": true,
+	"join": true, "lateral": true, "leading": true, "left": true, "like": true,
+	"limit": true, "localtime": true, "localtimestamp": true, "natural": true, "not": true,
+	"notnull": true, "null": true, "offset": true, "on": true, "only": true,
+	"or": true, "order": true, "outer": true, "overlaps": true, "placing": true,
+	"primary": true, "references": true, "returning": true, "right": true, "select": true,
+	"session_user": true, "similar": true, "some": true, "symmetric": true, "system_user": true,
+	"table": true, "tablesample": true, "then": true, "to": true, "trailing": true,
+	"true": true, "union": true, "unique": true, "user": true, "using": true,
+	"variadic": true, "verbose": true, "when": true, "where": true, "window": true,
+	"with": true,
+}
+
+// IsReservedWord reports whether name is a PostgreSQL reserved key word
+func IsReservedWord(name string) bool {
+	return reservedWords[strings.ToLower(name)]
+}
+
+// foldIdent folds a name as PostgreSQL folds unquoted identifiers in UTF-8
+// databases: ASCII letters are lowercased, all other characters are kept
+func foldIdent(name string) string {
+	folded := []byte(name)
+	for i, c := range folded {
+		if c >= 'A' && c <= 'Z' {
+			folded[i] = c + 'a' - 'A'
+		}
+	}
+	return string(folded)
+}
+
+// needsQuoting reports whether a folded name cannot be written as an unquoted
+// identifier. Like PostgreSQL, it accepts non-ASCII letters.
+func needsQuoting(name string) bool {
+	if name == "" || reservedWords[name] {
+		return true
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= utf8.RuneSelf && r != utf8.RuneError):
+		case i > 0 && (r == '$' || (r >= '0' && r <= '9')):
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// QuoteIdent formats an identifier for PostgreSQL. With preserveCase the name is
+// always double-quoted. Otherwise it is left unquoted (and folded to lowercase by
+// PostgreSQL), except for reserved words and names with special characters, which
+// are quoted as folded by foldIdent so they refer to the same object as the
+// unquoted form.
+func QuoteIdent(name string, preserveCase bool) string {
+	if preserveCase {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	folded := foldIdent(name)
+	if needsQuoting(folded) {
+		return `"` + strings.ReplaceAll(folded, `"`, `""`) + `"`
+	}
+	return name
+}
+
+// QuoteQualified formats a schema-qualified table name for PostgreSQL
+func QuoteQualified(schema, table string, preserveCase bool) string {
+	return QuoteIdent(schema, preserveCase) + "." + QuoteIdent(table, preserveCase)
+}
@@ -0,0 +1,81 @@
+package dbmigrate
+
+import "testing"
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct {
+		name         string
+		preserveCase bool
+		want         string
+	}{
+		// Unquoted names are folded to lowercase by PostgreSQL
+		{"orders", false, "orders"},
+		{"OrderID", false, "OrderID"},
+		{"_tmp$1", false, "_tmp$1"},
+
+		// Reserved words are quoted in lowercase, in any case
+		{"user", false, `"user"`},
+		{"Order", false, `"order"`},
+		{"GROUP", false, `"group"`},
+		{"users", false, "users"},
+
+		// Special characters
+		{"Order Date", false, `"order date"`},
+		{"1st", false, `"1st"`},
+		{"a-b", false, `"a-b"`},
+		{"", false, `""`},
+		{`Say "Hi"`, false, `"say ""hi"""`},
+
+		// PostgreSQL folds only ASCII letters of unquoted names in UTF-8
+		// databases; non-ASCII letters are valid and keep their case
+		{"größe", false, "größe"},
+		{"Größe", false, "Größe"},
+		{"ÄRGER", false, "ÄRGER"},
+		{"Größe in €", false, `"größe in €"`},
+		{"ÄRGER-Grund", false, `"Ärger-grund"`},
+		{"日付", false, "日付"},
+		{"\xff", false, "\"\xff\""},
+
+		// With preserveCase every name is quoted as it is
+		{"orders", true, `"orders"`},
+		{"OrderID", true, `"OrderID"`},
+		{"user", true, `"user"`},
+		{"Order", true, `"Order"`},
+		{`Say "Hi"`, true, `"Say ""Hi"""`},
+		{"ÄRGER", true, `"ÄRGER"`},
+	}
+	for _, tt := range tests {
+		if got := QuoteIdent(tt.name, tt.preserveCase); got != tt.want {
+			t.Errorf("QuoteIdent(%q, %v) = %s, want %s", tt.name, tt.preserveCase, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteQualified(t *testing.T) {
+	tests := []struct {
+		schema, table string
+		preserveCase  bool
+		want          string
+	}{
+		{"sales", "Orders", false, "sales.Orders"},
+		{"User", "Group", false, `"user"."group"`},
+		{"Sales", "Order", true, `"Sales"."Order"`},
+	}
+	for _, tt := range tests {
+		if got := QuoteQualified(tt.schema, tt.table, tt.preserveCase); got != tt.want {
+			t.Errorf("QuoteQualified(%q, %q, %v) = %s, want %s", tt.schema, tt.table, tt.preserveCase, got, tt.want)
+		}
+	}
+}
+
+func TestCheckNameCollisionsNonASCII(t *testing.T) {
+	// PostgreSQL keeps the case of non-ASCII letters, so these do not collide
+	tables := map[string][]string{"dbo.Äpfel": {"ÄRGER", "ärger"}, "dbo.äpfel": {"id"}}
+	if collisions := CheckNameCollisions(tables, nil, false); len(collisions) != 0 {
+		t.Errorf("CheckNameCollisions() = %q, want none", collisions)
+	}
+	tables = map[string][]string{"dbo.Orders": {"UserID", "userid"}}
+	if collisions := CheckNameCollisions(tables, nil, false); len(collisions) != 1 {
+		t.Errorf("CheckNameCollisions() = %q, want one collision", collisions)
+	}
+}
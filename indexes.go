@@ -0,0 +1,213 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Secondary index handling for the -indexes flag of the data migration tool
+const (
+	// IndexesNone creates no secondary indexes
+	IndexesNone = "none"
+	// IndexesSchema creates secondary indexes together with the tables
+	IndexesSchema = "schema"
+	// IndexesAfterData creates the secondary indexes of each table once its
+	// data is loaded, which makes the load faster
+	IndexesAfterData = "after-data"
+)
+
+// ParseIndexes validates an -indexes value
+func ParseIndexes(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case IndexesNone, IndexesSchema, IndexesAfterData:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid index handling: %s (expected %s, %s or %s)", value, IndexesNone, IndexesSchema, IndexesAfterData)
+}
+
+// Index is a secondary index or unique constraint of a source table
+type Index struct {
+	Name   string
+	Unique bool
+	// Definition is the part of the CREATE INDEX statement after ON, or empty
+	// if the index cannot be created and Reason tells why
+	Definition string
+	Reason     string
+}
+
+// Statement returns the CREATE INDEX statement of the index under the given
+// name, which must be unique in the target schema
+func (i Index) Statement(name string, preserveCase bool) string {
+	unique := ""
+	if i.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s", unique, QuoteIdent(name, preserveCase), i.Definition)
+}
+
+// StatementConcurrently returns the CREATE INDEX CONCURRENTLY statement of the
+// index, which does not block writes to the table while the index is built.
+// It cannot run in a transaction, and a failed build leaves an invalid index
+// behind that has to be dropped.
+func (i Index) StatementConcurrently(name string, preserveCase bool) string {
+	unique := ""
+	if i.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX CONCURRENTLY IF NOT EXISTS %s ON %s", unique, QuoteIdent(name, preserveCase), i.Definition)
+}
+
+// indexTypes names the index types that have no PostgreSQL counterpart
+var indexTypes = map[int]string{
+	3: "XML index",
+	4: "spatial index",
+	5: "clustered columnstore index",
+	6: "nonclustered columnstore index",
+	7: "hash index",
+}
+
+// TableIndexes returns the secondary indexes and unique constraints of a source
+// table (schema.table) as CREATE INDEX statements for the target table created
+// for opts, ordered by name. Unique constraints become unique indexes and the
+// filter of a filtered index is translated like a view expression. Indexes of
+// types PostgreSQL has no counterpart for, on columns that are not migrated or
+// with a filter that cannot be translated are returned with a Reason instead.
+func TableIndexes(db *sql.DB, table string, opts SchemaOptions) ([]Index, error) {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+	}
+
+	rows, err := db.Query(`
+		SELECT i.name, i.type, i.is_unique, ISNULL(i.filter_definition, ''),
+			c.name, ic.is_descending_key, ic.is_included_column
+		FROM sys.indexes i
+		JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+		JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		WHERE i.object_id = OBJECT_ID(QUOTENAME(@p1) + '.' + QUOTENAME(@p2))
+		AND i.is_primary_key = 0 AND i.is_hypothetical = 0 AND i.type > 0
+		ORDER BY i.name, ic.is_included_column, ic.key_ordinal, ic.index_column_id`, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error querying indexes of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var indexes []*sourceIndex
+	for rows.Next() {
+		var name, filter, column string
+		var indexType int
+		var unique, descending, included bool
+		if err := rows.Scan(&name, &indexType, &unique, &filter, &column, &descending, &included); err != nil {
+			return nil, fmt.Errorf("error scanning index: %v", err)
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].name != name {
+			indexes = append(indexes, &sourceIndex{name: name, filter: filter, indexType: indexType, unique: unique})
+		}
+		index := indexes[len(indexes)-1]
+		if included {
+			index.included = append(index.included, column)
+		} else {
+			index.keys = append(index.keys, column)
+			index.descending = append(index.descending, descending)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying indexes of %s: %v", table, err)
+	}
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	// Columns left out of the target table cannot be indexed
+	columns, err := TableColumns(db, table, opts)
+	if err != nil {
+		return nil, err
+	}
+	migrated := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if column.TargetType != "" {
+			migrated[strings.ToLower(column.Name)] = true
+		}
+	}
+
+	schemaName, tableName := opts.Mapper.Map(parts[0], parts[1])
+	target := QuoteQualified(schemaName, tableName, opts.PreserveCase)
+	tablespace := opts.Config.TableSettings(table).Tablespace
+	result := make([]Index, len(indexes))
+	for i, source := range indexes {
+		result[i] = Index{Name: source.name, Unique: source.unique}
+		result[i].Definition, result[i].Reason = source.definition(target, tablespace, migrated, opts)
+	}
+	return result, nil
+}
+
+// sourceIndex is an index of a source table as read by TableIndexes
+type sourceIndex struct {
+	name, filter   string
+	indexType      int
+	unique         bool
+	keys, included []string
+	descending     []bool
+}
+
+// definition returns the part of the CREATE INDEX statement after ON for the
+// target table, or the reason the index cannot be created. migrated holds the
+// lowercase names of the columns of the target table.
+func (s *sourceIndex) definition(target, tablespace string, migrated map[string]bool, opts SchemaOptions) (string, string) {
+	if kind, ok := indexTypes[s.indexType]; ok {
+		return "", "it is a " + kind
+	}
+
+	var keys, included, missing []string
+	for i, column := range s.keys {
+		if !migrated[strings.ToLower(column)] {
+			missing = append(missing, column)
+		}
+		key := QuoteIdent(column, opts.PreserveCase)
+		if s.descending[i] {
+			key += " DESC"
+		}
+		keys = append(keys, key)
+	}
+	for _, column := range s.included {
+		if !migrated[strings.ToLower(column)] {
+			missing = append(missing, column)
+		}
+		included = append(included, QuoteIdent(column, opts.PreserveCase))
+	}
+	if len(missing) > 0 {
+		return "", "it uses columns that are not migrated: " + strings.Join(missing, ", ")
+	}
+
+	definition := target + " (" + strings.Join(keys, ", ") + ")"
+	if len(included) > 0 {
+		definition += " INCLUDE (" + strings.Join(included, ", ") + ")"
+	}
+	if tablespace != "" {
+		definition += " TABLESPACE " + QuoteIdent(tablespace, opts.PreserveCase)
+	}
+	if s.filter != "" {
+		filter, err := translateIndexFilter(s.filter, opts)
+		if err != nil {
+			return "", "its filter " + err.Error()
+		}
+		definition += " WHERE " + filter
+	}
+	return definition, ""
+}
+
+// translateIndexFilter translates the filter of a filtered index, e.g.
+// ([Status]=(1) AND [DeletedAt] IS NULL), with the view translator
+func translateIndexFilter(filter string, opts SchemaOptions) (string, error) {
+	tokens, err := tokenizeView(filter)
+	if err != nil {
+		return "", err
+	}
+	t := &viewTranslator{opts: opts}
+	out, err := t.translateBody(tokens)
+	if err != nil {
+		return "", err
+	}
+	return joinViewTokens(out), nil
+}
@@ -0,0 +1,161 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Kinds of programmable objects in an inventory
+const (
+	ObjectProcedure       = "procedure"
+	ObjectFunction        = "function"
+	ObjectTrigger         = "trigger"
+	ObjectDatabaseTrigger = "database trigger"
+	ObjectSynonym         = "synonym"
+	ObjectFullTextIndex   = "full-text index"
+)
+
+// ObjectKinds lists the kinds of programmable objects in report order
+var ObjectKinds = []string{ObjectProcedure, ObjectFunction, ObjectTrigger, ObjectDatabaseTrigger, ObjectSynonym, ObjectFullTextIndex}
+
+// PortingHints tells how each kind of object is usually ported to PostgreSQL
+var PortingHints = map[string]string{
+	ObjectProcedure:       "Rewrite in PL/pgSQL as a procedure, or as a function if it returns result sets",
+	ObjectFunction:        "Rewrite as a SQL or PL/pgSQL function; inline table-valued functions become SQL functions returning TABLE",
+	ObjectTrigger:         "Rewrite the body as a PL/pgSQL trigger function and attach it with CREATE TRIGGER; inserted/deleted become NEW/OLD (or transition tables)",
+	ObjectDatabaseTrigger: "Rewrite as an event trigger",
+	ObjectSynonym:         "PostgreSQL has no synonyms: use a view, or add the schema to search_path",
+	ObjectFullTextIndex:   "Add a tsvector column or expression with a GIN index and rewrite CONTAINS/FREETEXT queries",
+}
+
+// ProgrammableObject is a source object that the tools do not migrate
+type ProgrammableObject struct {
+	Kind   string `json:"kind"`
+	Schema string `json:"schema,omitempty"` // empty for database triggers
+	Name   string `json:"name"`
+	// Parent is the table (schema.table) of a trigger or full-text index
+	Parent string `json:"parent,omitempty"`
+	// Target is the object a synonym refers to
+	Target string `json:"target,omitempty"`
+	// Columns are the indexed columns of a full-text index
+	Columns []string `json:"columns,omitempty"`
+	// Lines is the number of lines of the T-SQL definition, or 0 if unavailable
+	Lines int `json:"lines,omitempty"`
+	// Encrypted is set for objects created WITH ENCRYPTION, whose definition cannot be read
+	Encrypted bool `json:"encrypted,omitempty"`
+	// CLR is set for objects implemented in a .NET assembly
+	CLR bool `json:"clr,omitempty"`
+}
+
+// ProgrammableObjects returns the stored procedures, functions, triggers,
+// synonyms and full-text indexes of the source database, ordered by kind,
+// schema and name. Objects in other than the given schemas are left out; all
+// schemas are included if schemas is empty. Database triggers belong to no
+// schema and are always included.
+func ProgrammableObjects(db *sql.DB, schemas []string) ([]ProgrammableObject, error) {
+	include := make(map[string]bool)
+	for _, schema := range schemas {
+		include[strings.ToLower(schema)] = true
+	}
+
+	rows, err := db.Query(`
+		SELECT o.type, s.name, o.name,
+			ISNULL(OBJECT_SCHEMA_NAME(o.parent_object_id) + '.' + OBJECT_NAME(o.parent_object_id), ''),
+			ISNULL(sn.base_object_name, ''),
+			CASE WHEN m.definition IS NULL THEN 0
+				ELSE LEN(m.definition) - LEN(REPLACE(m.definition, CHAR(10), '')) + 1 END,
+			CASE WHEN o.type IN ('P', 'FN', 'IF', 'TF', 'TR') AND m.definition IS NULL THEN 1 ELSE 0 END
+		FROM sys.objects o
+		JOIN sys.schemas s ON s.schema_id = o.schema_id
+		LEFT JOIN sys.sql_modules m ON m.object_id = o.object_id
+		LEFT JOIN sys.synonyms sn ON sn.object_id = o.object_id
+		WHERE o.is_ms_shipped = 0
+		AND o.type IN ('P', 'PC', 'X', 'FN', 'IF', 'TF', 'FS', 'FT', 'AF', 'TR', 'TA', 'SN')
+		UNION ALL
+		SELECT t.type, '', t.name, '', '',
+			CASE WHEN m.definition IS NULL THEN 0
+				ELSE LEN(m.definition) - LEN(REPLACE(m.definition, CHAR(10), '')) + 1 END,
+			CASE WHEN t.type = 'TR' AND m.definition IS NULL THEN 1 ELSE 0 END
+		FROM sys.triggers t
+		LEFT JOIN sys.sql_modules m ON m.object_id = t.object_id
+		WHERE t.parent_class = 0 AND t.is_ms_shipped = 0
+		ORDER BY 2, 3`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying programmable objects: %v", err)
+	}
+	defer rows.Close()
+
+	byKind := make(map[string][]ProgrammableObject)
+	for rows.Next() {
+		var object ProgrammableObject
+		var objectType string
+		var encrypted int
+		if err := rows.Scan(&objectType, &object.Schema, &object.Name, &object.Parent, &object.Target,
+			&object.Lines, &encrypted); err != nil {
+			return nil, fmt.Errorf("error scanning programmable object: %v", err)
+		}
+		object.Encrypted = encrypted == 1
+		switch objectType = strings.TrimSpace(objectType); objectType {
+		case "P", "PC", "X":
+			object.Kind = ObjectProcedure
+		case "FN", "IF", "TF", "FS", "FT", "AF":
+			object.Kind = ObjectFunction
+		case "TR", "TA":
+			object.Kind = ObjectTrigger
+		case "SN":
+			object.Kind = ObjectSynonym
+		}
+		object.CLR = objectType == "PC" || objectType == "FS" || objectType == "FT" || objectType == "AF" || objectType == "TA"
+		if object.Schema == "" {
+			object.Kind = ObjectDatabaseTrigger
+		} else if len(include) > 0 && !include[strings.ToLower(object.Schema)] {
+			continue
+		}
+		byKind[object.Kind] = append(byKind[object.Kind], object)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying programmable objects: %v", err)
+	}
+
+	indexRows, err := db.Query(`
+		SELECT s.name, t.name, c.name
+		FROM sys.fulltext_indexes fi
+		JOIN sys.tables t ON t.object_id = fi.object_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		JOIN sys.fulltext_index_columns fc ON fc.object_id = fi.object_id
+		JOIN sys.columns c ON c.object_id = fc.object_id AND c.column_id = fc.column_id
+		ORDER BY s.name, t.name, fc.column_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying full-text indexes: %v", err)
+	}
+	defer indexRows.Close()
+
+	for indexRows.Next() {
+		var schema, table, column string
+		if err := indexRows.Scan(&schema, &table, &column); err != nil {
+			return nil, fmt.Errorf("error scanning full-text index: %v", err)
+		}
+		if len(include) > 0 && !include[strings.ToLower(schema)] {
+			continue
+		}
+		// Full-text indexes have no name; a table has at most one
+		indexes := byKind[ObjectFullTextIndex]
+		if n := len(indexes); n > 0 && indexes[n-1].Parent == schema+"."+table {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+			continue
+		}
+		byKind[ObjectFullTextIndex] = append(indexes, ProgrammableObject{
+			Kind: ObjectFullTextIndex, Schema: schema, Name: table, Parent: schema + "." + table, Columns: []string{column},
+		})
+	}
+	if err := indexRows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying full-text indexes: %v", err)
+	}
+
+	var objects []ProgrammableObject
+	for _, kind := range ObjectKinds {
+		objects = append(objects, byKind[kind]...)
+	}
+	return objects, nil
+}
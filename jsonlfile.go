@@ -0,0 +1,192 @@
+package dbmigrate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A JSONLTableFile writes the rows of a table as JSON Lines, optionally
+// gzip-compressed: one object per row, with the columns as keys in table
+// order. Integers, floats and the values of numeric columns are numbers, bits
+// are booleans, dates and times RFC 3339 strings and binary values base64
+// strings; NULL values are null.
+type JSONLTableFile struct {
+	file    *os.File
+	gzip    *gzip.Writer
+	buffer  *bufio.Writer
+	keys    [][]byte // quoted column names, with the colon
+	numeric []bool
+	line    []byte
+	scratch bytes.Buffer
+	encoder *json.Encoder
+	Rows    int64
+}
+
+// CreateJSONLTableFile creates the JSON Lines file of a table. columns are the
+// keys (the target column names) and numeric tells which columns hold decimal
+// values as text that are written as numbers.
+func CreateJSONLTableFile(path string, columns []string, numeric []bool, compress bool) (*JSONLTableFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", path, err)
+	}
+	f := &JSONLTableFile{file: file, numeric: numeric}
+	f.encoder = json.NewEncoder(&f.scratch)
+	f.encoder.SetEscapeHTML(false)
+	for _, column := range columns {
+		key, err := f.appendString(nil, column)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		f.keys = append(f.keys, append(key, ':'))
+	}
+	var w io.Writer = file
+	if compress {
+		f.gzip = gzip.NewWriter(file)
+		w = f.gzip
+	}
+	f.buffer = bufio.NewWriterSize(w, 256*1024)
+	return f, nil
+}
+
+// WriteRow writes a row as a line holding a JSON object
+func (f *JSONLTableFile) WriteRow(values []interface{}) error {
+	f.line = append(f.line[:0], '{')
+	for i, value := range values {
+		if i > 0 {
+			f.line = append(f.line, ',')
+		}
+		f.line = append(f.line, f.keys[i]...)
+		value, err := driver.DefaultParameterConverter.ConvertValue(value)
+		if err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case nil:
+			f.line = append(f.line, "null"...)
+		case int64:
+			f.line = strconv.AppendInt(f.line, v, 10)
+		case float64:
+			// JSON has no infinity or NaN
+			if s, ok := specialFloatText(v); ok {
+				f.line = append(append(append(f.line, '"'), s...), '"')
+			} else {
+				f.line = strconv.AppendFloat(f.line, v, 'g', -1, 64)
+			}
+		case bool:
+			f.line = strconv.AppendBool(f.line, v)
+		case []byte:
+			f.line = append(f.line, '"')
+			f.line = base64.StdEncoding.AppendEncode(f.line, v)
+			f.line = append(f.line, '"')
+		case time.Time:
+			f.line = append(f.line, '"')
+			f.line = v.AppendFormat(f.line, time.RFC3339Nano)
+			f.line = append(f.line, '"')
+		case string:
+			if f.numeric[i] && json.Valid([]byte(v)) {
+				f.line = append(f.line, v...)
+			} else if f.line, err = f.appendString(f.line, v); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported value type %T", value)
+		}
+	}
+	f.line = append(f.line, '}', '\n')
+	if _, err := f.buffer.Write(f.line); err != nil {
+		return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+	}
+	f.Rows++
+	return nil
+}
+
+// Close flushes the rows and closes the file
+func (f *JSONLTableFile) Close() error {
+	defer f.file.Close()
+	if err := f.buffer.Flush(); err != nil {
+		return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+	}
+	if f.gzip != nil {
+		if err := f.gzip.Close(); err != nil {
+			return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+		}
+	}
+	return f.file.Close()
+}
+
+// appendString appends a string as a JSON string, without escaping HTML
+// characters
+func (f *JSONLTableFile) appendString(buf []byte, s string) ([]byte, error) {
+	f.scratch.Reset()
+	if err := f.encoder.Encode(s); err != nil {
+		return nil, err
+	}
+	return append(buf, bytes.TrimSuffix(f.scratch.Bytes(), []byte("\n"))...), nil
+}
+
+// A JSONLTableReader reads the rows of a JSON Lines file, one object per row,
+// gzip-compressed if its name ends in .gz. Numbers are read as json.Number, so
+// decimal values keep their digits.
+type JSONLTableReader struct {
+	file    *os.File
+	gzip    *gzip.Reader
+	decoder *json.Decoder
+	rows    int64
+}
+
+// OpenJSONLTableFile opens a JSON Lines file
+func OpenJSONLTableFile(path string) (*JSONLTableReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	r := &JSONLTableReader{file: file}
+	var rd io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		if r.gzip, err = gzip.NewReader(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		rd = r.gzip
+	}
+	r.decoder = json.NewDecoder(bufio.NewReaderSize(rd, 256*1024))
+	r.decoder.UseNumber()
+	return r, nil
+}
+
+// ReadObject reads the object of the next row, returning io.EOF after the
+// last one
+func (r *JSONLTableReader) ReadObject() (map[string]interface{}, error) {
+	var object map[string]interface{}
+	if err := r.decoder.Decode(&object); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error reading row %d of %s: %v", r.rows+1, r.file.Name(), err)
+	}
+	if object == nil {
+		return nil, fmt.Errorf("row %d of %s is not an object", r.rows+1, r.file.Name())
+	}
+	r.rows++
+	return object, nil
+}
+
+// Close closes the file
+func (r *JSONLTableReader) Close() error {
+	if r.gzip != nil {
+		r.gzip.Close()
+	}
+	return r.file.Close()
+}
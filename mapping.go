@@ -0,0 +1,111 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NameMapper translates source schema and table names to their target names
+type NameMapper struct {
+	schemas map[string]string // lowercase source schema -> target schema
+	tables  map[string]string // lowercase source schema.table -> target name
+}
+
+// ParseSchemaMap parses a comma-separated list of source=target schema pairs
+// (e.g., "dbo=public,sales=sales_v2")
+func ParseSchemaMap(value string) (map[string]string, error) {
+	schemaMap := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid schema mapping: %s (expected source=target)", pair)
+		}
+		schemaMap[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return schemaMap, nil
+}
+
+// NewNameMapper builds a mapper from the config file (may be nil) and the
+// -schema-map flag value. Mappings from the flag take precedence over the config.
+func NewNameMapper(cfg *Config, schemaMapFlag string) (*NameMapper, error) {
+	m := &NameMapper{
+		schemas: make(map[string]string),
+		tables:  make(map[string]string),
+	}
+
+	if cfg != nil {
+		for source, target := range cfg.SchemaMap {
+			m.schemas[strings.ToLower(source)] = target
+		}
+		for key, table := range cfg.Tables {
+			if table.Rename != "" {
+				m.tables[strings.ToLower(key)] = table.Rename
+			}
+		}
+	}
+
+	flagMap, err := ParseSchemaMap(schemaMapFlag)
+	if err != nil {
+		return nil, err
+	}
+	for source, target := range flagMap {
+		m.schemas[strings.ToLower(source)] = target
+	}
+
+	return m, nil
+}
+
+// NewReportNameMapper builds a mapper that maps each table of a report to the
+// target table it was migrated to
+func NewReportNameMapper(tables []TableReport) *NameMapper {
+	m := &NameMapper{
+		schemas: make(map[string]string),
+		tables:  make(map[string]string),
+	}
+	for _, table := range tables {
+		m.tables[strings.ToLower(table.Table)] = table.TargetTable
+	}
+	return m
+}
+
+// Map returns the target schema and table for a source table. SQL Server
+// identifiers are case-insensitive, so lookups ignore case.
+func (m *NameMapper) Map(schema, table string) (string, string) {
+	if m == nil {
+		return schema, table
+	}
+
+	targetSchema := schema
+	if mapped, ok := m.schemas[strings.ToLower(schema)]; ok {
+		targetSchema = mapped
+	}
+
+	rename, ok := m.tables[strings.ToLower(schema+"."+table)]
+	if !ok {
+		return targetSchema, table
+	}
+
+	// A rename may also move the table to another schema (schema.table)
+	if parts := strings.SplitN(rename, ".", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return targetSchema, rename
+}
+
+// Describe returns a human-readable list of the configured mappings
+func (m *NameMapper) Describe() []string {
+	var lines []string
+	for source, target := range m.schemas {
+		lines = append(lines, fmt.Sprintf("schema %s -> %s", source, target))
+	}
+	for source, target := range m.tables {
+		lines = append(lines, fmt.Sprintf("table %s -> %s", source, target))
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,217 @@
+package dbmigrate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SMTPConfig holds the settings for emailing reports
+type SMTPConfig struct {
+	Addr     string   // SMTP server address (host:port)
+	Username string   // optional, enables PLAIN authentication
+	Password string   // password for Username
+	From     string   // sender address
+	To       []string // recipient addresses
+}
+
+// SendReportEmail emails the report to the configured recipients, with the HTML
+// report as the message body and the JSON report as an attachment
+func SendReportEmail(cfg SMTPConfig, report *Report) error {
+	if cfg.Addr == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return fmt.Errorf("incomplete SMTP settings: server, sender and recipients are required")
+	}
+
+	htmlBody, err := report.HTML()
+	if err != nil {
+		return fmt.Errorf("error rendering HTML report: %v", err)
+	}
+	jsonBody, err := report.JSON()
+	if err != nil {
+		return fmt.Errorf("error rendering JSON report: %v", err)
+	}
+
+	subject := fmt.Sprintf("[dbmigrate] Migration %s", report.Status)
+	if report.Database != "" {
+		subject += ": " + report.Database
+	}
+
+	var msg bytes.Buffer
+	writer := multipart.NewWriter(&msg)
+
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "X-Dbmigrate-Run-Id: %s\r\n", report.RunID)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	htmlPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64(htmlPart, []byte(htmlBody))
+
+	jsonPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"application/json; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`attachment; filename="dbmigrate-report.json"`},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64(jsonPart, jsonBody)
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP server address %s: %v", cfg.Addr, err)
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	if err := sendMail(cfg.Addr, host, auth, cfg.From, cfg.To, msg.Bytes()); err != nil {
+		return fmt.Errorf("error sending email: %v", err)
+	}
+	return nil
+}
+
+// sendMail sends a message like smtp.SendMail, over a connection from dialSMTP
+func sendMail(addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := dialSMTP(addr)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("server does not support authentication")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := c.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// dialSMTP connects to an SMTP server. If HTTPS_PROXY (or https_proxy) is
+// set and NO_PROXY does not exclude the server, as for the HTTP requests of
+// the alerters, the connection is tunneled through the proxy with CONNECT.
+func dialSMTP(addr string) (net.Conn, error) {
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy setting: %v", err)
+	}
+	if proxy == nil {
+		return net.DialTimeout("tcp", addr, 30*time.Second)
+	}
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		port := "80"
+		if proxy.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", proxyAddr, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to proxy %s: %v", proxyAddr, err)
+	}
+	if proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+	}
+
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting through proxy %s: %v", proxyAddr, err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting through proxy %s: %v", proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused the connection to %s: %s", proxyAddr, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	// The greeting of the server may already be buffered
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads a connection through the reader that buffered its start
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// writeBase64 writes data base64-encoded with the 76-character lines required by MIME
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
@@ -0,0 +1,215 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PartitionSuggestionRows is the estimated row count above which partitioning
+// a table is suggested
+const PartitionSuggestionRows = 100_000_000
+
+// partitionTargetRows is the number of rows integer key ranges are sized for
+const partitionTargetRows = 50_000_000
+
+// maxMonthlyPartitions is the number of months above which date keys are
+// partitioned by year instead of by month
+const maxMonthlyPartitions = 120
+
+// Partitioning is a range partitioning scheme for a large target table
+type Partitioning struct {
+	// Column is the partition key, a primary key column, as PostgreSQL requires
+	// unique constraints of partitioned tables to include it
+	Column string
+	// Interval is "month" or "year" for date keys, or empty for integer keys
+	// partitioned in ranges of Step values
+	Interval string
+	Step     int64
+	// Bounds are the lower bounds of the partitions as SQL literals, followed
+	// by the upper bound of the last one; values outside go to a default partition
+	Bounds []string
+	// Reason tells why no scheme could be suggested, if Column is empty
+	Reason string
+}
+
+// String describes the scheme, e.g. "range of OrderDate by month, 84 partitions"
+func (p Partitioning) String() string {
+	if p.Column == "" {
+		return "no partitioning: " + p.Reason
+	}
+	by := "by " + p.Interval
+	if p.Interval == "" {
+		by = fmt.Sprintf("in steps of %d", p.Step)
+	}
+	return fmt.Sprintf("range of %s %s, %d partitions", p.Column, by, len(p.Bounds)-1)
+}
+
+// SuggestPartitioning suggests range partitioning of a source table
+// (schema.table) with the given number of rows: by month or year of a date
+// primary key column, or else in ranges of an integer primary key column sized
+// for about 50 million rows each
+func SuggestPartitioning(db *sql.DB, table string, rows int64) (Partitioning, error) {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return Partitioning{}, fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+	}
+
+	keyRows, err := db.Query(`
+		SELECT c.name, TYPE_NAME(c.system_type_id)
+		FROM sys.indexes i
+		JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+		JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		WHERE i.object_id = OBJECT_ID(QUOTENAME(@p1) + '.' + QUOTENAME(@p2)) AND i.is_primary_key = 1
+		ORDER BY ic.key_ordinal`, parts[0], parts[1])
+	if err != nil {
+		return Partitioning{}, fmt.Errorf("error querying primary key of %s: %v", table, err)
+	}
+	defer keyRows.Close()
+
+	var dateColumn, integerColumn string
+	for keyRows.Next() {
+		var column, dataType string
+		if err := keyRows.Scan(&column, &dataType); err != nil {
+			return Partitioning{}, fmt.Errorf("error scanning primary key column: %v", err)
+		}
+		switch strings.ToLower(dataType) {
+		case "date", "datetime", "datetime2", "smalldatetime", "datetimeoffset":
+			if dateColumn == "" {
+				dateColumn = column
+			}
+		case "tinyint", "smallint", "int", "bigint":
+			if integerColumn == "" {
+				integerColumn = column
+			}
+		}
+	}
+	if err := keyRows.Err(); err != nil {
+		return Partitioning{}, fmt.Errorf("error querying primary key of %s: %v", table, err)
+	}
+
+	switch {
+	case dateColumn != "":
+		var low, high sql.NullTime
+		query := fmt.Sprintf("SELECT MIN([%s]), MAX([%s]) FROM [%s].[%s]", dateColumn, dateColumn, parts[0], parts[1])
+		if err := db.QueryRow(query).Scan(&low, &high); err != nil {
+			return Partitioning{}, fmt.Errorf("error reading the range of %s.%s: %v", table, dateColumn, err)
+		}
+		if !low.Valid {
+			return Partitioning{Reason: "the table is empty"}, nil
+		}
+		return datePartitioning(dateColumn, low.Time, high.Time), nil
+	case integerColumn != "":
+		var low, high sql.NullInt64
+		query := fmt.Sprintf("SELECT MIN(CAST([%s] AS BIGINT)), MAX(CAST([%s] AS BIGINT)) FROM [%s].[%s]", integerColumn, integerColumn, parts[0], parts[1])
+		if err := db.QueryRow(query).Scan(&low, &high); err != nil {
+			return Partitioning{}, fmt.Errorf("error reading the range of %s.%s: %v", table, integerColumn, err)
+		}
+		if !low.Valid {
+			return Partitioning{Reason: "the table is empty"}, nil
+		}
+		return integerPartitioning(integerColumn, low.Int64, high.Int64, rows), nil
+	}
+	return Partitioning{Reason: "the primary key has no date or integer column"}, nil
+}
+
+// datePartitioning partitions the range low..high of a date column by month,
+// or by year if that would make too many partitions
+func datePartitioning(column string, low, high time.Time) Partitioning {
+	p := Partitioning{Column: column, Interval: "month"}
+	start := time.Date(low.Year(), low.Month(), 1, 0, 0, 0, 0, time.UTC)
+	months := (high.Year()-low.Year())*12 + int(high.Month()-low.Month()) + 1
+	step := func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	if months > maxMonthlyPartitions {
+		p.Interval = "year"
+		start = time.Date(low.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		step = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	}
+	for bound := start; ; bound = step(bound) {
+		p.Bounds = append(p.Bounds, QuoteLiteral(bound.Format("2006-01-02")))
+		if bound.After(high) {
+			break
+		}
+	}
+	return p
+}
+
+// integerPartitioning partitions the range low..high of an integer column in
+// round steps holding about partitionTargetRows of the rows each
+func integerPartitioning(column string, low, high, rows int64) Partitioning {
+	count := max((rows+partitionTargetRows-1)/partitionTargetRows, 2)
+	step := max((high-low+count)/count, 1)
+	// Round the step up to two significant digits
+	unit := int64(1)
+	for step/unit >= 100 {
+		unit *= 10
+	}
+	step = (step + unit - 1) / unit * unit
+
+	p := Partitioning{Column: column, Step: step}
+	bound := low / step * step
+	if low < 0 && low%step != 0 {
+		bound -= step
+	}
+	for ; ; bound += step {
+		p.Bounds = append(p.Bounds, strconv.FormatInt(bound, 10))
+		if bound > high {
+			break
+		}
+	}
+	return p
+}
+
+// Statements returns the statements creating the partitions of the target
+// table, which must have been created with "PARTITION BY RANGE (column)",
+// including a default partition for values outside the bounds
+func (p Partitioning) Statements(schemaName, tableName string, ifNotExists, preserveCase bool) []string {
+	createTable := "CREATE TABLE"
+	if ifNotExists {
+		createTable = "CREATE TABLE IF NOT EXISTS"
+	}
+	parent := QuoteQualified(schemaName, tableName, preserveCase)
+	var statements []string
+	for i := 0; i+1 < len(p.Bounds); i++ {
+		suffix := fmt.Sprintf("p%03d", i)
+		if p.Interval != "" {
+			date := strings.Trim(p.Bounds[i], "'")
+			suffix = "p" + strings.ReplaceAll(date[:7], "-", "_")
+			if p.Interval == "year" {
+				suffix = "p" + date[:4]
+			}
+		}
+		statements = append(statements, fmt.Sprintf("%s %s PARTITION OF %s FOR VALUES FROM (%s) TO (%s)",
+			createTable, QuoteQualified(schemaName, tableName+"_"+suffix, preserveCase), parent, p.Bounds[i], p.Bounds[i+1]))
+	}
+	return append(statements, fmt.Sprintf("%s %s PARTITION OF %s DEFAULT",
+		createTable, QuoteQualified(schemaName, tableName+"_default", preserveCase), parent))
+}
+
+// tableRowCounts returns the row counts of all user tables (schema.table) from
+// the partition stats, which are cheap to query
+func tableRowCounts(db *sql.DB) (map[string]int64, error) {
+	rows, err := db.Query(`
+		SELECT s.name, t.name, COALESCE(SUM(CASE WHEN p.index_id IN (0, 1) THEN p.row_count END), 0)
+		FROM sys.tables t
+		JOIN sys.schemas s ON t.schema_id = s.schema_id
+		LEFT JOIN sys.dm_db_partition_stats p ON p.object_id = t.object_id
+		GROUP BY s.name, t.name`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying table sizes: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var schema, table string
+		var count int64
+		if err := rows.Scan(&schema, &table, &count); err != nil {
+			return nil, fmt.Errorf("error scanning table size: %v", err)
+		}
+		counts[schema+"."+table] = count
+	}
+	return counts, rows.Err()
+}
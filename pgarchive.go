@@ -0,0 +1,300 @@
+package dbmigrate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A DumpArchive writes a pg_dump directory-format archive: a toc.dat file
+// listing the schema objects and table data, and a gzip-compressed file of COPY
+// data per table. pg_restore restores it like an archive written by pg_dump -Fd,
+// including parallel (-j) and selective (-t, -n, -L) restores, or prints it as
+// a SQL script (-f). The archive uses format version 1.14, which pg_restore 12
+// and later reads.
+type DumpArchive struct {
+	dir           string
+	database      string
+	sourceVersion string
+	created       time.Time
+	entries       []*dumpEntry
+}
+
+// Sections of archive entries, as numbered by pg_dump
+const (
+	DumpSectionPreData  = 2
+	DumpSectionData     = 3
+	DumpSectionPostData = 4
+)
+
+// dumpFormatTar is the format code in toc.dat; directory archives share the
+// code of the tar format
+const dumpFormatTar = 3
+
+// dumpEntry is an entry of the table of contents
+type dumpEntry struct {
+	id        int
+	hasData   bool
+	tag       string
+	desc      string
+	section   int
+	defn      string
+	dropStmt  string
+	copyStmt  string
+	namespace string
+	tableam   string // access method of tables, empty (NULL) for other objects
+	deps      []int
+	file      string // data file of a TABLE DATA entry
+}
+
+// NewDumpArchive creates a directory archive of a database in dir, which must
+// not hold an archive yet. sourceVersion is recorded as the version of the
+// dumped server.
+func NewDumpArchive(dir, database, sourceVersion string) (*DumpArchive, error) {
+	if _, err := os.Stat(filepath.Join(dir, "toc.dat")); err == nil {
+		return nil, fmt.Errorf("%s already holds an archive", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %v", err)
+	}
+	a := &DumpArchive{dir: dir, database: database, sourceVersion: sourceVersion, created: time.Now()}
+	// pg_restore sets these up before restoring any object
+	a.AddEntry("ENCODING", "ENCODING", "", "SET client_encoding = 'UTF8';\n", "", DumpSectionPreData)
+	a.AddEntry("STDSTRINGS", "STDSTRINGS", "", "SET standard_conforming_strings = 'on';\n", "", DumpSectionPreData)
+	a.AddEntry("SEARCHPATH", "SEARCHPATH", "", "SELECT pg_catalog.set_config('search_path', '', false);\n", "", DumpSectionPreData)
+	return a, nil
+}
+
+// AddEntry adds an object, such as a SCHEMA or an INDEX, with its statements
+// (defn) and, for pg_restore --clean, the statement dropping it. It returns
+// the ID of the entry, which later entries can depend on.
+func (a *DumpArchive) AddEntry(desc, tag, namespace, defn, dropStmt string, section int, deps ...int) int {
+	entry := &dumpEntry{id: len(a.entries) + 1, tag: tag, desc: desc, section: section,
+		defn: defn, dropStmt: dropStmt, namespace: namespace, deps: deps}
+	a.entries = append(a.entries, entry)
+	return entry.id
+}
+
+// AddTable adds a table of a schema, created by defn and dropped by dropStmt
+func (a *DumpArchive) AddTable(schema, table, defn, dropStmt string, deps ...int) int {
+	id := a.AddEntry("TABLE", table, schema, defn, dropStmt, DumpSectionPreData, deps...)
+	a.entries[id-1].tableam = "heap"
+	return id
+}
+
+// DumpTableData writes the rows of a table to its data file
+type DumpTableData struct {
+	file   *os.File
+	gzip   *gzip.Writer
+	buffer *bufio.Writer
+	line   []byte
+	Rows   int64
+}
+
+// AddTableData adds the data of the table added as tableID and returns the
+// writer of its rows. target is the quoted name of the table and columns the
+// quoted names of the columns the rows hold.
+func (a *DumpArchive) AddTableData(tableID int, schema, table, target string, columns []string) (*DumpTableData, error) {
+	id := a.AddEntry("TABLE DATA", table, schema, "", "", DumpSectionData, tableID)
+	entry := a.entries[id-1]
+	entry.hasData = true
+	entry.copyStmt = fmt.Sprintf("COPY %s (%s) FROM stdin;\n", target, strings.Join(columns, ", "))
+	entry.file = fmt.Sprintf("%d.dat", id)
+
+	file, err := os.Create(filepath.Join(a.dir, entry.file+".gz"))
+	if err != nil {
+		return nil, fmt.Errorf("error creating data file of %s: %v", table, err)
+	}
+	w := &DumpTableData{file: file, gzip: gzip.NewWriter(file)}
+	w.buffer = bufio.NewWriterSize(w.gzip, 256*1024)
+	return w, nil
+}
+
+// WriteRow writes a row as a line of COPY text
+func (w *DumpTableData) WriteRow(values []interface{}) error {
+	w.line = w.line[:0]
+	for i, value := range values {
+		if i > 0 {
+			w.line = append(w.line, '\t')
+		}
+		var err error
+		if w.line, err = appendCopyText(w.line, value); err != nil {
+			return err
+		}
+	}
+	w.line = append(w.line, '\n')
+	if _, err := w.buffer.Write(w.line); err != nil {
+		return fmt.Errorf("error writing data file: %v", err)
+	}
+	w.Rows++
+	return nil
+}
+
+// Close ends the data with the end-of-data marker pg_dump writes and closes
+// the file
+func (w *DumpTableData) Close() error {
+	defer w.file.Close()
+	w.buffer.WriteString("\\.\n\n\n")
+	if err := w.buffer.Flush(); err != nil {
+		return fmt.Errorf("error writing data file: %v", err)
+	}
+	if err := w.gzip.Close(); err != nil {
+		return fmt.Errorf("error writing data file: %v", err)
+	}
+	return w.file.Close()
+}
+
+// appendCopyText appends a value in the text format of COPY, encoded like the
+// PostgreSQL driver encodes COPY values
+func appendCopyText(buf []byte, value interface{}) ([]byte, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(value)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case nil:
+		return append(buf, `\N`...), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float64:
+		if s, ok := specialFloatText(v); ok {
+			return append(buf, s...), nil
+		}
+		return strconv.AppendFloat(buf, v, 'f', -1, 64), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case []byte:
+		buf = append(buf, `\\x`...)
+		return hex.AppendEncode(buf, v), nil
+	case time.Time:
+		return append(buf, formatCopyTimestamp(v)...), nil
+	case string:
+		return appendCopyEscaped(buf, v), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", value)
+}
+
+// specialFloatText returns the PostgreSQL text of infinite and NaN values
+func specialFloatText(v float64) (string, bool) {
+	switch {
+	case math.IsInf(v, 1):
+		return "Infinity", true
+	case math.IsInf(v, -1):
+		return "-Infinity", true
+	case math.IsNaN(v):
+		return "NaN", true
+	}
+	return "", false
+}
+
+// appendCopyEscaped appends a string with the characters COPY text treats
+// specially escaped
+func appendCopyEscaped(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			buf = append(buf, `\\`...)
+		case '\n':
+			buf = append(buf, `\n`...)
+		case '\r':
+			buf = append(buf, `\r`...)
+		case '\t':
+			buf = append(buf, `\t`...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// formatCopyTimestamp formats a time with its offset; years before 1 AD are
+// written with " BC", as PostgreSQL expects
+func formatCopyTimestamp(t time.Time) string {
+	bc := t.Year() <= 0
+	if bc {
+		t = t.AddDate(-2*t.Year()+1, 0, 0)
+	}
+	s := t.Format("2006-01-02 15:04:05.999999999Z07:00:00")
+	s = strings.TrimSuffix(s, ":00")
+	if bc {
+		s += " BC"
+	}
+	return s
+}
+
+// Close writes the table of contents, completing the archive
+func (a *DumpArchive) Close() error {
+	var b bytes.Buffer
+	writeInt := func(i int) {
+		if i < 0 {
+			b.WriteByte(1)
+			i = -i
+		} else {
+			b.WriteByte(0)
+		}
+		for n := 0; n < 4; n++ {
+			b.WriteByte(byte(i))
+			i >>= 8
+		}
+	}
+	writeStr := func(s string) {
+		writeInt(len(s))
+		b.WriteString(s)
+	}
+	writeNull := func() { writeInt(-1) }
+
+	// Header: magic, format version 1.14.0, int and offset sizes, format,
+	// compression level (-1 = gzip default) and creation time
+	b.WriteString("PGDMP")
+	b.Write([]byte{1, 14, 0, 4, 8, dumpFormatTar})
+	writeInt(-1)
+	created := a.created
+	for _, field := range []int{created.Second(), created.Minute(), created.Hour(), created.Day(), int(created.Month()) - 1, created.Year() - 1900, 0} {
+		writeInt(field)
+	}
+	writeStr(a.database)
+	writeStr(a.sourceVersion)
+	writeStr("dbmigrate")
+
+	writeInt(len(a.entries))
+	for _, entry := range a.entries {
+		writeInt(entry.id)
+		hasData := 0
+		if entry.hasData {
+			hasData = 1
+		}
+		writeInt(hasData)
+		writeStr("0") // catalog table OID
+		writeStr("0") // object OID
+		writeStr(entry.tag)
+		writeStr(entry.desc)
+		writeInt(entry.section)
+		writeStr(entry.defn)
+		writeStr(entry.dropStmt)
+		writeStr(entry.copyStmt)
+		writeStr(entry.namespace)
+		writeStr("") // tablespace
+		if entry.tableam != "" {
+			writeStr(entry.tableam)
+		} else {
+			writeNull()
+		}
+		writeStr("")      // owner; objects belong to the restoring user
+		writeStr("false") // WITH OIDS
+		for _, dep := range entry.deps {
+			writeStr(strconv.Itoa(dep))
+		}
+		writeNull()
+		writeStr(entry.file)
+	}
+	return writeFileAtomic(filepath.Join(a.dir, "toc.dat"), b.Bytes())
+}
@@ -0,0 +1,349 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Postgres is the PostgreSQL source, selected by a postgres:// connection
+// string, for copying schemas and tables between PostgreSQL clusters (e.g.,
+// production to staging). Built-in types are kept as they are and domains are
+// created as their base types.
+var Postgres Source = postgresSource{}
+
+// PostgresSystemSchemas lists the PostgreSQL system schemas that are excluded
+// by default
+var PostgresSystemSchemas = map[string]bool{
+	"pg_catalog":         true,
+	"information_schema": true,
+	"pg_toast":           true,
+}
+
+// IsPostgresDsn tells whether a connection string is a postgres:// or
+// postgresql:// URL
+func IsPostgresDsn(dsn string) bool {
+	dsn = strings.ToLower(strings.TrimSpace(dsn))
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// postgresSource reads PostgreSQL through its system catalogs
+type postgresSource struct{}
+
+func (postgresSource) Name() string { return "PostgreSQL" }
+
+func (postgresSource) Database(db *sql.DB) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT current_database()").Scan(&name)
+	return name, err
+}
+
+// Tables returns the tables of the schemas, including partitioned tables but
+// not their partitions, which are read through them
+func (postgresSource) Tables(db *sql.DB, schemas []string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT n.nspname, c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND n.nspname = ANY($1)
+		ORDER BY n.nspname, c.relname`, pq.Array(schemas))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, schema+"."+table)
+	}
+	return tables, rows.Err()
+}
+
+// Columns returns the columns of a PostgreSQL table. The source type of a
+// column of a user-defined type (e.g., an enum or an extension type such as
+// public.citext) is schema-qualified; those are created as TEXT (see
+// pgUserType).
+func (s postgresSource) Columns(db *sql.DB, table string, opts SchemaOptions) ([]Column, error) {
+	rows, err := db.Query(`
+		SELECT a.attname,
+			CASE WHEN tn.nspname = 'pg_catalog' THEN format_type(t.oid, NULL)
+				ELSE quote_ident(tn.nspname) || '.' || quote_ident(t.typname) END,
+			format_type(t.oid, CASE WHEN d.typtype = 'd' THEN d.typtypmod ELSE a.atttypmod END),
+			NOT a.attnotnull, a.attidentity <> '', a.attgenerated <> '',
+			COALESCE(pg_get_expr(ad.adbin, ad.adrelid), ''), COALESCE(col_description(a.attrelid, a.attnum), ''),
+			COALESCE(array_position(pk.indkey::int2[], a.attnum), 0)
+		FROM pg_attribute a
+		JOIN pg_type d ON d.oid = a.atttypid
+		JOIN pg_type t ON t.oid = CASE WHEN d.typtype = 'd' THEN d.typbasetype ELSE d.oid END
+		JOIN pg_namespace tn ON tn.oid = t.typnamespace
+		LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		LEFT JOIN pg_index pk ON pk.indrelid = a.attrelid AND pk.indisprimary
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, s.QuoteTable(table))
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		var pgType, defaultValue string
+		if err := rows.Scan(&column.Name, &column.SourceType, &pgType, &column.Nullable,
+			&column.Identity, &column.Computed, &defaultValue, &column.Description,
+			&column.PrimaryKeyOrdinal); err != nil {
+			return nil, fmt.Errorf("error scanning column: %v", err)
+		}
+		// The default of a generated column is its expression
+		if !column.Computed {
+			column.Default = defaultValue
+		}
+		if !column.Computed || opts.ComputedColumns != ComputedDrop {
+			column.TargetType = pgType
+			if mapped, ok := opts.TypeMapper.Lookup(table, column.Name, column.SourceType); ok {
+				column.TargetType = mapped
+			} else if pgUserType(column.SourceType) {
+				column.TargetType = "TEXT"
+			}
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error getting columns of %s: %v", table, err)
+	}
+	return columns, nil
+}
+
+// pgUserType tells whether a source type returned by Columns is a
+// user-defined type, which the target may lack; the names of built-in types
+// are not schema-qualified and contain no dot
+func pgUserType(sourceType string) bool {
+	return strings.Contains(sourceType, ".")
+}
+
+func (s postgresSource) PrimaryKey(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT a.attname
+		FROM pg_index x
+		CROSS JOIN LATERAL unnest(x.indkey::int2[]) WITH ORDINALITY AS k(attnum, ordinal)
+		JOIN pg_attribute a ON a.attrelid = x.indrelid AND a.attnum = k.attnum
+		WHERE x.indrelid = $1::regclass AND x.indisprimary AND k.ordinal <= x.indnkeyatts
+		ORDER BY k.ordinal`, s.QuoteTable(table))
+	if err != nil {
+		return nil, fmt.Errorf("error getting primary key of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// Indexes returns the secondary indexes of a PostgreSQL table as the source
+// defines them (access method, expressions, INCLUDE columns and filters) on
+// the target table. Without -preserve-case the quoted names of mixed-case
+// columns are folded to lowercase like the target columns.
+func (s postgresSource) Indexes(db *sql.DB, table string, opts SchemaOptions) ([]Index, error) {
+	rows, err := db.Query(`
+		SELECT c.relname, x.indisunique, pg_get_indexdef(x.indexrelid),
+			ARRAY(SELECT a.attname
+				FROM pg_depend d
+				JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+				WHERE d.classid = 'pg_class'::regclass AND d.objid = x.indexrelid AND d.refobjsubid > 0)
+		FROM pg_index x
+		JOIN pg_class c ON c.oid = x.indexrelid
+		WHERE x.indrelid = $1::regclass AND NOT x.indisprimary AND x.indisvalid
+		ORDER BY c.relname`, s.QuoteTable(table))
+	if err != nil {
+		return nil, fmt.Errorf("error querying indexes of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	type pgIndex struct {
+		Index
+		sourceDefinition string
+		columns          []string
+	}
+	var indexes []pgIndex
+	for rows.Next() {
+		var index pgIndex
+		if err := rows.Scan(&index.Name, &index.Unique, &index.sourceDefinition, (*pq.StringArray)(&index.columns)); err != nil {
+			return nil, fmt.Errorf("error scanning index: %v", err)
+		}
+		indexes = append(indexes, index)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying indexes of %s: %v", table, err)
+	}
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	// Columns left out of the target table cannot be indexed
+	columns, err := s.Columns(db, table, opts)
+	if err != nil {
+		return nil, err
+	}
+	migrated := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if column.TargetType != "" {
+			migrated[column.Name] = true
+		}
+	}
+
+	parts := strings.SplitN(table, ".", 2)
+	schemaName, tableName := opts.Mapper.Map(parts[0], parts[1])
+	target := QuoteQualified(schemaName, tableName, opts.PreserveCase)
+	tablespace := opts.Config.TableSettings(table).Tablespace
+	result := make([]Index, len(indexes))
+	for i, index := range indexes {
+		result[i] = index.Index
+		var missing []string
+		for _, column := range index.columns {
+			if !migrated[column] {
+				missing = append(missing, column)
+			}
+		}
+		_, method, ok := strings.Cut(index.sourceDefinition, " USING ")
+		switch {
+		case len(missing) > 0:
+			result[i].Reason = "it uses columns that are not migrated: " + strings.Join(missing, ", ")
+		case !ok:
+			result[i].Reason = "its definition could not be read: " + index.sourceDefinition
+		default:
+			if !opts.PreserveCase {
+				for _, column := range index.columns {
+					method = strings.ReplaceAll(method, s.QuoteIdent(column), QuoteIdent(column, false))
+				}
+			}
+			if tablespace != "" {
+				clause := " TABLESPACE " + QuoteIdent(tablespace, opts.PreserveCase)
+				if before, filter, ok := strings.Cut(method, " WHERE "); ok {
+					method = before + clause + " WHERE " + filter
+				} else {
+					method += clause
+				}
+			}
+			result[i].Definition = target + " USING " + method
+		}
+	}
+	return result, nil
+}
+
+func (postgresSource) ForeignKeys(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT n.nspname, c.relname, rn.nspname, r.relname
+		FROM pg_constraint k
+		JOIN pg_class c ON c.oid = k.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_class r ON r.oid = k.confrelid
+		JOIN pg_namespace rn ON rn.oid = r.relnamespace
+		WHERE k.contype = 'f'`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying foreign keys: %v", err)
+	}
+	defer rows.Close()
+
+	dependencies := make(map[string][]string)
+	for rows.Next() {
+		var schema, table, referencedSchema, referencedTable string
+		if err := rows.Scan(&schema, &table, &referencedSchema, &referencedTable); err != nil {
+			return nil, fmt.Errorf("error scanning foreign key: %v", err)
+		}
+		key := schema + "." + table
+		dependencies[key] = append(dependencies[key], referencedSchema+"."+referencedTable)
+	}
+	return dependencies, rows.Err()
+}
+
+// GenerateSchema returns the DDL creating the target schemas and tables of
+// PostgreSQL schemas (see generateSourceSchema). Columns of user-defined
+// types need -type-map or -coerce-unknown-to-text.
+func (s postgresSource) GenerateSchema(db *sql.DB, opts SchemaOptions) ([]string, error) {
+	descriptions, err := s.tableComments(db, opts.Schemas)
+	if err != nil {
+		return nil, err
+	}
+	return generateSourceSchema(s, db, opts, descriptions, func(column Column) bool {
+		return !pgUserType(column.SourceType)
+	})
+}
+
+// tableComments returns the comments of the tables of the schemas
+func (postgresSource) tableComments(db *sql.DB, schemas []string) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT n.nspname, c.relname, obj_description(c.oid, 'pg_class')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND n.nspname = ANY($1) AND obj_description(c.oid, 'pg_class') <> ''`,
+		pq.Array(schemas))
+	if err != nil {
+		return nil, fmt.Errorf("error querying table comments: %v", err)
+	}
+	defer rows.Close()
+
+	comments := make(map[string]string)
+	for rows.Next() {
+		var schema, table, comment string
+		if err := rows.Scan(&schema, &table, &comment); err != nil {
+			return nil, fmt.Errorf("error scanning table comment: %v", err)
+		}
+		comments[schema+"."+table] = comment
+	}
+	return comments, rows.Err()
+}
+
+func (postgresSource) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (s postgresSource) QuoteTable(table string) string {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return s.QuoteIdent(table)
+	}
+	return s.QuoteIdent(parts[0]) + "." + s.QuoteIdent(parts[1])
+}
+
+// KeyCondition compares row values, e.g. `("a", "b") > ($1, $2)`
+func (postgresSource) KeyCondition(exprs []string) string {
+	placeholders := make([]string, len(exprs))
+	for i := range exprs {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return "(" + strings.Join(exprs, ", ") + ") > (" + strings.Join(placeholders, ", ") + ")"
+}
+
+func (s postgresSource) PageQuery(selectList, table, where, orderBy string, limit int) string {
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList, s.QuoteTable(table))
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return fmt.Sprintf("%s ORDER BY %s LIMIT %d", query, orderBy, limit)
+}
+
+func (s postgresSource) CountQuery(table string) string {
+	return "SELECT count(*) FROM " + s.QuoteTable(table)
+}
+
+// ConvertValue passes the values of the PostgreSQL driver on as text, which
+// it returns as bytes for types it does not decode (e.g., numeric, uuid,
+// json and arrays); bytea values stay bytes
+func (postgresSource) ConvertValue(column Column, value interface{}) (interface{}, error) {
+	if v, ok := value.([]byte); ok && column.SourceType != "bytea" {
+		return string(v), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,192 @@
+package dbmigrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"unicode"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// readOnlyForbidden are the T-SQL keywords that can write to a database, run
+// code or change server state; a read-only connection refuses statements that
+// contain any of them outside of strings, comments and quoted identifiers
+var readOnlyForbidden = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true, "into": true,
+	"create": true, "alter": true, "drop": true, "truncate": true, "rename": true,
+	"exec": true, "execute": true, "grant": true, "revoke": true, "deny": true,
+	"bulk": true, "dbcc": true, "backup": true, "restore": true, "kill": true,
+	"shutdown": true, "reconfigure": true, "openrowset": true, "opendatasource": true,
+	"openquery": true, "writetext": true, "updatetext": true, "declare": true,
+	"begin": true, "commit": true, "rollback": true, "save": true, "use": true,
+}
+
+// readOnlySettings are the session options a read-only connection may SET
+var readOnlySettings = map[string]bool{
+	"lock_timeout": true, "transaction": true, "nocount": true, "deadlock_priority": true,
+}
+
+// OpenReadOnlySqlServer opens a SQL Server database like sql.Open("sqlserver",
+// dsn), declaring a read-only application intent and refusing, before anything
+// is sent to the server, every statement other than a single SELECT (or WITH
+// ... SELECT) query or a SET of a session option. Transactions are started as
+// regular transactions, as SQL Server has no read-only transactions.
+func OpenReadOnlySqlServer(dsn string) (*sql.DB, error) {
+	connector, err := mssql.NewConnector(withDsnParam(dsn, "applicationintent", "ReadOnly"))
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(readOnlyConnector{connector}), nil
+}
+
+// CheckReadOnly returns an error unless a statement only reads, as enforced by
+// connections opened with OpenReadOnlySqlServer
+func CheckReadOnly(query string) error {
+	words := statementWords(query)
+	if len(words) == 0 {
+		return fmt.Errorf("refusing to run an empty statement on the read-only source")
+	}
+	for _, word := range words {
+		if word == ";" {
+			return fmt.Errorf("refusing to run several statements at once on the read-only source: %s", firstLine(query))
+		}
+		if readOnlyForbidden[word] {
+			return fmt.Errorf("refusing to run a statement with %s on the read-only source: %s", strings.ToUpper(word), firstLine(query))
+		}
+	}
+	if words[0] == "select" || words[0] == "with" || (words[0] == "set" && len(words) > 1 && readOnlySettings[words[1]]) {
+		return nil
+	}
+	return fmt.Errorf("refusing to run a statement other than SELECT on the read-only source: %s", firstLine(query))
+}
+
+// statementWords returns the lowercase words of a T-SQL statement outside of
+// string literals, comments and quoted identifiers, and ";" for each statement
+// separator that is followed by more words
+func statementWords(query string) []string {
+	var words []string
+	separator := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '[' || c == '"':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			for i++; i < len(query); i++ {
+				if query[i] == closing {
+					if i+1 < len(query) && query[i+1] == closing {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == ';':
+			separator = true
+			i++
+		case c == '_' || c == '@' || c == '#' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(query) && (query[i] == '_' || query[i] == '@' || query[i] == '#' || query[i] == '$' ||
+				unicode.IsLetter(rune(query[i])) || unicode.IsDigit(rune(query[i]))) {
+				i++
+			}
+			if separator {
+				words = append(words, ";")
+				separator = false
+			}
+			words = append(words, strings.ToLower(query[start:i]))
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// firstLine returns the first non-empty line of a statement, for error messages
+func firstLine(query string) string {
+	for _, line := range strings.Split(query, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// readOnlyConnector opens connections that refuse statements that may write
+type readOnlyConnector struct {
+	connector *mssql.Connector
+}
+
+func (c readOnlyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyConn{conn.(*mssql.Conn)}, nil
+}
+
+func (c readOnlyConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// readOnlyConn checks every statement with CheckReadOnly before preparing it
+type readOnlyConn struct {
+	conn *mssql.Conn
+}
+
+func (c *readOnlyConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *readOnlyConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := CheckReadOnly(query); err != nil {
+		return nil, err
+	}
+	return c.conn.PrepareContext(ctx, query)
+}
+
+func (c *readOnlyConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *readOnlyConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c *readOnlyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	opts.ReadOnly = false
+	return c.conn.BeginTx(ctx, opts)
+}
+
+func (c *readOnlyConn) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx)
+}
+
+func (c *readOnlyConn) ResetSession(ctx context.Context) error {
+	return c.conn.ResetSession(ctx)
+}
+
+func (c *readOnlyConn) IsValid() bool {
+	return c.conn.IsValid()
+}
+
+func (c *readOnlyConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.conn.CheckNamedValue(nv)
+}
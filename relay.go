@@ -0,0 +1,548 @@
+package dbmigrate
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// A relay carries the queries of the data migration tool to a source database
+// in another network and their results back, over a TLS connection with every
+// message compressed. The extract process near the source serves the relay with
+// ServeRelay, and the load process near the target opens it with OpenRelay as
+// a regular *sql.DB. Each client connection is served by its own source
+// connection, so session settings and transactions behave as with a direct
+// connection.
+
+// relayBatchRows is the number of result rows sent per message
+const relayBatchRows = 1000
+
+func init() {
+	// Values returned by the SQL Server driver, besides the basic types gob knows
+	gob.Register(time.Time{})
+}
+
+// relayRequest is a message from the client
+type relayRequest struct {
+	// Op is hello (with Token), query, exec (with Query and Args), begin,
+	// commit, rollback or ping
+	Op    string
+	Token string
+	Query string
+	Args  []interface{}
+	// ReadOnly and Isolation are the options of begin
+	ReadOnly  bool
+	Isolation int
+}
+
+// relayResponse is a message from the server. A query is answered with its
+// columns, followed by messages with rows until one has Done set.
+type relayResponse struct {
+	Columns      []string
+	Rows         [][]interface{}
+	Done         bool
+	RowsAffected int64
+	Error        string
+	// ErrorNumber is the SQL Server error number, so callers can still
+	// recognize errors such as lock timeouts
+	ErrorNumber int32
+}
+
+// err returns the error of a response, if any
+func (r *relayResponse) err() error {
+	switch {
+	case r.ErrorNumber != 0:
+		return mssql.Error{Number: r.ErrorNumber, Message: r.Error}
+	case r.Error != "":
+		return errors.New(r.Error)
+	}
+	return nil
+}
+
+// errorResponse returns the response reporting err
+func errorResponse(err error) relayResponse {
+	response := relayResponse{Error: err.Error(), Done: true}
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		response.ErrorNumber = sqlErr.Number
+	}
+	return response
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w     io.Writer
+	count *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// relayStream sends and receives compressed gob messages over a connection
+type relayStream struct {
+	conn       net.Conn
+	encoder    *gob.Encoder
+	decoder    *gob.Decoder
+	compressor *flate.Writer
+	buffer     *bufio.Writer
+	// sent counts the bytes before compression, wire the bytes written to the
+	// connection
+	sent, wire atomic.Int64
+}
+
+func newRelayStream(conn net.Conn) *relayStream {
+	s := &relayStream{conn: conn}
+	s.buffer = bufio.NewWriter(countingWriter{conn, &s.wire})
+	s.compressor, _ = flate.NewWriter(s.buffer, flate.BestSpeed)
+	s.encoder = gob.NewEncoder(countingWriter{s.compressor, &s.sent})
+	s.decoder = gob.NewDecoder(flate.NewReader(bufio.NewReader(conn)))
+	return s
+}
+
+// send writes a message and flushes it to the connection
+func (s *relayStream) send(message interface{}) error {
+	if err := s.encoder.Encode(message); err != nil {
+		return err
+	}
+	if err := s.compressor.Flush(); err != nil {
+		return err
+	}
+	return s.buffer.Flush()
+}
+
+func (s *relayStream) receive(message interface{}) error {
+	return s.decoder.Decode(message)
+}
+
+// ServeRelay serves relay clients connecting to listener with connections of db,
+// until ctx is done. Clients must present token. listener should be a TLS
+// listener, since queries and rows are sent as they are.
+func ServeRelay(ctx context.Context, listener net.Listener, db *sql.DB, token string) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error accepting relay connection: %v", err)
+		}
+		go serveRelayConn(ctx, conn, db, token)
+	}
+}
+
+// serveRelayConn serves the requests of one client connection
+func serveRelayConn(ctx context.Context, conn net.Conn, db *sql.DB, token string) {
+	defer conn.Close()
+	stream := newRelayStream(conn)
+	start := time.Now()
+	var rowCount int64
+
+	var hello relayRequest
+	if err := stream.receive(&hello); err != nil {
+		log.Printf("Warning: Relay connection from %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if hello.Op != "hello" || subtle.ConstantTimeCompare([]byte(hello.Token), []byte(token)) != 1 {
+		log.Printf("Warning: Refused relay connection from %s: invalid token", conn.RemoteAddr())
+		stream.send(relayResponse{Error: "invalid relay token", Done: true})
+		return
+	}
+	source, err := db.Conn(ctx)
+	if err != nil {
+		stream.send(errorResponse(err))
+		return
+	}
+	defer source.Close()
+	if err := stream.send(relayResponse{Done: true}); err != nil {
+		return
+	}
+
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+		log.Printf("Relay connection from %s closed after %s: %d rows, %s sent (%s uncompressed)",
+			conn.RemoteAddr(), time.Since(start).Round(time.Second), rowCount,
+			formatBytes(stream.wire.Load()), formatBytes(stream.sent.Load()))
+	}()
+	for {
+		var request relayRequest
+		if err := stream.receive(&request); err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Warning: Relay connection from %s failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		var response relayResponse
+		switch request.Op {
+		case "query":
+			var rows *sql.Rows
+			if tx != nil {
+				rows, err = tx.QueryContext(ctx, request.Query, request.Args...)
+			} else {
+				rows, err = source.QueryContext(ctx, request.Query, request.Args...)
+			}
+			if err != nil {
+				response = errorResponse(err)
+				break
+			}
+			sent, err := sendRelayRows(stream, rows)
+			rowCount += sent
+			if err != nil {
+				return
+			}
+			continue
+		case "exec":
+			var result sql.Result
+			if tx != nil {
+				result, err = tx.ExecContext(ctx, request.Query, request.Args...)
+			} else {
+				result, err = source.ExecContext(ctx, request.Query, request.Args...)
+			}
+			if err == nil {
+				response.RowsAffected, err = result.RowsAffected()
+			}
+		case "begin":
+			if tx != nil {
+				err = errors.New("a transaction is already open")
+			} else {
+				tx, err = source.BeginTx(ctx, &sql.TxOptions{ReadOnly: request.ReadOnly, Isolation: sql.IsolationLevel(request.Isolation)})
+			}
+		case "commit", "rollback":
+			if tx == nil {
+				err = errors.New("no transaction is open")
+			} else if request.Op == "commit" {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			tx = nil
+		case "ping":
+			err = source.PingContext(ctx)
+		default:
+			err = fmt.Errorf("unknown relay request: %s", request.Op)
+		}
+		if err != nil {
+			response = errorResponse(err)
+		}
+		response.Done = true
+		if err := stream.send(response); err != nil {
+			return
+		}
+	}
+}
+
+// sendRelayRows sends the columns and rows of a query result, returning the
+// number of rows sent. An error is only returned if the client is gone.
+func sendRelayRows(stream *relayStream, rows *sql.Rows) (int64, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, stream.send(errorResponse(err))
+	}
+	if err := stream.send(relayResponse{Columns: columns}); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	batch := make([][]interface{}, 0, relayBatchRows)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return count, stream.send(errorResponse(err))
+		}
+		batch = append(batch, values)
+		count++
+		if len(batch) == relayBatchRows {
+			if err := stream.send(relayResponse{Rows: batch}); err != nil {
+				return count, err
+			}
+			batch = batch[:0]
+		}
+	}
+	final := relayResponse{Rows: batch, Done: true}
+	if err := rows.Err(); err != nil {
+		final = errorResponse(err)
+		final.Rows = batch
+	}
+	return count, stream.send(final)
+}
+
+// formatBytes formats a byte count in MB
+func formatBytes(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
+
+// OpenRelay opens the source database served by ServeRelay at addr (host:port)
+// over TLS, presenting token
+func OpenRelay(addr string, tlsConfig *tls.Config, token string) *sql.DB {
+	return sql.OpenDB(relayConnector{addr: addr, tlsConfig: tlsConfig, token: token})
+}
+
+// relayConnector opens relay connections
+type relayConnector struct {
+	addr      string
+	tlsConfig *tls.Config
+	token     string
+}
+
+func (c relayConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dialer := &tls.Dialer{Config: c.tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to relay %s: %v", c.addr, err)
+	}
+	rc := &relayConn{stream: newRelayStream(conn)}
+	if _, err := rc.do(relayRequest{Op: "hello", Token: c.token}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to relay %s: %v", c.addr, err)
+	}
+	return rc, nil
+}
+
+func (c relayConnector) Driver() driver.Driver {
+	return relayDriver{}
+}
+
+// relayDriver only exists for relayConnector.Driver; connections are opened by
+// OpenRelay
+type relayDriver struct{}
+
+func (relayDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("relay connections are opened with OpenRelay")
+}
+
+// relayConn is a client connection, which is served by one source connection
+type relayConn struct {
+	stream *relayStream
+	// broken is set after a network error, so the pool discards the connection
+	broken bool
+	// rows is the result being read, which must be read to the end before the
+	// next request
+	rows *relayRows
+}
+
+// roundTrip sends a request and receives the first message of its response;
+// the error the response reports is left to the caller
+func (c *relayConn) roundTrip(request relayRequest, response *relayResponse) error {
+	if c.broken {
+		return driver.ErrBadConn
+	}
+	if c.rows != nil {
+		if err := c.rows.Close(); err != nil {
+			return err
+		}
+	}
+	if err := c.stream.send(request); err != nil {
+		c.broken = true
+		return err
+	}
+	if err := c.stream.receive(response); err != nil {
+		c.broken = true
+		return err
+	}
+	return nil
+}
+
+// do runs a request answered with a single message
+func (c *relayConn) do(request relayRequest) (*relayResponse, error) {
+	var response relayResponse
+	if err := c.roundTrip(request, &response); err != nil {
+		return nil, err
+	}
+	return &response, response.err()
+}
+
+func (c *relayConn) Prepare(query string) (driver.Stmt, error) {
+	return &relayStmt{conn: c, query: query}, nil
+}
+
+func (c *relayConn) Close() error {
+	c.broken = true
+	return c.stream.conn.Close()
+}
+
+func (c *relayConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *relayConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.do(relayRequest{Op: "begin", ReadOnly: opts.ReadOnly, Isolation: int(opts.Isolation)}); err != nil {
+		return nil, err
+	}
+	return relayTx{c}, nil
+}
+
+func (c *relayConn) Ping(ctx context.Context) error {
+	_, err := c.do(relayRequest{Op: "ping"})
+	return err
+}
+
+func (c *relayConn) IsValid() bool {
+	return !c.broken
+}
+
+func (c *relayConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows := &relayRows{conn: c}
+	if err := c.roundTrip(relayRequest{Op: "query", Query: query, Args: namedValues(args)}, &rows.response); err != nil {
+		return nil, err
+	}
+	if err := rows.response.err(); err != nil {
+		return nil, err
+	}
+	rows.columns = rows.response.Columns
+	c.rows = rows
+	return rows, nil
+}
+
+func (c *relayConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	response, err := c.do(relayRequest{Op: "exec", Query: query, Args: namedValues(args)})
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(response.RowsAffected), nil
+}
+
+// namedValues returns the values of positional query arguments
+func namedValues(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+// relayStmt runs a query on a relay connection; statements are not prepared on
+// the source
+type relayStmt struct {
+	conn  *relayConn
+	query string
+}
+
+func (s *relayStmt) Close() error  { return nil }
+func (s *relayStmt) NumInput() int { return -1 }
+
+func (s *relayStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, positional(args))
+}
+
+func (s *relayStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, positional(args))
+}
+
+// positional turns arguments into positional named values
+func positional(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// relayTx is a transaction on the source connection serving a relay connection
+type relayTx struct {
+	conn *relayConn
+}
+
+func (t relayTx) Commit() error {
+	_, err := t.conn.do(relayRequest{Op: "commit"})
+	return err
+}
+
+func (t relayTx) Rollback() error {
+	_, err := t.conn.do(relayRequest{Op: "rollback"})
+	return err
+}
+
+// relayRows reads the rows of a query result as they arrive
+type relayRows struct {
+	conn     *relayConn
+	columns  []string
+	response relayResponse
+	next     int
+	closed   bool
+}
+
+func (r *relayRows) Columns() []string {
+	return r.columns
+}
+
+func (r *relayRows) Next(dest []driver.Value) error {
+	for r.next >= len(r.response.Rows) {
+		if r.response.Done {
+			r.finish()
+			if err := r.response.err(); err != nil {
+				return err
+			}
+			return io.EOF
+		}
+		if err := r.receive(); err != nil {
+			return err
+		}
+	}
+	for i, value := range r.response.Rows[r.next] {
+		dest[i] = value
+	}
+	r.next++
+	return nil
+}
+
+// receive reads the next message of the result
+func (r *relayRows) receive() error {
+	r.response, r.next = relayResponse{}, 0
+	if err := r.conn.stream.receive(&r.response); err != nil {
+		r.conn.broken = true
+		r.finish()
+		return err
+	}
+	return nil
+}
+
+// Close reads the rest of the result, which the server sends regardless
+func (r *relayRows) Close() error {
+	for !r.closed {
+		if r.response.Done {
+			r.finish()
+			break
+		}
+		if err := r.receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish marks the result as read to the end
+func (r *relayRows) finish() {
+	r.closed = true
+	if r.conn.rows == r {
+		r.conn.rows = nil
+	}
+}
@@ -0,0 +1,179 @@
+package dbmigrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+)
+
+// Report statuses
+const (
+	StatusSucceeded   = "succeeded"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+	StatusSkipped     = "skipped"
+	StatusDeferred    = "deferred" // the table was locked; see -only-deferred
+)
+
+// Report summarizes a migration run
+type Report struct {
+	RunID    string `json:"run_id"`
+	Status   string `json:"status"`
+	Database string `json:"database,omitempty"`
+	// SourceSnapshot is the database snapshot the tables were read from, if any
+	SourceSnapshot string        `json:"source_snapshot,omitempty"`
+	Phases         []string      `json:"phases,omitempty"`
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     time.Time     `json:"finished_at"`
+	Duration       string        `json:"duration"`
+	TotalRows      int64         `json:"total_rows"`
+	TotalBytes     int64         `json:"total_bytes"`
+	Tables         []TableReport `json:"tables"`
+	Error          string        `json:"error,omitempty"`
+	Settings       *RunSettings  `json:"settings,omitempty"`
+}
+
+// RunSettings records the options of a run that affect how its tables are
+// verified, so a later run can verify them the same way (-verify-from)
+type RunSettings struct {
+	PreserveCase    bool    `json:"preserve_case"`
+	ComputedColumns string  `json:"computed_columns,omitempty"`
+	ColumnSets      string  `json:"column_sets,omitempty"`
+	DatetimeType    string  `json:"datetime_type,omitempty"`
+	XmlType         string  `json:"xml_type,omitempty"`
+	Rowversion      string  `json:"rowversion,omitempty"`
+	Hierarchyid     string  `json:"hierarchyid,omitempty"`
+	PostGIS         bool    `json:"postgis,omitempty"`
+	SourceTimezone  string  `json:"source_timezone,omitempty"`
+	InvalidText     string  `json:"invalid_text,omitempty"`
+	TypeMap         string  `json:"type_map,omitempty"`
+	Config          *Config `json:"config,omitempty"` // column settings of the config file, if any
+}
+
+// TableReport summarizes the migration of a single table
+type TableReport struct {
+	Table       string `json:"table"`
+	TargetTable string `json:"target_table"`
+	Status      string `json:"status"`
+	Rows        int64  `json:"rows"`
+	Rejected    int64  `json:"rejected,omitempty"` // rows written to the reject file
+	Bytes       int64  `json:"bytes"`              // approximate size of the source values
+	Duration    string `json:"duration"`
+	Reason      string `json:"reason,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Batch       int    `json:"batch,omitempty"`      // batch that failed, counting from 1
+	KeyRange    string `json:"key_range,omitempty"`  // keys or rows read in the failed batch up to the failure, if known
+	Column      string `json:"column,omitempty"`     // column (and value) that caused the failure, if known
+	SampleRow   string `json:"sample_row,omitempty"` // row that caused the failure, if known
+	// TargetDatabase names the target database with -target-databases: the
+	// source schemas migrated to it, joined by "+"
+	TargetDatabase string `json:"target_database,omitempty"`
+	// NullConversions counts the values changed by a column's null_policy or
+	// filled in by -audit-backfill, by column
+	NullConversions map[string]int64 `json:"null_conversions,omitempty"`
+	// SanitizedValues counts the values with NUL bytes or invalid UTF-8 that were cleaned, by column
+	SanitizedValues map[string]int64 `json:"sanitized_values,omitempty"`
+	// UnexpectedTypes holds, by column, the Go type the driver returned where it
+	// differs from the one expected for the source type
+	UnexpectedTypes map[string]string `json:"unexpected_types,omitempty"`
+	// Columns holds the statistics of the values written, by column
+	Columns map[string]*ColumnStats `json:"columns,omitempty"`
+}
+
+// ColumnStats describes the values of a column as they were written to the
+// target, after conversion
+type ColumnStats struct {
+	Nulls int64 `json:"nulls"`
+	// MaxLength is the longest text value in characters or binary value in
+	// bytes
+	MaxLength int `json:"max_length,omitempty"`
+	// Truncations counts the values that lost characters or bytes in
+	// conversion, or digits beyond the microseconds PostgreSQL keeps
+	Truncations int64 `json:"truncations,omitempty"`
+	// Conversions counts the values changed by a conversion, e.g., of their
+	// type or time zone, by a null_policy or by -invalid-text
+	Conversions int64 `json:"conversions,omitempty"`
+}
+
+// NewReport starts a report for the run runID beginning now
+func NewReport(runID string) *Report {
+	return &Report{RunID: runID, StartedAt: time.Now(), Tables: []TableReport{}}
+}
+
+// AddTable records the outcome of a table
+func (r *Report) AddTable(table TableReport) {
+	r.Tables = append(r.Tables, table)
+	r.TotalRows += table.Rows
+	r.TotalBytes += table.Bytes
+}
+
+// Finish records the final status of the run. err may be nil.
+func (r *Report) Finish(status string, err error) {
+	r.Status = status
+	r.FinishedAt = time.Now()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond).String()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// MigratedTables returns the tables the run migrated, including those completed
+// by a previous run it resumed
+func (r *Report) MigratedTables() []TableReport {
+	var tables []TableReport
+	for _, table := range r.Tables {
+		if table.TargetTable != "" && (table.Status == StatusSucceeded || table.Status == StatusSkipped) {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// LoadReport reads a report written as JSON (e.g., with -summary-json)
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("error parsing report %s: %v", path, err)
+	}
+	return &report, nil
+}
+
+// JSON renders the report as indented JSON
+func (r *Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>dbmigrate report</title></head>
+<body style="font-family: sans-serif">
+<h2>Migration {{.Status}}{{if .Database}}: {{.Database}}{{end}}</h2>
+<p>Started {{.StartedAt.Format "2006-01-02 15:04:05 MST"}}, finished {{.FinishedAt.Format "2006-01-02 15:04:05 MST"}} ({{.Duration}})<br>
+Run ID: {{.RunID}}<br>
+Phases: {{range $i, $p := .Phases}}{{if $i}}, {{end}}{{$p}}{{end}}<br>
+{{if .SourceSnapshot}}Read from database snapshot: {{.SourceSnapshot}}<br>
+{{end}}Total rows migrated: {{.TotalRows}} ({{.TotalBytes}} bytes)</p>
+{{if .Error}}<p style="color: #b00"><strong>Error:</strong> {{.Error}}</p>{{end}}
+<table border="1" cellpadding="4" cellspacing="0" style="border-collapse: collapse">
+<tr><th>Table</th><th>Target</th><th>Status</th><th>Rows</th><th>Rejected</th><th>Bytes</th><th>Duration</th><th>Details</th></tr>
+{{range .Tables}}<tr><td>{{.Table}}</td><td>{{.TargetTable}}</td><td>{{.Status}}</td><td align="right">{{.Rows}}</td><td align="right">{{.Rejected}}</td><td align="right">{{.Bytes}}</td><td>{{.Duration}}</td><td>{{.Reason}}{{.Error}}{{if .SampleRow}}<br><code>{{.SampleRow}}</code>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// HTML renders the report as a standalone HTML page
+func (r *Report) HTML() (string, error) {
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
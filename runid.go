@@ -0,0 +1,17 @@
+package dbmigrate
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// NewRunID returns a random (version 4) UUID identifying a migration run
+func NewRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("error generating run ID: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,544 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TypeMapping maps SQL Server data types to PostgreSQL data types.
+// Types that are not listed are mapped to TEXT.
+var TypeMapping = map[string]string{
+	"int":              "INTEGER",
+	"bigint":           "BIGINT",
+	"smallint":         "SMALLINT",
+	"bit":              "BOOLEAN",
+	"nvarchar":         "TEXT",
+	"varchar":          "TEXT",
+	"nchar":            "TEXT",
+	"char":             "TEXT",
+	"text":             "TEXT",
+	"datetime":         "TIMESTAMPTZ",
+	"datetime2":        "TIMESTAMPTZ",
+	"datetimeoffset":   "TIMESTAMPTZ",
+	"smalldatetime":    "TIMESTAMP",
+	"date":             "DATE",
+	"time":             "TIME",
+	"float":            "DOUBLE PRECISION",
+	"real":             "REAL",
+	"decimal":          "NUMERIC",
+	"numeric":          "NUMERIC",
+	"money":            "NUMERIC",
+	"uniqueidentifier": "UUID",
+	"binary":           "BYTEA",
+	"varbinary":        "BYTEA",
+	"image":            "BYTEA",
+	"xml":              "XML",
+	"timestamp":        "BYTEA", // rowversion, unless excluded
+	"rowversion":       "BYTEA",
+	"hierarchyid":      "TEXT",
+	"sql_variant":      "TEXT",
+	"geometry":         "TEXT", // extended WKT, unless PostGIS is used
+	"geography":        "TEXT",
+}
+
+// SchemaOptions controls how the PostgreSQL schema is generated
+type SchemaOptions struct {
+	// Schemas lists the source schemas to read
+	Schemas []string
+	// Tables optionally restricts generation to these source tables (schema.table)
+	Tables []string
+	// IncludeSystemSchemas keeps system tables (names starting with "sys")
+	IncludeSystemSchemas bool
+	// PreserveCase quotes all identifiers to keep their case
+	PreserveCase bool
+	// IfNotExists emits CREATE TABLE IF NOT EXISTS so the DDL can be applied repeatedly
+	IfNotExists bool
+	// Mapper translates source names to target names (optional)
+	Mapper *NameMapper
+	// ComputedColumns is ComputedMaterialize (the default if empty) or ComputedDrop
+	ComputedColumns string
+	// DatetimeType is DatetimeTimestampTZ (the default if empty) or DatetimeTimestamp
+	// for datetime and datetime2 columns
+	DatetimeType string
+	// XmlType is XmlTypeXML (the default if empty) or XmlTypeText for xml columns
+	XmlType string
+	// Rowversion is RowversionExclude (the default if empty) or RowversionBytea
+	Rowversion string
+	// Hierarchyid is HierarchyidText (the default if empty) or HierarchyidLtree
+	Hierarchyid string
+	// ColumnSets is ColumnSetsSkip (the default if empty) or ColumnSetsKeep
+	ColumnSets string
+	// PostGIS creates geometry and geography columns as PostGIS types instead of TEXT
+	PostGIS bool
+	// TypeMapper overrides the target types of TypeMapping (optional)
+	TypeMapper *TypeMapper
+	// Config provides per-table settings such as the tablespace (optional)
+	Config *Config
+	// ProvenanceColumn adds a TEXT column of this name recording the run that loaded each row (optional)
+	ProvenanceColumn string
+	// CoerceUnknownTypes creates columns of types without a mapping as TEXT;
+	// otherwise GenerateSchema fails with an UnmappedTypesError
+	CoerceUnknownTypes bool
+	// UpdatedAtColumn adds a TIMESTAMPTZ column of this name, unless the source
+	// table has one, and a trigger setting it on every update (optional)
+	UpdatedAtColumn string
+	// PartitionRows creates tables with more rows range partitioned as
+	// suggested by SuggestPartitioning (0 = never)
+	PartitionRows int64
+	// Citus distributes the tables configured with distribution_column or
+	// reference_table on a Citus cluster
+	Citus bool
+	// MaterializedViews lists the source views (schema.view, names or
+	// patterns) to create as materialized views, such as heavy reporting views
+	MaterializedViews []string
+	// AuditBackfill creates the columns with translated current date and time
+	// defaults NOT NULL, for a migration filling in their NULL values
+	// (-audit-backfill)
+	AuditBackfill bool
+}
+
+// targetType returns the PostgreSQL type of a source column (table is
+// schema.table) and the extension providing it, if any
+func targetType(db *sql.DB, table, column, dataType string, opts SchemaOptions) (pgType string, extension string, err error) {
+	if pgType, ok := opts.TypeMapper.Lookup(table, column, dataType); ok {
+		return pgType, "", nil
+	}
+	pgType, ok := TypeMapping[strings.ToLower(dataType)]
+	if !ok {
+		pgType = "TEXT"
+	}
+	switch {
+	case opts.XmlType == XmlTypeText && strings.EqualFold(dataType, "xml"):
+		pgType = "TEXT"
+	case opts.Hierarchyid == HierarchyidLtree && strings.EqualFold(dataType, "hierarchyid"):
+		pgType, extension = "LTREE", "ltree"
+	case opts.PostGIS && IsSpatial(dataType):
+		if pgType, err = postgisType(db, table, column, dataType); err != nil {
+			return "", "", err
+		}
+		extension = "postgis"
+	case opts.DatetimeType == DatetimeTimestamp && IsNaiveDatetime(dataType):
+		pgType = "TIMESTAMP"
+	}
+	return pgType, extension, nil
+}
+
+// UnmappedColumn is a source column whose type has no PostgreSQL mapping
+type UnmappedColumn struct {
+	Table, Column, Type string
+}
+
+// UnmappedTypesError lists the source columns whose types have no mapping,
+// unless SchemaOptions.CoerceUnknownTypes creates them as TEXT
+type UnmappedTypesError []UnmappedColumn
+
+func (e UnmappedTypesError) Error() string {
+	types := make(map[string][]string)
+	var names []string
+	for _, column := range e {
+		name := strings.ToLower(column.Type)
+		if types[name] == nil {
+			names = append(names, name)
+		}
+		types[name] = append(types[name], column.Table+"."+column.Column)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("  %s: %s", name, strings.Join(types[name], ", "))
+	}
+	return fmt.Sprintf("no type mapping for %d columns of %d types:\n%s", len(e), len(names), strings.Join(lines, "\n"))
+}
+
+// GenerateSchema reads the source catalog and returns the PostgreSQL DDL
+// statements (without trailing semicolons) that create the target schemas and tables
+func GenerateSchema(db *sql.DB, opts SchemaOptions) ([]string, error) {
+	// Build schema filter for SQL queries
+	schemaFilter := ""
+	schemaParams := make([]interface{}, len(opts.Schemas))
+	for i, schema := range opts.Schemas {
+		if i > 0 {
+			schemaFilter += " OR "
+		}
+		schemaFilter += "c.TABLE_SCHEMA = @p" + fmt.Sprintf("%d", i+1)
+		schemaParams[i] = schema
+	}
+
+	columnQuery := fmt.Sprintf(`
+		SELECT c.TABLE_SCHEMA, c.TABLE_NAME, c.COLUMN_NAME, %s, c.IS_NULLABLE,
+			ISNULL(%s, 0), %s,
+			c.CHARACTER_MAXIMUM_LENGTH, c.NUMERIC_PRECISION, c.NUMERIC_SCALE, c.DATETIME_PRECISION,
+			ISNULL(%s, 0), ISNULL(%s, 0), %s, c.COLUMN_DEFAULT
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		JOIN INFORMATION_SCHEMA.TABLES t ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME
+		WHERE t.TABLE_TYPE = 'BASE TABLE'
+		AND (%s)
+		ORDER BY c.TABLE_SCHEMA, c.TABLE_NAME, c.ORDINAL_POSITION`, DataTypeExpr, computedColumnExpr, aliasTypeExpr,
+		columnPropertyExpr("IsSparse"), columnPropertyExpr("IsColumnSet"), columnDescriptionExpr, schemaFilter)
+
+	// Build schema filter for primary key query
+	schemaPKFilter := ""
+	for i := range opts.Schemas {
+		if i > 0 {
+			schemaPKFilter += " OR "
+		}
+		schemaPKFilter += "s.name = @p" + fmt.Sprintf("%d", i+1)
+	}
+
+	pkQuery := fmt.Sprintf(`
+		SELECT s.name AS schema_name, t.name AS table_name, c.name AS column_name
+		FROM sys.indexes i
+		JOIN sys.index_columns ic ON i.object_id = ic.object_id AND i.index_id = ic.index_id
+		JOIN sys.columns c ON ic.object_id = c.object_id AND ic.column_id = c.column_id
+		JOIN sys.tables t ON i.object_id = t.object_id
+		JOIN sys.schemas s ON t.schema_id = s.schema_id
+		WHERE i.is_primary_key = 1
+		AND (%s)
+		ORDER BY s.name, t.name, ic.key_ordinal`, schemaPKFilter)
+
+	// Restrict to the requested tables, if any
+	includeTables := make(map[string]bool)
+	for _, table := range opts.Tables {
+		includeTables[strings.ToLower(table)] = true
+	}
+
+	rows, err := db.Query(columnQuery, schemaParams...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying columns: %v", err)
+	}
+	defer rows.Close()
+
+	tables := make(map[string][]string)
+	extensions := make(map[string]bool) // extensions providing column types and defaults
+	aliases := make(map[string]bool)    // alias types already reported
+	sparseColumns := make(map[string]int)
+	comments := make(map[string][][2]string) // column name and MS_Description of each table
+	// Computed columns of each table, with ComputedGenerate
+	generated := make(map[string]map[string]ComputedColumn)
+	hasUpdatedAt := make(map[string]bool) // tables with an UpdatedAtColumn in the source
+	var unmapped UnmappedTypesError
+	for rows.Next() {
+		var schema, table, column, dataType, nullable string
+		var computed, sparse, columnSet int
+		var alias, description, defaultValue sql.NullString
+		var length, precision, scale, datetimePrecision sql.NullInt64
+		if err := rows.Scan(&schema, &table, &column, &dataType, &nullable, &computed, &alias,
+			&length, &precision, &scale, &datetimePrecision, &sparse, &columnSet, &description, &defaultValue); err != nil {
+			return nil, fmt.Errorf("error scanning column: %v", err)
+		}
+
+		// Create a unique table identifier that includes the schema
+		tableKey := schema + "." + table
+		if len(includeTables) > 0 && !includeTables[strings.ToLower(tableKey)] {
+			continue
+		}
+
+		// Alias types are mapped like their base type
+		if alias.Valid && !aliases[alias.String] {
+			fmt.Printf("Resolved alias type %s to %s\n", alias.String, formatBaseType(dataType, length, precision, scale, datetimePrecision))
+			aliases[alias.String] = true
+		}
+
+		// Computed columns become plain columns holding the migrated values, unless dropped
+		if computed == 1 && opts.ComputedColumns == ComputedDrop {
+			fmt.Printf("Dropping computed column: %s.%s\n", tableKey, column)
+			continue
+		}
+
+		// Column sets repeat the values of the sparse columns as XML
+		if columnSet == 1 && opts.ColumnSets != ColumnSetsKeep {
+			fmt.Printf("Skipping column set: %s.%s\n", tableKey, column)
+			continue
+		}
+		if sparse == 1 {
+			sparseColumns[tableKey]++
+		}
+
+		// rowversion values are generated by SQL Server, so they are excluded unless requested
+		if IsRowversion(dataType) && opts.Rowversion != RowversionBytea {
+			fmt.Printf("Excluding rowversion column: %s.%s\n", tableKey, column)
+			continue
+		}
+
+		_, overridden := opts.TypeMapper.Lookup(tableKey, column, dataType)
+		if _, ok := TypeMapping[strings.ToLower(dataType)]; !ok && !overridden {
+			if !opts.CoerceUnknownTypes {
+				unmapped = append(unmapped, UnmappedColumn{Table: tableKey, Column: column, Type: dataType})
+				continue
+			}
+			fmt.Printf("Warning: No type mapping for %s.%s (%s), using TEXT\n", tableKey, column, dataType)
+		}
+		pgType, extension, err := targetType(db, tableKey, column, dataType, opts)
+		if err != nil {
+			return nil, err
+		}
+		if extension != "" {
+			extensions[extension] = true
+		}
+		if strings.EqualFold(dataType, "sql_variant") {
+			fmt.Printf("Warning: Column %s.%s is sql_variant; its values are migrated as text without their base type\n", tableKey, column)
+		}
+
+		null := "NOT NULL"
+		if nullable == "YES" {
+			null = "NULL"
+		}
+
+		// Current date and time defaults of audit columns (CreatedAt, ...) are
+		// translated; with AuditBackfill their NULL values are filled in while
+		// copying, so the columns can be NOT NULL
+		defaultExpr, translated := TranslateDateDefault(defaultValue.String, pgType)
+		if translated {
+			fmt.Printf("Translated default %s of %s.%s to %s\n", defaultValue.String, tableKey, column, defaultExpr)
+			if opts.AuditBackfill {
+				null = "NOT NULL"
+			}
+		} else if defaultExpr, translated = TranslateUUIDDefault(defaultValue.String, pgType); translated {
+			// Keeps generating IDs for rows inserted after the cutover
+			fmt.Printf("Translated default %s of %s.%s to %s\n", defaultValue.String, tableKey, column, defaultExpr)
+			extensions["pgcrypto"] = true
+		}
+
+		// Translatable computed columns become generated columns
+		generatedExpr := ""
+		if computed == 1 && opts.ComputedColumns == ComputedGenerate {
+			if generated[tableKey] == nil {
+				definitions, err := ComputedColumnDefinitions(db, tableKey, opts.PreserveCase)
+				if err != nil {
+					return nil, err
+				}
+				generated[tableKey] = make(map[string]ComputedColumn, len(definitions))
+				for _, definition := range definitions {
+					generated[tableKey][definition.Name] = definition
+				}
+			}
+			if definition := generated[tableKey][column]; definition.Generated != "" {
+				generatedExpr = definition.Generated
+			} else {
+				fmt.Printf("Warning: Computed column %s.%s %s cannot be generated (%s), materializing it\n", tableKey, column, definition.Definition, definition.Reason)
+			}
+		}
+
+		// Format column definition based on preserve-case flag (reserved words are always quoted)
+		colDef := fmt.Sprintf("  %s %s %s", QuoteIdent(column, opts.PreserveCase), pgType, null)
+		if translated {
+			colDef += " DEFAULT " + defaultExpr
+		}
+		if generatedExpr != "" {
+			colDef = fmt.Sprintf("  %s %s GENERATED ALWAYS AS (%s) STORED", QuoteIdent(column, opts.PreserveCase), pgType, generatedExpr)
+		}
+		tables[tableKey] = append(tables[tableKey], colDef)
+		if opts.UpdatedAtColumn != "" && strings.EqualFold(column, opts.UpdatedAtColumn) {
+			fmt.Printf("Column %s.%s exists in the source, maintaining it with the update trigger\n", tableKey, column)
+			hasUpdatedAt[tableKey] = true
+		}
+		if description.String != "" {
+			comments[tableKey] = append(comments[tableKey], [2]string{column, description.String})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying columns: %v", err)
+	}
+	if len(unmapped) > 0 {
+		return nil, unmapped
+	}
+	sparseTables := make([]string, 0, len(sparseColumns))
+	for table := range sparseColumns {
+		sparseTables = append(sparseTables, table)
+	}
+	sort.Strings(sparseTables)
+	for _, table := range sparseTables {
+		fmt.Printf("Table %s has %d sparse columns, creating them as regular nullable columns\n", table, sparseColumns[table])
+	}
+
+	// Get primary key columns
+	pkRows, err := db.Query(pkQuery, schemaParams...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying primary keys: %v", err)
+	}
+	defer pkRows.Close()
+
+	pkMap := make(map[string][]string)
+	for pkRows.Next() {
+		var schema, table, column string
+		if err := pkRows.Scan(&schema, &table, &column); err != nil {
+			return nil, fmt.Errorf("error scanning primary key: %v", err)
+		}
+		tableKey := schema + "." + table
+		pkMap[tableKey] = append(pkMap[tableKey], column)
+	}
+	if err := pkRows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying primary keys: %v", err)
+	}
+
+	// Filter out system tables (tables with names starting with "sys")
+	if !opts.IncludeSystemSchemas {
+		for tableKey := range tables {
+			parts := strings.Split(tableKey, ".")
+			if len(parts) == 2 && IsSystemTable(parts[1]) {
+				fmt.Printf("Excluding system table: %s\n", tableKey)
+				delete(tables, tableKey)
+			}
+		}
+	}
+
+	tableNames := make([]string, 0, len(tables))
+	for name := range tables {
+		tableNames = append(tableNames, name)
+	}
+	sort.Strings(tableNames)
+
+	createTable := "CREATE TABLE"
+	if opts.IfNotExists {
+		createTable = "CREATE TABLE IF NOT EXISTS"
+	}
+
+	descriptions, err := tableDescriptions(db)
+	if err != nil {
+		return nil, err
+	}
+	var rowCounts map[string]int64
+	if opts.PartitionRows > 0 {
+		if rowCounts, err = tableRowCounts(db); err != nil {
+			return nil, err
+		}
+	}
+
+	// Track which schemas we've created
+	createdSchemas := make(map[string]bool)
+	updatedAtFunctions := make(map[string]bool) // schemas with the update trigger function
+	var statements []string
+	for _, table := range tableNames {
+		if opts.Config.IsHypertable(table) {
+			extensions["timescaledb"] = true
+		}
+	}
+	for _, extension := range []string{"ltree", "postgis", "pgcrypto", "timescaledb"} {
+		if extensions[extension] {
+			statements = append(statements, "CREATE EXTENSION IF NOT EXISTS "+extension)
+		}
+	}
+
+	for _, table := range tableNames {
+		columns := tables[table]
+		if opts.ProvenanceColumn != "" {
+			columns = append(columns, fmt.Sprintf("  %s TEXT", QuoteIdent(opts.ProvenanceColumn, opts.PreserveCase)))
+		}
+		if opts.UpdatedAtColumn != "" && !hasUpdatedAt[table] {
+			columns = append(columns, fmt.Sprintf("  %s TIMESTAMPTZ NOT NULL DEFAULT now()", QuoteIdent(opts.UpdatedAtColumn, opts.PreserveCase)))
+		}
+		tablespace := opts.Config.TableSettings(table).Tablespace
+		if pks, ok := pkMap[table]; ok && len(pks) > 0 {
+			// Format primary key based on preserve-case flag
+			quotedPKs := make([]string, len(pks))
+			for i, pk := range pks {
+				quotedPKs[i] = QuoteIdent(pk, opts.PreserveCase)
+			}
+			primaryKey := fmt.Sprintf("  PRIMARY KEY (%s)", strings.Join(quotedPKs, ", "))
+			if tablespace != "" {
+				primaryKey += " USING INDEX TABLESPACE " + QuoteIdent(tablespace, opts.PreserveCase)
+			}
+			columns = append(columns, primaryKey)
+		}
+
+		parts := strings.SplitN(table, ".", 2)
+		schemaName, tableName := opts.Mapper.Map(parts[0], parts[1])
+
+		// Create schema if it doesn't exist and we haven't created it yet
+		if !createdSchemas[schemaName] {
+			statements = append(statements, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdent(schemaName, opts.PreserveCase)))
+			createdSchemas[schemaName] = true
+		}
+
+		createStatement := fmt.Sprintf("%s %s (\n%s\n)",
+			createTable, QuoteQualified(schemaName, tableName, opts.PreserveCase), strings.Join(columns, ",\n"))
+		// Hypertables are partitioned by TimescaleDB
+		var partitioning Partitioning
+		if rows := rowCounts[table]; opts.PartitionRows > 0 && rows > opts.PartitionRows && !opts.Config.IsHypertable(table) {
+			if partitioning, err = SuggestPartitioning(db, table, rows); err != nil {
+				return nil, err
+			}
+			if partitioning.Column == "" {
+				fmt.Printf("Warning: Table %s has ~%d rows but cannot be partitioned: %s\n", table, rows, partitioning.Reason)
+			} else {
+				fmt.Printf("Partitioning table %s (~%d rows): %s\n", table, rows, partitioning)
+				createStatement += fmt.Sprintf(" PARTITION BY RANGE (%s)", QuoteIdent(partitioning.Column, opts.PreserveCase))
+			}
+		}
+		if tablespace != "" {
+			createStatement += " TABLESPACE " + QuoteIdent(tablespace, opts.PreserveCase)
+		}
+		statements = append(statements, createStatement)
+		if partitioning.Column != "" {
+			statements = append(statements, partitioning.Statements(schemaName, tableName, opts.IfNotExists, opts.PreserveCase)...)
+		}
+		hypertable, err := hypertableStatement(table, pkMap[table], opts)
+		if err != nil {
+			return nil, err
+		}
+		if hypertable != "" {
+			fmt.Printf("Creating %s as a hypertable by %s\n", table, opts.Config.TableSettings(table).HypertableColumn)
+			statements = append(statements, hypertable)
+		}
+
+		// Tables created by an earlier run may lack the provenance column
+		if opts.IfNotExists && opts.ProvenanceColumn != "" {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s TEXT",
+				QuoteQualified(schemaName, tableName, opts.PreserveCase), QuoteIdent(opts.ProvenanceColumn, opts.PreserveCase)))
+		}
+		if opts.UpdatedAtColumn != "" {
+			if opts.IfNotExists && !hasUpdatedAt[table] {
+				statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s TIMESTAMPTZ NOT NULL DEFAULT now()",
+					QuoteQualified(schemaName, tableName, opts.PreserveCase), QuoteIdent(opts.UpdatedAtColumn, opts.PreserveCase)))
+			}
+			if !updatedAtFunctions[schemaName] {
+				statements = append(statements, updatedAtFunction(schemaName, opts))
+				updatedAtFunctions[schemaName] = true
+			}
+			statements = append(statements, updatedAtTrigger(schemaName, tableName, opts)...)
+		}
+
+		// MS_Description extended properties become comments
+		if description, ok := descriptions[table]; ok {
+			statements = append(statements, fmt.Sprintf("COMMENT ON TABLE %s IS %s",
+				QuoteQualified(schemaName, tableName, opts.PreserveCase), QuoteLiteral(description)))
+		}
+		for _, comment := range comments[table] {
+			statements = append(statements, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s",
+				QuoteQualified(schemaName, tableName, opts.PreserveCase), QuoteIdent(comment[0], opts.PreserveCase), QuoteLiteral(comment[1])))
+		}
+	}
+
+	if opts.Citus {
+		distribution, err := citusStatements(tableNames, pkMap, opts)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, distribution...)
+	}
+
+	return statements, nil
+}
+
+// updatedAtFunction returns the statement creating the trigger function of a
+// target schema that sets the UpdatedAtColumn of the updated row
+func updatedAtFunction(schemaName string, opts SchemaOptions) string {
+	return fmt.Sprintf("CREATE OR REPLACE FUNCTION %s() RETURNS trigger LANGUAGE plpgsql AS $$\nBEGIN\n  NEW.%s := now();\n  RETURN NEW;\nEND\n$$",
+		QuoteQualified(schemaName, "dbmigrate_set_"+opts.UpdatedAtColumn, opts.PreserveCase), QuoteIdent(opts.UpdatedAtColumn, opts.PreserveCase))
+}
+
+// updatedAtTrigger returns the statements creating the trigger that sets the
+// UpdatedAtColumn of a target table on every update. Rows inserted get the
+// column default. With IfNotExists, the trigger of an earlier run is replaced.
+func updatedAtTrigger(schemaName, tableName string, opts SchemaOptions) []string {
+	trigger := QuoteIdent("dbmigrate_set_"+opts.UpdatedAtColumn, opts.PreserveCase)
+	target := QuoteQualified(schemaName, tableName, opts.PreserveCase)
+	var statements []string
+	if opts.IfNotExists {
+		statements = append(statements, fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", trigger, target))
+	}
+	return append(statements, fmt.Sprintf("CREATE TRIGGER %s BEFORE UPDATE ON %s FOR EACH ROW EXECUTE FUNCTION %s()",
+		trigger, target, QuoteQualified(schemaName, "dbmigrate_set_"+opts.UpdatedAtColumn, opts.PreserveCase)))
+}
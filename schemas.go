@@ -0,0 +1,117 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SystemSchemas lists the SQL Server system schemas that are excluded by default
+var SystemSchemas = map[string]bool{
+	"sys":                true,
+	"INFORMATION_SCHEMA": true,
+	"db_owner":           true,
+	"db_accessadmin":     true,
+	"db_securityadmin":   true,
+	"db_ddladmin":        true,
+	"db_backupoperator":  true,
+	"db_datareader":      true,
+	"db_datawriter":      true,
+	"db_denydatareader":  true,
+	"db_denydatawriter":  true,
+}
+
+// IsSystemTable reports whether a table is a SQL Server system table
+// (tables with names starting with "sys")
+func IsSystemTable(table string) bool {
+	return strings.HasPrefix(strings.ToLower(table), "sys")
+}
+
+// ResolveTableNames resolves table names given by the user against the
+// available tables (schema.table), returning the matched tables and the names
+// that match none. A qualified name matches a table case-insensitively. An
+// unqualified name matches the table of that name in any schema, and is an
+// error if tables of several schemas have that name. Patterns (see
+// IsTablePattern) match any number of tables.
+func ResolveTableNames(names, tables []string) (matched, unmatched []string, err error) {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if IsTablePattern(name) {
+			matches, err := MatchTablePattern(name, tables)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(matches) == 0 {
+				unmatched = append(unmatched, name)
+			}
+			for _, table := range matches {
+				if !seen[table] {
+					seen[table] = true
+					matched = append(matched, table)
+				}
+			}
+			continue
+		}
+		var matches []string
+		for _, table := range tables {
+			if strings.EqualFold(name, table) {
+				matches = append(matches, table)
+			} else if !strings.Contains(name, ".") && strings.EqualFold(name, table[strings.Index(table, ".")+1:]) {
+				matches = append(matches, table)
+			}
+		}
+		switch {
+		case len(matches) == 0:
+			unmatched = append(unmatched, name)
+		case len(matches) > 1:
+			return nil, nil, fmt.Errorf("table name %s is ambiguous, qualify it with its schema: %s", name, strings.Join(matches, ", "))
+		default:
+			if !seen[matches[0]] {
+				seen[matches[0]] = true
+				matched = append(matched, matches[0])
+			}
+		}
+	}
+	return matched, unmatched, nil
+}
+
+// IsTablePattern reports whether a table filter entry is a pattern rather than
+// a name: a wildcard pattern with '*', or a regular expression prefixed with
+// "re:"
+func IsTablePattern(name string) bool {
+	return strings.Contains(name, "*") || strings.HasPrefix(name, "re:")
+}
+
+// MatchTablePattern returns the tables (schema.table) matching a pattern,
+// case-insensitively. A wildcard pattern matches the qualified name, or the
+// table name in any schema if it has no schema part. A regular expression
+// always matches the whole qualified name.
+func MatchTablePattern(pattern string, tables []string) ([]string, error) {
+	var expr string
+	qualified := true
+	if regex, ok := strings.CutPrefix(pattern, "re:"); ok {
+		expr = regex
+	} else {
+		expr = strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+		qualified = strings.Contains(pattern, ".")
+	}
+	re, err := regexp.Compile("(?i)^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid table pattern %s: %v", pattern, err)
+	}
+	var matches []string
+	for _, table := range tables {
+		name := table
+		if !qualified {
+			name = table[strings.Index(table, ".")+1:]
+		}
+		if re.MatchString(name) {
+			matches = append(matches, table)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,368 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Source is the database system tables are migrated from: it reads the
+// catalog (tables, columns with their target types, primary keys, indexes and
+// foreign keys), generates the PostgreSQL DDL, writes the queries reading the
+// rows and converts the values its driver returns. Tables are named
+// schema.table; for MySQL the schema is the database. SQLServer is the
+// default; MySQL is selected by a mysql:// connection string and Postgres by a
+// postgres:// one (see SourceForDsn).
+type Source interface {
+	// Name names the database system in messages
+	Name() string
+	// Database returns the name of the connected database
+	Database(db *sql.DB) (string, error)
+	// Tables returns the base tables of the schemas, ordered by name
+	Tables(db *sql.DB, schemas []string) ([]string, error)
+	// Columns returns the columns of a table in order, with their target types
+	Columns(db *sql.DB, table string, opts SchemaOptions) ([]Column, error)
+	// PrimaryKey returns the primary key columns of a table in key order
+	PrimaryKey(db *sql.DB, table string) ([]string, error)
+	// Indexes returns the secondary indexes and unique constraints of a table
+	// as CREATE INDEX statements for the target table created for opts
+	Indexes(db *sql.DB, table string, opts SchemaOptions) ([]Index, error)
+	// ForeignKeys returns, for each table with foreign keys, the tables it
+	// references
+	ForeignKeys(db *sql.DB) (map[string][]string, error)
+	// GenerateSchema returns the DDL statements creating the target schemas
+	// and tables
+	GenerateSchema(db *sql.DB, opts SchemaOptions) ([]string, error)
+
+	// QuoteIdent quotes a column name for the source's queries
+	QuoteIdent(name string) string
+	// QuoteTable quotes a table name (schema.table) for the source's queries
+	QuoteTable(table string) string
+	// KeyCondition returns the WHERE condition selecting the rows after a key
+	// given as query parameters, one per column, in the order of
+	// "ORDER BY exprs"
+	KeyCondition(exprs []string) string
+	// PageQuery returns the query reading the first limit rows of a table
+	// matching where (all rows if empty) in the order of orderBy
+	PageQuery(selectList, table, where, orderBy string, limit int) string
+	// CountQuery returns the query counting the rows of a table
+	CountQuery(table string) string
+	// ConvertValue converts a value the driver returned for a column to one
+	// the target accepts for its target type
+	ConvertValue(column Column, value interface{}) (interface{}, error)
+}
+
+// SourceForDsn returns the source a connection string connects to
+func SourceForDsn(dsn string) Source {
+	switch {
+	case IsMySQLDsn(dsn):
+		return MySQL
+	case IsPostgresDsn(dsn):
+		return Postgres
+	}
+	return SQLServer
+}
+
+// SQLServer is the SQL Server source
+var SQLServer Source = sqlServerSource{}
+
+// sqlServerSource reads SQL Server through the catalog functions of this
+// package
+type sqlServerSource struct{}
+
+func (sqlServerSource) Name() string { return "SQL Server" }
+
+func (sqlServerSource) Database(db *sql.DB) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT DB_NAME()").Scan(&name)
+	return name, err
+}
+
+func (sqlServerSource) Tables(db *sql.DB, schemas []string) ([]string, error) {
+	// Build schema filter for SQL queries
+	schemaFilter := ""
+	schemaParams := make([]interface{}, len(schemas))
+	for i, schema := range schemas {
+		if i > 0 {
+			schemaFilter += " OR "
+		}
+		schemaFilter += "TABLE_SCHEMA = @p" + fmt.Sprintf("%d", i+1)
+		schemaParams[i] = schema
+	}
+
+	query := fmt.Sprintf(`
+		SELECT TABLE_SCHEMA AS schema_name, TABLE_NAME AS table_name
+		FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_TYPE = 'BASE TABLE'
+		AND (%s)
+		ORDER BY TABLE_SCHEMA, TABLE_NAME`, schemaFilter)
+
+	rows, err := db.Query(query, schemaParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var schema, tableName string
+		if err := rows.Scan(&schema, &tableName); err != nil {
+			return nil, err
+		}
+		tables = append(tables, schema+"."+tableName)
+	}
+	return tables, rows.Err()
+}
+
+func (sqlServerSource) Columns(db *sql.DB, table string, opts SchemaOptions) ([]Column, error) {
+	return TableColumns(db, table, opts)
+}
+
+func (sqlServerSource) PrimaryKey(db *sql.DB, table string) ([]string, error) {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+	}
+	rows, err := db.Query(`
+		SELECT c.name
+		FROM sys.indexes i
+		JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+		JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		WHERE i.is_primary_key = 1 AND i.object_id = OBJECT_ID(QUOTENAME(@p1) + '.' + QUOTENAME(@p2))
+		ORDER BY ic.key_ordinal`, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting primary key of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+func (sqlServerSource) Indexes(db *sql.DB, table string, opts SchemaOptions) ([]Index, error) {
+	return TableIndexes(db, table, opts)
+}
+
+func (sqlServerSource) ForeignKeys(db *sql.DB) (map[string][]string, error) {
+	return ForeignKeyDependencies(db)
+}
+
+func (sqlServerSource) GenerateSchema(db *sql.DB, opts SchemaOptions) ([]string, error) {
+	return GenerateSchema(db, opts)
+}
+
+func (sqlServerSource) QuoteIdent(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+func (s sqlServerSource) QuoteTable(table string) string {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return s.QuoteIdent(table)
+	}
+	return s.QuoteIdent(parts[0]) + "." + s.QuoteIdent(parts[1])
+}
+
+// KeyCondition returns e.g. "([a] > @p1) OR ([a] = @p1 AND [b] > @p2)"
+func (sqlServerSource) KeyCondition(exprs []string) string {
+	var terms []string
+	for i := range exprs {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = @p%d", exprs[j], j+1))
+		}
+		parts = append(parts, fmt.Sprintf("%s > @p%d", exprs[i], i+1))
+		terms = append(terms, "("+strings.Join(parts, " AND ")+")")
+	}
+	return strings.Join(terms, " OR ")
+}
+
+func (s sqlServerSource) PageQuery(selectList, table, where, orderBy string, limit int) string {
+	query := fmt.Sprintf("SELECT TOP (%d) %s FROM %s", limit, selectList, s.QuoteTable(table))
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query + " ORDER BY " + orderBy
+}
+
+func (s sqlServerSource) CountQuery(table string) string {
+	return "SELECT COUNT_BIG(*) FROM " + s.QuoteTable(table)
+}
+
+// ConvertValue returns SQL Server values unchanged: the data migration tool
+// converts them by source type, which depends on its settings
+func (sqlServerSource) ConvertValue(column Column, value interface{}) (interface{}, error) {
+	return value, nil
+}
+
+// generateSourceSchema returns the DDL creating the target schemas and tables
+// of a source other than SQL Server, with their primary keys, translated
+// current date and time defaults and comments (descriptions, by table), and
+// the provenance and updated-at columns. Columns whose source type is not
+// mapped fail unless -coerce-unknown-to-text or -type-map covers them.
+// Partitioning and Citus distribution are not supported.
+func generateSourceSchema(s Source, db *sql.DB, opts SchemaOptions, descriptions map[string]string, mapped func(column Column) bool) ([]string, error) {
+	if opts.PartitionRows > 0 || opts.Citus {
+		fmt.Printf("Warning: Partitioning and Citus distribution are not supported for %s sources, creating plain tables\n", s.Name())
+	}
+	tables, err := s.Tables(db, opts.Schemas)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tables: %v", err)
+	}
+	if len(opts.Tables) > 0 {
+		includeTables := make(map[string]bool)
+		for _, table := range opts.Tables {
+			includeTables[strings.ToLower(table)] = true
+		}
+		var included []string
+		for _, table := range tables {
+			if includeTables[strings.ToLower(table)] {
+				included = append(included, table)
+			}
+		}
+		tables = included
+	}
+
+	createTable := "CREATE TABLE"
+	if opts.IfNotExists {
+		createTable = "CREATE TABLE IF NOT EXISTS"
+	}
+	createdSchemas := make(map[string]bool)
+	updatedAtFunctions := make(map[string]bool)
+	var statements []string
+	var unmapped UnmappedTypesError
+	hypertables := false
+	for _, table := range tables {
+		columns, err := s.Columns(db, table, opts)
+		if err != nil {
+			return nil, err
+		}
+		var definitions, pks []string
+		var comments [][2]string
+		hasUpdatedAt := false
+		for _, column := range columns {
+			if column.TargetType == "" {
+				fmt.Printf("Dropping computed column: %s.%s\n", table, column.Name)
+				continue
+			}
+			_, overridden := opts.TypeMapper.Lookup(table, column.Name, column.SourceType)
+			if !mapped(column) && !overridden {
+				if !opts.CoerceUnknownTypes {
+					unmapped = append(unmapped, UnmappedColumn{Table: table, Column: column.Name, Type: column.SourceType})
+					continue
+				}
+				fmt.Printf("Warning: No type mapping for %s.%s (%s), using TEXT\n", table, column.Name, column.SourceType)
+			}
+
+			null := "NOT NULL"
+			if column.Nullable {
+				null = "NULL"
+			}
+			defaultExpr, translated := TranslateDateDefault(column.Default, column.TargetType)
+			if translated {
+				fmt.Printf("Translated default %s of %s.%s to %s\n", column.Default, table, column.Name, defaultExpr)
+				if opts.AuditBackfill {
+					null = "NOT NULL"
+				}
+			}
+			definition := fmt.Sprintf("  %s %s %s", QuoteIdent(column.Name, opts.PreserveCase), column.TargetType, null)
+			if translated {
+				definition += " DEFAULT " + defaultExpr
+			}
+			definitions = append(definitions, definition)
+			if opts.UpdatedAtColumn != "" && strings.EqualFold(column.Name, opts.UpdatedAtColumn) {
+				fmt.Printf("Column %s.%s exists in the source, maintaining it with the update trigger\n", table, column.Name)
+				hasUpdatedAt = true
+			}
+			if column.Description != "" {
+				comments = append(comments, [2]string{column.Name, column.Description})
+			}
+		}
+		sort.SliceStable(columns, func(i, j int) bool { return columns[i].PrimaryKeyOrdinal < columns[j].PrimaryKeyOrdinal })
+		var keyColumns []string
+		for _, column := range columns {
+			if column.PrimaryKeyOrdinal > 0 {
+				pks = append(pks, QuoteIdent(column.Name, opts.PreserveCase))
+				keyColumns = append(keyColumns, column.Name)
+			}
+		}
+
+		if opts.ProvenanceColumn != "" {
+			definitions = append(definitions, fmt.Sprintf("  %s TEXT", QuoteIdent(opts.ProvenanceColumn, opts.PreserveCase)))
+		}
+		if opts.UpdatedAtColumn != "" && !hasUpdatedAt {
+			definitions = append(definitions, fmt.Sprintf("  %s TIMESTAMPTZ NOT NULL DEFAULT now()", QuoteIdent(opts.UpdatedAtColumn, opts.PreserveCase)))
+		}
+		tablespace := opts.Config.TableSettings(table).Tablespace
+		if len(pks) > 0 {
+			primaryKey := fmt.Sprintf("  PRIMARY KEY (%s)", strings.Join(pks, ", "))
+			if tablespace != "" {
+				primaryKey += " USING INDEX TABLESPACE " + QuoteIdent(tablespace, opts.PreserveCase)
+			}
+			definitions = append(definitions, primaryKey)
+		}
+
+		parts := strings.SplitN(table, ".", 2)
+		schemaName, tableName := opts.Mapper.Map(parts[0], parts[1])
+		target := QuoteQualified(schemaName, tableName, opts.PreserveCase)
+		if !createdSchemas[schemaName] {
+			statements = append(statements, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdent(schemaName, opts.PreserveCase)))
+			createdSchemas[schemaName] = true
+		}
+		createStatement := fmt.Sprintf("%s %s (\n%s\n)", createTable, target, strings.Join(definitions, ",\n"))
+		if tablespace != "" {
+			createStatement += " TABLESPACE " + QuoteIdent(tablespace, opts.PreserveCase)
+		}
+		statements = append(statements, createStatement)
+		hypertable, err := hypertableStatement(table, keyColumns, opts)
+		if err != nil {
+			return nil, err
+		}
+		if hypertable != "" {
+			fmt.Printf("Creating %s as a hypertable by %s\n", table, opts.Config.TableSettings(table).HypertableColumn)
+			statements = append(statements, hypertable)
+			hypertables = true
+		}
+
+		// Tables created by an earlier run may lack the provenance column
+		if opts.IfNotExists && opts.ProvenanceColumn != "" {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s TEXT",
+				target, QuoteIdent(opts.ProvenanceColumn, opts.PreserveCase)))
+		}
+		if opts.UpdatedAtColumn != "" {
+			if opts.IfNotExists && !hasUpdatedAt {
+				statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s TIMESTAMPTZ NOT NULL DEFAULT now()",
+					target, QuoteIdent(opts.UpdatedAtColumn, opts.PreserveCase)))
+			}
+			if !updatedAtFunctions[schemaName] {
+				statements = append(statements, updatedAtFunction(schemaName, opts))
+				updatedAtFunctions[schemaName] = true
+			}
+			statements = append(statements, updatedAtTrigger(schemaName, tableName, opts)...)
+		}
+
+		// Table and column comments are kept
+		if description := descriptions[table]; description != "" {
+			statements = append(statements, fmt.Sprintf("COMMENT ON TABLE %s IS %s", target, QuoteLiteral(description)))
+		}
+		for _, comment := range comments {
+			statements = append(statements, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s",
+				target, QuoteIdent(comment[0], opts.PreserveCase), QuoteLiteral(comment[1])))
+		}
+	}
+	if len(unmapped) > 0 {
+		return nil, unmapped
+	}
+	if hypertables {
+		statements = append([]string{"CREATE EXTENSION IF NOT EXISTS timescaledb"}, statements...)
+	}
+	return statements, nil
+}
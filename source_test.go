@@ -0,0 +1,59 @@
+package dbmigrate
+
+import "testing"
+
+func TestKeyCondition(t *testing.T) {
+	tests := []struct {
+		name   string
+		source Source
+		exprs  []string
+		want   string
+	}{
+		{"sqlserver single", SQLServer, []string{"[id]"}, "([id] > @p1)"},
+		{"sqlserver composite", SQLServer, []string{"[a]", "[b]", "[c]"},
+			"([a] > @p1) OR ([a] = @p1 AND [b] > @p2) OR ([a] = @p1 AND [b] = @p2 AND [c] > @p3)"},
+		{"mysql single", MySQL, []string{"`id`"}, "(`id`) > (?)"},
+		{"mysql composite", MySQL, []string{"`a`", "`b`"}, "(`a`, `b`) > (?, ?)"},
+		{"postgres single", Postgres, []string{`"id"`}, `("id") > ($1)`},
+		{"postgres composite", Postgres, []string{`"a"`, `"b"`, `"c"`}, `("a", "b", "c") > ($1, $2, $3)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.source.KeyCondition(tt.exprs); got != tt.want {
+				t.Errorf("KeyCondition(%v) = %q, want %q", tt.exprs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  Source
+		where   string
+		orderBy string
+		want    string
+	}{
+		{"sqlserver", SQLServer, "", "[a], [b]",
+			"SELECT TOP (100) [a], [b], [c] FROM [sales].[Orders] ORDER BY [a], [b]"},
+		{"sqlserver where", SQLServer, "([a] > @p1) OR ([a] = @p1 AND [b] > @p2)", "[a], [b]",
+			"SELECT TOP (100) [a], [b], [c] FROM [sales].[Orders] WHERE ([a] > @p1) OR ([a] = @p1 AND [b] > @p2) ORDER BY [a], [b]"},
+		{"mysql", MySQL, "", "`a`, `b`",
+			"SELECT [a], [b], [c] FROM `sales`.`Orders` ORDER BY `a`, `b` LIMIT 100"},
+		{"mysql where", MySQL, "(`a`, `b`) > (?, ?)", "`a`, `b`",
+			"SELECT [a], [b], [c] FROM `sales`.`Orders` WHERE (`a`, `b`) > (?, ?) ORDER BY `a`, `b` LIMIT 100"},
+		{"postgres", Postgres, "", `"a", "b"`,
+			`SELECT [a], [b], [c] FROM "sales"."Orders" ORDER BY "a", "b" LIMIT 100`},
+		{"postgres where", Postgres, `("a", "b") > ($1, $2)`, `"a", "b"`,
+			`SELECT [a], [b], [c] FROM "sales"."Orders" WHERE ("a", "b") > ($1, $2) ORDER BY "a", "b" LIMIT 100`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The select list is passed through as is
+			got := tt.source.PageQuery("[a], [b], [c]", "sales.Orders", tt.where, tt.orderBy, 100)
+			if got != tt.want {
+				t.Errorf("PageQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Column set handling for SchemaOptions.ColumnSets and the -column-sets flag.
+// Sparse columns are always migrated as regular nullable columns; a column set
+// (xml COLUMN_SET FOR ALL_SPARSE_COLUMNS) holds the same values as XML.
+const (
+	// ColumnSetsSkip leaves column sets out of the target table
+	ColumnSetsSkip = "skip"
+	// ColumnSetsKeep also copies column sets, as XML columns holding the sparse
+	// values at migration time
+	ColumnSetsKeep = "keep"
+)
+
+// ParseColumnSets validates a -column-sets value
+func ParseColumnSets(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case ColumnSetsSkip, ColumnSetsKeep:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid column set handling: %s (expected %s or %s)", value, ColumnSetsSkip, ColumnSetsKeep)
+}
+
+// columnPropertyExpr returns the SQL Server expression for a COLUMNPROPERTY of
+// column c of an INFORMATION_SCHEMA.COLUMNS row, e.g. IsSparse
+func columnPropertyExpr(property string) string {
+	return fmt.Sprintf("COLUMNPROPERTY(OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME)), c.COLUMN_NAME, '%s')", property)
+}
+
+// ColumnSets returns the names of the column sets of a source table (schema.table)
+func ColumnSets(db *sql.DB, table string) ([]string, error) {
+	return columnsWithProperty(db, table, "IsColumnSet")
+}
+
+// columnsWithProperty returns the names of the columns of a source table for
+// which a COLUMNPROPERTY is 1
+func columnsWithProperty(db *sql.DB, table, property string) ([]string, error) {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid table name format: %s (expected schema.table)", table)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.COLUMN_NAME
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		WHERE c.TABLE_SCHEMA = @p1 AND c.TABLE_NAME = @p2
+		AND %s = 1
+		ORDER BY c.ORDINAL_POSITION`, columnPropertyExpr(property))
+
+	rows, err := db.Query(query, parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,58 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// IsSpatial tells whether a SQL Server data type is geometry or geography
+func IsSpatial(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "geometry", "geography":
+		return true
+	}
+	return false
+}
+
+// SpatialEWKTExpr is the SQL Server expression reading a spatial column as
+// extended WKT (SRID=4326;POINT (...)), keeping the SRID and any Z and M values
+func SpatialEWKTExpr(column string) string {
+	return fmt.Sprintf("N'SRID=' + CAST([%s].STSrid AS NVARCHAR(10)) + N';' + [%s].AsTextZM()", column, column)
+}
+
+// postgisType returns the PostGIS type of a spatial column. SQL Server stores an
+// SRID with each value, so the column is constrained to an SRID only if all its
+// values share one.
+func postgisType(db *sql.DB, table, column, dataType string) (string, error) {
+	parts := strings.SplitN(table, ".", 2)
+	query := fmt.Sprintf("SELECT DISTINCT TOP (2) [%s].STSrid FROM [%s].[%s] WHERE [%s] IS NOT NULL", column, parts[0], parts[1], column)
+	rows, err := db.Query(query)
+	if err != nil {
+		return "", fmt.Errorf("error reading the SRIDs of %s.%s: %v", table, column, err)
+	}
+	defer rows.Close()
+	var srids []int
+	for rows.Next() {
+		var srid int
+		if err := rows.Scan(&srid); err != nil {
+			return "", err
+		}
+		srids = append(srids, srid)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	pgType, subtype := "GEOMETRY", "Geometry"
+	if strings.EqualFold(dataType, "geography") {
+		pgType, subtype = "GEOGRAPHY", "Geography"
+	}
+	switch {
+	case len(srids) == 1 && srids[0] != 0:
+		return fmt.Sprintf("%s(%s, %d)", pgType, subtype, srids[0]), nil
+	case len(srids) > 1:
+		fmt.Printf("Warning: Column %s.%s has values with different SRIDs, creating it without an SRID constraint\n", table, column)
+	}
+	return pgType, nil
+}
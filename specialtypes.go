@@ -0,0 +1,66 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rowversion column handling for SchemaOptions.Rowversion and the -rowversion flag
+const (
+	// RowversionExclude leaves rowversion columns out of the target table; their
+	// values are generated by SQL Server and mean nothing elsewhere
+	RowversionExclude = "exclude"
+	// RowversionBytea copies the 8-byte values into a BYTEA column
+	RowversionBytea = "bytea"
+)
+
+// ParseRowversion validates a -rowversion value
+func ParseRowversion(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case RowversionExclude, RowversionBytea:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid rowversion handling: %s (expected %s or %s)", value, RowversionExclude, RowversionBytea)
+}
+
+// IsRowversion tells whether a SQL Server data type is rowversion, which
+// INFORMATION_SCHEMA reports under its old name timestamp
+func IsRowversion(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "timestamp", "rowversion":
+		return true
+	}
+	return false
+}
+
+// Target types for SQL Server hierarchyid columns, for SchemaOptions.Hierarchyid
+// and the -hierarchyid flag
+const (
+	// HierarchyidText stores the path as TEXT (e.g., /1/3/2/)
+	HierarchyidText = "text"
+	// HierarchyidLtree stores the path as an ltree label path (e.g., 1.3.2)
+	HierarchyidLtree = "ltree"
+)
+
+// ParseHierarchyid validates a -hierarchyid value
+func ParseHierarchyid(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case HierarchyidText, HierarchyidLtree:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid hierarchyid type: %s (expected %s or %s)", value, HierarchyidText, HierarchyidLtree)
+}
+
+// HierarchyidToLtree converts the string form of a hierarchyid (e.g., /1/3.5/2/)
+// to an ltree path (1.3_5.2). The root (/) becomes the empty path.
+func HierarchyidToLtree(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	labels := strings.Split(path, "/")
+	for i, label := range labels {
+		labels[i] = strings.ReplaceAll(label, ".", "_")
+	}
+	return strings.Join(labels, ".")
+}
@@ -0,0 +1,171 @@
+package dbmigrate
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// A spool decouples extracting the source rows from loading them into the
+// target: the extract process writes the rows of each table to compressed
+// chunk files in a directory, such as a volume or a mounted object store
+// bucket, and the load process copies them into the target, in another network
+// and at its own pace. The manifest lists the tables with their columns and
+// chunks; it is replaced atomically after every chunk, so the load process can
+// follow a running extraction.
+
+// SpoolManifestFile is the name of the manifest in a spool directory
+const SpoolManifestFile = "manifest.json"
+
+// spoolVersion is the version of the spool format
+const spoolVersion = 1
+
+// SpoolManifest describes the contents of a spool directory
+type SpoolManifest struct {
+	Version int `json:"version"`
+	// Source is the name of the source database
+	Source string        `json:"source"`
+	Tables []*SpoolTable `json:"tables"`
+	// Complete is set once every table is extracted
+	Complete bool `json:"complete"`
+}
+
+// SpoolTable is a source table (schema.table) in a spool
+type SpoolTable struct {
+	Table string `json:"table"`
+	// Generation counts the extractions of the table; an interrupted extraction
+	// is started over with a new generation, whose chunks replace the old ones
+	Generation int           `json:"generation"`
+	Columns    []SpoolColumn `json:"columns"`
+	Chunks     []SpoolChunk  `json:"chunks"`
+	Rows       int64         `json:"rows"`
+	Complete   bool          `json:"complete"`
+}
+
+// SpoolColumn is a column of a spooled table with its SQL Server data type
+type SpoolColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// SpoolChunk is a chunk file of rows
+type SpoolChunk struct {
+	// File is the path of the chunk relative to the spool directory
+	File  string `json:"file"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+	// SHA256 is the checksum of the file, checked before it is loaded
+	SHA256 string `json:"sha256"`
+}
+
+// Table returns the table with the given name, or nil
+func (m *SpoolManifest) Table(name string) *SpoolTable {
+	for _, table := range m.Tables {
+		if table.Table == name {
+			return table
+		}
+	}
+	return nil
+}
+
+// LoadSpoolManifest reads the manifest of a spool directory. It returns an
+// empty manifest if the directory has none yet.
+func LoadSpoolManifest(dir string) (*SpoolManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, SpoolManifestFile))
+	if os.IsNotExist(err) {
+		return &SpoolManifest{Version: spoolVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading spool manifest: %v", err)
+	}
+	var manifest SpoolManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing spool manifest: %v", err)
+	}
+	if manifest.Version != spoolVersion {
+		return nil, fmt.Errorf("unsupported spool version %d (expected %d)", manifest.Version, spoolVersion)
+	}
+	return &manifest, nil
+}
+
+// SaveSpoolManifest replaces the manifest of a spool directory
+func SaveSpoolManifest(dir string, manifest *SpoolManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, SpoolManifestFile), data)
+}
+
+// writeFileAtomic writes a file through a temporary file, so readers never
+// see it partially written
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// WriteSpoolChunk writes rows to a chunk file at the relative path file of a
+// spool directory
+func WriteSpoolChunk(dir, file string, rows [][]interface{}) (SpoolChunk, error) {
+	path := filepath.Join(dir, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return SpoolChunk{}, fmt.Errorf("error creating spool directory: %v", err)
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return SpoolChunk{}, fmt.Errorf("error creating chunk %s: %v", file, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	var size atomic.Int64
+	compressor, _ := flate.NewWriter(countingWriter{io.MultiWriter(f, hash), &size}, flate.BestSpeed)
+	if err := gob.NewEncoder(compressor).Encode(rows); err != nil {
+		return SpoolChunk{}, fmt.Errorf("error writing chunk %s: %v", file, err)
+	}
+	if err := compressor.Close(); err != nil {
+		return SpoolChunk{}, fmt.Errorf("error writing chunk %s: %v", file, err)
+	}
+	if err := f.Close(); err != nil {
+		return SpoolChunk{}, fmt.Errorf("error writing chunk %s: %v", file, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return SpoolChunk{}, fmt.Errorf("error writing chunk %s: %v", file, err)
+	}
+	return SpoolChunk{File: file, Rows: int64(len(rows)), Bytes: size.Load(), SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// ReadSpoolChunk reads the rows of a chunk file of a spool directory after
+// checking its checksum
+func ReadSpoolChunk(dir string, chunk SpoolChunk) ([][]interface{}, error) {
+	data, err := os.ReadFile(filepath.Join(dir, chunk.File))
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunk %s: %v", chunk.File, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != chunk.SHA256 {
+		return nil, fmt.Errorf("chunk %s is corrupt: checksum mismatch", chunk.File)
+	}
+	var rows [][]interface{}
+	if err := gob.NewDecoder(flate.NewReader(bytes.NewReader(data))).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("error decoding chunk %s: %v", chunk.File, err)
+	}
+	if int64(len(rows)) != chunk.Rows {
+		return nil, fmt.Errorf("chunk %s holds %d rows, expected %d", chunk.File, len(rows), chunk.Rows)
+	}
+	return rows, nil
+}
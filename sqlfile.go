@@ -0,0 +1,159 @@
+package dbmigrate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLFileInsertRows is the number of rows of each INSERT statement written by
+// a SQLTableFile with inserts
+const SQLFileInsertRows = 1000
+
+// A SQLTableFile writes the rows of a table as a psql script, optionally
+// gzip-compressed: a COPY ... FROM stdin with its data, which is the fastest to
+// apply, or multi-row INSERT statements, which also run outside of psql. The
+// rows are loaded in a single transaction, so a failed file can be applied
+// again.
+type SQLTableFile struct {
+	file    *os.File
+	gzip    *gzip.Writer
+	buffer  *bufio.Writer
+	target  string
+	columns string
+	insert  bool
+	line    []byte
+	Rows    int64
+}
+
+// CreateSQLTableFile creates the script of a table. target is the quoted name
+// of the table and columns the quoted names of the columns the rows hold.
+func CreateSQLTableFile(path, target string, columns []string, insert, compress bool) (*SQLTableFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", path, err)
+	}
+	f := &SQLTableFile{file: file, target: target, columns: strings.Join(columns, ", "), insert: insert}
+	var w io.Writer = file
+	if compress {
+		f.gzip = gzip.NewWriter(file)
+		w = f.gzip
+	}
+	f.buffer = bufio.NewWriterSize(w, 256*1024)
+	f.buffer.WriteString("SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n\nBEGIN;\n\n")
+	if !insert {
+		fmt.Fprintf(f.buffer, "COPY %s (%s) FROM stdin;\n", target, f.columns)
+	}
+	return f, nil
+}
+
+// WriteRow writes a row as a line of COPY data or a row of an INSERT statement
+func (f *SQLTableFile) WriteRow(values []interface{}) error {
+	f.line = f.line[:0]
+	if f.insert {
+		if f.Rows%SQLFileInsertRows == 0 {
+			if f.Rows > 0 {
+				f.line = append(f.line, ";\n\n"...)
+			}
+			f.line = fmt.Appendf(f.line, "INSERT INTO %s (%s) VALUES\n", f.target, f.columns)
+		} else {
+			f.line = append(f.line, ",\n"...)
+		}
+		f.line = append(f.line, '(')
+	}
+	for i, value := range values {
+		if i > 0 {
+			if f.insert {
+				f.line = append(f.line, ", "...)
+			} else {
+				f.line = append(f.line, '\t')
+			}
+		}
+		var err error
+		if f.insert {
+			f.line, err = appendSQLLiteral(f.line, value)
+		} else {
+			f.line, err = appendCopyText(f.line, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if f.insert {
+		f.line = append(f.line, ')')
+	} else {
+		f.line = append(f.line, '\n')
+	}
+	if _, err := f.buffer.Write(f.line); err != nil {
+		return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+	}
+	f.Rows++
+	return nil
+}
+
+// Close ends the data and the transaction and closes the file
+func (f *SQLTableFile) Close() error {
+	defer f.file.Close()
+	switch {
+	case !f.insert:
+		f.buffer.WriteString("\\.\n\n")
+	case f.Rows > 0:
+		f.buffer.WriteString(";\n\n")
+	}
+	f.buffer.WriteString("COMMIT;\n")
+	if err := f.buffer.Flush(); err != nil {
+		return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+	}
+	if f.gzip != nil {
+		if err := f.gzip.Close(); err != nil {
+			return fmt.Errorf("error writing %s: %v", f.file.Name(), err)
+		}
+	}
+	return f.file.Close()
+}
+
+// appendSQLLiteral appends a value as a SQL literal, for a session with
+// standard_conforming_strings on
+func appendSQLLiteral(buf []byte, value interface{}) ([]byte, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(value)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case nil:
+		return append(buf, "NULL"...), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float64:
+		// Infinity and NaN are only accepted as strings
+		if s, ok := specialFloatText(v); ok {
+			return append(append(append(buf, '\''), s...), '\''), nil
+		}
+		return strconv.AppendFloat(buf, v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return append(buf, "TRUE"...), nil
+		}
+		return append(buf, "FALSE"...), nil
+	case []byte:
+		buf = append(buf, `'\x`...)
+		buf = hex.AppendEncode(buf, v)
+		return append(buf, `'::bytea`...), nil
+	case time.Time:
+		buf = append(buf, '\'')
+		buf = append(buf, formatCopyTimestamp(v)...)
+		return append(buf, '\''), nil
+	case string:
+		buf = append(buf, '\'')
+		buf = append(buf, strings.ReplaceAll(v, "'", "''")...)
+		return append(buf, '\''), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", value)
+}
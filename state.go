@@ -0,0 +1,284 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint records the migration progress of a single table. Entries whose
+// Table starts with "phase:", "run:" or "deferred:" hold run-level state instead.
+type Checkpoint struct {
+	Table        string    `json:"table"`
+	RowsMigrated int64     `json:"rows_migrated"`
+	Completed    bool      `json:"completed"`
+	Value        string    `json:"value,omitempty"`  // free-form state, e.g. a counter
+	RunID        string    `json:"run_id,omitempty"` // run that saved the checkpoint
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// StateStore persists checkpoints so an interrupted migration can be resumed
+type StateStore interface {
+	// Load returns all checkpoints keyed by source table name
+	Load() (map[string]Checkpoint, error)
+	// Save records the checkpoint of a table, replacing any previous one
+	Save(cp Checkpoint) error
+	// SaveAll records several checkpoints at once, as Save would one by one
+	SaveAll(cps []Checkpoint) error
+	// Reset removes all checkpoints
+	Reset() error
+}
+
+// OpenStateStore opens the state store described by spec, which is either
+// "file:<path>" for a JSON file (e.g., on a mounted volume) or
+// "target[:schema.table]" for a table in the target database.
+// With readOnly, nothing is created in the target database.
+func OpenStateStore(spec string, targetDb *sql.DB, readOnly bool) (StateStore, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, fmt.Errorf("missing path in state store: %s", spec)
+		}
+		return NewFileStateStore(path), nil
+	case spec == "target" || strings.HasPrefix(spec, "target:"):
+		table := strings.TrimPrefix(strings.TrimPrefix(spec, "target"), ":")
+		if table == "" {
+			table = "public.dbmigrate_state"
+		}
+		if readOnly {
+			return &PostgresStateStore{db: targetDb, table: table, readOnly: true}, nil
+		}
+		return NewPostgresStateStore(targetDb, table)
+	}
+	return nil, fmt.Errorf("invalid state store: %s (expected file:<path> or target[:schema.table])", spec)
+}
+
+// FileStateStore keeps checkpoints in a JSON file. The file is read once;
+// the checkpoints are then kept in memory and every save rewrites the file.
+type FileStateStore struct {
+	path        string
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint // nil until the file is read
+}
+
+// NewFileStateStore returns a state store backed by the JSON file at path
+func NewFileStateStore(path string) *FileStateStore {
+	return &FileStateStore{path: path}
+}
+
+// Load implements StateStore
+func (s *FileStateStore) Load() (map[string]Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	checkpoints := make(map[string]Checkpoint, len(s.checkpoints))
+	for key, cp := range s.checkpoints {
+		checkpoints[key] = cp
+	}
+	return checkpoints, nil
+}
+
+// load reads the state file unless it was read already
+func (s *FileStateStore) load() error {
+	if s.checkpoints != nil {
+		return nil
+	}
+	checkpoints := make(map[string]Checkpoint)
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading state file: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &checkpoints); err != nil {
+			return fmt.Errorf("error parsing state file %s: %v", s.path, err)
+		}
+	}
+	s.checkpoints = checkpoints
+	return nil
+}
+
+// Save implements StateStore
+func (s *FileStateStore) Save(cp Checkpoint) error {
+	return s.SaveAll([]Checkpoint{cp})
+}
+
+// SaveAll implements StateStore, rewriting the file once
+func (s *FileStateStore) SaveAll(cps []Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, cp := range cps {
+		cp.UpdatedAt = now
+		s.checkpoints[cp.Table] = cp
+	}
+	return s.write(s.checkpoints)
+}
+
+// Reset implements StateStore
+func (s *FileStateStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints = make(map[string]Checkpoint)
+	return s.write(s.checkpoints)
+}
+
+// write replaces the state file atomically so a kill mid-write cannot corrupt it
+func (s *FileStateStore) write(checkpoints map[string]Checkpoint) error {
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	return nil
+}
+
+// PostgresStateStore keeps checkpoints in a table of the target database
+type PostgresStateStore struct {
+	db       *sql.DB
+	table    string
+	readOnly bool // the table may not exist yet
+}
+
+// NewPostgresStateStore returns a state store backed by the given table,
+// creating the table if it does not exist
+func NewPostgresStateStore(db *sql.DB, table string) (*PostgresStateStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("state table %s requires a target database connection", table)
+	}
+	createSQL := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			table_name TEXT PRIMARY KEY,
+			rows_migrated BIGINT NOT NULL,
+			completed BOOLEAN NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`, table)
+	if _, err := db.Exec(createSQL); err != nil {
+		return nil, fmt.Errorf("error creating state table %s: %v", table, err)
+	}
+	// Upgrade state tables created by older versions
+	for _, column := range []string{"value", "run_id"} {
+		alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s TEXT NOT NULL DEFAULT ''", table, column)
+		if _, err := db.Exec(alterSQL); err != nil {
+			return nil, fmt.Errorf("error upgrading state table %s: %v", table, err)
+		}
+	}
+	return &PostgresStateStore{db: db, table: table}, nil
+}
+
+// Load implements StateStore
+func (s *PostgresStateStore) Load() (map[string]Checkpoint, error) {
+	if s.readOnly {
+		var exists bool
+		if err := s.db.QueryRow("SELECT to_regclass($1) IS NOT NULL", s.table).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("error reading state table: %v", err)
+		}
+		if !exists {
+			return make(map[string]Checkpoint), nil
+		}
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf("SELECT table_name, rows_migrated, completed, value, run_id, updated_at FROM %s", s.table))
+	if err != nil {
+		return nil, fmt.Errorf("error reading state table: %v", err)
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string]Checkpoint)
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.Table, &cp.RowsMigrated, &cp.Completed, &cp.Value, &cp.RunID, &cp.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error reading state table: %v", err)
+		}
+		checkpoints[cp.Table] = cp
+	}
+	return checkpoints, rows.Err()
+}
+
+// Save implements StateStore
+func (s *PostgresStateStore) Save(cp Checkpoint) error {
+	if s.readOnly {
+		return fmt.Errorf("state table %s was opened read-only", s.table)
+	}
+	upsertSQL := fmt.Sprintf(`
+		INSERT INTO %s (table_name, rows_migrated, completed, value, run_id, updated_at)
+		VALUES ($1, $2, $3, $4, $5, now())
+		ON CONFLICT (table_name) DO UPDATE
+		SET rows_migrated = EXCLUDED.rows_migrated, completed = EXCLUDED.completed,
+			value = EXCLUDED.value, run_id = EXCLUDED.run_id, updated_at = EXCLUDED.updated_at`, s.table)
+	if _, err := s.db.Exec(upsertSQL, cp.Table, cp.RowsMigrated, cp.Completed, cp.Value, cp.RunID); err != nil {
+		return fmt.Errorf("error saving checkpoint for %s: %v", cp.Table, err)
+	}
+	return nil
+}
+
+// SaveAll implements StateStore, in one transaction
+func (s *PostgresStateStore) SaveAll(cps []Checkpoint) error {
+	if s.readOnly {
+		return fmt.Errorf("state table %s was opened read-only", s.table)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error saving checkpoints: %v", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+		INSERT INTO %s (table_name, rows_migrated, completed, value, run_id, updated_at)
+		VALUES ($1, $2, $3, $4, $5, now())
+		ON CONFLICT (table_name) DO UPDATE
+		SET rows_migrated = EXCLUDED.rows_migrated, completed = EXCLUDED.completed,
+			value = EXCLUDED.value, run_id = EXCLUDED.run_id, updated_at = EXCLUDED.updated_at`, s.table))
+	if err != nil {
+		return fmt.Errorf("error saving checkpoints: %v", err)
+	}
+	defer stmt.Close()
+	for _, cp := range cps {
+		if _, err := stmt.Exec(cp.Table, cp.RowsMigrated, cp.Completed, cp.Value, cp.RunID); err != nil {
+			return fmt.Errorf("error saving checkpoint for %s: %v", cp.Table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error saving checkpoints: %v", err)
+	}
+	return nil
+}
+
+// Reset implements StateStore
+func (s *PostgresStateStore) Reset() error {
+	if s.readOnly {
+		return fmt.Errorf("state table %s was opened read-only", s.table)
+	}
+	if _, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s", s.table)); err != nil {
+		return fmt.Errorf("error resetting state table: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// A Target is a database system other than PostgreSQL that tables read from
+// a PostgreSQL source (see Postgres) are written to, by the push subcommand
+// of the data migration tool: it maps the column types, generates the DDL of
+// the target tables and bulk-loads the rows. SQLServerTarget is the only one.
+type Target interface {
+	// Name names the database system in messages
+	Name() string
+	// ColumnType returns the target type of a PostgreSQL column type as
+	// format_type returns it (e.g., character varying(100)) and whether the
+	// type has a mapping
+	ColumnType(pgType string) (string, bool)
+	// QuoteTable quotes a table name (schema.table) for the target's statements
+	QuoteTable(table string) string
+	// CreateTable returns the statements creating a table, and its schema,
+	// unless they exist, with the columns in order (TargetType holding the
+	// target type) and the primary key of their PrimaryKeyOrdinal
+	CreateTable(table string, columns []Column) []string
+	// TruncateTable returns the statement emptying a table
+	TruncateTable(table string) string
+	// CopyIn prepares the bulk load of rows into the columns of a table in tx.
+	// Each Exec with values adds a row; an Exec without values sends the rows.
+	CopyIn(tx *sql.Tx, table string, columns []string) (*sql.Stmt, error)
+	// ConvertValue converts a value read from the source to one the bulk load
+	// accepts for a column of the target type
+	ConvertValue(targetType string, value interface{}) (interface{}, error)
+}
+
+// SQLServerTarget writes to SQL Server with the bulk copy of the SQL Server
+// driver (INSERT BULK)
+var SQLServerTarget Target = sqlServerTarget{}
+
+// PostgresToSqlServerTypeMapping maps PostgreSQL data types (without their
+// modifiers) to SQL Server types. Sizes and precisions are carried over where
+// SQL Server allows them (see sqlServerTarget.ColumnType). money and xml are
+// written as DECIMAL(19,4) and NVARCHAR(MAX), which the bulk copy supports.
+var PostgresToSqlServerTypeMapping = map[string]string{
+	"smallint":                    "SMALLINT",
+	"integer":                     "INT",
+	"bigint":                      "BIGINT",
+	"real":                        "REAL",
+	"double precision":            "FLOAT",
+	"numeric":                     "DECIMAL",
+	"money":                       "DECIMAL(19,4)",
+	"boolean":                     "BIT",
+	"character varying":           "NVARCHAR",
+	"character":                   "NCHAR",
+	"text":                        "NVARCHAR(MAX)",
+	"name":                        "NVARCHAR(128)",
+	"json":                        "NVARCHAR(MAX)",
+	"jsonb":                       "NVARCHAR(MAX)",
+	"xml":                         "NVARCHAR(MAX)",
+	"inet":                        "NVARCHAR(50)",
+	"cidr":                        "NVARCHAR(50)",
+	"macaddr":                     "NVARCHAR(17)",
+	"uuid":                        "UNIQUEIDENTIFIER",
+	"bytea":                       "VARBINARY(MAX)",
+	"date":                        "DATE",
+	"time without time zone":      "TIME",
+	"timestamp without time zone": "DATETIME2",
+	"timestamp with time zone":    "DATETIMEOFFSET",
+}
+
+// sqlServerTarget writes SQL Server tables
+type sqlServerTarget struct{}
+
+func (sqlServerTarget) Name() string { return "SQL Server" }
+
+// ColumnType keeps the length of character types (NVARCHAR(MAX) beyond 4000
+// characters), the precision and scale of numeric (DECIMAL(38,10) without
+// them) and the fractional seconds of time types (at most 7 digits). Arrays
+// have no mapping.
+func (sqlServerTarget) ColumnType(pgType string) (string, bool) {
+	if strings.HasSuffix(pgType, "]") {
+		return "", false
+	}
+	base, modifiers := pgType, ""
+	if open := strings.IndexByte(pgType, '('); open >= 0 {
+		if end := strings.IndexByte(pgType[open:], ')'); end >= 0 {
+			base = strings.Join(strings.Fields(pgType[:open]+" "+pgType[open+end+1:]), " ")
+			modifiers = pgType[open+1 : open+end]
+		}
+	}
+	sqlType, ok := PostgresToSqlServerTypeMapping[strings.ToLower(base)]
+	if !ok {
+		return "", false
+	}
+	switch sqlType {
+	case "DECIMAL":
+		if modifiers == "" {
+			return "DECIMAL(38,10)", true
+		}
+		return "DECIMAL(" + strings.ReplaceAll(modifiers, " ", "") + ")", true
+	case "NVARCHAR", "NCHAR":
+		length, err := strconv.Atoi(modifiers)
+		switch {
+		case err != nil && sqlType == "NCHAR":
+			return "NCHAR(1)", true
+		case err != nil || length > 4000:
+			return sqlType + "(MAX)", true
+		}
+		return fmt.Sprintf("%s(%d)", sqlType, length), true
+	case "TIME", "DATETIME2", "DATETIMEOFFSET":
+		precision, err := strconv.Atoi(modifiers)
+		if err != nil {
+			precision = 6
+		}
+		return fmt.Sprintf("%s(%d)", sqlType, min(precision, 7)), true
+	}
+	return sqlType, true
+}
+
+func (sqlServerTarget) QuoteTable(table string) string {
+	return SQLServer.QuoteTable(table)
+}
+
+func (t sqlServerTarget) CreateTable(table string, columns []Column) []string {
+	parts := strings.SplitN(table, ".", 2)
+	schema := parts[0]
+	var definitions []string
+	var keys []Column
+	for _, column := range columns {
+		null := "NOT NULL"
+		if column.Nullable {
+			null = "NULL"
+		}
+		definitions = append(definitions, fmt.Sprintf("  %s %s %s", SQLServer.QuoteIdent(column.Name), column.TargetType, null))
+		if column.PrimaryKeyOrdinal > 0 {
+			keys = append(keys, column)
+		}
+	}
+	if len(keys) > 0 {
+		pks := make([]string, len(keys))
+		for _, column := range keys {
+			pks[column.PrimaryKeyOrdinal-1] = SQLServer.QuoteIdent(column.Name)
+		}
+		definitions = append(definitions, fmt.Sprintf("  PRIMARY KEY (%s)", strings.Join(pks, ", ")))
+	}
+	return []string{
+		fmt.Sprintf("IF SCHEMA_ID(%s) IS NULL EXEC('CREATE SCHEMA %s')",
+			sqlServerLiteral(schema), strings.ReplaceAll(SQLServer.QuoteIdent(schema), "'", "''")),
+		fmt.Sprintf("IF OBJECT_ID(%s, 'U') IS NULL CREATE TABLE %s (\n%s\n)",
+			sqlServerLiteral(t.QuoteTable(table)), t.QuoteTable(table), strings.Join(definitions, ",\n")),
+	}
+}
+
+// sqlServerLiteral quotes a string as a SQL Server Unicode literal
+func sqlServerLiteral(value string) string {
+	return "N'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func (t sqlServerTarget) TruncateTable(table string) string {
+	return "TRUNCATE TABLE " + t.QuoteTable(table)
+}
+
+func (t sqlServerTarget) CopyIn(tx *sql.Tx, table string, columns []string) (*sql.Stmt, error) {
+	return tx.Prepare(mssql.CopyIn(t.QuoteTable(table), mssql.BulkOptions{}, columns...))
+}
+
+// ConvertValue passes UUIDs, which the PostgreSQL source returns as text, as
+// the bytes of a uniqueidentifier, and numbers written to DECIMAL columns as
+// text
+func (sqlServerTarget) ConvertValue(targetType string, value interface{}) (interface{}, error) {
+	switch {
+	case targetType == "UNIQUEIDENTIFIER":
+		var id mssql.UniqueIdentifier
+		if err := id.Scan(value); err != nil {
+			return nil, err
+		}
+		return id.Value()
+	case strings.HasPrefix(targetType, "DECIMAL"):
+		switch v := value.(type) {
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,58 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TimescaleDB stores a hypertable in chunks by ranges of its time column. The
+// tables configured with hypertable_column are converted to hypertables right
+// after they are created, while they are still empty.
+
+// IsHypertable tells whether a source table (schema.table) is configured to
+// become a hypertable. Safe to call on a nil config.
+func (c *Config) IsHypertable(table string) bool {
+	return c.TableSettings(table).HypertableColumn != ""
+}
+
+// hypertableStatement returns the statement converting the target table of a
+// source table configured with hypertable_column to a hypertable, or "" for
+// other tables. pks holds the primary key columns of the table, which must
+// include the time column.
+func hypertableStatement(table string, pks []string, opts SchemaOptions) (string, error) {
+	settings := opts.Config.TableSettings(table)
+	column := settings.HypertableColumn
+	if column == "" {
+		return "", nil
+	}
+	if len(pks) > 0 && !slices.ContainsFunc(pks, func(pk string) bool { return strings.EqualFold(pk, column) }) {
+		return "", fmt.Errorf("hypertable_column %s of %s is not part of its primary key (%s), which TimescaleDB requires",
+			column, table, strings.Join(pks, ", "))
+	}
+	// TimescaleDB takes the column name as stored, not as an identifier
+	for _, pk := range pks {
+		if strings.EqualFold(pk, column) {
+			column = pk
+		}
+	}
+	if !opts.PreserveCase {
+		column = strings.ToLower(column)
+	}
+
+	call := fmt.Sprintf("create_hypertable(%s, %s", QuoteLiteral(quotedTarget(table, opts)), QuoteLiteral(column))
+	if interval := settings.HypertableChunkInterval; interval != "" {
+		// Integer time columns take the interval as a number
+		if _, err := strconv.ParseInt(interval, 10, 64); err == nil {
+			call += ", chunk_time_interval => " + interval
+		} else {
+			call += ", chunk_time_interval => INTERVAL " + QuoteLiteral(interval)
+		}
+	}
+	// Hypertables created by an earlier run are left as they are
+	if opts.IfNotExists {
+		call += ", if_not_exists => TRUE"
+	}
+	return "SELECT " + call + ")", nil
+}
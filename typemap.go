@@ -0,0 +1,103 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeMapper holds user-defined target types that override TypeMapping: by
+// source type, and per table and column from the config file
+type TypeMapper struct {
+	types  map[string]string // lowercase source type -> PostgreSQL type
+	config *Config
+}
+
+// ParseTypeMap parses a comma-separated list of source=target type pairs
+// (e.g., "money=MONEY,datetime=TIMESTAMP"). Target types may not contain commas.
+func ParseTypeMap(value string) (map[string]string, error) {
+	typeMap := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid type mapping: %s (expected source=target)", pair)
+		}
+		typeMap[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return typeMap, nil
+}
+
+// NewTypeMapper builds a type mapper from the config file (may be nil) and the
+// -type-map flag value. Mappings from the flag take precedence over the type_map
+// of the config file.
+func NewTypeMapper(cfg *Config, typeMapFlag string) (*TypeMapper, error) {
+	m := &TypeMapper{types: make(map[string]string), config: cfg}
+	if cfg != nil {
+		for source, target := range cfg.TypeMap {
+			m.types[strings.ToLower(source)] = target
+		}
+	}
+
+	flagMap, err := ParseTypeMap(typeMapFlag)
+	if err != nil {
+		return nil, err
+	}
+	for source, target := range flagMap {
+		m.types[source] = target
+	}
+	return m, nil
+}
+
+// Lookup returns the user-defined target type of a column of a source table
+// (schema.table): the type of the column in the config file, else the type
+// the table's type_map maps its source type to, else the global mapping.
+// Safe to call on a nil mapper.
+func (m *TypeMapper) Lookup(table, column, dataType string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	settings := m.config.TableSettings(table)
+	for name, columnSettings := range settings.Columns {
+		if strings.EqualFold(name, column) && columnSettings.Type != "" {
+			return columnSettings.Type, true
+		}
+	}
+	for source, target := range settings.TypeMap {
+		if strings.EqualFold(source, dataType) {
+			return target, true
+		}
+	}
+	target, ok := m.types[strings.ToLower(dataType)]
+	return target, ok
+}
+
+// IsIntegerType tells whether a PostgreSQL type is an integer or numeric type
+func IsIntegerType(pgType string) bool {
+	switch baseTypeName(pgType) {
+	case "SMALLINT", "INTEGER", "INT", "BIGINT", "INT2", "INT4", "INT8", "NUMERIC", "DECIMAL":
+		return true
+	}
+	return false
+}
+
+// IsTimestampWithoutTimeZone tells whether a PostgreSQL type is TIMESTAMP WITHOUT TIME ZONE
+func IsTimestampWithoutTimeZone(pgType string) bool {
+	switch baseTypeName(pgType) {
+	case "TIMESTAMP", "TIMESTAMP WITHOUT TIME ZONE":
+		return true
+	}
+	return false
+}
+
+// baseTypeName returns a PostgreSQL type name in upper case without its
+// modifiers, e.g. NUMERIC for numeric(10,2)
+func baseTypeName(pgType string) string {
+	if i := strings.Index(pgType, "("); i >= 0 {
+		// TIMESTAMP(3) WITHOUT TIME ZONE
+		pgType = pgType[:i] + pgType[strings.LastIndex(pgType, ")")+1:]
+	}
+	return strings.Join(strings.Fields(strings.ToUpper(pgType)), " ")
+}
@@ -0,0 +1,53 @@
+package dbmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DDLError describes a generated statement rejected by the target database
+type DDLError struct {
+	Statement string
+	Err       error
+}
+
+func (e DDLError) Error() string {
+	statement := e.Statement
+	if i := strings.Index(statement, "\n"); i >= 0 {
+		statement = statement[:i]
+	}
+	return fmt.Sprintf("%s: %v", statement, e.Err)
+}
+
+// ValidateDDL executes the statements on the target database inside a
+// transaction that is always rolled back, so nothing is created. Each statement
+// runs under its own savepoint, so every invalid statement is reported rather
+// than only the first. Later statements may still fail if they depend on a
+// rejected one.
+func ValidateDDL(ctx context.Context, db *sql.DB, statements []string) ([]DDLError, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting validation transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var failures []DDLError
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT dbmigrate_validate"); err != nil {
+			return nil, fmt.Errorf("error creating savepoint: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			failures = append(failures, DDLError{Statement: statement, Err: err})
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT dbmigrate_validate"); err != nil {
+				return nil, fmt.Errorf("error rolling back to savepoint: %v", err)
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT dbmigrate_validate"); err != nil {
+			return nil, fmt.Errorf("error releasing savepoint: %v", err)
+		}
+	}
+	return failures, nil
+}
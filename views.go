@@ -0,0 +1,633 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// View is a source view with its PostgreSQL translation
+type View struct {
+	Schema string
+	Name   string
+	// Definition is the T-SQL definition of the view, or empty if it is encrypted
+	Definition string
+	// SQL is the CREATE VIEW statement (without a trailing semicolon), or empty if
+	// the view needs manual conversion
+	SQL string
+	// Reason tells why the view needs manual conversion
+	Reason string
+	// Materialized is set for reporting views created as materialized views
+	// (SchemaOptions.MaterializedViews); RefreshSQL then holds the REFRESH
+	// MATERIALIZED VIEW statement that loads them once the tables are loaded
+	Materialized bool
+	RefreshSQL   string
+}
+
+// viewFunctions are the T-SQL functions views may call, with their PostgreSQL
+// names; an empty name keeps the function as written
+var viewFunctions = map[string]string{
+	"isnull": "COALESCE", "getdate": "now", "sysdatetime": "now", "newid": "gen_random_uuid",
+	"coalesce": "", "nullif": "", "upper": "", "lower": "", "ltrim": "", "rtrim": "",
+	"replace": "", "substring": "", "abs": "", "round": "", "floor": "", "ceiling": "",
+	"count": "", "sum": "", "min": "", "max": "", "avg": "", "cast": "",
+	"row_number": "", "rank": "", "dense_rank": "",
+}
+
+// viewKeywords are the words that may be followed by a parenthesis without
+// being a function call, and other words that are never identifiers
+var viewKeywords = map[string]bool{
+	"as": true, "in": true, "exists": true, "from": true, "join": true, "on": true,
+	"and": true, "or": true, "not": true, "when": true, "then": true, "else": true,
+	"over": true, "all": true, "any": true, "some": true, "union": true, "except": true,
+	"intersect": true, "by": true, "partition": true, "select": true, "where": true,
+	"having": true, "top": true, "percent": true, "with": true, "between": true, "is": true,
+	"null": true, "case": true, "end": true, "distinct": true, "like": true, "asc": true, "desc": true,
+}
+
+// viewClauses start a new line of a translated view when at the top level
+var viewClauses = map[string]bool{
+	"from": true, "where": true, "group": true, "having": true, "order": true,
+	"union": true, "except": true, "intersect": true,
+}
+
+// GenerateViews reads the views of opts.Schemas and translates their T-SQL
+// definitions to PostgreSQL CREATE VIEW statements on a best-effort basis:
+// TOP becomes LIMIT, ISNULL becomes COALESCE, GETDATE becomes now() and
+// bracketed identifiers are quoted for PostgreSQL, with source tables and views
+// mapped to their target names. Views using constructs that are not translated
+// are returned without SQL and with the reason. Views are ordered so that the
+// views they select from come first. The views of opts.MaterializedViews are
+// created as materialized views WITH NO DATA, to be refreshed after the data
+// is loaded.
+func GenerateViews(db *sql.DB, opts SchemaOptions) ([]View, error) {
+	placeholders := make([]string, len(opts.Schemas))
+	params := make([]interface{}, len(opts.Schemas))
+	for i, schema := range opts.Schemas {
+		placeholders[i] = fmt.Sprintf("@p%d", i+1)
+		params[i] = schema
+	}
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT s.name, v.name, m.definition
+		FROM sys.views v
+		JOIN sys.schemas s ON s.schema_id = v.schema_id
+		LEFT JOIN sys.sql_modules m ON m.object_id = v.object_id
+		WHERE s.name IN (%s)
+		ORDER BY s.name, v.name`, strings.Join(placeholders, ", ")), params...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying views: %v", err)
+	}
+	defer rows.Close()
+
+	var views []View
+	for rows.Next() {
+		var view View
+		var definition sql.NullString
+		if err := rows.Scan(&view.Schema, &view.Name, &definition); err != nil {
+			return nil, fmt.Errorf("error scanning view: %v", err)
+		}
+		view.Definition = definition.String
+		views = append(views, view)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying views: %v", err)
+	}
+	if len(views) == 0 {
+		return nil, nil
+	}
+
+	// Reporting views are named like tables, by name or pattern
+	materialized := make(map[string]bool)
+	if len(opts.MaterializedViews) > 0 {
+		names := make([]string, len(views))
+		for i, view := range views {
+			names[i] = view.Schema + "." + view.Name
+		}
+		matched, unmatched, err := ResolveTableNames(opts.MaterializedViews, names)
+		if err != nil {
+			return nil, fmt.Errorf("error in materialized views: %v", err)
+		}
+		for _, name := range unmatched {
+			fmt.Printf("Warning: materialized view entry %s matched no view\n", name)
+		}
+		for _, name := range matched {
+			materialized[name] = true
+		}
+	}
+
+	// Tables and views that views may select from, by lowercase schema.name
+	objects := make(map[string][2]string)
+	objectRows, err := db.Query(`SELECT TABLE_SCHEMA, TABLE_NAME FROM INFORMATION_SCHEMA.TABLES`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tables: %v", err)
+	}
+	defer objectRows.Close()
+	for objectRows.Next() {
+		var schema, name string
+		if err := objectRows.Scan(&schema, &name); err != nil {
+			return nil, fmt.Errorf("error scanning table: %v", err)
+		}
+		objects[strings.ToLower(schema+"."+name)] = [2]string{schema, name}
+	}
+	if err := objectRows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying tables: %v", err)
+	}
+
+	// With PreserveCase, unbracketed column names are quoted in their declared case
+	names := make(map[string]string)
+	if opts.PreserveCase {
+		columnRows, err := db.Query(`SELECT DISTINCT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS`)
+		if err != nil {
+			return nil, fmt.Errorf("error querying columns: %v", err)
+		}
+		defer columnRows.Close()
+		for columnRows.Next() {
+			var name string
+			if err := columnRows.Scan(&name); err != nil {
+				return nil, fmt.Errorf("error scanning column: %v", err)
+			}
+			names[strings.ToLower(name)] = name
+		}
+		if err := columnRows.Err(); err != nil {
+			return nil, fmt.Errorf("error querying columns: %v", err)
+		}
+	}
+
+	isView := make(map[string]bool, len(views))
+	for _, view := range views {
+		isView[strings.ToLower(view.Schema+"."+view.Name)] = true
+	}
+	dependencies := make(map[string][]string)
+	for i := range views {
+		view := &views[i]
+		view.Materialized = materialized[view.Schema+"."+view.Name]
+		if view.Definition == "" {
+			view.Reason = "the definition is encrypted"
+			continue
+		}
+		t := &viewTranslator{opts: opts, schema: view.Schema, objects: objects, names: names, isView: isView}
+		statement, err := t.translate(view.Definition)
+		if err != nil {
+			view.Reason = err.Error()
+			continue
+		}
+		schemaName, viewName := opts.Mapper.Map(view.Schema, view.Name)
+		target := QuoteQualified(schemaName, viewName, opts.PreserveCase)
+		if view.Materialized {
+			// The tables are still empty when the schema is created
+			view.SQL = fmt.Sprintf("CREATE MATERIALIZED VIEW %s%s\nWITH NO DATA", target, statement)
+			view.RefreshSQL = "REFRESH MATERIALIZED VIEW " + target
+		} else {
+			view.SQL = fmt.Sprintf("CREATE VIEW %s%s", target, statement)
+		}
+		dependencies[strings.ToLower(view.Schema+"."+view.Name)] = t.views
+	}
+	return orderViews(views, dependencies), nil
+}
+
+// orderViews sorts views so that each comes after the views it depends on, and
+// marks views depending on a view that needs manual conversion as needing it too
+func orderViews(views []View, dependencies map[string][]string) []View {
+	byKey := make(map[string]*View, len(views))
+	for i := range views {
+		byKey[strings.ToLower(views[i].Schema+"."+views[i].Name)] = &views[i]
+	}
+
+	var ordered []View
+	done := make(map[string]bool)
+	var visit func(key string, path map[string]bool)
+	visit = func(key string, path map[string]bool) {
+		if done[key] {
+			return
+		}
+		view := byKey[key]
+		path[key] = true
+		for _, dependency := range dependencies[key] {
+			if path[dependency] {
+				continue // SQL Server does not allow cycles
+			}
+			visit(dependency, path)
+			if other := byKey[dependency]; other.SQL == "" && view.SQL != "" {
+				view.SQL, view.RefreshSQL, view.Reason = "", "", fmt.Sprintf("it selects from %s.%s, which needs manual conversion", other.Schema, other.Name)
+			}
+		}
+		delete(path, key)
+		done[key] = true
+		ordered = append(ordered, *view)
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		visit(key, make(map[string]bool))
+	}
+	return ordered
+}
+
+// viewTranslator translates the definition of a view
+type viewTranslator struct {
+	opts    SchemaOptions
+	schema  string               // schema of the view, for unqualified names
+	objects map[string][2]string // source tables and views by lowercase schema.name
+	names   map[string]string    // column names by lowercase name, with PreserveCase
+	isView  map[string]bool      // source views by lowercase schema.name
+	views   []string             // lowercase schema.name of the views selected from
+}
+
+// translate returns the part of a CREATE VIEW statement after the view name
+func (t *viewTranslator) translate(definition string) (string, error) {
+	tokens, err := tokenizeView(definition)
+	if err != nil {
+		return "", err
+	}
+
+	// CREATE VIEW name [(columns)] [WITH options] AS body
+	pos := 0
+	for pos < len(tokens) && !strings.EqualFold(tokens[pos], "view") {
+		pos++
+	}
+	for pos += 2; pos+1 < len(tokens) && tokens[pos] == "."; pos += 2 {
+	}
+	columns := ""
+	if pos < len(tokens) && tokens[pos] == "(" {
+		var names []string
+		for pos++; pos < len(tokens) && tokens[pos] != ")"; pos++ {
+			if tokens[pos] != "," {
+				names = append(names, t.ident(tokens[pos]))
+			}
+		}
+		columns = " (" + strings.Join(names, ", ") + ")"
+		pos++
+	}
+	for pos < len(tokens) && !strings.EqualFold(tokens[pos], "as") {
+		pos++
+	}
+	if pos >= len(tokens) {
+		return "", fmt.Errorf("the definition has no AS")
+	}
+	body := tokens[pos+1:]
+	for len(body) > 0 && body[len(body)-1] == ";" {
+		body = body[:len(body)-1]
+	}
+
+	body, limit, err := t.leadingTop(body)
+	if err != nil {
+		return "", err
+	}
+	out, err := t.translateBody(body)
+	if err != nil {
+		return "", err
+	}
+	if limit != "" {
+		out = append(out, "\n", "LIMIT", limit)
+	}
+	return columns + " AS\n" + joinViewTokens(out), nil
+}
+
+// leadingTop removes TOP from the leading SELECT of a view body and returns the
+// LIMIT it becomes; TOP 100 PERCENT, which only allows an ORDER BY, is dropped
+func (t *viewTranslator) leadingTop(body []string) ([]string, string, error) {
+	pos := 1
+	if pos < len(body) && (strings.EqualFold(body[pos], "distinct") || strings.EqualFold(body[pos], "all")) {
+		pos++
+	}
+	if len(body) == 0 || !strings.EqualFold(body[0], "select") || pos >= len(body) || !strings.EqualFold(body[pos], "top") {
+		return body, "", nil
+	}
+	end := pos + 1
+	limit := ""
+	if end < len(body) && body[end] == "(" && end+2 < len(body) && body[end+2] == ")" {
+		limit, end = body[end+1], end+3
+	} else if end < len(body) {
+		limit, end = body[end], end+1
+	}
+	if limit == "" || !unicode.IsDigit(rune(limit[0])) {
+		return nil, "", fmt.Errorf("uses TOP with an expression")
+	}
+	if end < len(body) && strings.EqualFold(body[end], "percent") {
+		if limit != "100" {
+			return nil, "", fmt.Errorf("uses TOP %s PERCENT", limit)
+		}
+		limit, end = "", end+1
+	}
+	if end+1 < len(body) && strings.EqualFold(body[end], "with") && strings.EqualFold(body[end+1], "ties") {
+		return nil, "", fmt.Errorf("uses TOP WITH TIES")
+	}
+
+	depth := 0
+	for _, token := range body[end:] {
+		switch {
+		case token == "(":
+			depth++
+		case token == ")":
+			depth--
+		case depth == 0 && limit != "" && (strings.EqualFold(token, "union") || strings.EqualFold(token, "except") || strings.EqualFold(token, "intersect")):
+			return nil, "", fmt.Errorf("uses TOP with %s", strings.ToUpper(token))
+		}
+	}
+	return append(append([]string{}, body[:pos]...), body[end:]...), limit, nil
+}
+
+// translateBody translates the tokens of the SELECT statement of a view
+func (t *viewTranslator) translateBody(body []string) ([]string, error) {
+	var out []string
+	var calls []string // function (or "") of each open parenthesis
+	for pos := 0; pos < len(body); pos++ {
+		token := body[pos]
+		lower := strings.ToLower(token)
+		next := ""
+		if pos+1 < len(body) {
+			next = body[pos+1]
+		}
+		previous := ""
+		if len(out) > 0 {
+			previous = strings.ToLower(out[len(out)-1])
+		}
+
+		switch {
+		case token == "(":
+			calls = append(calls, "")
+			out = append(out, token)
+		case token == ")":
+			if len(calls) > 0 {
+				calls = calls[:len(calls)-1]
+			}
+			out = append(out, token)
+		case token[0] == '\'':
+			out = append(out, token)
+		case (token[0] == 'N' || token[0] == 'n') && len(token) > 1 && token[1] == '\'':
+			out = append(out, token[1:])
+		case token == "+" && (isViewString(previous) || isViewString(next)):
+			// + next to a string literal concatenates
+			out = append(out, "||")
+		case token[0] == '@' || token[0] == '#':
+			return nil, fmt.Errorf("uses %s", token)
+		case lower == "top":
+			return nil, fmt.Errorf("uses TOP outside the leading SELECT")
+		case lower == "apply" || lower == "pivot" || lower == "unpivot":
+			return nil, fmt.Errorf("uses %s", strings.ToUpper(token))
+		case lower == "for" && (strings.EqualFold(next, "xml") || strings.EqualFold(next, "json")):
+			return nil, fmt.Errorf("uses FOR %s", strings.ToUpper(next))
+		case lower == "with" && next == "(":
+			// Table hints, such as WITH (NOLOCK), do not apply to PostgreSQL
+			depth := 0
+			for pos++; pos < len(body); pos++ {
+				if body[pos] == "(" {
+					depth++
+				} else if body[pos] == ")" {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+		case lower == "as" && len(calls) > 0 && calls[len(calls)-1] == "cast":
+			pgType, skip, err := viewCastType(body[pos+1:])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, "AS", pgType)
+			pos += skip
+		case lower == "getutcdate" && next == "(" && pos+2 < len(body) && body[pos+2] == ")":
+			out = append(out, "(now() AT TIME ZONE 'UTC')")
+			pos += 2
+		case isViewWord(token) && next == "(" && !viewKeywords[lower]:
+			name, ok := viewFunctions[lower]
+			if !ok {
+				return nil, fmt.Errorf("uses the function %s", strings.ToUpper(token))
+			}
+			if name == "" {
+				name = token
+			}
+			calls = append(calls, lower)
+			out = append(out, name+"(")
+			pos++
+		case isViewIdent(token) && !(isViewWord(token) && (viewKeywords[lower] || reservedWords[lower])):
+			name, skip, err := t.translateName(body[pos:], previous)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, name)
+			pos += skip - 1
+		default:
+			if len(calls) == 0 && viewClauses[lower] {
+				out = append(out, "\n")
+			}
+			out = append(out, token)
+		}
+	}
+	return out, nil
+}
+
+// translateName translates a possibly qualified name at the start of tokens and
+// returns the number of tokens it spans. previous is the lowercase token before it.
+func (t *viewTranslator) translateName(tokens []string, previous string) (string, int, error) {
+	parts := []string{unquoteViewIdent(tokens[0])}
+	idents := []string{t.ident(tokens[0])}
+	count := 1
+	for count+1 < len(tokens) && tokens[count] == "." && isViewIdent(tokens[count+1]) {
+		parts = append(parts, unquoteViewIdent(tokens[count+1]))
+		idents = append(idents, t.ident(tokens[count+1]))
+		count += 2
+	}
+	if count < len(tokens) && tokens[count] == "(" {
+		return "", 0, fmt.Errorf("uses the function %s", strings.Join(parts, "."))
+	}
+
+	switch len(parts) {
+	case 1:
+		// Unqualified tables and views belong to the schema of the view or dbo
+		if previous == "from" || previous == "join" {
+			for _, schema := range []string{t.schema, "dbo"} {
+				if object, ok := t.objects[strings.ToLower(schema+"."+parts[0])]; ok {
+					return t.object(object), count, nil
+				}
+			}
+		}
+		return idents[0], count, nil
+	case 2, 3:
+		// schema.table, alias.column or schema.table.column
+		if object, ok := t.objects[strings.ToLower(parts[0]+"."+parts[1])]; ok {
+			name := t.object(object)
+			if len(parts) == 3 {
+				name += "." + idents[2]
+			}
+			return name, count, nil
+		}
+		if len(parts) == 2 {
+			return idents[0] + "." + idents[1], count, nil
+		}
+	}
+	return "", 0, fmt.Errorf("references %s, which is not in this database", strings.Join(parts, "."))
+}
+
+// ident translates an identifier that is not a table or view. With PreserveCase,
+// unbracketed names take the case of the column they refer to, or are left
+// unquoted (and folded to lowercase) if they name no column, like aliases.
+func (t *viewTranslator) ident(token string) string {
+	if isViewWord(token) && t.opts.PreserveCase {
+		if name, ok := t.names[strings.ToLower(token)]; ok {
+			return QuoteIdent(name, true)
+		}
+		return token
+	}
+	return QuoteIdent(unquoteViewIdent(token), t.opts.PreserveCase)
+}
+
+// object returns the target name of a source table or view
+func (t *viewTranslator) object(object [2]string) string {
+	key := strings.ToLower(object[0] + "." + object[1])
+	if t.isView[key] {
+		t.views = append(t.views, key)
+	}
+	schema, table := t.opts.Mapper.Map(object[0], object[1])
+	return QuoteQualified(schema, table, t.opts.PreserveCase)
+}
+
+// viewCastType translates the target type of a CAST at the start of tokens and
+// returns the number of tokens it spans; the length of text types is dropped
+func viewCastType(tokens []string) (string, int, error) {
+	if len(tokens) == 0 {
+		return "", 0, fmt.Errorf("the definition ends in a CAST")
+	}
+	dataType := strings.ToLower(unquoteViewIdent(tokens[0]))
+	pgType, ok := TypeMapping[dataType]
+	if !ok {
+		return "", 0, fmt.Errorf("casts to %s", dataType)
+	}
+	count := 1
+	if len(tokens) > 1 && tokens[1] == "(" {
+		end := 2
+		for end < len(tokens) && tokens[end] != ")" {
+			end++
+		}
+		if dataType == "decimal" || dataType == "numeric" {
+			pgType += "(" + strings.Join(tokens[2:end], "") + ")"
+		}
+		count = end + 1
+	}
+	return pgType, count, nil
+}
+
+// tokenizeView splits a T-SQL definition into identifiers ([name], "name" or
+// bare words), numbers, string literals and operators, dropping comments
+func tokenizeView(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				end = len(s) - i
+			}
+			i += end
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("the definition has an unterminated comment")
+			}
+			i += end + 2
+		case c == '[' || c == '"':
+			closing := "]"
+			if c == '"' {
+				closing = `"`
+			}
+			end := strings.Index(s[i+1:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("the definition has an unterminated identifier")
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		case c == '\'' || ((c == 'N' || c == 'n') && i+1 < len(s) && s[i+1] == '\''):
+			start := i
+			if c != '\'' {
+				i++
+			}
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, fmt.Errorf("the definition has an unterminated string")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			tokens = append(tokens, s[start:i+1])
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			start := i
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		case unicode.IsLetter(c) || c == '_' || c == '@' || c == '#':
+			start := i
+			for i < len(s) && (unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i])) || strings.ContainsRune("_@#$", rune(s[i]))) {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		case strings.HasPrefix(s[i:], "<>") || strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("()+-*/%,.=<>;", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("the definition has the unsupported character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// joinViewTokens joins translated tokens with spaces; "\n" tokens start a new line
+func joinViewTokens(tokens []string) string {
+	var b strings.Builder
+	for i, token := range tokens {
+		if token == "\n" {
+			b.WriteString("\n")
+			continue
+		}
+		if i > 0 && tokens[i-1] != "\n" && token != "," && token != ")" && token != "." &&
+			tokens[i-1] != "." && !strings.HasSuffix(tokens[i-1], "(") {
+			b.WriteString(" ")
+		}
+		b.WriteString(token)
+	}
+	return b.String()
+}
+
+// isViewWord tells whether a token is a bare word
+func isViewWord(token string) bool {
+	c := rune(token[0])
+	return unicode.IsLetter(c) || c == '_'
+}
+
+// isViewIdent tells whether a token is a bare word or a quoted identifier
+func isViewIdent(token string) bool {
+	return isViewWord(token) || token[0] == '[' || token[0] == '"'
+}
+
+// isViewString tells whether a translated token is a string literal
+func isViewString(token string) bool {
+	return strings.HasPrefix(token, "'") || strings.HasPrefix(token, "N'") || strings.HasPrefix(token, "n'")
+}
+
+// unquoteViewIdent returns the name of an identifier token
+func unquoteViewIdent(token string) string {
+	switch token[0] {
+	case '[':
+		return token[1 : len(token)-1]
+	case '"':
+		return strings.ReplaceAll(token[1:len(token)-1], `""`, `"`)
+	}
+	return token
+}
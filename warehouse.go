@@ -0,0 +1,204 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Analytics warehouses the archive subcommand writes load files for: the DDL
+// of the tables, a CSV file per table and a script that stages the files and
+// loads them with the warehouse's bulk load statement
+const (
+	// WarehouseSnowflake uploads the files to a stage with PUT and loads them
+	// with COPY INTO
+	WarehouseSnowflake = "snowflake"
+	// WarehouseRedshift loads the files with COPY from an S3 prefix they are
+	// uploaded to
+	WarehouseRedshift = "redshift"
+)
+
+// WarehouseColumnType returns the warehouse type of a column created in
+// PostgreSQL as pgType (the TargetType of a Column). Types the warehouse has no
+// counterpart for, such as json, uuid, bytea (as \x hex), arrays and interval,
+// are loaded as text.
+func WarehouseColumnType(warehouse, pgType string) string {
+	base, modifiers := strings.ToLower(strings.TrimSpace(pgType)), ""
+	if strings.HasSuffix(base, "]") {
+		base = "text"
+	}
+	if open := strings.IndexByte(base, '('); open >= 0 {
+		if end := strings.IndexByte(base[open:], ')'); end >= 0 {
+			modifiers = strings.ReplaceAll(base[open+1:open+end], " ", "")
+			base = strings.Join(strings.Fields(base[:open]+" "+base[open+end+1:]), " ")
+		}
+	}
+	length, _ := strconv.Atoi(modifiers)
+	redshift := warehouse == WarehouseRedshift
+	text := "VARCHAR"
+	if redshift {
+		text = "VARCHAR(65535)"
+	}
+	switch base {
+	case "smallint", "int2":
+		return "SMALLINT"
+	case "integer", "int", "int4":
+		return "INTEGER"
+	case "bigint", "int8":
+		return "BIGINT"
+	case "numeric", "decimal":
+		if modifiers == "" {
+			return "DECIMAL(38,10)"
+		}
+		return "DECIMAL(" + modifiers + ")"
+	case "money":
+		return "DECIMAL(19,4)"
+	case "real", "float4":
+		return "REAL"
+	case "double precision", "float8":
+		return "DOUBLE PRECISION"
+	case "boolean", "bool":
+		return "BOOLEAN"
+	case "varchar", "character varying", "char", "character", "bpchar":
+		if length == 0 {
+			return text
+		}
+		// Redshift lengths are in bytes, and its CHAR holds single-byte
+		// characters only
+		if redshift {
+			return fmt.Sprintf("VARCHAR(%d)", min(4*length, 65535))
+		}
+		if base == "char" || base == "character" || base == "bpchar" {
+			return fmt.Sprintf("CHAR(%d)", length)
+		}
+		return fmt.Sprintf("VARCHAR(%d)", length)
+	case "uuid":
+		return "VARCHAR(36)"
+	case "date":
+		return "DATE"
+	case "time", "time without time zone":
+		return "TIME"
+	case "timestamp", "timestamp without time zone":
+		if redshift {
+			return "TIMESTAMP"
+		}
+		return "TIMESTAMP_NTZ"
+	case "timestamptz", "timestamp with time zone":
+		if redshift {
+			return "TIMESTAMPTZ"
+		}
+		return "TIMESTAMP_TZ"
+	}
+	return text
+}
+
+// WarehouseCreateTable returns the statements creating a warehouse table
+// (schema and table quoted as for PostgreSQL) and its schema unless they
+// exist, with the columns that have a TargetType. The primary key is
+// informational in both warehouses; it is not enforced.
+func WarehouseCreateTable(warehouse, schema, table string, columns []Column, preserveCase bool) []string {
+	var definitions, keys []string
+	for _, column := range columns {
+		if column.TargetType == "" {
+			continue
+		}
+		definition := QuoteIdent(column.Name, preserveCase) + " " + WarehouseColumnType(warehouse, column.TargetType)
+		if !column.Nullable {
+			definition += " NOT NULL"
+		}
+		definitions = append(definitions, "  "+definition)
+		if column.PrimaryKeyOrdinal > 0 {
+			keys = append(keys, "")
+		}
+	}
+	if len(keys) > 0 {
+		for _, column := range columns {
+			if column.PrimaryKeyOrdinal > 0 && column.PrimaryKeyOrdinal <= len(keys) {
+				keys[column.PrimaryKeyOrdinal-1] = QuoteIdent(column.Name, preserveCase)
+			}
+		}
+		definitions = append(definitions, "  PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	return []string{
+		"CREATE SCHEMA IF NOT EXISTS " + QuoteIdent(schema, preserveCase),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n)", QuoteQualified(schema, table, preserveCase), strings.Join(definitions, ",\n")),
+	}
+}
+
+// WarehouseLoad describes the CSV file of a table to load into a warehouse
+type WarehouseLoad struct {
+	// Table is the quoted schema-qualified table and Columns its quoted
+	// columns, in the order of the file
+	Table   string
+	Columns []string
+	// Path is the local path of the file, which Snowflake uploads with PUT
+	Path string
+	// File is the name of the file in the stage
+	File string
+	// Stage is the Snowflake stage (e.g., @~/dbmigrate or @my_stage/path) or
+	// the S3 prefix (s3://bucket/path) the file is uploaded to for Redshift
+	Stage string
+	// IAMRole is the role Redshift reads the S3 prefix with (default: the
+	// default role of the cluster)
+	IAMRole    string
+	CSV        CSVOptions
+	Compressed bool
+}
+
+// WarehouseLoadStatements returns the statements that load the CSV file of a
+// table into the warehouse: PUT and COPY INTO for Snowflake, COPY for Redshift.
+// NULL is an unquoted empty field (or CSV.Null) and empty strings are quoted,
+// as written by a CSVTableFile.
+func WarehouseLoadStatements(warehouse string, load WarehouseLoad) []string {
+	columns := strings.Join(load.Columns, ", ")
+	stage := strings.TrimSuffix(load.Stage, "/")
+	skipHeader := 0
+	if load.CSV.Header {
+		skipHeader = 1
+	}
+	if warehouse == WarehouseRedshift {
+		var options []string
+		options = append(options, "FORMAT AS CSV QUOTE AS '\"'", "DELIMITER AS "+warehouseLiteral(string(load.CSV.Delimiter)))
+		if skipHeader > 0 {
+			options = append(options, "IGNOREHEADER 1")
+		}
+		if load.CSV.Null != "" {
+			options = append(options, "NULL AS "+warehouseLiteral(load.CSV.Null))
+		} else {
+			options = append(options, "EMPTYASNULL")
+		}
+		if load.Compressed {
+			options = append(options, "GZIP")
+		}
+		role := "default"
+		if load.IAMRole != "" {
+			role = warehouseLiteral(load.IAMRole)
+		}
+		options = append(options, "TIMEFORMAT 'auto'", "DATEFORMAT 'auto'", "IAM_ROLE "+role)
+		return []string{fmt.Sprintf("COPY %s (%s)\nFROM %s\n%s", load.Table, columns,
+			warehouseLiteral(stage+"/"+load.File), strings.Join(options, "\n"))}
+	}
+
+	if !strings.HasPrefix(stage, "@") {
+		stage = "@" + stage
+	}
+	nullIf, emptyAsNull := "()", "TRUE"
+	if load.CSV.Null != "" {
+		nullIf, emptyAsNull = "("+warehouseLiteral(load.CSV.Null)+")", "FALSE"
+	}
+	compression := "NONE"
+	if load.Compressed {
+		compression = "GZIP"
+	}
+	return []string{
+		fmt.Sprintf("PUT %s %s/ AUTO_COMPRESS = FALSE OVERWRITE = TRUE", warehouseLiteral("file://"+load.Path), stage),
+		fmt.Sprintf("COPY INTO %s (%s)\nFROM %s/%s\nFILE_FORMAT = (TYPE = CSV FIELD_DELIMITER = %s SKIP_HEADER = %d FIELD_OPTIONALLY_ENCLOSED_BY = '\"' ESCAPE_UNENCLOSED_FIELD = NONE NULL_IF = %s EMPTY_FIELD_AS_NULL = %s COMPRESSION = %s TIMESTAMP_FORMAT = AUTO)",
+			load.Table, columns, stage, load.File, warehouseLiteral(string(load.CSV.Delimiter)), skipHeader, nullIf, emptyAsNull, compression),
+	}
+}
+
+// warehouseLiteral quotes a string literal for Snowflake and Redshift
+func warehouseLiteral(value string) string {
+	value = strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), "'", "''")
+	return "'" + strings.ReplaceAll(value, "\t", `\t`) + "'"
+}
@@ -0,0 +1,92 @@
+package dbmigrate
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// warehouseColumns is a table with the types the warehouses map differently, a
+// composite primary key and a column left out of the target table
+var warehouseColumns = []Column{
+	{Name: "OrderID", TargetType: "bigint", PrimaryKeyOrdinal: 2},
+	{Name: "Region", TargetType: "character varying(10)", PrimaryKeyOrdinal: 1},
+	{Name: "Code", TargetType: "char(3)", Nullable: true},
+	{Name: "Country", TargetType: "character(2)"},
+	{Name: "City", TargetType: "varchar(40)", Nullable: true},
+	{Name: "Amount", TargetType: "numeric(12,2)", Nullable: true},
+	{Name: "Ratio", TargetType: "numeric", Nullable: true},
+	{Name: "Total", TargetType: "money", Nullable: true},
+	{Name: "Shipped", TargetType: "boolean"},
+	{Name: "OrderedAt", TargetType: "timestamp(3) without time zone"},
+	{Name: "ChangedAt", TargetType: "timestamp with time zone", Nullable: true},
+	{Name: "ShipDate", TargetType: "date", Nullable: true},
+	{Name: "Notes", TargetType: "text", Nullable: true},
+	{Name: "Tags", TargetType: "text[]", Nullable: true},
+	{Name: "Payload", TargetType: "jsonb", Nullable: true},
+	{Name: "Token", TargetType: "uuid", Nullable: true},
+	{Name: "Photo", TargetType: "bytea", Nullable: true},
+	{Name: "order", TargetType: "integer", Nullable: true},
+	{Name: "RowVersion", TargetType: ""},
+}
+
+// warehouseScript returns the schema and load statements of the warehouse
+// files of the table, joined as writeWarehouseFiles writes them
+func warehouseScript(warehouse string, load WarehouseLoad) string {
+	statements := WarehouseCreateTable(warehouse, "Sales", "Orders", warehouseColumns, false)
+	load.Table = QuoteQualified("Sales", "Orders", false)
+	for _, column := range warehouseColumns {
+		if column.TargetType != "" {
+			load.Columns = append(load.Columns, QuoteIdent(column.Name, false))
+		}
+	}
+	statements = append(statements, WarehouseLoadStatements(warehouse, load)...)
+	return strings.Join(statements, ";\n\n") + ";\n"
+}
+
+func TestWarehouseScripts(t *testing.T) {
+	tests := []struct {
+		name      string
+		warehouse string
+		load      WarehouseLoad
+	}{
+		{"snowflake", WarehouseSnowflake, WarehouseLoad{
+			Path: "/data/out/sales.orders.csv", File: "sales.orders.csv", Stage: "@~/dbmigrate",
+			CSV: CSVOptions{Delimiter: ',', Header: true},
+		}},
+		{"snowflake_gzip_null", WarehouseSnowflake, WarehouseLoad{
+			Path: "/data/it's/sales.orders.csv.gz", File: "sales.orders.csv.gz", Stage: "analytics.public.loads/",
+			CSV: CSVOptions{Delimiter: '\t', Null: `\N`}, Compressed: true,
+		}},
+		{"redshift", WarehouseRedshift, WarehouseLoad{
+			File: "sales.orders.csv", Stage: "s3://analytics-loads/sales",
+			CSV: CSVOptions{Delimiter: ',', Header: true},
+		}},
+		{"redshift_gzip_null", WarehouseRedshift, WarehouseLoad{
+			File: "sales.orders.csv.gz", Stage: "s3://analytics-loads/sales/", IAMRole: "arn:aws:iam::123456789012:role/RedshiftLoad",
+			CSV: CSVOptions{Delimiter: '|', Null: `\N`}, Compressed: true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := warehouseScript(tt.warehouse, tt.load)
+			golden := filepath.Join("testdata", "warehouse_"+tt.name+".sql.golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("script differs from %s:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
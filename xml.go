@@ -0,0 +1,24 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target types for SQL Server xml columns, for SchemaOptions.XmlType and the
+// -xml-type flag
+const (
+	// XmlTypeXML stores the values as PostgreSQL xml, which checks they are well formed
+	XmlTypeXML = "xml"
+	// XmlTypeText stores the values as TEXT, e.g. if the target server is built without libxml
+	XmlTypeText = "text"
+)
+
+// ParseXmlType validates a -xml-type value
+func ParseXmlType(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case XmlTypeXML, XmlTypeText:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid xml type: %s (expected %s or %s)", value, XmlTypeXML, XmlTypeText)
+}